@@ -0,0 +1,179 @@
+// Package activitypub makes the blog a single ActivityPub actor, so people on
+// Mastodon and friends can follow it, get new posts in their timelines, and
+// reply to them.
+//
+// See http://www.w3.org/TR/activitypub/ for the protocol.
+package activitypub
+
+import (
+    "encoding/json"
+    "github.com/darkhelmet/blargh/post"
+    "html/template"
+    "logging"
+    "strings"
+    "time"
+)
+
+const (
+    ContentType = "application/activity+json"
+    Public      = "https://www.w3.org/ns/activitystreams#Public"
+)
+
+var (
+    logger  = logging.New("activitypub")
+    context = []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"}
+)
+
+// Actor is the document that describes an account, ours or someone
+// else's.
+type Actor struct {
+    Context           interface{} `json:"@context,omitempty"`
+    Id                string      `json:"id"`
+    Type              string      `json:"type"`
+    PreferredUsername string      `json:"preferredUsername,omitempty"`
+    Name              string      `json:"name,omitempty"`
+    Summary           string      `json:"summary,omitempty"`
+    URL               string      `json:"url,omitempty"`
+    Icon              *Image      `json:"icon,omitempty"`
+    Inbox             string      `json:"inbox"`
+    Outbox            string      `json:"outbox,omitempty"`
+    Followers         string      `json:"followers,omitempty"`
+    Endpoints         *Endpoints  `json:"endpoints,omitempty"`
+    PublicKey         PublicKey   `json:"publicKey"`
+}
+
+type Image struct {
+    Type string `json:"type"`
+    URL  string `json:"url"`
+}
+
+type Endpoints struct {
+    SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+type PublicKey struct {
+    Id           string `json:"id"`
+    Owner        string `json:"owner"`
+    PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// DeliveryInbox is where to send things for this actor, sharing an inbox
+// with everyone else on their server when we can.
+func (a *Actor) DeliveryInbox() string {
+    if a.Endpoints != nil && a.Endpoints.SharedInbox != "" {
+        return a.Endpoints.SharedInbox
+    }
+    return a.Inbox
+}
+
+// Activity is something an actor did. Object is whatever it was done to,
+// which is either another activity, an object, or just its id.
+type Activity struct {
+    Context   interface{} `json:"@context,omitempty"`
+    Id        string      `json:"id"`
+    Type      string      `json:"type"`
+    Actor     string      `json:"actor"`
+    Object    interface{} `json:"object"`
+    To        []string    `json:"to,omitempty"`
+    Cc        []string    `json:"cc,omitempty"`
+    Published string      `json:"published,omitempty"`
+}
+
+// Article is a post, the way the fediverse sees it.
+type Article struct {
+    Context      interface{} `json:"@context,omitempty"`
+    Id           string      `json:"id"`
+    Type         string      `json:"type"`
+    Name         string      `json:"name"`
+    Summary      string      `json:"summary,omitempty"`
+    Content      string      `json:"content"`
+    URL          string      `json:"url"`
+    AttributedTo string      `json:"attributedTo"`
+    Published    string      `json:"published"`
+    To           []string    `json:"to"`
+    Cc           []string    `json:"cc"`
+    Tag          []Tag       `json:"tag,omitempty"`
+}
+
+type Tag struct {
+    Type string `json:"type"`
+    Href string `json:"href"`
+    Name string `json:"name"`
+}
+
+// NewArticle builds the article actor wrote for a post. url is where the
+// post lives, and tagURL makes the link for one of its tags.
+func NewArticle(actor *Actor, p *post.Post, url string, html template.HTML, tagURL func(string) string) *Article {
+    a := &Article{
+        Context:      context[0],
+        Id:           url,
+        Type:         "Article",
+        Name:         p.Title,
+        Summary:      p.Description,
+        Content:      string(html),
+        URL:          url,
+        AttributedTo: actor.Id,
+        Published:    p.PublishedOn.UTC().Format(time.RFC3339),
+        To:           []string{Public},
+        Cc:           []string{actor.Followers},
+    }
+    for _, tag := range p.Tags {
+        a.Tag = append(a.Tag, Tag{"Hashtag", tagURL(tag), "#" + strings.Replace(tag, " ", "", -1)})
+    }
+    return a
+}
+
+// NewCreate wraps an article up to be delivered.
+func NewCreate(a *Article) *Activity {
+    article := *a
+    article.Context = nil
+    return &Activity{
+        Context:   context[0],
+        Id:        a.Id + "#create",
+        Type:      "Create",
+        Actor:     a.AttributedTo,
+        Object:    &article,
+        To:        a.To,
+        Cc:        a.Cc,
+        Published: a.Published,
+    }
+}
+
+// incoming is an activity someone sent us, with the parts that might be
+// an id or a whole object left raw.
+type incoming struct {
+    Id     string          `json:"id"`
+    Type   string          `json:"type"`
+    Actor  json.RawMessage `json:"actor"`
+    Object json.RawMessage `json:"object"`
+}
+
+// note is a reply, or whatever else got created.
+type note struct {
+    Id           string          `json:"id"`
+    Type         string          `json:"type"`
+    AttributedTo json.RawMessage `json:"attributedTo"`
+    InReplyTo    json.RawMessage `json:"inReplyTo"`
+    Content      string          `json:"content"`
+    URL          json.RawMessage `json:"url"`
+    Published    string          `json:"published"`
+}
+
+// idOf reads something that's either an id or an object with one.
+func idOf(raw json.RawMessage) string {
+    var id string
+    if err := json.Unmarshal(raw, &id); err == nil {
+        return id
+    }
+    var object struct {
+        Id   string `json:"id"`
+        Href string `json:"href"`
+    }
+    if err := json.Unmarshal(raw, &object); err == nil {
+        if object.Id != "" {
+            return object.Id
+        }
+        return object.Href
+    }
+    return ""
+}
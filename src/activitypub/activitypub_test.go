@@ -0,0 +1,219 @@
+package activitypub_test
+
+import (
+    "activitypub"
+    "bytes"
+    "crypto/rand"
+    "crypto/rsa"
+    "encoding/json"
+    "github.com/darkhelmet/blargh/post"
+    "io/ioutil"
+    . "launchpad.net/gocheck"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+const permalink = "http://verboselogging.com/2013/05/01/ruby-batteries-included"
+
+type ActivityPubSuite struct {
+    remote    *httptest.Server
+    remoteKey *rsa.PrivateKey
+    actor     *activitypub.Actor
+    service   *activitypub.Service
+    inbox     chan *http.Request
+    bodies    chan []byte
+}
+
+var _ = Suite(&ActivityPubSuite{})
+
+func (s *ActivityPubSuite) SetUpSuite(c *C) {
+    var err error
+    s.remoteKey, err = rsa.GenerateKey(rand.Reader, 1024)
+    c.Assert(err, IsNil)
+}
+
+// SetUpTest starts a stand-in for someone's Mastodon server, with one
+// actor on it.
+func (s *ActivityPubSuite) SetUpTest(c *C) {
+    s.inbox, s.bodies = make(chan *http.Request, 10), make(chan []byte, 10)
+    s.remote = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/users/jane":
+            w.Header().Set("Content-Type", activitypub.ContentType)
+            json.NewEncoder(w).Encode(s.actor)
+        case "/inbox", "/users/jane/inbox":
+            body, _ := ioutil.ReadAll(r.Body)
+            s.inbox <- r
+            s.bodies <- body
+            w.WriteHeader(http.StatusAccepted)
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    pem, err := activitypub.PublicKeyPEM(s.remoteKey)
+    c.Assert(err, IsNil)
+    id := s.remote.URL + "/users/jane"
+    s.actor = &activitypub.Actor{
+        Id:                id,
+        Type:              "Person",
+        PreferredUsername: "jane",
+        Name:              "Jane Doe",
+        URL:               s.remote.URL + "/@jane",
+        Inbox:             s.remote.URL + "/users/jane/inbox",
+        Endpoints:         &activitypub.Endpoints{SharedInbox: s.remote.URL + "/inbox"},
+        PublicKey:         activitypub.PublicKey{Id: id + "#main-key", Owner: id, PublicKeyPem: pem},
+    }
+
+    key, err := activitypub.LoadKey(filepath.Join(c.MkDir(), "actor.pem"))
+    c.Assert(err, IsNil)
+    store, err := activitypub.OpenStore(filepath.Join(c.MkDir(), "activitypub.json"))
+    c.Assert(err, IsNil)
+    s.service, err = activitypub.NewService(&activitypub.Actor{
+        Id:        "http://verboselogging.com/actor",
+        Type:      "Person",
+        Inbox:     "http://verboselogging.com/actor/inbox",
+        Followers: "http://verboselogging.com/actor/followers",
+    }, key, store, http.DefaultClient)
+    c.Assert(err, IsNil)
+    s.service.Replyable = func(id string) bool { return id == permalink }
+}
+
+func (s *ActivityPubSuite) TearDownTest(c *C) {
+    s.remote.Close()
+}
+
+// send POSTs an activity to our inbox the way Jane's server would.
+func (s *ActivityPubSuite) send(activity string, tamper func(*http.Request)) error {
+    body := []byte(strings.Replace(activity, "JANE", s.actor.Id, -1))
+    req, _ := http.NewRequest("POST", "http://verboselogging.com/actor/inbox", bytes.NewReader(body))
+    if err := activitypub.Sign(req, body, s.actor.PublicKey.Id, s.remoteKey); err != nil {
+        return err
+    }
+    if tamper != nil {
+        tamper(req)
+    }
+    return s.service.Inbox(req.Method, req.URL.RequestURI(), req.Header, body)
+}
+
+const follow = `{"@context":"https://www.w3.org/ns/activitystreams","id":"JANE#follows/1","type":"Follow","actor":"JANE","object":"http://verboselogging.com/actor"}`
+
+// received waits for something to turn up in Jane's inbox.
+func (s *ActivityPubSuite) received(c *C) (*http.Request, []byte, map[string]interface{}) {
+    select {
+    case req := <-s.inbox:
+        body := <-s.bodies
+        var activity map[string]interface{}
+        c.Assert(json.Unmarshal(body, &activity), IsNil)
+        return req, body, activity
+    case <-time.After(5 * time.Second):
+        c.Fatal("nothing was delivered")
+    }
+    return nil, nil, nil
+}
+
+func (s *ActivityPubSuite) TestFollowAndUndo(c *C) {
+    c.Assert(s.send(follow, nil), IsNil)
+    followers := s.service.Store.Followers()
+    c.Assert(followers, HasLen, 1)
+    c.Check(followers[0].Actor, Equals, s.actor.Id)
+    c.Check(followers[0].Inbox, Equals, s.remote.URL+"/inbox")
+
+    req, _, accept := s.received(c)
+    c.Check(accept["type"], Equals, "Accept")
+    c.Check(accept["object"].(map[string]interface{})["id"], Equals, s.actor.Id+"#follows/1")
+    c.Check(req.Header.Get("Signature"), Matches, `keyId="http://verboselogging.com/actor#main-key".*`)
+
+    c.Assert(s.send(`{"id":"JANE#undo","type":"Undo","actor":"JANE","object":`+follow+`}`, nil), IsNil)
+    c.Check(s.service.Store.Followers(), HasLen, 0)
+}
+
+func (s *ActivityPubSuite) TestSignatures(c *C) {
+    body := []byte(strings.Replace(follow, "JANE", s.actor.Id, -1))
+    req, _ := http.NewRequest("POST", "http://verboselogging.com/actor/inbox", bytes.NewReader(body))
+    c.Check(s.service.Inbox("POST", "/actor/inbox", req.Header, body), Equals, activitypub.ErrUnsigned)
+
+    err := s.send(follow, func(req *http.Request) { req.Header.Set("Host", "evil.example.com") })
+    c.Check(err, Equals, activitypub.ErrBadSignature)
+    err = s.send(follow, func(req *http.Request) { req.Header.Set("Digest", "SHA-256=nope") })
+    c.Check(err, ErrorMatches, "digest doesn't match the body")
+    err = s.send(follow, func(req *http.Request) {
+        req.Header.Set("Date", time.Now().Add(-24*time.Hour).UTC().Format(http.TimeFormat))
+    })
+    c.Check(err, ErrorMatches, "request is too old, or from the future")
+
+    // Signed by Jane, claiming to be someone else.
+    err = s.send(strings.Replace(follow, `"actor":"JANE"`, `"actor":"http://example.com/bob"`, 1), nil)
+    c.Check(err, Equals, activitypub.ErrWrongActor)
+    c.Check(s.service.Store.Followers(), HasLen, 0)
+}
+
+func (s *ActivityPubSuite) TestReplies(c *C) {
+    reply := `{"id":"JANE/statuses/1/activity","type":"Create","actor":"JANE","object":{"id":"JANE/statuses/1","type":"Note",` +
+        `"attributedTo":"JANE","inReplyTo":"` + permalink + `","published":"2013-05-02T14:30:00Z",` +
+        `"content":"<p>Nice &amp; <a href=\"x\">tidy</a></p><p>Thanks!</p><script>alert(1)</script>"}}`
+    c.Assert(s.send(reply, nil), IsNil)
+    c.Assert(s.send(strings.Replace(reply, permalink, "http://example.com/elsewhere", 1), nil), IsNil)
+
+    // Nothing shows up until it's approved.
+    c.Check(activitypub.RepliesTo(permalink), HasLen, 0)
+    pending := s.service.Store.Pending()
+    c.Assert(pending, HasLen, 1)
+    c.Assert(s.service.Store.ApproveReply(pending[0].Id), IsNil)
+    c.Check(s.service.Store.Pending(), HasLen, 0)
+
+    // Sending it again doesn't take that back, unless it's changed.
+    c.Assert(s.send(reply, nil), IsNil)
+    c.Check(activitypub.RepliesTo(permalink), HasLen, 1)
+    c.Assert(s.send(strings.Replace(reply, "Thanks!", "Thanks again!", 1), nil), IsNil)
+    c.Check(activitypub.RepliesTo(permalink), HasLen, 0)
+    c.Assert(s.service.Store.ApproveReply(pending[0].Id), IsNil)
+
+    replies := activitypub.RepliesTo(permalink)
+    c.Assert(replies, HasLen, 1)
+    r := replies[0]
+    c.Check(r.Content, Equals, "Nice & tidy\n\nThanks again!alert(1)")
+    c.Check(r.AuthorName, Equals, "Jane Doe")
+    c.Check(r.AuthorURL, Equals, s.remote.URL+"/@jane")
+    c.Check(r.URL, Equals, s.actor.Id+"/statuses/1")
+    c.Check(r.Published.Equal(time.Date(2013, 5, 2, 14, 30, 0, 0, time.UTC)), Equals, true)
+
+    c.Assert(s.send(`{"id":"JANE#delete","type":"Delete","actor":"JANE","object":{"id":"JANE/statuses/1","type":"Tombstone"}}`, nil), IsNil)
+    c.Check(activitypub.RepliesTo(permalink), HasLen, 0)
+}
+
+func (s *ActivityPubSuite) TestDeliver(c *C) {
+    c.Assert(s.send(follow, nil), IsNil)
+    s.received(c)
+
+    p := &post.Post{Title: "Ruby Batteries Included", Description: "Batteries", Tags: []string{"ruby", "stdlib"},
+        PublishedOn: time.Date(2013, 5, 1, 9, 0, 0, 0, time.UTC)}
+    article := activitypub.NewArticle(s.service.Actor, p, permalink, "<p>Hi</p>", func(tag string) string {
+        return "http://verboselogging.com/tag/" + tag
+    })
+    s.service.Deliver(activitypub.NewCreate(article))
+
+    req, body, create := s.received(c)
+    c.Check(create["type"], Equals, "Create")
+    c.Check(create["actor"], Equals, "http://verboselogging.com/actor")
+    object := create["object"].(map[string]interface{})
+    c.Check(object["type"], Equals, "Article")
+    c.Check(object["name"], Equals, "Ruby Batteries Included")
+    c.Check(object["content"], Equals, "<p>Hi</p>")
+    c.Check(object["published"], Equals, "2013-05-01T09:00:00Z")
+    c.Check(object["cc"], DeepEquals, []interface{}{"http://verboselogging.com/actor/followers"})
+    c.Check(object["tag"].([]interface{})[0].(map[string]interface{})["name"], Equals, "#ruby")
+
+    // Check it's signed with the key the actor document gives out.
+    req.Header.Set("Host", req.Host)
+    keyId, err := activitypub.Verify("POST", "/inbox", req.Header, body, func(string) (*rsa.PublicKey, error) {
+        return &s.service.Key.PublicKey, nil
+    })
+    c.Check(err, IsNil)
+    c.Check(keyId, Equals, "http://verboselogging.com/actor#main-key")
+}
@@ -0,0 +1,282 @@
+package activitypub
+
+import (
+    "bytes"
+    "crypto/rsa"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "html"
+    "io"
+    "io/ioutil"
+    "metrics"
+    "net/http"
+    "regexp"
+    "strings"
+    "sync"
+    "time"
+)
+
+// MaxActivitySize is as much of an activity or actor document as gets
+// read.
+const MaxActivitySize = 1 << 20
+
+var (
+    ErrWrongActor = errors.New("activity isn't signed by its actor")
+
+    tags       = regexp.MustCompile(`(?s)<[^>]*>`)
+    paragraphs = regexp.MustCompile(`(?i)</p>\s*<p[^>]*>|<br\s*/?>`)
+
+    actorCacheRequests = metrics.NewCounter("activitypub_actor_cache_requests_total", "Actor documents asked for, by whether they were cached.", "result")
+)
+
+// Service is our actor: its document, its key, and everything it knows.
+type Service struct {
+    Actor  *Actor
+    Key    *rsa.PrivateKey
+    Store  *Store
+    Client *http.Client
+
+    // Replyable tells whether an id is one of our posts.
+    Replyable func(id string) bool
+
+    lock   sync.Mutex
+    actors map[string]cachedActor
+}
+
+type cachedActor struct {
+    actor   *Actor
+    fetched time.Time
+}
+
+// NewService sets up the actor with id, and fills in the public key on
+// its document.
+func NewService(actor *Actor, key *rsa.PrivateKey, store *Store, client *http.Client) (*Service, error) {
+    pem, err := PublicKeyPEM(key)
+    if err != nil {
+        return nil, err
+    }
+    actor.Context = context
+    actor.PublicKey = PublicKey{Id: actor.Id + "#main-key", Owner: actor.Id, PublicKeyPem: pem}
+    return &Service{
+        Actor:     actor,
+        Key:       key,
+        Store:     store,
+        Client:    client,
+        Replyable: func(string) bool { return false },
+        actors:    make(map[string]cachedActor),
+    }, nil
+}
+
+// FetchActor gets someone's actor document, keeping it around for an hour.
+func (s *Service) FetchActor(id string) (*Actor, error) {
+    s.lock.Lock()
+    cached, ok := s.actors[id]
+    s.lock.Unlock()
+    if ok && time.Since(cached.fetched) < time.Hour {
+        actorCacheRequests.Inc("hit")
+        return cached.actor, nil
+    }
+    actorCacheRequests.Inc("miss")
+
+    req, err := http.NewRequest("GET", id, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Accept", ContentType)
+    if err := Sign(req, nil, s.Actor.PublicKey.Id, s.Key); err != nil {
+        return nil, err
+    }
+    resp, err := s.Client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return nil, fmt.Errorf("%s responded with %s", id, resp.Status)
+    }
+    var actor Actor
+    if err := json.NewDecoder(io.LimitReader(resp.Body, MaxActivitySize)).Decode(&actor); err != nil {
+        return nil, err
+    }
+    if actor.Id != id || actor.Inbox == "" {
+        return nil, fmt.Errorf("%s isn't an actor", id)
+    }
+
+    s.lock.Lock()
+    s.actors[id] = cachedActor{&actor, time.Now()}
+    s.lock.Unlock()
+    return &actor, nil
+}
+
+// lookupKey finds the key for a signature, which lives on its owner's
+// actor document.
+func (s *Service) lookupKey(keyId string) (*rsa.PublicKey, error) {
+    actor, err := s.FetchActor(strings.SplitN(keyId, "#", 2)[0])
+    if err != nil {
+        return nil, err
+    }
+    if actor.PublicKey.Id != keyId {
+        return nil, fmt.Errorf("%s doesn't have key %s", actor.Id, keyId)
+    }
+    return parsePublicKey(actor.PublicKey.PublicKeyPem)
+}
+
+// Inbox takes an activity POSTed to us. header has to include Host, for
+// checking the signature.
+func (s *Service) Inbox(method, target string, header http.Header, body []byte) error {
+    keyId, err := Verify(method, target, header, body, s.lookupKey)
+    if err != nil {
+        return err
+    }
+    var activity incoming
+    if err := json.Unmarshal(body, &activity); err != nil {
+        return err
+    }
+    actor := idOf(activity.Actor)
+    if strings.SplitN(keyId, "#", 2)[0] != actor {
+        return ErrWrongActor
+    }
+
+    switch activity.Type {
+    case "Follow":
+        return s.follow(actor, activity.Id, body)
+    case "Undo":
+        var undone incoming
+        if err := json.Unmarshal(activity.Object, &undone); err == nil && undone.Type == "Follow" {
+            logger.Printf("%s stopped following", actor)
+            return s.Store.Unfollow(actor)
+        }
+    case "Create":
+        return s.reply(actor, activity.Object)
+    case "Delete":
+        return s.Store.DeleteReply(idOf(activity.Object), actor)
+    }
+    return nil
+}
+
+func (s *Service) follow(actor, id string, body []byte) error {
+    remote, err := s.FetchActor(actor)
+    if err != nil {
+        return err
+    }
+    err = s.Store.Follow(Follower{Actor: actor, Inbox: remote.DeliveryInbox(), Since: time.Now()})
+    if err != nil {
+        return err
+    }
+    logger.Printf("%s started following", actor)
+
+    // Send the Follow back as it came, so the other end recognizes it.
+    accept := &Activity{
+        Context: context[0],
+        Id:      fmt.Sprintf("%s#accepts/%d", s.Actor.Id, time.Now().UnixNano()),
+        Type:    "Accept",
+        Actor:   s.Actor.Id,
+        Object:  json.RawMessage(body),
+    }
+    go func() {
+        if err := s.Post(remote.Inbox, accept); err != nil {
+            logger.Printf("failed accepting follow %s: %s", id, err)
+        }
+    }()
+    return nil
+}
+
+// reply keeps notes that reply to one of our posts, and ignores the rest.
+func (s *Service) reply(actor string, object json.RawMessage) error {
+    var n note
+    if err := json.Unmarshal(object, &n); err != nil {
+        return err
+    }
+    inReplyTo := idOf(n.InReplyTo)
+    if n.Id == "" || !s.Replyable(inReplyTo) {
+        return nil
+    }
+    if author := idOf(n.AttributedTo); author != "" && author != actor {
+        return ErrWrongActor
+    }
+    remote, err := s.FetchActor(actor)
+    if err != nil {
+        return err
+    }
+
+    r := Reply{
+        Id:         n.Id,
+        Actor:      actor,
+        InReplyTo:  inReplyTo,
+        URL:        idOf(n.URL),
+        Content:    plainText(n.Content),
+        AuthorName: remote.Name,
+        AuthorURL:  actor,
+    }
+    if r.URL == "" {
+        r.URL = n.Id
+    }
+    if r.AuthorName == "" {
+        r.AuthorName = remote.PreferredUsername
+    }
+    if remote.URL != "" {
+        r.AuthorURL = remote.URL
+    }
+    if remote.Icon != nil {
+        r.AuthorIcon = remote.Icon.URL
+    }
+    r.Published, _ = time.Parse(time.RFC3339, n.Published)
+    if r.Published.IsZero() {
+        r.Published = time.Now()
+    }
+    logger.Printf("%s replied to %s", actor, inReplyTo)
+    return s.Store.AddReply(r)
+}
+
+// plainText is the note without its markup. We show it escaped, so none of
+// someone else's HTML ends up on the page.
+func plainText(content string) string {
+    content = paragraphs.ReplaceAllString(content, "\n\n")
+    return strings.TrimSpace(html.UnescapeString(tags.ReplaceAllString(content, "")))
+}
+
+// Post delivers an activity to an inbox, signed.
+func (s *Service) Post(inbox string, activity interface{}) error {
+    body, err := json.Marshal(activity)
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequest("POST", inbox, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", ContentType)
+    if err := Sign(req, body, s.Actor.PublicKey.Id, s.Key); err != nil {
+        return err
+    }
+    resp, err := s.Client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxActivitySize))
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("%s responded with %s", inbox, resp.Status)
+    }
+    return nil
+}
+
+// Deliver sends an activity to every follower, trying each inbox a couple
+// of times before giving up on it.
+func (s *Service) Deliver(activity *Activity) {
+    for _, inbox := range s.Store.Inboxes() {
+        var err error
+        for attempt := 0; attempt < 3; attempt++ {
+            if attempt > 0 {
+                time.Sleep(time.Duration(attempt) * 10 * time.Second)
+            }
+            if err = s.Post(inbox, activity); err == nil {
+                break
+            }
+        }
+        if err != nil {
+            logger.Printf("failed delivering %s to %s: %s", activity.Id, inbox, err)
+        }
+    }
+}
@@ -0,0 +1,164 @@
+package activitypub
+
+import (
+    "atomicfile"
+    "crypto"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "regexp"
+    "strings"
+    "time"
+)
+
+// MaxClockSkew is how far off a signed request's Date can be.
+const MaxClockSkew = 12 * time.Hour
+
+var (
+    ErrUnsigned     = errors.New("request isn't signed")
+    ErrBadSignature = errors.New("signature doesn't check out")
+
+    signatureParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+    signedHeaders  = []string{"(request-target)", "host", "date", "digest"}
+)
+
+// LoadKey reads the actor's private key, making one the first time.
+func LoadKey(path string) (*rsa.PrivateKey, error) {
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        key, err := rsa.GenerateKey(rand.Reader, 2048)
+        if err != nil {
+            return nil, err
+        }
+        data = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+        return key, atomicfile.WriteFile(path, data, 0600)
+    }
+    if err != nil {
+        return nil, err
+    }
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, fmt.Errorf("no key in %s", path)
+    }
+    return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// PublicKeyPEM is the public half of key, the way actor documents have it.
+func PublicKeyPEM(key *rsa.PrivateKey) (string, error) {
+    der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+    if err != nil {
+        return "", err
+    }
+    return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+func parsePublicKey(data string) (*rsa.PublicKey, error) {
+    block, _ := pem.Decode([]byte(data))
+    if block == nil {
+        return nil, errors.New("no public key")
+    }
+    key, err := x509.ParsePKIXPublicKey(block.Bytes)
+    if err != nil {
+        return nil, err
+    }
+    rsaKey, ok := key.(*rsa.PublicKey)
+    if !ok {
+        return nil, errors.New("public key isn't RSA")
+    }
+    return rsaKey, nil
+}
+
+func digest(body []byte) string {
+    sum := sha256.Sum256(body)
+    return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// signingString is what gets signed: the listed headers, one per line.
+func signingString(method, target string, header http.Header, headers []string) string {
+    lines := make([]string, len(headers))
+    for i, h := range headers {
+        value := header.Get(h)
+        if h == "(request-target)" {
+            value = strings.ToLower(method) + " " + target
+        }
+        lines[i] = h + ": " + value
+    }
+    return strings.Join(lines, "\n")
+}
+
+// Sign adds Date, Digest and Signature headers to req, the way Mastodon
+// expects them.
+func Sign(req *http.Request, body []byte, keyId string, key *rsa.PrivateKey) error {
+    req.Header.Set("Host", req.URL.Host)
+    req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+    req.Header.Set("Digest", digest(body))
+    s := signingString(req.Method, req.URL.RequestURI(), req.Header, signedHeaders)
+    hash := sha256.Sum256([]byte(s))
+    signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+        keyId, strings.Join(signedHeaders, " "), base64.StdEncoding.EncodeToString(signature)))
+    return nil
+}
+
+// Verify checks the signature on a request and returns the id of the key
+// that signed it. lookup finds the key. The header has to include Host.
+func Verify(method, target string, header http.Header, body []byte, lookup func(keyId string) (*rsa.PublicKey, error)) (string, error) {
+    params := make(map[string]string)
+    for _, m := range signatureParam.FindAllStringSubmatch(header.Get("Signature"), -1) {
+        params[m[1]] = m[2]
+    }
+    keyId, signature := params["keyId"], params["signature"]
+    if keyId == "" || signature == "" {
+        return "", ErrUnsigned
+    }
+    if algorithm := params["algorithm"]; algorithm != "" && algorithm != "rsa-sha256" && algorithm != "hs2019" {
+        return "", fmt.Errorf("can't check %s signatures", algorithm)
+    }
+    headers := strings.Fields(strings.ToLower(params["headers"]))
+    if len(headers) == 0 {
+        headers = []string{"date"}
+    }
+    covered := make(map[string]bool)
+    for _, h := range headers {
+        covered[h] = true
+    }
+    if !covered["(request-target)"] || !covered["host"] || !covered["date"] || (len(body) > 0 && !covered["digest"]) {
+        return "", errors.New("signature doesn't cover enough of the request")
+    }
+
+    date, err := http.ParseTime(header.Get("Date"))
+    if err != nil {
+        return "", err
+    }
+    if skew := time.Since(date); skew > MaxClockSkew || skew < -MaxClockSkew {
+        return "", errors.New("request is too old, or from the future")
+    }
+    if covered["digest"] && header.Get("Digest") != digest(body) {
+        return "", errors.New("digest doesn't match the body")
+    }
+
+    decoded, err := base64.StdEncoding.DecodeString(signature)
+    if err != nil {
+        return "", err
+    }
+    key, err := lookup(keyId)
+    if err != nil {
+        return "", err
+    }
+    hash := sha256.Sum256([]byte(signingString(method, target, header, headers)))
+    if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], decoded) != nil {
+        return "", ErrBadSignature
+    }
+    return keyId, nil
+}
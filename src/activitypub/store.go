@@ -0,0 +1,205 @@
+package activitypub
+
+import (
+    "atomicfile"
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "sort"
+    "sync"
+    "time"
+)
+
+var (
+    lock    sync.RWMutex
+    current *Store
+)
+
+// Follower is someone following the blog.
+type Follower struct {
+    Actor string
+    Inbox string
+    Since time.Time
+}
+
+// Reply is a note someone wrote in reply to one of our posts. Nobody sees it
+// until it's approved.
+type Reply struct {
+    Id         string
+    Actor      string
+    InReplyTo  string
+    URL        string
+    Content    string
+    Published  time.Time
+    AuthorName string
+    AuthorURL  string
+    AuthorIcon string
+    Approved   bool
+}
+
+// Store keeps followers and replies in a JSON file, all in memory, the
+// same as the webmention store.
+type Store struct {
+    Path string
+
+    lock sync.RWMutex
+    data struct {
+        Followers map[string]Follower
+        Replies   map[string]Reply
+    }
+}
+
+// OpenStore loads the store at path, which doesn't have to exist yet, and
+// makes it the one RepliesTo looks in.
+func OpenStore(path string) (*Store, error) {
+    s := &Store{Path: path}
+    data, err := ioutil.ReadFile(path)
+    switch {
+    case os.IsNotExist(err):
+    case err != nil:
+        return nil, err
+    default:
+        if err := json.Unmarshal(data, &s.data); err != nil {
+            return nil, err
+        }
+    }
+    if s.data.Followers == nil {
+        s.data.Followers = make(map[string]Follower)
+    }
+    if s.data.Replies == nil {
+        s.data.Replies = make(map[string]Reply)
+    }
+    lock.Lock()
+    current = s
+    lock.Unlock()
+    return s, nil
+}
+
+// RepliesTo returns the replies to a post from the store that was opened
+// last.
+func RepliesTo(id string) []*Reply {
+    lock.RLock()
+    s := current
+    lock.RUnlock()
+    if s == nil {
+        return nil
+    }
+    return s.Replies(id)
+}
+
+func (s *Store) update(f func()) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    f()
+    data, err := json.MarshalIndent(&s.data, "", "  ")
+    if err != nil {
+        return err
+    }
+    return atomicfile.WriteFile(s.Path, data, 0644)
+}
+
+func (s *Store) Follow(f Follower) error {
+    return s.update(func() { s.data.Followers[f.Actor] = f })
+}
+
+func (s *Store) Unfollow(actor string) error {
+    return s.update(func() { delete(s.data.Followers, actor) })
+}
+
+// Followers returns everyone following, oldest first.
+func (s *Store) Followers() []Follower {
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    followers := make([]Follower, 0, len(s.data.Followers))
+    for _, f := range s.data.Followers {
+        followers = append(followers, f)
+    }
+    sort.Sort(bySince(followers))
+    return followers
+}
+
+// Inboxes returns where to deliver to reach every follower, once each.
+func (s *Store) Inboxes() []string {
+    seen := make(map[string]bool)
+    var inboxes []string
+    for _, f := range s.Followers() {
+        if !seen[f.Inbox] {
+            seen[f.Inbox] = true
+            inboxes = append(inboxes, f.Inbox)
+        }
+    }
+    return inboxes
+}
+
+// AddReply keeps a reply for moderating. Sending it again with changes
+// means approving it again.
+func (s *Store) AddReply(r Reply) error {
+    return s.update(func() {
+        if old, ok := s.data.Replies[r.Id]; ok && old.Content == r.Content {
+            r.Approved = old.Approved
+        }
+        s.data.Replies[r.Id] = r
+    })
+}
+
+// ApproveReply lets a reply show up on its post.
+func (s *Store) ApproveReply(id string) error {
+    return s.update(func() {
+        if r, ok := s.data.Replies[id]; ok {
+            r.Approved = true
+            s.data.Replies[id] = r
+        }
+    })
+}
+
+// RemoveReply throws a reply away, whoever wrote it.
+func (s *Store) RemoveReply(id string) error {
+    return s.update(func() { delete(s.data.Replies, id) })
+}
+
+// DeleteReply removes a reply, but only for the author who wrote it.
+func (s *Store) DeleteReply(id, author string) error {
+    s.lock.RLock()
+    r, ok := s.data.Replies[id]
+    s.lock.RUnlock()
+    if !ok || r.Actor != author {
+        return nil
+    }
+    return s.update(func() { delete(s.data.Replies, id) })
+}
+
+// Replies returns the approved replies to a post, oldest first.
+func (s *Store) Replies(inReplyTo string) []*Reply {
+    return s.replies(func(r *Reply) bool { return r.Approved && r.InReplyTo == inReplyTo })
+}
+
+// Pending returns the replies waiting to be approved, oldest first.
+func (s *Store) Pending() []*Reply {
+    return s.replies(func(r *Reply) bool { return !r.Approved })
+}
+
+func (s *Store) replies(keep func(*Reply) bool) []*Reply {
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    var replies []*Reply
+    for _, r := range s.data.Replies {
+        r := r
+        if keep(&r) {
+            replies = append(replies, &r)
+        }
+    }
+    sort.Sort(byPublished(replies))
+    return replies
+}
+
+type bySince []Follower
+
+func (b bySince) Len() int           { return len(b) }
+func (b bySince) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b bySince) Less(i, j int) bool { return b[i].Since.Before(b[j].Since) }
+
+type byPublished []*Reply
+
+func (b byPublished) Len() int           { return len(b) }
+func (b byPublished) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byPublished) Less(i, j int) bool { return b[i].Published.Before(b[j].Published) }
@@ -0,0 +1,240 @@
+// Package analytics counts page views on the server, so there's nothing for
+// browsers to load or block. There are no cookies, addresses are only kept
+// as a hash that's good for a day, and what's written down is counts by the
+// hour.
+package analytics
+
+import (
+    "logging"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+)
+
+var logger = logging.New("analytics")
+
+// Hit is one page view.
+type Hit struct {
+    Time time.Time
+    // Path is the page, and Host is the site it's on, to tell links from
+    // other pages here from ones from other sites.
+    Path, Host      string
+    Addr, UserAgent string
+    Referrer        string
+}
+
+// Handler counts the pages Handler serves to Store. Only GETs of HTML that
+// worked count.
+type Handler struct {
+    http.Handler
+    Store *Store
+    // Ignore are paths not to count, along with everything under them.
+    Ignore []string
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" || h.ignored(r.URL.Path) {
+        h.Handler.ServeHTTP(w, r)
+        return
+    }
+    rec := &recorder{ResponseWriter: w}
+    h.Handler.ServeHTTP(rec, r)
+    if rec.status != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+        return
+    }
+    h.Store.Record(&Hit{
+        Time:      time.Now(),
+        Path:      cleanPath(r.URL.Path),
+        Host:      r.Host,
+        Addr:      logging.RemoteAddr(r),
+        UserAgent: r.UserAgent(),
+        Referrer:  r.Referer(),
+    })
+}
+
+func (h Handler) ignored(path string) bool {
+    for _, prefix := range h.Ignore {
+        if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+            return true
+        }
+    }
+    return false
+}
+
+// cleanPath makes the different ways to ask for a page count as one.
+func cleanPath(path string) string {
+    if len(path) > 1 {
+        path = strings.TrimSuffix(path, "/")
+    }
+    return path
+}
+
+// recorder notices the status of a response as it goes by.
+type recorder struct {
+    http.ResponseWriter
+    status int
+}
+
+func (r *recorder) WriteHeader(status int) {
+    if r.status == 0 {
+        r.status = status
+    }
+    r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+    if r.status == 0 {
+        r.status = http.StatusOK
+    }
+    return r.ResponseWriter.Write(p)
+}
+
+func (r *recorder) Flush() {
+    if f, ok := r.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+// Period is the views in an hour or a day, for drawing a chart. Percent is
+// of the busiest period in the summary.
+type Period struct {
+    Start time.Time
+    Count
+    Percent int
+}
+
+// PageCount is the views for one page.
+type PageCount struct {
+    Path string
+    Count
+}
+
+// NameCount is the views for a kind of source, or a referring site.
+type NameCount struct {
+    Name  string
+    Views int
+}
+
+// Summary adds up buckets.
+type Summary struct {
+    Count
+    Bots int
+    // Periods are hours for a summary of two days or less, and days
+    // otherwise.
+    Periods   []*Period
+    Pages     []*PageCount
+    Sources   []*NameCount
+    Referrers []*NameCount
+}
+
+// Summarize adds up the hours from from up to to.
+func (s *Store) Summarize(from, to time.Time) (*Summary, error) {
+    buckets, err := s.Buckets(from, to)
+    if err != nil {
+        return nil, err
+    }
+    sum := new(Summary)
+    step := time.Hour
+    if to.Sub(from) > 48*time.Hour {
+        step = 24 * time.Hour
+    }
+    periods := make(map[time.Time]*Period)
+    pages := make(map[string]*PageCount)
+    sources, referrers := make(map[string]int), make(map[string]int)
+    for _, b := range buckets {
+        sum.Views += b.Views
+        sum.Visitors += b.Visitors
+        sum.Bots += b.Bots
+        start := b.Hour.Truncate(step)
+        p, ok := periods[start]
+        if !ok {
+            p = &Period{Start: start}
+            periods[start] = p
+            sum.Periods = append(sum.Periods, p)
+        }
+        p.Views += b.Views
+        p.Visitors += b.Visitors
+        for path, count := range b.Pages {
+            page, ok := pages[path]
+            if !ok {
+                page = &PageCount{Path: path}
+                pages[path] = page
+                sum.Pages = append(sum.Pages, page)
+            }
+            page.Views += count.Views
+            page.Visitors += count.Visitors
+        }
+        for kind, n := range b.Sources {
+            sources[kind] += n
+        }
+        for host, n := range b.Referrers {
+            referrers[host] += n
+        }
+    }
+
+    busiest := 0
+    for _, p := range sum.Periods {
+        if p.Views > busiest {
+            busiest = p.Views
+        }
+    }
+    for _, p := range sum.Periods {
+        if busiest > 0 {
+            p.Percent = 100 * p.Views / busiest
+        }
+    }
+    sort.Sort(byViews(sum.Pages))
+    sum.Sources = ranked(sources)
+    sum.Referrers = ranked(referrers)
+    return sum, nil
+}
+
+// Popular are the n most viewed pages from from up to to that keep says to.
+func (s *Store) Popular(from, to time.Time, n int, keep func(path string) bool) ([]*PageCount, error) {
+    sum, err := s.Summarize(from, to)
+    if err != nil {
+        return nil, err
+    }
+    var popular []*PageCount
+    for _, page := range sum.Pages {
+        if len(popular) == n {
+            break
+        }
+        if keep == nil || keep(page.Path) {
+            popular = append(popular, page)
+        }
+    }
+    return popular, nil
+}
+
+func ranked(counts map[string]int) []*NameCount {
+    names := make([]*NameCount, 0, len(counts))
+    for name, views := range counts {
+        names = append(names, &NameCount{name, views})
+    }
+    sort.Sort(bySize(names))
+    return names
+}
+
+type byViews []*PageCount
+
+func (b byViews) Len() int      { return len(b) }
+func (b byViews) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byViews) Less(i, j int) bool {
+    if b[i].Views == b[j].Views {
+        return b[i].Path < b[j].Path
+    }
+    return b[i].Views > b[j].Views
+}
+
+type bySize []*NameCount
+
+func (b bySize) Len() int      { return len(b) }
+func (b bySize) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b bySize) Less(i, j int) bool {
+    if b[i].Views == b[j].Views {
+        return b[i].Name < b[j].Name
+    }
+    return b[i].Views > b[j].Views
+}
@@ -0,0 +1,178 @@
+package analytics_test
+
+import (
+    "analytics"
+    "config"
+    "io/ioutil"
+    . "launchpad.net/gocheck"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type AnalyticsSuite struct {
+    dir string
+}
+
+var _ = Suite(&AnalyticsSuite{})
+
+const browser = "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0"
+
+func (s *AnalyticsSuite) SetUpTest(c *C) {
+    s.dir = c.MkDir()
+}
+
+func (s *AnalyticsSuite) TestIsBot(c *C) {
+    c.Check(analytics.IsBot(browser), Equals, false)
+    for _, ua := range []string{"", "Googlebot/2.1 (+http://www.google.com/bot.html)", "curl/8.4.0", "Feedly/1.0", "facebookexternalhit/1.1"} {
+        c.Check(analytics.IsBot(ua), Equals, true)
+    }
+}
+
+func (s *AnalyticsSuite) TestClassify(c *C) {
+    host := "verboselogging.com"
+    for referrer, source := range map[string]analytics.Source{
+        "":                                       {analytics.Direct, ""},
+        "not a link":                             {analytics.Direct, ""},
+        "http://www.verboselogging.com/archive":  {analytics.Internal, "verboselogging.com"},
+        "https://www.google.co.uk/":              {analytics.Search, "google.co.uk"},
+        "https://duckduckgo.com/?q=go":           {analytics.Search, "duckduckgo.com"},
+        "https://t.co/abc":                       {analytics.Social, "t.co"},
+        "https://old.reddit.com/r/golang":        {analytics.Social, "old.reddit.com"},
+        "https://mastodon.social/@jane":          {analytics.Social, "mastodon.social"},
+        "https://blog.example.com:8080/links":    {analytics.Other, "blog.example.com"},
+        "https://notgoogle.example.com/":         {analytics.Other, "notgoogle.example.com"},
+        "https://www.bing.com.evil.example.com/": {analytics.Other, "bing.com.evil.example.com"},
+    } {
+        c.Check(analytics.Classify(referrer, host), Equals, source)
+    }
+}
+
+func (s *AnalyticsSuite) TestStore(c *C) {
+    store, err := analytics.Open(s.dir)
+    c.Assert(err, IsNil)
+    day := time.Date(2013, 5, 1, 9, 15, 0, 0, time.UTC)
+    hit := func(t time.Time, path, addr, ua, referrer string) {
+        store.Record(&analytics.Hit{Time: t, Path: path, Host: "verboselogging.com", Addr: addr, UserAgent: ua, Referrer: referrer})
+    }
+    hit(day, "/", "192.0.2.1", browser, "https://www.google.com/")
+    hit(day.Add(time.Minute), "/about", "192.0.2.1", browser, "http://verboselogging.com/")
+    hit(day.Add(time.Hour), "/", "192.0.2.1", browser, "")
+    hit(day.Add(time.Hour), "/", "192.0.2.2", browser, "https://news.ycombinator.com/item?id=1")
+    hit(day.Add(time.Hour), "/", "192.0.2.3", "Googlebot/2.1", "")
+    // The next day, the same person is somebody new.
+    hit(day.Add(24*time.Hour), "/about", "192.0.2.1", browser, "")
+
+    data, err := ioutil.ReadFile(filepath.Join(s.dir, "2013-05-01.json"))
+    c.Assert(err, IsNil)
+    c.Check(strings.Contains(string(data), "192.0.2"), Equals, false)
+
+    buckets, err := store.Buckets(day, day.Add(2*time.Hour))
+    c.Assert(err, IsNil)
+    c.Assert(buckets, HasLen, 2)
+    c.Check(buckets[0].Hour, Equals, day.Truncate(time.Hour))
+    c.Check(buckets[0].Count, Equals, analytics.Count{Views: 2, Visitors: 1})
+    c.Check(buckets[1].Count, Equals, analytics.Count{Views: 2, Visitors: 1})
+    c.Check(buckets[1].Bots, Equals, 1)
+
+    sum, err := store.Summarize(day.Add(-time.Hour), day.Add(25*time.Hour))
+    c.Assert(err, IsNil)
+    c.Check(sum.Count, Equals, analytics.Count{Views: 5, Visitors: 3})
+    c.Check(sum.Bots, Equals, 1)
+    c.Assert(sum.Pages, HasLen, 2)
+    c.Check(*sum.Pages[0], Equals, analytics.PageCount{Path: "/", Count: analytics.Count{Views: 3, Visitors: 2}})
+    c.Check(*sum.Pages[1], Equals, analytics.PageCount{Path: "/about", Count: analytics.Count{Views: 2, Visitors: 2}})
+    c.Check(sum.Sources, DeepEquals, []*analytics.NameCount{{Name: "direct", Views: 2}, {Name: "internal", Views: 1}, {Name: "search", Views: 1}, {Name: "social", Views: 1}})
+    c.Check(sum.Referrers, DeepEquals, []*analytics.NameCount{{Name: "google.com", Views: 1}, {Name: "news.ycombinator.com", Views: 1}})
+    c.Assert(sum.Periods, HasLen, 3)
+    c.Check(sum.Periods[0].Percent, Equals, 100)
+    c.Check(sum.Periods[2].Percent, Equals, 50)
+
+    popular, err := store.Popular(day, day.Add(48*time.Hour), 1, func(path string) bool { return path != "/" })
+    c.Assert(err, IsNil)
+    c.Assert(popular, HasLen, 1)
+    c.Check(popular[0].Path, Equals, "/about")
+
+    // What's in memory gets written down, and read back in after a restart.
+    c.Assert(store.Flush(), IsNil)
+    store, err = analytics.Open(s.dir)
+    c.Assert(err, IsNil)
+    hit(day.Add(24*time.Hour+time.Minute), "/about", "192.0.2.4", browser, "")
+    sum, err = store.Summarize(day.Add(24*time.Hour), day.Add(48*time.Hour))
+    c.Assert(err, IsNil)
+    c.Check(sum.Count, Equals, analytics.Count{Views: 2, Visitors: 2})
+}
+
+func (s *AnalyticsSuite) TestTrending(c *C) {
+    store, err := analytics.Open(s.dir)
+    c.Assert(err, IsNil)
+    now := time.Date(2013, 5, 8, 12, 0, 0, 0, time.UTC)
+    views := func(t time.Time, path string, n int) {
+        for i := 0; i < n; i++ {
+            store.Record(&analytics.Hit{Time: t, Path: path, Addr: "192.0.2.1", UserAgent: browser})
+        }
+    }
+    // The old favourite has more views, but they're from days ago.
+    views(now.Add(-72*time.Hour), "/old-favourite", 10)
+    views(now.Add(-2*time.Hour), "/new-hotness", 4)
+    views(now.Add(-time.Hour), "/skipped", 20)
+
+    keep := func(path string) bool { return path != "/skipped" }
+    trending, err := store.Trending(now.Add(-7*24*time.Hour), now, 24*time.Hour, 5, keep)
+    c.Assert(err, IsNil)
+    c.Assert(trending, HasLen, 2)
+    c.Check(trending[0].Path, Equals, "/new-hotness")
+    c.Check(trending[1].Path, Equals, "/old-favourite")
+    c.Check(trending[1].Score > 1 && trending[1].Score < 1.5, Equals, true)
+
+    popular, err := store.Popular(now.Add(-7*24*time.Hour), now, 1, keep)
+    c.Assert(err, IsNil)
+    c.Check(popular[0].Path, Equals, "/old-favourite")
+
+    trending, err = store.Trending(now.Add(-7*24*time.Hour), now, 24*time.Hour, 1, nil)
+    c.Assert(err, IsNil)
+    c.Assert(trending, HasLen, 1)
+    c.Check(trending[0].Path, Equals, "/skipped")
+}
+
+func (s *AnalyticsSuite) TestHandler(c *C) {
+    store, err := analytics.Open(s.dir)
+    c.Assert(err, IsNil)
+    mux := http.NewServeMux()
+    mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/missing":
+            http.NotFound(w, r)
+        case "/feed":
+            w.Header().Set("Content-Type", "application/rss+xml")
+            w.Write([]byte("<rss/>"))
+        default:
+            w.Header().Set("Content-Type", "text/html; charset=utf-8")
+            w.Write([]byte("<p>Hi</p>"))
+        }
+    })
+    h := analytics.Handler{Handler: mux, Store: store, Ignore: []string{"/admin"}}
+    config.TrustProxy = "yes"
+    defer func() { config.TrustProxy = "no" }()
+    for _, path := range []string{"/2013/05/01/post/", "/2013/05/01/post?utm_source=x", "/missing", "/feed", "/admin", "/admin/posts/new", "/administrivia"} {
+        req, _ := http.NewRequest("GET", "http://verboselogging.com"+path, nil)
+        req.RemoteAddr = "10.0.0.1:1234"
+        req.Header.Set("X-Forwarded-For", "192.0.2.1, 10.0.0.2")
+        req.Header.Set("User-Agent", browser)
+        h.ServeHTTP(httptest.NewRecorder(), req)
+    }
+    req, _ := http.NewRequest("POST", "http://verboselogging.com/2013/05/01/post", nil)
+    h.ServeHTTP(httptest.NewRecorder(), req)
+
+    sum, err := store.Summarize(time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+    c.Assert(err, IsNil)
+    c.Check(sum.Count, Equals, analytics.Count{Views: 3, Visitors: 1})
+    c.Assert(sum.Pages, HasLen, 2)
+    c.Check(*sum.Pages[0], Equals, analytics.PageCount{Path: "/2013/05/01/post", Count: analytics.Count{Views: 2, Visitors: 1}})
+    c.Check(sum.Pages[1].Path, Equals, "/administrivia")
+}
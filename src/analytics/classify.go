@@ -0,0 +1,93 @@
+package analytics
+
+import (
+    "net/url"
+    "strings"
+)
+
+// botMarks are bits of user agents that mean it's a program, not a person.
+var botMarks = []string{
+    "bot", "crawl", "spider", "slurp", "archiver", "fetch", "scan", "monitor",
+    "curl", "wget", "python", "go-http-client", "java/", "ruby", "perl", "php",
+    "headless", "phantomjs", "facebookexternalhit", "embedly", "preview",
+    "feed", "rss", "lighthouse", "pingdom", "uptime",
+}
+
+// IsBot guesses whether userAgent is a program. No user agent at all counts,
+// since browsers always send one.
+func IsBot(userAgent string) bool {
+    ua := strings.ToLower(strings.TrimSpace(userAgent))
+    if ua == "" {
+        return true
+    }
+    for _, mark := range botMarks {
+        if strings.Contains(ua, mark) {
+            return true
+        }
+    }
+    return false
+}
+
+// Kinds of places people come from.
+const (
+    Direct   = "direct"
+    Internal = "internal"
+    Search   = "search"
+    Social   = "social"
+    Other    = "other"
+)
+
+var searchEngines = []string{
+    "google.", "bing.com", "duckduckgo.com", "search.yahoo.", "yandex.",
+    "baidu.com", "ecosia.org", "kagi.com", "startpage.com", "search.brave.com",
+}
+
+var socialSites = []string{
+    "t.co", "twitter.com", "x.com", "facebook.com", "linkedin.com",
+    "reddit.com", "news.ycombinator.com", "lobste.rs", "mastodon.",
+    "bsky.app", "tumblr.com", "pinterest.",
+}
+
+// Source is where a view came from: the kind of place, and its host without
+// the www.
+type Source struct {
+    Kind, Host string
+}
+
+// Classify sorts a Referer header, for a request to host. Referers that
+// aren't URLs are direct, since there's nothing to say about them.
+func Classify(referrer, host string) Source {
+    u, err := url.Parse(strings.TrimSpace(referrer))
+    if err != nil || u.Host == "" {
+        return Source{Kind: Direct}
+    }
+    from := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+    if i := strings.LastIndex(from, ":"); i >= 0 {
+        from = from[:i]
+    }
+    if from == strings.TrimPrefix(strings.ToLower(host), "www.") {
+        return Source{Internal, from}
+    }
+    if matches(from, searchEngines) {
+        return Source{Search, from}
+    }
+    if matches(from, socialSites) {
+        return Source{Social, from}
+    }
+    return Source{Other, from}
+}
+
+// matches is whether host is one of sites, or under one. Sites ending in a
+// dot match any top level domain.
+func matches(host string, sites []string) bool {
+    for _, site := range sites {
+        if strings.HasSuffix(site, ".") {
+            if strings.HasPrefix(host, site) || strings.Contains(host, "."+site) {
+                return true
+            }
+        } else if host == site || strings.HasSuffix(host, "."+site) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,258 @@
+package analytics
+
+import (
+    "atomicfile"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+)
+
+const dateFormat = "2006-01-02"
+
+// Count is how many times something was looked at, and by how many
+// visitors. A visitor is only counted once a day, in the hour they showed
+// up, since that's as long as their hash means anything.
+type Count struct {
+    Views, Visitors int
+}
+
+// Bucket is one hour of views.
+type Bucket struct {
+    Hour time.Time
+    Count
+    Bots int
+    // Pages is keyed by path.
+    Pages map[string]*Count
+    // Sources is views by kind of place they came from, and Referrers is
+    // views by the site, for the ones that came from another site.
+    Sources   map[string]int
+    Referrers map[string]int
+}
+
+func newBucket(hour time.Time) *Bucket {
+    return &Bucket{
+        Hour:      hour,
+        Pages:     make(map[string]*Count),
+        Sources:   make(map[string]int),
+        Referrers: make(map[string]int),
+    }
+}
+
+// Store keeps a JSON file of hourly buckets for each day in Dir. Today's is
+// kept in memory and written out by Flush.
+//
+// Nothing that could pick out a person is kept: addresses are hashed with a
+// salt that's made up fresh each day and never written down, so yesterday's
+// hashes can't be matched to anybody, not even by whoever has the files.
+// That also means a restart forgets who's been seen today, and they count
+// again.
+type Store struct {
+    Dir     string
+    lock    sync.Mutex
+    today   string
+    buckets map[int]*Bucket
+    salt    []byte
+    seen    map[string]bool
+    dirty   bool
+}
+
+// Open uses dir for the store, making it if it's not there.
+func Open(dir string) (*Store, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, err
+    }
+    return &Store{Dir: dir}, nil
+}
+
+// Record counts a hit.
+func (s *Store) Record(h *Hit) {
+    t := h.Time.UTC()
+    date := t.Format(dateFormat)
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    switch {
+    case date > s.today:
+        s.rotate(date)
+    case date < s.today:
+        // Just after midnight, a hit can lose the race for the lock. It goes
+        // in today.
+        t, _ = time.Parse(dateFormat, s.today)
+    }
+    b, ok := s.buckets[t.Hour()]
+    if !ok {
+        b = newBucket(t.Truncate(time.Hour))
+        s.buckets[t.Hour()] = b
+    }
+    s.dirty = true
+    if IsBot(h.UserAgent) {
+        b.Bots++
+        return
+    }
+
+    page, ok := b.Pages[h.Path]
+    if !ok {
+        page = new(Count)
+        b.Pages[h.Path] = page
+    }
+    b.Views++
+    page.Views++
+    visitor := s.visitor(h.Addr, h.UserAgent)
+    if !s.seen[visitor] {
+        s.seen[visitor] = true
+        b.Visitors++
+    }
+    if key := visitor + " " + h.Path; !s.seen[key] {
+        s.seen[key] = true
+        page.Visitors++
+    }
+
+    from := Classify(h.Referrer, h.Host)
+    b.Sources[from.Kind]++
+    if from.Kind != Direct && from.Kind != Internal {
+        b.Referrers[from.Host]++
+    }
+}
+
+// visitor is a hash of somebody that's only good for today. The lock must
+// be held.
+func (s *Store) visitor(addr, userAgent string) string {
+    hash := sha256.New()
+    hash.Write(s.salt)
+    hash.Write([]byte(addr))
+    hash.Write([]byte{0})
+    hash.Write([]byte(userAgent))
+    return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+// rotate writes out the day that's done and starts date, with a new salt.
+// The lock must be held.
+func (s *Store) rotate(date string) {
+    if err := s.flush(); err != nil {
+        logger.Printf("failed writing %s: %s", s.today, err)
+    }
+    s.today = date
+    s.buckets = make(map[int]*Bucket)
+    buckets, err := s.load(date)
+    if err != nil {
+        logger.Printf("failed reading %s, starting it over: %s", date, err)
+    }
+    for _, b := range buckets {
+        s.buckets[b.Hour.Hour()] = b
+    }
+    s.salt = make([]byte, 32)
+    if _, err := rand.Read(s.salt); err != nil {
+        panic(err)
+    }
+    s.seen = make(map[string]bool)
+    s.dirty = false
+}
+
+// Flush writes today out, if anything's changed.
+func (s *Store) Flush() error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    return s.flush()
+}
+
+func (s *Store) flush() error {
+    if !s.dirty {
+        return nil
+    }
+    buckets := make([]*Bucket, 0, len(s.buckets))
+    for _, b := range s.buckets {
+        buckets = append(buckets, b)
+    }
+    sort.Sort(byHour(buckets))
+    data, err := json.MarshalIndent(buckets, "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := atomicfile.WriteFile(s.path(s.today), data, 0600); err != nil {
+        return err
+    }
+    s.dirty = false
+    return nil
+}
+
+func (s *Store) path(date string) string {
+    return filepath.Join(s.Dir, date+".json")
+}
+
+func (s *Store) load(date string) ([]*Bucket, error) {
+    data, err := ioutil.ReadFile(s.path(date))
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var buckets []*Bucket
+    if err := json.Unmarshal(data, &buckets); err != nil {
+        return nil, err
+    }
+    return buckets, nil
+}
+
+// Buckets are the hours from from up to to, oldest first. Hours nobody
+// looked at aren't there.
+func (s *Store) Buckets(from, to time.Time) ([]*Bucket, error) {
+    from, to = from.UTC().Truncate(time.Hour), to.UTC()
+    var buckets []*Bucket
+    for day := from.Truncate(24 * time.Hour); day.Before(to); day = day.Add(24 * time.Hour) {
+        those, err := s.day(day.Format(dateFormat))
+        if err != nil {
+            return nil, err
+        }
+        for _, b := range those {
+            if !b.Hour.Before(from) && b.Hour.Before(to) {
+                buckets = append(buckets, b)
+            }
+        }
+    }
+    sort.Sort(byHour(buckets))
+    return buckets, nil
+}
+
+// day is the buckets for date, from memory if it's today.
+func (s *Store) day(date string) ([]*Bucket, error) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if date != s.today {
+        return s.load(date)
+    }
+    buckets := make([]*Bucket, 0, len(s.buckets))
+    for _, b := range s.buckets {
+        buckets = append(buckets, b.copy())
+    }
+    return buckets, nil
+}
+
+// copy is a bucket that's safe to use without the lock.
+func (b *Bucket) copy() *Bucket {
+    c := newBucket(b.Hour)
+    c.Count, c.Bots = b.Count, b.Bots
+    for path, count := range b.Pages {
+        page := *count
+        c.Pages[path] = &page
+    }
+    for kind, n := range b.Sources {
+        c.Sources[kind] = n
+    }
+    for host, n := range b.Referrers {
+        c.Referrers[host] = n
+    }
+    return c
+}
+
+type byHour []*Bucket
+
+func (b byHour) Len() int           { return len(b) }
+func (b byHour) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byHour) Less(i, j int) bool { return b[i].Hour.Before(b[j].Hour) }
@@ -0,0 +1,62 @@
+package analytics
+
+import (
+    "math"
+    "sort"
+    "time"
+)
+
+// PageScore is a page and how much it's been read lately.
+type PageScore struct {
+    Path  string
+    Score float64
+}
+
+// Trending are the n pages read the most from from up to to that keep says
+// to, where a view counts half as much for every halfLife it happened before
+// to. A page that's busy now beats one that was busier last week.
+func (s *Store) Trending(from, to time.Time, halfLife time.Duration, n int, keep func(path string) bool) ([]*PageScore, error) {
+    buckets, err := s.Buckets(from, to)
+    if err != nil {
+        return nil, err
+    }
+    scores := make(map[string]*PageScore)
+    var pages []*PageScore
+    for _, b := range buckets {
+        // The middle of the hour is as good a guess as any for when the
+        // views in it happened.
+        age := to.Sub(b.Hour.Add(30 * time.Minute))
+        if age < 0 {
+            age = 0
+        }
+        weight := math.Pow(0.5, float64(age)/float64(halfLife))
+        for path, count := range b.Pages {
+            page, ok := scores[path]
+            if !ok {
+                if keep != nil && !keep(path) {
+                    continue
+                }
+                page = &PageScore{Path: path}
+                scores[path] = page
+                pages = append(pages, page)
+            }
+            page.Score += weight * float64(count.Views)
+        }
+    }
+    sort.Sort(byScore(pages))
+    if len(pages) > n {
+        pages = pages[:n]
+    }
+    return pages, nil
+}
+
+type byScore []*PageScore
+
+func (b byScore) Len() int      { return len(b) }
+func (b byScore) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byScore) Less(i, j int) bool {
+    if b[i].Score == b[j].Score {
+        return b[i].Path < b[j].Path
+    }
+    return b[i].Score > b[j].Score
+}
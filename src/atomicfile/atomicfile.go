@@ -0,0 +1,41 @@
+// Package atomicfile writes files so readers only ever see the old contents
+// or the new ones, never something half written.
+package atomicfile
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+)
+
+// WriteFile is ioutil.WriteFile, except the data goes to a temporary file
+// next to path that's renamed over it once it's all there. Missing
+// directories are created.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+    dir := filepath.Dir(path)
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return err
+    }
+    tmp, err := ioutil.TempFile(dir, ".tmp-")
+    if err != nil {
+        return err
+    }
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        os.Remove(tmp.Name())
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        os.Remove(tmp.Name())
+        return err
+    }
+    if err := os.Chmod(tmp.Name(), perm); err != nil {
+        os.Remove(tmp.Name())
+        return err
+    }
+    if err := os.Rename(tmp.Name(), path); err != nil {
+        os.Remove(tmp.Name())
+        return err
+    }
+    return nil
+}
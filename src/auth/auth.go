@@ -0,0 +1,233 @@
+// Package auth checks who's signing in: bcrypt password hashes, an
+// optional TOTP second factor, and throttling for whoever keeps getting it
+// wrong.
+package auth
+
+import (
+    "code.google.com/p/go.crypto/bcrypt"
+    "crypto/hmac"
+    "crypto/sha1"
+    "crypto/subtle"
+    "encoding/base32"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    // TOTPStep is how long a code lasts.
+    TOTPStep = 30 * time.Second
+    // TOTPDigits is how long a code is.
+    TOTPDigits = 6
+)
+
+var (
+    ErrBadLogin = errors.New("incorrect user name, password or code")
+
+    // dummyHash is checked against when there's no such user, so it takes
+    // just as long to find that out.
+    dummyHash     []byte
+    dummyHashOnce sync.Once
+
+    // usedSteps is the last step a code was taken for, by user name, so
+    // someone who sees a code go by can't sign in with it too.
+    usedSteps     = make(map[string]int64)
+    usedStepsLock sync.Mutex
+)
+
+// User is someone who can sign in.
+type User struct {
+    Name string
+    Hash []byte
+    // TOTPSecret is base32, the way authenticator apps take it. Without one
+    // there's no second factor.
+    TOTPSecret string
+}
+
+// Users are everybody who can sign in, by name.
+type Users map[string]*User
+
+// ParseUsers reads users written as name:hash, or name:hash:secret with a
+// TOTP secret, separated by spaces or commas.
+func ParseUsers(s string) (Users, error) {
+    users := make(Users)
+    fields := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\t' })
+    for _, field := range fields {
+        parts := strings.Split(field, ":")
+        if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+            return nil, fmt.Errorf("can't read the user %#v", field)
+        }
+        if _, err := bcrypt.Cost([]byte(parts[1])); err != nil {
+            return nil, fmt.Errorf("the hash for %s isn't bcrypt: %s", parts[0], err)
+        }
+        u := &User{Name: parts[0], Hash: []byte(parts[1])}
+        if len(parts) == 3 {
+            u.TOTPSecret = parts[2]
+            if _, err := decodeSecret(u.TOTPSecret); err != nil {
+                return nil, fmt.Errorf("the TOTP secret for %s isn't base32: %s", u.Name, err)
+            }
+        }
+        users[u.Name] = u
+    }
+    return users, nil
+}
+
+// HashPassword is what goes in the config for a password.
+func HashPassword(password string) (string, error) {
+    hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    return string(hash), err
+}
+
+// Authenticate checks the password, and the code if the user needs one. A
+// code only works once, and none from before it work after. Every way of
+// getting it wrong is the same error.
+func (users Users) Authenticate(name, password, code string, now time.Time) (*User, error) {
+    u, ok := users[name]
+    if !ok {
+        dummyHashOnce.Do(func() {
+            dummyHash, _ = bcrypt.GenerateFromPassword([]byte("nobody"), bcrypt.DefaultCost)
+        })
+        bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+        return nil, ErrBadLogin
+    }
+    if bcrypt.CompareHashAndPassword(u.Hash, []byte(password)) != nil {
+        return nil, ErrBadLogin
+    }
+    if u.TOTPSecret != "" {
+        step, ok := codeStep(u.TOTPSecret, code, now)
+        if !ok || !useStep(u.Name, step) {
+            return nil, ErrBadLogin
+        }
+    }
+    return u, nil
+}
+
+// useStep takes step for name, unless it or a later one already was.
+func useStep(name string, step int64) bool {
+    usedStepsLock.Lock()
+    defer usedStepsLock.Unlock()
+    if last, ok := usedSteps[name]; ok && step <= last {
+        return false
+    }
+    usedSteps[name] = step
+    return true
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+    secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+    if n := len(secret) % 8; n != 0 {
+        secret += strings.Repeat("=", 8-n)
+    }
+    return base32.StdEncoding.DecodeString(secret)
+}
+
+// Code is the TOTP code for secret at t, from RFC 6238.
+func Code(secret string, t time.Time) (string, error) {
+    key, err := decodeSecret(secret)
+    if err != nil {
+        return "", err
+    }
+    return code(key, t.Unix()/int64(TOTPStep/time.Second)), nil
+}
+
+func code(key []byte, counter int64) string {
+    var msg [8]byte
+    binary.BigEndian.PutUint64(msg[:], uint64(counter))
+    hm := hmac.New(sha1.New, key)
+    hm.Write(msg[:])
+    sum := hm.Sum(nil)
+    offset := sum[len(sum)-1] & 0xf
+    n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+    return fmt.Sprintf("%0*d", TOTPDigits, n%1000000)
+}
+
+// CheckCode tells whether code is right for secret at now, allowing a step
+// either way for clocks that are a bit off.
+func CheckCode(secret, given string, now time.Time) bool {
+    _, ok := codeStep(secret, given, now)
+    return ok
+}
+
+// codeStep is CheckCode, along with the step the code was for. If it's right
+// for more than one, it's the latest.
+func codeStep(secret, given string, now time.Time) (int64, bool) {
+    key, err := decodeSecret(secret)
+    given = strings.Replace(given, " ", "", -1)
+    if err != nil || len(given) != TOTPDigits {
+        return 0, false
+    }
+    counter := now.Unix() / int64(TOTPStep/time.Second)
+    var step int64
+    ok := false
+    for i := int64(-1); i <= 1; i++ {
+        if subtle.ConstantTimeCompare([]byte(code(key, counter+i)), []byte(given)) == 1 {
+            step, ok = counter+i, true
+        }
+    }
+    return step, ok
+}
+
+// Throttle counts failures by key, like an address or a user name, and
+// holds off a key that's had too many lately.
+type Throttle struct {
+    max      int
+    window   time.Duration
+    failures map[string][]time.Time
+    lock     sync.Mutex
+}
+
+// NewThrottle allows max failures for a key within window.
+func NewThrottle(max int, window time.Duration) *Throttle {
+    return &Throttle{max: max, window: window, failures: make(map[string][]time.Time)}
+}
+
+// recent drops failures outside the window. The lock has to be held.
+func (t *Throttle) recent(key string, now time.Time) []time.Time {
+    var kept []time.Time
+    for _, at := range t.failures[key] {
+        if now.Sub(at) < t.window {
+            kept = append(kept, at)
+        }
+    }
+    if len(kept) == 0 {
+        delete(t.failures, key)
+    } else {
+        t.failures[key] = kept
+    }
+    return kept
+}
+
+// Wait is how long key has to wait before trying again, zero if it doesn't.
+func (t *Throttle) Wait(key string, now time.Time) time.Duration {
+    t.lock.Lock()
+    defer t.lock.Unlock()
+    recent := t.recent(key, now)
+    if len(recent) < t.max {
+        return 0
+    }
+    return recent[len(recent)-t.max].Add(t.window).Sub(now)
+}
+
+// Failures is how many times key has failed within the window.
+func (t *Throttle) Failures(key string, now time.Time) int {
+    t.lock.Lock()
+    defer t.lock.Unlock()
+    return len(t.recent(key, now))
+}
+
+// Fail counts a failure for key.
+func (t *Throttle) Fail(key string, now time.Time) {
+    t.lock.Lock()
+    defer t.lock.Unlock()
+    t.failures[key] = append(t.recent(key, now), now)
+}
+
+// Reset forgets the failures for key, after it gets it right.
+func (t *Throttle) Reset(key string) {
+    t.lock.Lock()
+    defer t.lock.Unlock()
+    delete(t.failures, key)
+}
@@ -0,0 +1,97 @@
+package auth_test
+
+import (
+    "auth"
+    . "launchpad.net/gocheck"
+    "testing"
+    "time"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type AuthSuite struct{}
+
+var _ = Suite(&AuthSuite{})
+
+// The secret from RFC 6238, "12345678901234567890".
+const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func (s *AuthSuite) TestCode(c *C) {
+    for unix, expected := range map[int64]string{
+        59:          "287082",
+        1111111109:  "081804",
+        1234567890:  "005924",
+        20000000000: "353130",
+    } {
+        code, err := auth.Code(secret, time.Unix(unix, 0))
+        c.Assert(err, IsNil)
+        c.Check(code, Equals, expected)
+    }
+
+    now := time.Unix(1111111109, 0)
+    c.Check(auth.CheckCode(secret, "081804", now), Equals, true)
+    c.Check(auth.CheckCode(secret, "081 804", now.Add(auth.TOTPStep)), Equals, true)
+    c.Check(auth.CheckCode(secret, "081804", now.Add(3*auth.TOTPStep)), Equals, false)
+    c.Check(auth.CheckCode(secret, "", now), Equals, false)
+    c.Check(auth.CheckCode("not base32!", "081804", now), Equals, false)
+}
+
+func (s *AuthSuite) TestAuthenticate(c *C) {
+    hash, err := auth.HashPassword("hunter2")
+    c.Assert(err, IsNil)
+    users, err := auth.ParseUsers("jane:" + hash + ", bob:" + hash + ":" + secret)
+    c.Assert(err, IsNil)
+    c.Assert(users, HasLen, 2)
+
+    now := time.Unix(1234567890, 0)
+    u, err := users.Authenticate("jane", "hunter2", "", now)
+    c.Assert(err, IsNil)
+    c.Check(u.Name, Equals, "jane")
+    _, err = users.Authenticate("jane", "hunter3", "", now)
+    c.Check(err, Equals, auth.ErrBadLogin)
+    _, err = users.Authenticate("nobody", "hunter2", "", now)
+    c.Check(err, Equals, auth.ErrBadLogin)
+
+    _, err = users.Authenticate("bob", "hunter2", "", now)
+    c.Check(err, Equals, auth.ErrBadLogin)
+    u, err = users.Authenticate("bob", "hunter2", "005924", now)
+    c.Assert(err, IsNil)
+    c.Check(u.Name, Equals, "bob")
+    // Codes don't work twice, and older ones don't work at all after.
+    _, err = users.Authenticate("bob", "hunter2", "005924", now)
+    c.Check(err, Equals, auth.ErrBadLogin)
+    previous, _ := auth.Code(secret, now.Add(-auth.TOTPStep))
+    _, err = users.Authenticate("bob", "hunter2", previous, now)
+    c.Check(err, Equals, auth.ErrBadLogin)
+    next, _ := auth.Code(secret, now.Add(auth.TOTPStep))
+    _, err = users.Authenticate("bob", "hunter2", next, now)
+    c.Check(err, IsNil)
+
+    _, err = auth.ParseUsers("jane:plaintext")
+    c.Check(err, ErrorMatches, "the hash for jane isn't bcrypt: .*")
+    _, err = auth.ParseUsers("jane")
+    c.Check(err, ErrorMatches, `can't read the user "jane"`)
+    users, err = auth.ParseUsers("")
+    c.Assert(err, IsNil)
+    c.Check(users, HasLen, 0)
+}
+
+func (s *AuthSuite) TestThrottle(c *C) {
+    t := auth.NewThrottle(3, time.Minute)
+    now := time.Unix(1000, 0)
+    for i := 0; i < 3; i++ {
+        c.Check(t.Wait("1.2.3.4", now), Equals, time.Duration(0))
+        t.Fail("1.2.3.4", now.Add(time.Duration(i)*time.Second))
+    }
+    c.Check(t.Wait("1.2.3.4", now.Add(10*time.Second)), Equals, 50*time.Second)
+    c.Check(t.Wait("5.6.7.8", now), Equals, time.Duration(0))
+    c.Check(t.Failures("1.2.3.4", now.Add(30*time.Second)), Equals, 3)
+    c.Check(t.Wait("1.2.3.4", now.Add(time.Minute)), Equals, time.Duration(0))
+    c.Check(t.Failures("1.2.3.4", now.Add(time.Minute+time.Second)), Equals, 1)
+
+    t.Fail("5.6.7.8", now)
+    t.Fail("5.6.7.8", now)
+    t.Fail("5.6.7.8", now)
+    t.Reset("5.6.7.8")
+    c.Check(t.Wait("5.6.7.8", now), Equals, time.Duration(0))
+}
@@ -0,0 +1,189 @@
+package comments
+
+import (
+    "atomicfile"
+    "encoding/json"
+    "io/ioutil"
+    "math"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "unicode"
+)
+
+const (
+    // SpamThreshold is the score past which a comment goes straight to spam
+    // instead of waiting with the rest.
+    SpamThreshold = 0.9
+    // interesting is how many of the words furthest from neutral get a say.
+    interesting = 15
+)
+
+// Filter is a naive Bayesian spam filter, in the style of Paul Graham's "A
+// Plan for Spam". It knows how many spam and ham comments it's been shown,
+// and how many of each every word turned up in.
+type Filter struct {
+    Path string
+
+    lock sync.RWMutex
+    data filterData
+}
+
+type filterData struct {
+    SpamCount, HamCount int
+    Spam, Ham           map[string]int
+}
+
+// OpenFilter loads what the filter at path has learned so far, which might
+// be nothing.
+func OpenFilter(path string) (*Filter, error) {
+    f := &Filter{Path: path, data: filterData{Spam: make(map[string]int), Ham: make(map[string]int)}}
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        return f, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal(data, &f.data); err != nil {
+        return nil, err
+    }
+    return f, nil
+}
+
+// Tokens are the different words in text, lowercased. Links are kept
+// whole as well as split up, since spam is mostly about where it links.
+func Tokens(text string) []string {
+    seen := make(map[string]bool)
+    var tokens []string
+    add := func(token string) {
+        if len(token) >= 2 && len(token) <= 40 && !seen[token] {
+            seen[token] = true
+            tokens = append(tokens, token)
+        }
+    }
+    for _, field := range strings.Fields(strings.ToLower(text)) {
+        if strings.HasPrefix(field, "http://") || strings.HasPrefix(field, "https://") {
+            host := strings.SplitN(strings.SplitN(field, "//", 2)[1], "/", 2)[0]
+            add("host:" + host)
+        }
+        for _, word := range strings.FieldsFunc(field, func(r rune) bool {
+            return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '$'
+        }) {
+            add(word)
+        }
+    }
+    return tokens
+}
+
+// Train teaches the filter that text is spam, or isn't.
+func (f *Filter) Train(text string, spam bool) error {
+    return f.learn(text, spam, 1)
+}
+
+// Untrain takes back what Train taught, for when a comment was moderated
+// the wrong way the first time.
+func (f *Filter) Untrain(text string, spam bool) error {
+    return f.learn(text, spam, -1)
+}
+
+func (f *Filter) learn(text string, spam bool, n int) error {
+    f.lock.Lock()
+    defer f.lock.Unlock()
+    counts, total := f.data.Ham, &f.data.HamCount
+    if spam {
+        counts, total = f.data.Spam, &f.data.SpamCount
+    }
+    if *total += n; *total < 0 {
+        *total = 0
+    }
+    for _, token := range Tokens(text) {
+        if counts[token] += n; counts[token] <= 0 {
+            delete(counts, token)
+        }
+    }
+    data, err := json.Marshal(f.data)
+    if err != nil {
+        return err
+    }
+    return atomicfile.WriteFile(f.Path, data, 0600)
+}
+
+// probability is how spammy one word is. Words that haven't been seen much
+// are pulled towards neutral, as Gary Robinson suggests.
+func (f *Filter) probability(token string) float64 {
+    spam, ham := f.data.Spam[token], f.data.Ham[token]
+    if spam+ham == 0 {
+        return 0.5
+    }
+    s := float64(spam) / math.Max(float64(f.data.SpamCount), 1)
+    h := float64(ham) / math.Max(float64(f.data.HamCount), 1)
+    p := s / (s + h)
+    n := float64(spam + ham)
+    p = (0.5 + n*p) / (1 + n)
+    return math.Min(math.Max(p, 0.01), 0.99)
+}
+
+type byInterest []float64
+
+func (b byInterest) Len() int           { return len(b) }
+func (b byInterest) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byInterest) Less(i, j int) bool { return math.Abs(b[i]-0.5) > math.Abs(b[j]-0.5) }
+
+// Score is how likely text is to be spam, from 0 to 1. Until it's seen
+// some of both there's no telling, so it's 0.5.
+func (f *Filter) Score(text string) float64 {
+    f.lock.RLock()
+    defer f.lock.RUnlock()
+    if f.data.SpamCount == 0 || f.data.HamCount == 0 {
+        return 0.5
+    }
+    var ps []float64
+    for _, token := range Tokens(text) {
+        ps = append(ps, f.probability(token))
+    }
+    sort.Sort(byInterest(ps))
+    if len(ps) > interesting {
+        ps = ps[:interesting]
+    }
+    // Multiplying lots of small numbers goes to zero, so add logs instead.
+    var spam, ham float64
+    for _, p := range ps {
+        spam += math.Log(p)
+        ham += math.Log(1 - p)
+    }
+    return 1 / (1 + math.Exp(ham-spam))
+}
+
+// Classify scores a new comment, and sends it to spam if it's bad enough.
+func (f *Filter) Classify(c *Comment) {
+    c.Spamminess = f.Score(c.Text())
+    if c.Spamminess >= SpamThreshold {
+        c.Status = Spam
+    }
+}
+
+// Moderate sets the status of a comment and teaches the filter about it,
+// taking back anything it was taught about the comment before. Pending
+// leaves the filter knowing nothing about it.
+func (f *Filter) Moderate(c *Comment, status Status) error {
+    c.Status = status
+    learned := status
+    if learned == Pending {
+        learned = ""
+    }
+    if c.Trained == learned {
+        return nil
+    }
+    if c.Trained != "" {
+        if err := f.Untrain(c.Text(), c.Trained == Spam); err != nil {
+            return err
+        }
+    }
+    c.Trained = learned
+    if learned == "" {
+        return nil
+    }
+    return f.Train(c.Text(), learned == Spam)
+}
@@ -0,0 +1,152 @@
+// Package comments keeps what readers write under posts. Everything waits
+// for moderation, and a spam filter that learns from the moderating gets
+// first look at it.
+package comments
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "logging"
+    "net/url"
+    "strings"
+    "time"
+    "unicode/utf8"
+)
+
+var logger = logging.New("comments")
+
+// MaxLength is the most a comment can say, in characters.
+const MaxLength = 5000
+
+var (
+    ErrNoName   = errors.New("there has to be a name")
+    ErrNoBody   = errors.New("there has to be a comment")
+    ErrTooLong  = errors.New("the comment is too long")
+    ErrBadURL   = errors.New("the web site has to be an http or https URL")
+    ErrBadEmail = errors.New("that doesn't look like an email address")
+    ErrNoParent = errors.New("there's no such comment to reply to")
+)
+
+// Status is where a comment is in moderation.
+type Status string
+
+const (
+    Pending  Status = "pending"
+    Approved Status = "approved"
+    Spam     Status = "spam"
+)
+
+// Comment is one comment on one post.
+type Comment struct {
+    Id     string
+    Target string
+    // Parent is the comment this one replies to, if any.
+    Parent string `json:",omitempty"`
+    Name   string
+    Email  string `json:",omitempty"`
+    URL    string `json:",omitempty"`
+    Body   string
+    Addr   string `json:",omitempty"`
+    Status Status
+    // Spamminess is what the filter thought of it when it came in.
+    Spamminess float64
+    // Trained is what the filter has been taught this comment is, if
+    // anything, so that changing your mind can be untaught.
+    Trained  Status `json:",omitempty"`
+    Received time.Time
+}
+
+// New makes a pending comment out of what somebody filled in, checking
+// that it's all there.
+func New(target, parent, name, email, site, body, addr string) (*Comment, error) {
+    c := &Comment{
+        Id:       newId(),
+        Target:   target,
+        Parent:   parent,
+        Name:     strings.TrimSpace(name),
+        Email:    strings.TrimSpace(email),
+        URL:      strings.TrimSpace(site),
+        Body:     strings.TrimSpace(strings.Replace(body, "\r\n", "\n", -1)),
+        Addr:     addr,
+        Status:   Pending,
+        Received: time.Now().UTC(),
+    }
+    switch {
+    case c.Name == "":
+        return nil, ErrNoName
+    case c.Body == "":
+        return nil, ErrNoBody
+    case utf8.RuneCountInString(c.Body) > MaxLength || len(c.Name) > 100 || len(c.Email) > 200 || len(c.URL) > 500:
+        return nil, ErrTooLong
+    case c.Email != "" && (!strings.Contains(c.Email, "@") || strings.ContainsAny(c.Email, " <>")):
+        return nil, ErrBadEmail
+    }
+    if c.URL != "" {
+        u, err := url.Parse(c.URL)
+        if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            return nil, ErrBadURL
+        }
+    }
+    return c, nil
+}
+
+func newId() string {
+    p := make([]byte, 8)
+    if _, err := rand.Read(p); err != nil {
+        panic(err)
+    }
+    return hex.EncodeToString(p)
+}
+
+// Visible reports whether the comment should show up under the post.
+func (c *Comment) Visible() bool {
+    return c.Status == Approved
+}
+
+// SpamPercent is Spamminess for people.
+func (c *Comment) SpamPercent() int {
+    return int(c.Spamminess*100 + 0.5)
+}
+
+// Text is everything about the comment the spam filter looks at.
+func (c *Comment) Text() string {
+    return strings.Join([]string{c.Name, c.Email, c.URL, c.Body}, "\n")
+}
+
+// Paragraphs splits the body up the way it was typed, since comments are
+// plain text.
+func (c *Comment) Paragraphs() []string {
+    var paragraphs []string
+    for _, p := range strings.Split(c.Body, "\n\n") {
+        if p = strings.TrimSpace(p); p != "" {
+            paragraphs = append(paragraphs, p)
+        }
+    }
+    return paragraphs
+}
+
+// Thread is a comment and the replies to it.
+type Thread struct {
+    *Comment
+    Replies []*Thread
+}
+
+// threads arranges comments, already in order, under what they reply to.
+// A reply to something that isn't there starts its own thread.
+func threads(comments []*Comment) []*Thread {
+    byId := make(map[string]*Thread)
+    for _, c := range comments {
+        byId[c.Id] = &Thread{Comment: c}
+    }
+    var roots []*Thread
+    for _, c := range comments {
+        t := byId[c.Id]
+        if parent, ok := byId[c.Parent]; ok && c.Parent != c.Id {
+            parent.Replies = append(parent.Replies, t)
+        } else {
+            roots = append(roots, t)
+        }
+    }
+    return roots
+}
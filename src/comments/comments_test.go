@@ -0,0 +1,127 @@
+package comments_test
+
+import (
+    "comments"
+    . "launchpad.net/gocheck"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type CommentsSuite struct {
+    dir string
+}
+
+var _ = Suite(&CommentsSuite{})
+
+const target = "http://verboselogging.com/2013/05/01/ruby-batteries-included"
+
+func (s *CommentsSuite) SetUpTest(c *C) {
+    s.dir = c.MkDir()
+}
+
+func (s *CommentsSuite) TestNew(c *C) {
+    comment, err := comments.New(target, "", " Jane ", "jane@example.com", "https://jane.example.com/", "Nice.\r\n\r\nReally.", "192.0.2.1")
+    c.Assert(err, IsNil)
+    c.Check(comment.Name, Equals, "Jane")
+    c.Check(comment.Status, Equals, comments.Pending)
+    c.Check(comment.Id, Matches, "[0-9a-f]{16}")
+    c.Check(comment.Paragraphs(), DeepEquals, []string{"Nice.", "Really."})
+
+    for _, bad := range [][]string{
+        {"", "", "", "Hi", "there has to be a name"},
+        {"Jane", "", "", " ", "there has to be a comment"},
+        {"Jane", "", "javascript:alert(1)", "Hi", "the web site has to be .*"},
+        {"Jane", "jane", "", "Hi", "that doesn't look like .*"},
+    } {
+        _, err := comments.New(target, "", bad[0], bad[1], bad[2], bad[3], "")
+        c.Check(err, ErrorMatches, bad[4])
+    }
+}
+
+func (s *CommentsSuite) TestThreads(c *C) {
+    store, err := comments.Open(filepath.Join(s.dir, "comments.json"))
+    c.Assert(err, IsNil)
+    add := func(parent, name string, status comments.Status) *comments.Comment {
+        comment, err := comments.New(target, parent, name, "", "", "Hello from "+name, "")
+        c.Assert(err, IsNil)
+        c.Assert(store.Add(comment), IsNil)
+        comment.Status = status
+        c.Assert(store.Put(comment), IsNil)
+        time.Sleep(time.Millisecond)
+        return comment
+    }
+    first := add("", "Jane", comments.Approved)
+    reply := add(first.Id, "Bob", comments.Approved)
+    add(reply.Id, "Jane", comments.Approved)
+    add("", "Spammer", comments.Spam)
+    add("", "Carl", comments.Pending)
+    second := add("", "Dana", comments.Approved)
+
+    // Nobody gets to reply to what isn't showing.
+    pending := store.List(comments.Pending)[0]
+    orphan, _ := comments.New(target, pending.Id, "Eve", "", "", "Hi", "")
+    c.Check(store.Add(orphan), Equals, comments.ErrNoParent)
+
+    threads := comments.For(target)
+    c.Assert(threads, HasLen, 2)
+    c.Check(threads[0].Name, Equals, "Jane")
+    c.Assert(threads[0].Replies, HasLen, 1)
+    c.Check(threads[0].Replies[0].Name, Equals, "Bob")
+    c.Assert(threads[0].Replies[0].Replies, HasLen, 1)
+    c.Check(threads[1].Id, Equals, second.Id)
+    c.Check(comments.For("http://example.com/"), HasLen, 0)
+
+    // Taking out a comment leaves its replies.
+    c.Assert(store.Delete(first.Id), IsNil)
+    reopened, err := comments.Open(store.Path)
+    c.Assert(err, IsNil)
+    threads = reopened.For(target)
+    c.Assert(threads, HasLen, 2)
+    c.Check(threads[0].Name, Equals, "Bob")
+    c.Check(reopened.List(comments.Spam), HasLen, 1)
+}
+
+func (s *CommentsSuite) TestFilter(c *C) {
+    path := filepath.Join(s.dir, "spam.json")
+    f, err := comments.OpenFilter(path)
+    c.Assert(err, IsNil)
+    c.Check(f.Score("anything at all"), Equals, 0.5)
+
+    spam := []string{
+        "Cheap pills online, buy viagra now http://pills.example.com/buy",
+        "Buy cheap watches online http://pills.example.com/watches best prices",
+        "Casino bonus, cheap online casino http://casino.example.com/",
+    }
+    ham := []string{
+        "Thanks for the post, the part about Go interfaces really helped.",
+        "I tried this with Ruby too and the batteries included approach works.",
+        "Great talk, do you have the slides for the Go part?",
+    }
+    for _, text := range spam {
+        c.Assert(f.Train(text, true), IsNil)
+    }
+    for _, text := range ham {
+        c.Assert(f.Train(text, false), IsNil)
+    }
+    c.Check(f.Score("buy cheap pills online http://pills.example.com/") > comments.SpamThreshold, Equals, true)
+    c.Check(f.Score("The Go interfaces part was great, thanks") < 0.1, Equals, true)
+
+    // What it learned sticks around.
+    f, err = comments.OpenFilter(path)
+    c.Assert(err, IsNil)
+    comment, _ := comments.New(target, "", "Pills", "", "http://pills.example.com/", "Buy cheap pills online", "")
+    f.Classify(comment)
+    c.Check(comment.Status, Equals, comments.Spam)
+
+    // Changing your mind takes back the first lesson.
+    c.Assert(f.Moderate(comment, comments.Approved), IsNil)
+    c.Check(comment.Trained, Equals, comments.Approved)
+    c.Assert(f.Moderate(comment, comments.Spam), IsNil)
+    c.Check(comment.Trained, Equals, comments.Spam)
+    c.Check(f.Score("The Go interfaces part was great, thanks") < 0.1, Equals, true)
+
+    c.Check(comments.Tokens("Visit http://Spam.example.com/x now, now!"), DeepEquals, []string{"visit", "host:spam.example.com", "http", "spam", "example", "com", "now"})
+}
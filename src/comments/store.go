@@ -0,0 +1,159 @@
+package comments
+
+import (
+    "atomicfile"
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "sort"
+    "sync"
+)
+
+var (
+    lock    sync.RWMutex
+    current *Store
+)
+
+// Store keeps every comment in a JSON file, all in memory, and writes the
+// whole file on each change, like the webmentions.
+type Store struct {
+    Path     string
+    lock     sync.RWMutex
+    comments map[string]Comment
+}
+
+// Open loads the store at path, which doesn't have to exist yet, and makes
+// it the one For looks in.
+func Open(path string) (*Store, error) {
+    s := &Store{Path: path, comments: make(map[string]Comment)}
+    data, err := ioutil.ReadFile(path)
+    switch {
+    case os.IsNotExist(err):
+    case err != nil:
+        return nil, err
+    default:
+        var comments []Comment
+        if err := json.Unmarshal(data, &comments); err != nil {
+            return nil, err
+        }
+        for _, c := range comments {
+            s.comments[c.Id] = c
+        }
+    }
+    lock.Lock()
+    current = s
+    lock.Unlock()
+    return s, nil
+}
+
+// For returns the threads under target that should be shown, from the
+// store that was opened last.
+func For(target string) []*Thread {
+    lock.RLock()
+    s := current
+    lock.RUnlock()
+    if s == nil {
+        return nil
+    }
+    return s.For(target)
+}
+
+// Get returns a copy of a comment.
+func (s *Store) Get(id string) (*Comment, bool) {
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    c, ok := s.comments[id]
+    return &c, ok
+}
+
+// Add puts a new comment in the store. A reply has to be to a comment on
+// the same post that's showing.
+func (s *Store) Add(c *Comment) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if c.Parent != "" {
+        if parent, ok := s.comments[c.Parent]; !ok || parent.Target != c.Target || !parent.Visible() {
+            return ErrNoParent
+        }
+    }
+    s.comments[c.Id] = *c
+    return s.save()
+}
+
+// Put replaces a comment and saves the store.
+func (s *Store) Put(c *Comment) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.comments[c.Id] = *c
+    return s.save()
+}
+
+// Delete removes a comment and saves the store. Replies to it stay, and
+// start their own threads.
+func (s *Store) Delete(id string) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    delete(s.comments, id)
+    return s.save()
+}
+
+// List returns every comment with status, newest first.
+func (s *Store) List(status Status) []*Comment {
+    return s.filter(func(c *Comment) bool { return c.Status == status }, byNewest)
+}
+
+// For returns the visible comments on target as threads, oldest first.
+func (s *Store) For(target string) []*Thread {
+    return threads(s.filter(func(c *Comment) bool {
+        return c.Target == target && c.Visible()
+    }, byOldest))
+}
+
+func (s *Store) filter(keep func(*Comment) bool, less func(a, b *Comment) bool) []*Comment {
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    var comments []*Comment
+    for _, c := range s.comments {
+        c := c
+        if keep(&c) {
+            comments = append(comments, &c)
+        }
+    }
+    sort.Sort(sorter{comments, less})
+    return comments
+}
+
+// save writes the whole store atomically. The lock must be held.
+func (s *Store) save() error {
+    comments := make([]*Comment, 0, len(s.comments))
+    for id := range s.comments {
+        c := s.comments[id]
+        comments = append(comments, &c)
+    }
+    sort.Sort(sorter{comments, byOldest})
+    data, err := json.MarshalIndent(comments, "", "  ")
+    if err != nil {
+        return err
+    }
+    return atomicfile.WriteFile(s.Path, data, 0600)
+}
+
+type sorter struct {
+    comments []*Comment
+    less     func(a, b *Comment) bool
+}
+
+func (s sorter) Len() int           { return len(s.comments) }
+func (s sorter) Swap(i, j int)      { s.comments[i], s.comments[j] = s.comments[j], s.comments[i] }
+func (s sorter) Less(i, j int) bool { return s.less(s.comments[i], s.comments[j]) }
+
+func byOldest(a, b *Comment) bool {
+    if a.Received.Equal(b.Received) {
+        return a.Id < b.Id
+    }
+    return a.Received.Before(b.Received)
+}
+
+func byNewest(a, b *Comment) bool {
+    return byOldest(b, a)
+}
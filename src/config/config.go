@@ -3,16 +3,63 @@ package config
 import (
     "fmt"
     "github.com/darkhelmet/env"
-    "log"
+    "os"
+    "strings"
 )
 
 var (
     Port            = env.IntDefault("PORT", 5000)
     CanonicalHost   = env.StringDefaultF("CANONICAL_HOST", func() string { return fmt.Sprintf("localhost:%d", Port) })
-    AssetHost       = env.StringDefaultF("ASSET_HOST", func() string { return fmt.Sprintf("http://%s", CanonicalHost) })
-    LogFlags        = env.IntDefault("LOG_FLAGS", log.LstdFlags|log.Lmicroseconds)
+    CanonicalScheme = env.StringDefaultF("CANONICAL_SCHEME", defaultScheme)
+    AssetHost       = env.StringDefaultF("ASSET_HOST", func() string { return fmt.Sprintf("%s://%s", CanonicalScheme, CanonicalHost) })
+    LogFormat       = env.StringDefault("LOG_FORMAT", "logfmt")
+    LogLevel        = env.StringDefault("LOG_LEVEL", "info")
+    LogNotFound     = env.IntDefault("LOG_404_SAMPLE", 10)
+    DataDir         = env.StringDefault("DATA_DIR", "data")
+    AdminUsers      = env.StringDefault("ADMIN_USERS", "")
+    SessionSecret   = env.StringDefault("SESSION_SECRET", "")
+    SecureCookies   = env.StringDefault("SECURE_COOKIES", "yes")
+    TrustProxy      = env.StringDefaultF("TRUST_PROXY", defaultTrustProxy)
+    MaxUploadSize   = env.IntDefault("MAX_UPLOAD_SIZE", 10<<20)
+    History         = env.StringDefault("HISTORY", "yes")
+    HistoryGitDir   = env.StringDefault("HISTORY_GIT_DIR", "")
+    Comments        = env.StringDefault("COMMENTS", "yes")
+    Analytics       = env.StringDefault("ANALYTICS", "yes")
+    Metrics         = env.StringDefault("METRICS", "yes")
+    MetricsToken    = env.StringDefault("METRICS_TOKEN", "")
+    SMTPAddr        = env.StringDefault("SMTP_ADDR", "")
+    SMTPUser        = env.StringDefault("SMTP_USER", "")
+    SMTPPassword    = env.StringDefault("SMTP_PASSWORD", "")
+    MailFrom        = env.StringDefaultF("MAIL_FROM", func() string { return SiteContact })
+    NewsletterKey   = env.StringDefault("NEWSLETTER_SECRET", "")
+    SendMentions    = env.StringDefault("SEND_MENTIONS", "no")
+    Hub             = env.StringDefault("WEBSUB_HUB", "")
+    BuiltinHub      = env.StringDefault("WEBSUB_BUILTIN_HUB", "no")
+    ActorName       = env.StringDefault("ACTOR_NAME", "blog")
+    MicropubToken   = env.StringDefault("MICROPUB_TOKEN", "")
+    DefaultCategory = env.StringDefault("DEFAULT_CATEGORY", "editorial")
+    BloggingUser    = env.StringDefault("BLOGGING_USER", "")
+    BloggingPass    = env.StringDefault("BLOGGING_PASSWORD", "")
     SiteTitle       = "Verbose Logging"
     SiteDescription = "software development with some really amazing hair"
     SiteContact     = "darkhelmet@darkhelmetlive.com"
     SiteAuthor      = "Daniel Huckstep"
+    SiteTwitter     = "@darkhelmetlive"
 )
+
+// defaultTrustProxy is yes on Heroku, where the router is always in front and
+// says who asked in X-Forwarded-For. Anywhere else it's up to the client.
+func defaultTrustProxy() string {
+    if os.Getenv("DYNO") != "" {
+        return "yes"
+    }
+    return "no"
+}
+
+// defaultScheme is https, except when running locally.
+func defaultScheme() string {
+    if strings.HasPrefix(CanonicalHost, "localhost") {
+        return "http"
+    }
+    return "https"
+}
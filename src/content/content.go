@@ -0,0 +1,178 @@
+// Package content reads the markdown sources behind the post and page repos.
+// blargh only keeps the front matter it knows about, so this holds on to the
+// rest of it along with the raw body.
+package content
+
+import (
+    "github.com/james4k/fmatter"
+    "images"
+    "path/filepath"
+    "strings"
+    "sync"
+)
+
+// What the post and page directories are called.
+const (
+    PostsDir = "posts"
+    PagesDir = "pages"
+)
+
+var (
+    lock    sync.RWMutex
+    indexes []*Index
+)
+
+// Meta is the front matter the rest of the site cares about.
+type Meta struct {
+    Id     int
+    Slugs  []string
+    Toc    bool
+    Images map[string]map[string]string
+}
+
+// Source is a single markdown file.
+type Source struct {
+    Path string
+    Meta Meta
+    Body string
+
+    // Images are the variants of the images kept next to the source, by
+    // name. They're made when it's read, so rendering only has to look them
+    // up. ImagesErr is why they couldn't be.
+    Images    map[string]*images.Image
+    ImagesErr error
+}
+
+// Slug returns the primary slug, matching post.Post.Slug.
+func (s *Source) Slug() string {
+    if len(s.Meta.Slugs) == 0 {
+        return ""
+    }
+    return s.Meta.Slugs[0]
+}
+
+// Index is every source in a directory, keyed by slug.
+type Index struct {
+    Dir    string
+    lock   sync.RWMutex
+    bySlug map[string]*Source
+}
+
+// Load reads every markdown file in dir and registers the index so Named
+// can find it.
+func Load(dir string) (*Index, error) {
+    index := &Index{Dir: dir}
+    if err := index.Reload(); err != nil {
+        return nil, err
+    }
+    lock.Lock()
+    defer lock.Unlock()
+    for n, existing := range indexes {
+        if existing.Dir == dir {
+            indexes[n] = index
+            return index, nil
+        }
+    }
+    indexes = append(indexes, index)
+    return index, nil
+}
+
+// Reload reads the directory again, replacing every source.
+func (i *Index) Reload() error {
+    paths, err := filepath.Glob(filepath.Join(i.Dir, "*.md"))
+    if err != nil {
+        return err
+    }
+    bySlug := make(map[string]*Source)
+    for _, path := range paths {
+        source, err := Read(path)
+        if err != nil {
+            return err
+        }
+        for _, slug := range source.Meta.Slugs {
+            bySlug[slug] = source
+        }
+    }
+    i.lock.Lock()
+    i.bySlug = bySlug
+    i.lock.Unlock()
+    return nil
+}
+
+// Find returns the source for a slug.
+func (i *Index) Find(slug string) (*Source, bool) {
+    i.lock.RLock()
+    defer i.lock.RUnlock()
+    source, ok := i.bySlug[slug]
+    return source, ok
+}
+
+// FindById returns the source with an id in its front matter.
+func (i *Index) FindById(id int) (*Source, bool) {
+    for _, source := range i.All() {
+        if id > 0 && source.Meta.Id == id {
+            return source, true
+        }
+    }
+    return nil, false
+}
+
+// All returns every source, once each.
+func (i *Index) All() []*Source {
+    i.lock.RLock()
+    defer i.lock.RUnlock()
+    seen := make(map[*Source]bool)
+    var sources []*Source
+    for _, source := range i.bySlug {
+        if !seen[source] {
+            seen[source] = true
+            sources = append(sources, source)
+        }
+    }
+    return sources
+}
+
+// Read parses a single markdown file.
+func Read(path string) (*Source, error) {
+    source := &Source{Path: path}
+    body, err := fmatter.ReadFile(path, &source.Meta)
+    if err != nil {
+        return nil, err
+    }
+    source.Body = string(body)
+    source.Images, source.ImagesErr = processImages(source)
+    return source, nil
+}
+
+// processImages makes the variants for the images in a directory named
+// after the source.
+func processImages(source *Source) (map[string]*images.Image, error) {
+    processed := make(map[string]*images.Image)
+    dir := strings.TrimSuffix(source.Path, filepath.Ext(source.Path))
+    for name, sizes := range source.Meta.Images {
+        original, ok := images.Local(sizes)
+        if !ok {
+            continue
+        }
+        img, err := images.Process(name, filepath.Join(dir, original), source.Slug())
+        if err != nil {
+            return nil, err
+        }
+        processed[name] = img
+    }
+    return processed, nil
+}
+
+// Named returns the first index loaded from a directory called name, like
+// posts, wherever it is. Posts and pages can share a slug, so anything after
+// a source has to know which index it's from.
+func Named(name string) (*Index, bool) {
+    lock.RLock()
+    defer lock.RUnlock()
+    for _, index := range indexes {
+        if filepath.Base(index.Dir) == name {
+            return index, true
+        }
+    }
+    return nil, false
+}
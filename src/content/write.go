@@ -0,0 +1,211 @@
+package content
+
+import (
+    "atomicfile"
+    "bytes"
+    "fmt"
+    "github.com/james4k/fmatter"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+)
+
+// PublishedOnLayout is how publishedon is written in front matter.
+const PublishedOnLayout = "02 Jan 2006 15:04 MST"
+
+var (
+    // Location is where the blog lives, for writing publishedon the way
+    // it's always been written.
+    Location = mustLoadLocation("America/Edmonton")
+
+    plain     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 .,!?()/'_+-]*$`)
+    separator = regexp.MustCompile(`-+`)
+)
+
+func mustLoadLocation(name string) *time.Location {
+    loc, err := time.LoadLocation(name)
+    if err != nil {
+        return time.Local
+    }
+    return loc
+}
+
+// FrontMatter is all of the front matter a source can have, for reading a
+// file and writing it back.
+type FrontMatter struct {
+    Id          int
+    Author      string
+    Title       string
+    Category    string
+    Description string
+    Published   bool
+    PublishedOn string
+    Slugs       []string
+    Tags        []string
+    Toc         bool
+    Images      map[string]map[string]string
+}
+
+// Slug is the primary slug.
+func (fm *FrontMatter) Slug() string {
+    if len(fm.Slugs) == 0 {
+        return ""
+    }
+    return fm.Slugs[0]
+}
+
+// Time parses PublishedOn.
+func (fm *FrontMatter) Time() (time.Time, error) {
+    return time.ParseInLocation(PublishedOnLayout, fm.PublishedOn, Location)
+}
+
+// SetTime sets PublishedOn to t, in the blog's time zone.
+func (fm *FrontMatter) SetTime(t time.Time) {
+    fm.PublishedOn = t.In(Location).Format(PublishedOnLayout)
+}
+
+// ReadFile reads a source's front matter and body for editing.
+func ReadFile(path string) (*FrontMatter, string, error) {
+    var fm FrontMatter
+    body, err := fmatter.ReadFile(path, &fm)
+    if err != nil {
+        return nil, "", err
+    }
+    return &fm, string(body), nil
+}
+
+// Parse is ReadFile for a source that isn't in a file, like one about to
+// be.
+func Parse(data []byte) (*FrontMatter, string, error) {
+    var fm FrontMatter
+    body, err := fmatter.Read(data, &fm)
+    if err != nil {
+        return nil, "", err
+    }
+    return &fm, string(body), nil
+}
+
+// Encode writes out a source the way the ones already here look.
+func Encode(fm *FrontMatter, body string) []byte {
+    var b bytes.Buffer
+    b.WriteString("--- \n")
+    if fm.Id > 0 {
+        fmt.Fprintf(&b, "id: %d\n", fm.Id)
+    }
+    fmt.Fprintf(&b, "author: %s\n", yamlString(fm.Author))
+    fmt.Fprintf(&b, "title: %s\n", yamlString(fm.Title))
+    if fm.Category != "" {
+        fmt.Fprintf(&b, "category: %s\n", yamlString(fm.Category))
+    }
+    fmt.Fprintf(&b, "description: %s\n", yamlString(fm.Description))
+    fmt.Fprintf(&b, "published: %t\n", fm.Published)
+    fmt.Fprintf(&b, "publishedon: %s\n", fm.PublishedOn)
+    writeList(&b, "slugs", fm.Slugs)
+    if len(fm.Tags) > 0 {
+        writeList(&b, "tags", fm.Tags)
+    }
+    if fm.Toc {
+        b.WriteString("toc: true\n")
+    }
+    if len(fm.Images) > 0 {
+        b.WriteString("images: \n")
+        for _, name := range sortedKeys(fm.Images) {
+            fmt.Fprintf(&b, "  %s: \n", yamlString(name))
+            sizes := fm.Images[name]
+            keys := make([]string, 0, len(sizes))
+            for size := range sizes {
+                keys = append(keys, size)
+            }
+            sort.Strings(keys)
+            for _, size := range keys {
+                fmt.Fprintf(&b, "    %s: %s\n", yamlString(size), yamlString(sizes[size]))
+            }
+        }
+    }
+    b.WriteString("---\n")
+    b.WriteString(strings.TrimLeft(body, "\n"))
+    if !strings.HasSuffix(body, "\n") {
+        b.WriteString("\n")
+    }
+    return b.Bytes()
+}
+
+// WriteFile saves a source, atomically.
+func WriteFile(path string, fm *FrontMatter, body string) error {
+    return atomicfile.WriteFile(path, Encode(fm, body), 0644)
+}
+
+func writeList(b *bytes.Buffer, name string, items []string) {
+    fmt.Fprintf(b, "%s: \n", name)
+    for _, item := range items {
+        fmt.Fprintf(b, "- %s\n", yamlString(item))
+    }
+}
+
+func sortedKeys(m map[string]map[string]string) []string {
+    keys := make([]string, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// yamlString leaves simple strings alone, and quotes anything YAML might
+// read as something else.
+func yamlString(s string) string {
+    if plain.MatchString(s) && !strings.HasSuffix(s, " ") {
+        switch strings.ToLower(s) {
+        case "true", "false", "yes", "no", "on", "off", "null", "~":
+        default:
+            if _, err := strconv.ParseFloat(s, 64); err != nil {
+                return s
+            }
+        }
+    }
+    return strconv.Quote(s)
+}
+
+// Slugify makes a slug out of a title.
+func Slugify(text string) string {
+    slug := strings.Map(func(r rune) rune {
+        if unicode.IsLetter(r) || unicode.IsDigit(r) {
+            return unicode.ToLower(r)
+        }
+        if r == '\'' {
+            return -1
+        }
+        return '-'
+    }, text)
+    return strings.Trim(separator.ReplaceAllString(slug, "-"), "-")
+}
+
+// NextId is one more than the biggest id in any loaded index.
+func NextId() int {
+    lock.RLock()
+    defer lock.RUnlock()
+    max := 0
+    for _, index := range indexes {
+        for _, source := range index.All() {
+            if source.Meta.Id > max {
+                max = source.Meta.Id
+            }
+        }
+    }
+    return max + 1
+}
+
+// Exists tells whether any loaded index has something at slug.
+func Exists(slug string) bool {
+    lock.RLock()
+    defer lock.RUnlock()
+    for _, index := range indexes {
+        if _, ok := index.Find(slug); ok {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,191 @@
+// Package gitstore keeps the history of files in a local git repository,
+// by running git. Nothing here ever talks to a remote.
+package gitstore
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var (
+    ErrBadRevision = errors.New("not a revision")
+
+    revision = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+)
+
+// Repo is a work tree, and optionally a git directory kept somewhere other
+// than its .git.
+type Repo struct {
+    Dir    string
+    GitDir string
+}
+
+// Editor is who a commit is by.
+type Editor struct {
+    Name, Email string
+}
+
+// Revision is one commit that touched a file.
+type Revision struct {
+    Hash, Author, Subject string
+    Time                  time.Time
+}
+
+// Short is the abbreviated hash.
+func (r *Revision) Short() string {
+    if len(r.Hash) > 7 {
+        return r.Hash[:7]
+    }
+    return r.Hash
+}
+
+// Line is one line of a diff. Kind is add, remove, hunk or context.
+type Line struct {
+    Kind, Text string
+}
+
+// Sign is what goes in front of the line in a unified diff.
+func (l Line) Sign() string {
+    switch l.Kind {
+    case "add":
+        return "+"
+    case "remove":
+        return "-"
+    case "context":
+        return " "
+    }
+    return ""
+}
+
+func (r *Repo) git(env []string, args ...string) ([]byte, error) {
+    command := args[0]
+    if r.GitDir != "" {
+        args = append([]string{"--git-dir=" + r.GitDir, "--work-tree=."}, args...)
+    }
+    cmd := exec.Command("git", args...)
+    cmd.Dir = r.Dir
+    cmd.Env = append(os.Environ(), env...)
+    var stdout, stderr bytes.Buffer
+    cmd.Stdout, cmd.Stderr = &stdout, &stderr
+    if err := cmd.Run(); err != nil {
+        return nil, fmt.Errorf("git %s: %s: %s", command, err, strings.TrimSpace(stderr.String()))
+    }
+    return stdout.Bytes(), nil
+}
+
+// Init makes the repository if there isn't one yet. Doing it again is
+// harmless.
+func (r *Repo) Init() error {
+    _, err := r.git(nil, "init", "--quiet")
+    return err
+}
+
+// Commit records whatever changed at path, including it being deleted, as
+// a commit by editor. Nothing changing isn't an error.
+func (r *Repo) Commit(path string, editor Editor, message string) error {
+    status, err := r.git(nil, "status", "--porcelain", "--", path)
+    if err != nil {
+        return err
+    }
+    if len(bytes.TrimSpace(status)) == 0 {
+        return nil
+    }
+    if _, err := r.git(nil, "add", "-A", "--", path); err != nil {
+        return err
+    }
+    env := []string{
+        "GIT_AUTHOR_NAME=" + editor.Name,
+        "GIT_AUTHOR_EMAIL=" + editor.Email,
+        "GIT_COMMITTER_NAME=" + editor.Name,
+        "GIT_COMMITTER_EMAIL=" + editor.Email,
+    }
+    _, err = r.git(env, "commit", "--quiet", "-m", message, "--", path)
+    return err
+}
+
+// Log is the last n commits to touch path, newest first.
+func (r *Repo) Log(path string, n int) ([]*Revision, error) {
+    out, err := r.git(nil, "log", "-n", strconv.Itoa(n), "--follow", "--format=%H%x00%an%x00%at%x00%s", "--", path)
+    if err != nil {
+        return nil, err
+    }
+    var revisions []*Revision
+    for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+        fields := strings.SplitN(line, "\x00", 4)
+        if len(fields) != 4 {
+            continue
+        }
+        at, _ := strconv.ParseInt(fields[2], 10, 64)
+        revisions = append(revisions, &Revision{Hash: fields[0], Author: fields[1], Time: time.Unix(at, 0), Subject: fields[3]})
+    }
+    return revisions, nil
+}
+
+// check makes sure hash is a commit, and not something git would take as
+// an option.
+func (r *Repo) check(hash string) error {
+    if !revision.MatchString(hash) {
+        return ErrBadRevision
+    }
+    if _, err := r.git(nil, "rev-parse", "--quiet", "--verify", hash+"^{commit}"); err != nil {
+        return ErrBadRevision
+    }
+    return nil
+}
+
+// Diff is what the commit hash changed in path.
+func (r *Repo) Diff(path, hash string) ([]Line, error) {
+    if err := r.check(hash); err != nil {
+        return nil, err
+    }
+    out, err := r.git(nil, "show", "--format=", "--no-color", hash, "--", path)
+    if err != nil {
+        return nil, err
+    }
+    return ParseDiff(string(out)), nil
+}
+
+// File is what path looked like as of the commit hash.
+func (r *Repo) File(path, hash string) ([]byte, error) {
+    if err := r.check(hash); err != nil {
+        return nil, err
+    }
+    // ./ makes the path relative to Dir, not the top of the work tree.
+    return r.git(nil, "show", hash+":./"+path)
+}
+
+// ParseDiff reads a unified diff, leaving out the file headers.
+func ParseDiff(diff string) []Line {
+    var lines []Line
+    hunks := false
+    for _, text := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+        if strings.HasPrefix(text, "@@") {
+            hunks = true
+            lines = append(lines, Line{"hunk", text})
+            continue
+        }
+        if !hunks {
+            continue
+        }
+        switch {
+        case strings.HasPrefix(text, "+"):
+            lines = append(lines, Line{"add", text[1:]})
+        case strings.HasPrefix(text, "-"):
+            lines = append(lines, Line{"remove", text[1:]})
+        case strings.HasPrefix(text, "diff "):
+            hunks = false
+        case strings.HasPrefix(text, "\\"):
+            // No newline at end of file.
+        default:
+            lines = append(lines, Line{"context", strings.TrimPrefix(text, " ")})
+        }
+    }
+    return lines
+}
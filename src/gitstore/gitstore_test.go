@@ -0,0 +1,71 @@
+package gitstore_test
+
+import (
+    "gitstore"
+    "io/ioutil"
+    . "launchpad.net/gocheck"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type GitSuite struct {
+    repo *gitstore.Repo
+}
+
+var _ = Suite(&GitSuite{})
+
+func (s *GitSuite) SetUpTest(c *C) {
+    dir := c.MkDir()
+    s.repo = &gitstore.Repo{Dir: filepath.Join(dir, "work"), GitDir: filepath.Join(dir, "git")}
+    c.Assert(os.MkdirAll(filepath.Join(s.repo.Dir, "posts"), 0755), IsNil)
+    c.Assert(s.repo.Init(), IsNil)
+}
+
+func (s *GitSuite) write(c *C, text string) {
+    c.Assert(ioutil.WriteFile(filepath.Join(s.repo.Dir, "posts/hello.md"), []byte(text), 0644), IsNil)
+}
+
+func (s *GitSuite) TestHistory(c *C) {
+    jane := gitstore.Editor{Name: "Jane", Email: "jane@example.com"}
+    s.write(c, "one\ntwo\nthree\n")
+    c.Assert(s.repo.Commit("posts/hello.md", jane, "Add posts/hello.md"), IsNil)
+    s.write(c, "one\n2\nthree\n")
+    c.Assert(s.repo.Commit("posts/hello.md", gitstore.Editor{Name: "Bob", Email: "bob@example.com"}, "Update posts/hello.md"), IsNil)
+    // Nothing changed, so nothing to commit.
+    c.Assert(s.repo.Commit("posts/hello.md", jane, "Update posts/hello.md"), IsNil)
+
+    log, err := s.repo.Log("posts/hello.md", 10)
+    c.Assert(err, IsNil)
+    c.Assert(log, HasLen, 2)
+    c.Check(log[0].Author, Equals, "Bob")
+    c.Check(log[0].Subject, Equals, "Update posts/hello.md")
+    c.Check(log[0].Short(), HasLen, 7)
+    c.Check(log[1].Author, Equals, "Jane")
+
+    diff, err := s.repo.Diff("posts/hello.md", log[0].Hash)
+    c.Assert(err, IsNil)
+    c.Check(diff, DeepEquals, []gitstore.Line{
+        {Kind: "hunk", Text: "@@ -1,3 +1,3 @@"},
+        {Kind: "context", Text: "one"},
+        {Kind: "remove", Text: "two"},
+        {Kind: "add", Text: "2"},
+        {Kind: "context", Text: "three"},
+    })
+
+    data, err := s.repo.File("posts/hello.md", log[1].Hash)
+    c.Assert(err, IsNil)
+    c.Check(string(data), Equals, "one\ntwo\nthree\n")
+    _, err = s.repo.File("posts/hello.md", "--output=/tmp/nope")
+    c.Check(err, Equals, gitstore.ErrBadRevision)
+    _, err = s.repo.Diff("posts/hello.md", "abcdef0")
+    c.Check(err, Equals, gitstore.ErrBadRevision)
+
+    c.Assert(os.Remove(filepath.Join(s.repo.Dir, "posts/hello.md")), IsNil)
+    c.Assert(s.repo.Commit("posts/hello.md", jane, "Delete posts/hello.md"), IsNil)
+    log, err = s.repo.Log("posts/hello.md", 10)
+    c.Assert(err, IsNil)
+    c.Check(log, HasLen, 3)
+}
@@ -0,0 +1,121 @@
+// Package highlight does server side syntax highlighting. Lexers split code
+// into tokens, and tokens come out as spans with short Pygments style class
+// names, which the generated stylesheet knows how to colour.
+package highlight
+
+import (
+    "regexp"
+    "strings"
+    "unicode/utf8"
+)
+
+// TokenType doubles as the CSS class for the token.
+type TokenType string
+
+const (
+    Text        TokenType = ""
+    Comment     TokenType = "c"
+    Keyword     TokenType = "k"
+    KeywordType TokenType = "kt"
+    Constant    TokenType = "kc"
+    Builtin     TokenType = "nb"
+    Function    TokenType = "nf"
+    Class       TokenType = "nc"
+    Tag         TokenType = "nt"
+    Attribute   TokenType = "na"
+    Variable    TokenType = "nv"
+    Symbol      TokenType = "ss"
+    String      TokenType = "s"
+    Regex       TokenType = "sr"
+    Number      TokenType = "m"
+    Operator    TokenType = "o"
+    Punctuation TokenType = "p"
+    Preproc     TokenType = "cp"
+    Prompt      TokenType = "gp"
+    Deleted     TokenType = "gd"
+    Inserted    TokenType = "gi"
+    Heading     TokenType = "gh"
+)
+
+// Token is a run of code of a single type.
+type Token struct {
+    Type TokenType
+    Text string
+}
+
+// rule matches at the current position. If groups is set, each submatch
+// becomes its own token, and the groups have to cover the whole match.
+// lineStart rules only get a look in at the beginning of a line.
+type rule struct {
+    pattern   *regexp.Regexp
+    token     TokenType
+    groups    []TokenType
+    lineStart bool
+}
+
+// Lexer tokenizes a single language with an ordered list of rules. The first
+// rule to match at the current position wins.
+type Lexer struct {
+    Name    string
+    Aliases []string
+    rules   []rule
+}
+
+var lexers = make(map[string]*Lexer)
+
+// Register makes a lexer available under its name and aliases.
+func Register(l *Lexer) {
+    lexers[l.Name] = l
+    for _, alias := range l.Aliases {
+        lexers[alias] = l
+    }
+}
+
+// Lookup finds the lexer for a language, or nil if there isn't one.
+func Lookup(lang string) *Lexer {
+    return lexers[strings.ToLower(lang)]
+}
+
+// Tokenize splits code into tokens. Anything no rule matches is text.
+func (l *Lexer) Tokenize(code string) []Token {
+    var tokens []Token
+    emit := func(t TokenType, s string) {
+        if n := len(tokens); n > 0 && tokens[n-1].Type == t {
+            tokens[n-1].Text += s
+        } else {
+            tokens = append(tokens, Token{t, s})
+        }
+    }
+
+    bol := true
+    for len(code) > 0 {
+        n := 0
+        for _, r := range l.rules {
+            if r.lineStart && !bol {
+                continue
+            }
+            m := r.pattern.FindStringSubmatch(code)
+            if m == nil || m[0] == "" {
+                continue
+            }
+            if r.groups == nil {
+                emit(r.token, m[0])
+            } else {
+                for i, t := range r.groups {
+                    if m[i+1] != "" {
+                        emit(t, m[i+1])
+                    }
+                }
+            }
+            n = len(m[0])
+            break
+        }
+        if n == 0 {
+            _, n = utf8.DecodeRuneInString(code)
+            emit(Text, code[:n])
+        }
+        bol = code[n-1] == '\n'
+        code = code[n:]
+    }
+    return tokens
+}
@@ -0,0 +1,65 @@
+package highlight_test
+
+import (
+    "highlight"
+    . "launchpad.net/gocheck"
+    "strings"
+    "testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type HighlightSuite struct{}
+
+var _ = Suite(&HighlightSuite{})
+
+func (s *HighlightSuite) TestTokenize(c *C) {
+    tokens := highlight.Lookup("go").Tokenize(`func main() { return "hi" } // done`)
+    c.Check(tokens[0], Equals, highlight.Token{Type: highlight.Keyword, Text: "func"})
+    c.Check(tokens[2], Equals, highlight.Token{Type: highlight.Function, Text: "main"})
+
+    var types []highlight.TokenType
+    for _, t := range tokens {
+        types = append(types, t.Type)
+    }
+    c.Check(types, DeepEquals, []highlight.TokenType{
+        highlight.Keyword, highlight.Text, highlight.Function, highlight.Punctuation,
+        highlight.Text, highlight.Punctuation, highlight.Text, highlight.Keyword,
+        highlight.Text, highlight.String, highlight.Text, highlight.Punctuation,
+        highlight.Text, highlight.Comment,
+    })
+}
+
+func (s *HighlightSuite) TestLineStartRules(c *C) {
+    tokens := highlight.Lookup("diff").Tokenize("+added\n-removed\n a - b\n")
+    c.Check(tokens[0], Equals, highlight.Token{Type: highlight.Inserted, Text: "+added"})
+    c.Check(tokens[2], Equals, highlight.Token{Type: highlight.Deleted, Text: "-removed"})
+    c.Check(tokens[3], Equals, highlight.Token{Type: highlight.Text, Text: "\n a - b\n"})
+}
+
+func (s *HighlightSuite) TestParseInfo(c *C) {
+    lang, opts := highlight.ParseInfo("go {linenos=true,hl_lines=[2 4-5]}")
+    c.Check(lang, Equals, "go")
+    c.Check(opts.LineNumbers, Equals, true)
+    c.Check(opts.Highlight, DeepEquals, map[int]bool{2: true, 4: true, 5: true})
+
+    lang, opts = highlight.ParseInfo("ruby linenos linenostart=10 hl_lines=1,3")
+    c.Check(lang, Equals, "ruby")
+    c.Check(opts.Start, Equals, 10)
+    c.Check(opts.Highlight, DeepEquals, map[int]bool{1: true, 3: true})
+}
+
+func (s *HighlightSuite) TestFormat(c *C) {
+    _, opts := highlight.ParseInfo("go linenos hl_lines=2")
+    out := highlight.Format("x := 1\ny := \"<b>\"\n", "go", opts)
+    c.Check(strings.HasPrefix(out, `<div class="highlight"><pre><code class="language-go" data-lang="go">`), Equals, true)
+    c.Check(strings.Contains(out, `<span class="line"><span class="ln">1</span>x <span class="o">:=</span> <span class="m">1</span>`), Equals, true)
+    c.Check(strings.Contains(out, `<span class="line hl"><span class="ln">2</span>`), Equals, true)
+    c.Check(strings.Contains(out, `&#34;&lt;b&gt;&#34;`), Equals, true)
+    c.Check(strings.Count(out, `class="line`), Equals, 2)
+}
+
+func (s *HighlightSuite) TestUnknownLanguage(c *C) {
+    out := highlight.Format("<plain>", "klingon", highlight.Options{})
+    c.Check(out, Equals, `<div class="highlight"><pre><code class="language-klingon" data-lang="klingon"><span class="line">&lt;plain&gt;`+"\n</span></code></pre></div>")
+}
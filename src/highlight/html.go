@@ -0,0 +1,120 @@
+package highlight
+
+import (
+    "bytes"
+    "fmt"
+    "html"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+var (
+    infoOption = regexp.MustCompile(`([\w-]+)(?:=("[^"]*"|\[[^\]]*\]|[^\s,]+(?:,[\d-]+)*))?`)
+    lineRange  = regexp.MustCompile(`(\d+)(?:-(\d+))?`)
+)
+
+// Options control how a block comes out.
+type Options struct {
+    LineNumbers bool
+    Start       int
+    Highlight   map[int]bool
+}
+
+// ParseInfo splits a fenced code block info string into the language and
+// options. Everything after the language is a list of options, optionally in
+// braces, like:
+//
+//	go {linenos=true hl_lines=[2 4-6]}
+//	ruby linenos hl_lines=3,5 linenostart=10
+func ParseInfo(info string) (string, Options) {
+    opts := Options{Start: 1, Highlight: make(map[int]bool)}
+    fields := strings.Fields(info)
+    if len(fields) == 0 {
+        return "", opts
+    }
+    lang := strings.TrimPrefix(strings.Trim(fields[0], "{}"), "language-")
+    rest := strings.Trim(strings.TrimSpace(info[len(fields[0]):]), "{}")
+
+    for _, m := range infoOption.FindAllStringSubmatch(rest, -1) {
+        key, value := m[1], strings.Trim(m[2], `"[]`)
+        switch key {
+        case "linenos":
+            opts.LineNumbers = value == "" || value == "true" || value == "table" || value == "inline"
+        case "linenostart":
+            if n, err := strconv.Atoi(value); err == nil {
+                opts.Start = n
+            }
+        case "hl_lines", "hl":
+            for _, r := range lineRange.FindAllStringSubmatch(value, -1) {
+                from, _ := strconv.Atoi(r[1])
+                to := from
+                if r[2] != "" {
+                    to, _ = strconv.Atoi(r[2])
+                }
+                for n := from; n <= to; n++ {
+                    opts.Highlight[n] = true
+                }
+            }
+        }
+    }
+    return lang, opts
+}
+
+// Format highlights code and returns the markup. Unknown languages still get
+// the same markup, just without any coloured tokens. Highlighted lines are
+// counted from one, regardless of where the line numbers start.
+func Format(code, lang string, opts Options) string {
+    code = strings.TrimSuffix(code, "\n")
+    var tokens []Token
+    if lexer := Lookup(lang); lexer != nil {
+        tokens = lexer.Tokenize(code)
+    } else {
+        tokens = []Token{{Text, code}}
+    }
+    if opts.Start == 0 {
+        opts.Start = 1
+    }
+
+    var buffer bytes.Buffer
+    buffer.WriteString(`<div class="highlight"><pre>`)
+    if lang != "" {
+        fmt.Fprintf(&buffer, `<code class="language-%s" data-lang="%s">`, html.EscapeString(lang), html.EscapeString(lang))
+    } else {
+        buffer.WriteString(`<code>`)
+    }
+
+    line := 1
+    startLine := func() {
+        if opts.Highlight[line] {
+            buffer.WriteString(`<span class="line hl">`)
+        } else {
+            buffer.WriteString(`<span class="line">`)
+        }
+        if opts.LineNumbers {
+            fmt.Fprintf(&buffer, `<span class="ln">%d</span>`, opts.Start+line-1)
+        }
+    }
+
+    startLine()
+    for _, token := range tokens {
+        parts := strings.Split(token.Text, "\n")
+        for i, part := range parts {
+            if i > 0 {
+                buffer.WriteString("\n</span>")
+                line++
+                startLine()
+            }
+            if part == "" {
+                continue
+            }
+            if token.Type == Text {
+                buffer.WriteString(html.EscapeString(part))
+            } else {
+                fmt.Fprintf(&buffer, `<span class="%s">%s</span>`, token.Type, html.EscapeString(part))
+            }
+        }
+    }
+    buffer.WriteString("\n</span></code></pre></div>")
+    return buffer.String()
+}
@@ -0,0 +1,210 @@
+package highlight
+
+import (
+    "regexp"
+    "strings"
+)
+
+const (
+    doubleQuoted = `"(?:[^"\\\n]|\\.)*"`
+    singleQuoted = `'(?:[^'\\\n]|\\.)*'`
+    number       = `(?:0[xX][0-9a-fA-F]+|\d[\d_]*(?:\.\d+)?(?:[eE][-+]?\d+)?)`
+    identifier   = `[A-Za-z_]\w*`
+    whitespace   = `\s+`
+)
+
+func r(pattern string, token TokenType) rule {
+    return rule{pattern: regexp.MustCompile(`\A(?:` + pattern + `)`), token: token}
+}
+
+func g(pattern string, groups ...TokenType) rule {
+    return rule{pattern: regexp.MustCompile(`\A` + pattern), groups: groups}
+}
+
+func bol(rule rule) rule {
+    rule.lineStart = true
+    return rule
+}
+
+func words(ws string) string {
+    return `\b(?:` + strings.Join(strings.Fields(ws), "|") + `)\b`
+}
+
+func lexer(name, aliases string, rules ...rule) *Lexer {
+    return &Lexer{Name: name, Aliases: strings.Fields(aliases), rules: rules}
+}
+
+func init() {
+    Register(lexer("go", "golang",
+        r(`//[^\n]*|/\*(?s:.*?)\*/`, Comment),
+        r("`[^`]*`", String),
+        r(doubleQuoted+"|"+singleQuoted, String),
+        g(`(func)(\s+)(`+identifier+`)`, Keyword, Text, Function),
+        g(`(func)(\s*\([^)]*\)\s*)(`+identifier+`)(\()`, Keyword, Text, Function, Punctuation),
+        r(words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var"), Keyword),
+        r(words("bool byte complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune string uint uint8 uint16 uint32 uint64 uintptr"), KeywordType),
+        r(words("true false nil iota"), Constant),
+        r(words("append cap close complex copy delete imag len make new panic print println real recover"), Builtin),
+        r(identifier, Text),
+        r(number, Number),
+        r(`[-+*/%&|^!=<>:]+|\.\.\.`, Operator),
+        r(`[{}()\[\];,.]`, Punctuation),
+        r(whitespace, Text),
+    ))
+
+    Register(lexer("ruby", "rb rake gemfile",
+        bol(r(`=begin(?s:.*?)\n=end`, Comment)),
+        r(`#[^\n]*`, Comment),
+        r(doubleQuoted+"|"+singleQuoted, String),
+        r(`%[qQwWi]?(?:\{[^}]*\}|\([^)]*\)|\[[^\]]*\])`, String),
+        r(`%r\{[^}]*\}[imxo]*`, Regex),
+        r(`:[A-Za-z_]\w*[?!]?|:"[^"]*"`, Symbol),
+        g(`(def)(\s+)((?:self\.)?[\w]+[?!=]?)`, Keyword, Text, Function),
+        g(`(class|module)(\s+)([A-Z][\w:]*)`, Keyword, Text, Class),
+        r(words("alias and begin break case class def defined do else elsif end ensure for if in module next not or redo rescue retry return self super then undef unless until when while yield __FILE__ __LINE__"), Keyword),
+        r(words("true false nil"), Constant),
+        r(words("attr_accessor attr_reader attr_writer extend include lambda loop private protected proc public puts raise require require_relative"), Builtin),
+        r(`@@?\w+|\$\w+`, Variable),
+        r(`[A-Z]\w*`, Class),
+        r(`[a-z_]\w*[?!]?`, Text),
+        r(number, Number),
+        r(`[-+*/%&|^!=<>~]+`, Operator),
+        r(`[{}()\[\];,.:]`, Punctuation),
+        r(whitespace, Text),
+    ))
+
+    Register(lexer("javascript", "js json node",
+        r(`//[^\n]*|/\*(?s:.*?)\*/`, Comment),
+        r(doubleQuoted+"|"+singleQuoted, String),
+        g(`(function)(\s+)(`+identifier+`)`, Keyword, Text, Function),
+        r(words("break case catch const continue debugger default delete do else finally for function if in instanceof let new return switch this throw try typeof var void while with"), Keyword),
+        r(words("true false null undefined NaN Infinity"), Constant),
+        r(words("Array Boolean Date Error JSON Math Number Object RegExp String console document require module exports window"), Builtin),
+        r(`[$A-Za-z_][$\w]*`, Text),
+        r(number, Number),
+        r(`[-+*/%&|^!=<>?:~]+`, Operator),
+        r(`[{}()\[\];,.]`, Punctuation),
+        r(whitespace, Text),
+    ))
+
+    Register(lexer("coffeescript", "coffee",
+        r(`###(?s:.*?)###`, Comment),
+        r(`#[^\n]*`, Comment),
+        r(`"""(?s:.*?)"""|'''(?s:.*?)'''`, String),
+        r(doubleQuoted+"|"+singleQuoted, String),
+        r(`@\w*`, Variable),
+        r(words("and break by catch class continue delete do else extends finally for if in instanceof is isnt loop new not of or return super switch then this throw try typeof unless until when while yes no on off"), Keyword),
+        r(words("true false null undefined"), Constant),
+        r(`[$A-Za-z_][$\w]*`, Text),
+        r(number, Number),
+        r(`->|=>|[-+*/%&|^!=<>?:~]+`, Operator),
+        r(`[{}()\[\];,.]`, Punctuation),
+        r(whitespace, Text),
+    ))
+
+    Register(lexer("python", "py",
+        r(`#[^\n]*`, Comment),
+        r(`"""(?s:.*?)"""|'''(?s:.*?)'''`, String),
+        r(doubleQuoted+"|"+singleQuoted, String),
+        r(`@[\w.]+`, Preproc),
+        g(`(def)(\s+)(`+identifier+`)`, Keyword, Text, Function),
+        g(`(class)(\s+)(`+identifier+`)`, Keyword, Text, Class),
+        r(words("and as assert break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield"), Keyword),
+        r(words("True False None self"), Constant),
+        r(words("dict enumerate float int len list object open print range set str super tuple zip"), Builtin),
+        r(identifier, Text),
+        r(number, Number),
+        r(`[-+*/%&|^!=<>~]+`, Operator),
+        r(`[{}()\[\];,.:]`, Punctuation),
+        r(whitespace, Text),
+    ))
+
+    Register(lexer("bash", "sh shell zsh",
+        r(`#[^\n]*`, Comment),
+        r(doubleQuoted+"|"+singleQuoted, String),
+        r(`\$\{[^}]*\}|\$[\w@*#?$!-]`+`\w*`, Variable),
+        r(words("case do done elif else esac export fi for function if in local return select then until while"), Keyword),
+        r(words("alias cd echo eval exec exit read set shift source test trap unset"), Builtin),
+        r(`[\w.-]+`, Text),
+        r(`[|&;<>]+|\\`, Operator),
+        r(whitespace, Text),
+    ))
+
+    Register(lexer("console", "shell-session terminal",
+        bol(r(`[$#%>] `, Prompt)),
+        r(`[^\n]*\n?`, Text),
+    ))
+
+    Register(lexer("diff", "patch",
+        bol(r(`(?:\+\+\+|---)[^\n]*`, Heading)),
+        bol(r(`@@[^\n]*`, Heading)),
+        bol(r(`\+[^\n]*`, Inserted)),
+        bol(r(`-[^\n]*`, Deleted)),
+        r(`[^\n]*\n?`, Text),
+    ))
+
+    Register(lexer("html", "xml xhtml erb",
+        r(`<!--(?s:.*?)-->`, Comment),
+        r(`<!\[CDATA\[(?s:.*?)\]\]>`, String),
+        r(`<[!?][^>]*>`, Preproc),
+        r(`<%(?s:.*?)%>`, Preproc),
+        r(`</?[\w:.-]+|/?>`, Tag),
+        g(`([\w:-]+)(=)`, Attribute, Operator),
+        r(doubleQuoted+"|"+singleQuoted, String),
+        r(`&\w+;|&#\d+;`, Constant),
+        r(`[^<&"'=\s\w]+|\w+`, Text),
+        r(whitespace, Text),
+    ))
+
+    Register(lexer("css", "scss sass less",
+        r(`/\*(?s:.*?)\*/|//[^\n]*`, Comment),
+        r(doubleQuoted+"|"+singleQuoted, String),
+        r(`@[\w-]+`, Keyword),
+        r(`\$[\w-]+`, Variable),
+        r(`#[0-9a-fA-F]{3,6}\b`, Number),
+        r(`-?\d*\.?\d+(?:px|em|rem|ex|pt|%|s|ms|deg)?`, Number),
+        r(`!important`, Keyword),
+        r(`[.#][\w-]+`, Class),
+        r(`[\w-]+`, Text),
+        r(`[{}();,:>+~*=]`, Punctuation),
+        r(whitespace, Text),
+    ))
+
+    Register(lexer("sql", "mysql postgresql psql",
+        r(`--[^\n]*|/\*(?s:.*?)\*/`, Comment),
+        r(singleQuoted+"|"+doubleQuoted, String),
+        r(`(?i)`+words("add all alter and as asc begin between by case commit create default delete desc distinct drop else end exists from group having if in index insert into is join key left like limit not null on or order outer primary references right rollback select set table then union unique update values when where"), Keyword),
+        r(`(?i)`+words("bigint boolean char date decimal float int integer serial text timestamp varchar"), KeywordType),
+        r(`(?i)`+words("avg count max min sum coalesce now"), Builtin),
+        r(identifier, Text),
+        r(number, Number),
+        r(`[-+*/%=<>!|]+`, Operator),
+        r(`[().,;]`, Punctuation),
+        r(whitespace, Text),
+    ))
+
+    Register(lexer("c", "h cpp c++ objc java",
+        r(`//[^\n]*|/\*(?s:.*?)\*/`, Comment),
+        bol(r(`\s*#[^\n]*`, Preproc)),
+        r(doubleQuoted+"|"+singleQuoted, String),
+        r(words("break case catch class const continue default delete do else enum extends final finally for goto if implements import namespace new package private protected public return sizeof static struct switch template this throw try typedef union using virtual void volatile while"), Keyword),
+        r(words("bool boolean char double float int long short signed unsigned String"), KeywordType),
+        r(words("true false null NULL nullptr"), Constant),
+        r(identifier, Text),
+        r(number+`[uUlLfF]*`, Number),
+        r(`[-+*/%&|^!=<>?:~]+`, Operator),
+        r(`[{}()\[\];,.]`, Punctuation),
+        r(whitespace, Text),
+    ))
+
+    Register(lexer("yaml", "yml",
+        r(`#[^\n]*`, Comment),
+        bol(r(`---|\.\.\.`, Preproc)),
+        bol(g(`(\s*-?\s*)([\w.-]+)(:)`, Text, Attribute, Punctuation)),
+        r(doubleQuoted+"|"+singleQuoted, String),
+        r(words("true false yes no null"), Constant),
+        r(`[&*]\w+`, Variable),
+        r(`[^\n#]+`, Text),
+        r(whitespace, Text),
+    ))
+}
@@ -0,0 +1,52 @@
+package highlight
+
+import (
+    "bytes"
+    "fmt"
+    "sort"
+)
+
+// Style maps token types to CSS declarations. It's a light theme that sits
+// comfortably on the site's background.
+var Style = map[TokenType]string{
+    Comment:     "color: #8a8a7b; font-style: italic",
+    Keyword:     "color: #204a87; font-weight: bold",
+    KeywordType: "color: #204a87",
+    Constant:    "color: #204a87",
+    Builtin:     "color: #5c35cc",
+    Function:    "color: #000000; font-weight: bold",
+    Class:       "color: #3465a4; font-weight: bold",
+    Tag:         "color: #204a87; font-weight: bold",
+    Attribute:   "color: #c4a000",
+    Variable:    "color: #ad7fa8",
+    Symbol:      "color: #4e9a06",
+    String:      "color: #4e9a06",
+    Regex:       "color: #ce5c00",
+    Number:      "color: #0000cf",
+    Operator:    "color: #ce5c00",
+    Punctuation: "color: #555753",
+    Preproc:     "color: #8f5902",
+    Prompt:      "color: #8f5902; font-weight: bold; user-select: none",
+    Deleted:     "color: #a40000; background-color: #fdd",
+    Inserted:    "color: #00a000; background-color: #dfd",
+    Heading:     "color: #000080; font-weight: bold",
+}
+
+// Stylesheet generates the CSS for Style and the block markup.
+func Stylesheet() string {
+    var buffer bytes.Buffer
+    buffer.WriteString(".highlight pre { overflow-x: auto; padding: 0.5em; background-color: #f8f8f8; }\n")
+    buffer.WriteString(".highlight .line { display: flex; }\n")
+    buffer.WriteString(".highlight .hl { background-color: #ffffcc; }\n")
+    buffer.WriteString(".highlight .ln { color: #999; margin-right: 1em; padding-right: 0.5em; min-width: 2em; text-align: right; user-select: none; border-right: 1px solid #ddd; }\n")
+
+    classes := make([]string, 0, len(Style))
+    for t := range Style {
+        classes = append(classes, string(t))
+    }
+    sort.Strings(classes)
+    for _, class := range classes {
+        fmt.Fprintf(&buffer, ".highlight .%s { %s; }\n", class, Style[TokenType(class)])
+    }
+    return buffer.String()
+}
@@ -0,0 +1,264 @@
+// Package images makes the size variants for images that live next to the
+// posts, instead of pulling pre-sized copies off a CDN. Originals go in a
+// directory named after the post file, and the images front matter points
+// at them with a relative path:
+//
+//  images:
+//    kitty:
+//      original: kitty.png
+//
+// Variants come out fingerprinted under Output, so they can be cached
+// forever.
+package images
+
+import (
+    "atomicfile"
+    "bytes"
+    "config"
+    "crypto/sha1"
+    "fmt"
+    "html"
+    "image"
+    "image/color"
+    _ "image/gif"
+    "image/jpeg"
+    "image/png"
+    "io/ioutil"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+var (
+    // Sizes are the variants made for every image, by width. Images are
+    // never scaled up, so small originals get fewer variants.
+    Sizes = []Size{{"small", 240}, {"medium", 480}, {"large", 960}}
+
+    // Output is where variants get written. It's served from Prefix.
+    Output = "public/images"
+    Prefix = "/images"
+
+    // SizesAttr is the sizes attribute for the content column.
+    SizesAttr = "(max-width: 660px) 100vw, 640px"
+)
+
+// Size is a named target width.
+type Size struct {
+    Name  string
+    Width int
+}
+
+// Variant is a single generated file.
+type Variant struct {
+    Size          string
+    URL           string
+    Width, Height int
+}
+
+// Image is every variant of an original, smallest first. The original itself
+// is always last.
+type Image struct {
+    Name     string
+    Variants []Variant
+}
+
+// Local returns the path of the original if an images front matter entry is
+// a local file rather than a URL. It has to stay inside the post's directory,
+// so nothing with .. in it counts.
+func Local(sizes map[string]string) (string, bool) {
+    original, ok := sizes["original"]
+    if !ok || original == "" || strings.Contains(original, "://") || strings.HasPrefix(original, "/") {
+        return "", false
+    }
+    for _, element := range strings.FieldsFunc(original, func(r rune) bool { return r == '/' || r == '\\' }) {
+        if element == ".." {
+            return "", false
+        }
+    }
+    return original, true
+}
+
+// Largest picks the biggest URL out of an images front matter entry, for
+// places that only get one.
+func Largest(sizes map[string]string) string {
+    for _, size := range []string{"large", "original", "medium", "small"} {
+        if url, ok := sizes[size]; ok {
+            return url
+        }
+    }
+    return ""
+}
+
+// Get returns the variant for a size, if there is one.
+func (i *Image) Get(size string) (Variant, bool) {
+    for _, v := range i.Variants {
+        if v.Size == size {
+            return v, true
+        }
+    }
+    return Variant{}, false
+}
+
+// URLs maps size names to URLs, the same shape as the images front matter.
+// Sizes that got skipped because the original is too small point at the
+// original, so lookups for them still work.
+func (i *Image) URLs() map[string]string {
+    urls := make(map[string]string)
+    original := i.Variants[len(i.Variants)-1]
+    for _, s := range Sizes {
+        urls[s.Name] = original.URL
+    }
+    for _, v := range i.Variants {
+        urls[v.Size] = v.URL
+    }
+    return urls
+}
+
+// Srcset builds the srcset attribute.
+func (i *Image) Srcset() string {
+    candidates := make([]string, len(i.Variants))
+    for n, v := range i.Variants {
+        candidates[n] = fmt.Sprintf("%s %dw", v.URL, v.Width)
+    }
+    return strings.Join(candidates, ", ")
+}
+
+// Tag is an img tag for the variant for size, or the original if the
+// original's too small to have one, with every variant in srcset so the
+// browser can pick. attrs go in as they are.
+func (i *Image) Tag(size, alt, attrs string) string {
+    variant, ok := i.Get(size)
+    if !ok {
+        variant = i.Variants[len(i.Variants)-1]
+    }
+    if attrs != "" {
+        attrs = " " + attrs
+    }
+    return fmt.Sprintf(`<img src="%s" srcset="%s" sizes="%s" width="%d" height="%d" alt="%s" loading="lazy"%s>`,
+        html.EscapeString(variant.URL), html.EscapeString(i.Srcset()), SizesAttr,
+        variant.Width, variant.Height, html.EscapeString(alt), attrs)
+}
+
+// Process makes the variants for the original at file, writing them under
+// Output/dir. Variants that are already there are left alone.
+func Process(name, file, dir string) (*Image, error) {
+    data, err := ioutil.ReadFile(file)
+    if err != nil {
+        return nil, err
+    }
+    conf, format, err := image.DecodeConfig(bytes.NewReader(data))
+    if err != nil {
+        return nil, fmt.Errorf("%s: %s", file, err)
+    }
+
+    base := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+    sum := sha1.Sum(data)
+    fingerprint := fmt.Sprintf("%x", sum[:5])
+    img := &Image{Name: name}
+
+    var decoded image.Image
+    for _, size := range Sizes {
+        if size.Width >= conf.Width {
+            continue
+        }
+        height := conf.Height * size.Width / conf.Width
+        if height < 1 {
+            height = 1
+        }
+        ext := extension(format)
+        filename := fmt.Sprintf("%s-%s-%s%s", base, size.Name, fingerprint, ext)
+        if !exists(filepath.Join(Output, dir, filename)) {
+            if decoded == nil {
+                decoded, _, err = image.Decode(bytes.NewReader(data))
+                if err != nil {
+                    return nil, fmt.Errorf("%s: %s", file, err)
+                }
+            }
+            var buffer bytes.Buffer
+            if err := encode(&buffer, resize(decoded, size.Width, height), format); err != nil {
+                return nil, err
+            }
+            if err := atomicfile.WriteFile(filepath.Join(Output, dir, filename), buffer.Bytes(), 0644); err != nil {
+                return nil, err
+            }
+        }
+        img.Variants = append(img.Variants, Variant{size.Name, url(dir, filename), size.Width, height})
+    }
+
+    filename := fmt.Sprintf("%s-%s%s", base, fingerprint, filepath.Ext(file))
+    if !exists(filepath.Join(Output, dir, filename)) {
+        if err := atomicfile.WriteFile(filepath.Join(Output, dir, filename), data, 0644); err != nil {
+            return nil, err
+        }
+    }
+    img.Variants = append(img.Variants, Variant{"original", url(dir, filename), conf.Width, conf.Height})
+    sort.Stable(byWidth(img.Variants))
+    return img, nil
+}
+
+type byWidth []Variant
+
+func (v byWidth) Len() int           { return len(v) }
+func (v byWidth) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v byWidth) Less(i, j int) bool { return v[i].Width < v[j].Width }
+
+func url(dir, filename string) string {
+    return fmt.Sprintf("%s%s", config.AssetHost, path.Join(Prefix, dir, filename))
+}
+
+func exists(file string) bool {
+    _, err := os.Stat(file)
+    return err == nil
+}
+
+// GIFs come out as PNGs, since re-encoding them would mean dithering.
+func extension(format string) string {
+    switch format {
+    case "jpeg":
+        return ".jpg"
+    default:
+        return ".png"
+    }
+}
+
+func encode(buffer *bytes.Buffer, img image.Image, format string) error {
+    switch format {
+    case "jpeg":
+        return jpeg.Encode(buffer, img, &jpeg.Options{Quality: 85})
+    default:
+        return png.Encode(buffer, img)
+    }
+}
+
+// resize scales down with a box filter, averaging every source pixel that
+// lands in each destination pixel. It's only ever used to shrink.
+func resize(src image.Image, width, height int) image.Image {
+    b := src.Bounds()
+    dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+    for y := 0; y < height; y++ {
+        y0 := b.Min.Y + y*b.Dy()/height
+        y1 := b.Min.Y + (y+1)*b.Dy()/height
+        if y1 <= y0 {
+            y1 = y0 + 1
+        }
+        for x := 0; x < width; x++ {
+            x0 := b.Min.X + x*b.Dx()/width
+            x1 := b.Min.X + (x+1)*b.Dx()/width
+            if x1 <= x0 {
+                x1 = x0 + 1
+            }
+            var r, g, bl, a, n uint64
+            for sy := y0; sy < y1; sy++ {
+                for sx := x0; sx < x1; sx++ {
+                    pr, pg, pb, pa := src.At(sx, sy).RGBA()
+                    r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+                    n++
+                }
+            }
+            dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+        }
+    }
+    return dst
+}
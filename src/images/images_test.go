@@ -0,0 +1,84 @@
+package images_test
+
+import (
+    "image"
+    "image/color"
+    "image/png"
+    "images"
+    . "launchpad.net/gocheck"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ImagesSuite struct {
+    dir string
+}
+
+var _ = Suite(&ImagesSuite{})
+
+func (s *ImagesSuite) SetUpTest(c *C) {
+    s.dir = c.MkDir()
+    images.Output = filepath.Join(s.dir, "public")
+
+    img := image.NewNRGBA(image.Rect(0, 0, 600, 300))
+    for y := 0; y < 300; y++ {
+        for x := 0; x < 600; x++ {
+            img.Set(x, y, color.NRGBA{uint8(x), uint8(y), 0, 255})
+        }
+    }
+    f, err := os.Create(filepath.Join(s.dir, "kitty.png"))
+    c.Assert(err, IsNil)
+    defer f.Close()
+    c.Assert(png.Encode(f, img), IsNil)
+}
+
+func (s *ImagesSuite) TestLocal(c *C) {
+    _, ok := images.Local(map[string]string{"original": "http://cdn.verboselogging.com/kitty.png"})
+    c.Check(ok, Equals, false)
+    original, ok := images.Local(map[string]string{"original": "kitty.png"})
+    c.Check(ok, Equals, true)
+    c.Check(original, Equals, "kitty.png")
+    original, ok = images.Local(map[string]string{"original": "photos/kitty.png"})
+    c.Check(ok, Equals, true)
+    for _, outside := range []string{"../kitty.png", "photos/../../kitty.png", "..", "photos/..\\kitty.png", "/etc/passwd"} {
+        _, ok = images.Local(map[string]string{"original": outside})
+        c.Check(ok, Equals, false)
+    }
+}
+
+func (s *ImagesSuite) TestProcess(c *C) {
+    img, err := images.Process("kitty", filepath.Join(s.dir, "kitty.png"), "a-post")
+    c.Assert(err, IsNil)
+    c.Assert(img.Variants, HasLen, 3)
+
+    var sizes []string
+    for _, v := range img.Variants {
+        sizes = append(sizes, v.Size)
+        c.Check(strings.HasPrefix(v.URL, "http://localhost:5000/images/a-post/kitty-"), Equals, true)
+        _, err := os.Stat(filepath.Join(images.Output, "a-post", filepath.Base(v.URL)))
+        c.Check(err, IsNil)
+    }
+    c.Check(sizes, DeepEquals, []string{"small", "medium", "original"})
+
+    small, _ := img.Get("small")
+    c.Check(small.Width, Equals, 240)
+    c.Check(small.Height, Equals, 120)
+
+    f, err := os.Open(filepath.Join(images.Output, "a-post", filepath.Base(small.URL)))
+    c.Assert(err, IsNil)
+    defer f.Close()
+    conf, _, err := image.DecodeConfig(f)
+    c.Assert(err, IsNil)
+    c.Check(conf.Width, Equals, 240)
+
+    urls := img.URLs()
+    c.Check(urls["large"], Equals, urls["original"])
+    c.Check(strings.Count(img.Srcset(), "w,"), Equals, 2)
+
+    tag := img.Tag("large", `Kitty "Cat"`, `class="fright"`)
+    c.Check(tag, Matches, `<img src="[^"]+/kitty-[0-9a-f]+\.png" srcset="[^"]+ 240w, [^"]+ 480w, [^"]+ 600w" sizes="[^"]+" width="600" height="300" alt="Kitty &#34;Cat&#34;" loading="lazy" class="fright">`)
+}
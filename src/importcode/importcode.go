@@ -0,0 +1,243 @@
+// Command importcode turns the code in posts into fenced code blocks, so it
+// all gets highlighted when the post is rendered:
+//
+//  importcode [-n] [dir...]
+//
+// Indented code blocks get fences around them, and gists embedded with a
+// script tag get fetched from GitHub and put in the post in their place.
+// Only the body is touched, the front matter stays as it was. Commit what it
+// writes along with the posts. With -n it only says what it would do.
+package main
+
+import (
+    "content"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "highlight"
+    "io"
+    "io/ioutil"
+    "logging"
+    "net/http"
+    "os"
+    "path"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+)
+
+var (
+    logger = logging.New("importcode")
+    dryRun = flag.Bool("n", false, "only say what would be changed")
+    client = &http.Client{Timeout: time.Minute}
+    gists  = make(map[string]*gist)
+
+    gistEmbed = regexp.MustCompile(`<script[^>]*\bsrc="https?://gist\.github\.com/(?:[\w-]+/)?([0-9a-f]+)\.js(?:\?file=([^"&]*))?"[^>]*>\s*</script>`)
+    fenceLine = regexp.MustCompile("^ {0,3}(```|~~~)")
+)
+
+// maxGistSize is more than any gist the posts embed.
+const maxGistSize = 5 << 20
+
+func main() {
+    flag.Parse()
+    dirs := flag.Args()
+    if len(dirs) == 0 {
+        dirs = []string{"posts", "pages"}
+    }
+    failed := false
+    for _, dir := range dirs {
+        paths, err := filepath.Glob(filepath.Join(dir, "*.md"))
+        if err != nil {
+            logger.Fatalf("failed listing %s: %s", dir, err)
+        }
+        for _, path := range paths {
+            if err := importSource(path); err != nil {
+                logger.Error("failed importing", "path", path, "error", err)
+                failed = true
+            }
+        }
+    }
+    if failed {
+        os.Exit(1)
+    }
+}
+
+// importSource fences the code of the source at path, and writes it back if
+// anything changed. Gists that can't be fetched are left as they are, and
+// make it fail once everything else is written.
+func importSource(path string) error {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    _, body, err := content.Parse(data)
+    if err != nil {
+        return err
+    }
+    if !strings.HasSuffix(string(data), body) {
+        return errors.New("can't find where the body starts")
+    }
+    head := string(data[:len(data)-len(body)])
+
+    fenced, blocks := fenceIndented(body)
+    if blocks > 0 {
+        logger.Info("fenced indented code", "path", path, "blocks", blocks)
+    }
+    var failures []string
+    fenced = gistEmbed.ReplaceAllStringFunc(fenced, func(embed string) string {
+        m := gistEmbed.FindStringSubmatch(embed)
+        if *dryRun {
+            logger.Info("would import a gist", "path", path, "gist", m[1], "file", m[2])
+            return embed
+        }
+        code, err := fetchGist(m[1], m[2])
+        if err != nil {
+            failures = append(failures, fmt.Sprintf("gist %s: %s", m[1], err))
+            return embed
+        }
+        logger.Info("imported a gist", "path", path, "gist", m[1], "file", m[2])
+        return code
+    })
+
+    if fenced != body && !*dryRun {
+        if err := ioutil.WriteFile(path, []byte(head+fenced), 0644); err != nil {
+            return err
+        }
+    }
+    if len(failures) > 0 {
+        return errors.New(strings.Join(failures, "; "))
+    }
+    return nil
+}
+
+// fenceIndented puts fences around indented code blocks the same way
+// markdown finds them: indented lines after a blank line, up to the next
+// line that isn't indented or blank. It returns how many it fenced.
+func fenceIndented(body string) (string, int) {
+    lines := strings.SplitAfter(body, "\n")
+    var out, code []string
+    blocks := 0
+    flush := func() {
+        // Blank lines at the end go after the fence.
+        n := len(code)
+        for n > 0 && strings.TrimSpace(code[n-1]) == "" {
+            n--
+        }
+        out = append(out, fence("", strings.Join(code[:n], "")))
+        out = append(out, code[n:]...)
+        code = nil
+        blocks++
+    }
+
+    blank, fenced := true, false
+    for _, line := range lines {
+        isBlank := strings.TrimSpace(line) == ""
+        indented := strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")
+        switch {
+        case fenced:
+        case len(code) > 0 && (indented || isBlank):
+            code = append(code, unindent(line))
+            continue
+        case len(code) > 0:
+            flush()
+        }
+        if !fenced && blank && indented && !isBlank {
+            code = append(code, unindent(line))
+            continue
+        }
+        if fenceLine.MatchString(line) {
+            fenced = !fenced
+        }
+        out = append(out, line)
+        blank = isBlank
+    }
+    if len(code) > 0 {
+        flush()
+    }
+    return strings.Join(out, ""), blocks
+}
+
+func unindent(line string) string {
+    if strings.HasPrefix(line, "\t") {
+        return line[1:]
+    }
+    return strings.TrimPrefix(line, "    ")
+}
+
+// fence wraps code in a fence long enough that nothing in the code ends it.
+func fence(lang, code string) string {
+    marker := "```"
+    for strings.Contains(code, marker) {
+        marker += "`"
+    }
+    if !strings.HasSuffix(code, "\n") {
+        code += "\n"
+    }
+    return marker + lang + "\n" + code + marker + "\n"
+}
+
+type gist struct {
+    Files map[string]struct {
+        Content   string `json:"content"`
+        Truncated bool   `json:"truncated"`
+    } `json:"files"`
+}
+
+// fetchGist returns a file from a gist as fenced code, or every file in
+// it, in the order GitHub shows them, if file is empty.
+func fetchGist(id, file string) (string, error) {
+    g, ok := gists[id]
+    if !ok {
+        var err error
+        if g, err = download(id); err != nil {
+            return "", err
+        }
+        gists[id] = g
+    }
+
+    var names []string
+    if file != "" {
+        names = []string{file}
+    } else {
+        for name := range g.Files {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+    }
+    var blocks []string
+    for _, name := range names {
+        f, ok := g.Files[name]
+        switch {
+        case !ok:
+            return "", fmt.Errorf("there's no file %s", name)
+        case f.Truncated:
+            return "", fmt.Errorf("%s is too big to come with the gist", name)
+        }
+        lang := strings.TrimPrefix(path.Ext(name), ".")
+        if highlight.Lookup(lang) == nil {
+            lang = ""
+        }
+        blocks = append(blocks, fence(lang, f.Content))
+    }
+    return "\n\n" + strings.Join(blocks, "\n") + "\n", nil
+}
+
+func download(id string) (*gist, error) {
+    resp, err := client.Get("https://api.github.com/gists/" + id)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("GitHub responded with %s", resp.Status)
+    }
+    var g gist
+    if err := json.NewDecoder(io.LimitReader(resp.Body, maxGistSize)).Decode(&g); err != nil {
+        return nil, err
+    }
+    return &g, nil
+}
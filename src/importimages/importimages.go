@@ -0,0 +1,154 @@
+// Command importimages brings the images posts still load off the old CDN
+// home, next to the posts, so the site makes its own variants of them:
+//
+//  importimages [-n] [dir...]
+//
+// For every images front matter entry that's all URLs, the biggest copy that
+// can still be fetched goes in a directory named after the post file, and
+// the entry becomes just its original. Commit what it writes along with the
+// posts. With -n it only says what it would do.
+package main
+
+import (
+    "bytes"
+    "content"
+    "errors"
+    "flag"
+    "fmt"
+    "image"
+    "images"
+    "io"
+    "io/ioutil"
+    "logging"
+    "net/http"
+    "net/url"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+var (
+    logger = logging.New("importimages")
+    dryRun = flag.Bool("n", false, "only say what would be imported")
+    client = &http.Client{Timeout: time.Minute}
+)
+
+// maxImageSize is more than any image on the old CDN.
+const maxImageSize = 20 << 20
+
+func main() {
+    flag.Parse()
+    dirs := flag.Args()
+    if len(dirs) == 0 {
+        dirs = []string{"posts", "pages"}
+    }
+    failed := false
+    for _, dir := range dirs {
+        paths, err := filepath.Glob(filepath.Join(dir, "*.md"))
+        if err != nil {
+            logger.Fatalf("failed listing %s: %s", dir, err)
+        }
+        for _, path := range paths {
+            if err := importSource(path); err != nil {
+                logger.Error("failed importing", "path", path, "error", err)
+                failed = true
+            }
+        }
+    }
+    if failed {
+        os.Exit(1)
+    }
+}
+
+// importSource imports every remote image of the source at path, and
+// rewrites its front matter if any were.
+func importSource(path string) error {
+    fm, body, err := content.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    dir := strings.TrimSuffix(path, filepath.Ext(path))
+    changed := false
+    for _, name := range sortedNames(fm.Images) {
+        sizes := fm.Images[name]
+        if _, ok := images.Local(sizes); ok {
+            continue
+        }
+        file, err := fetch(name, sizes, dir)
+        if err != nil {
+            return fmt.Errorf("image %s: %s", name, err)
+        }
+        fm.Images[name] = map[string]string{"original": file}
+        changed = true
+    }
+    if !changed || *dryRun {
+        return nil
+    }
+    logger.Info("rewrote front matter", "path", path)
+    return content.WriteFile(path, fm, body)
+}
+
+// fetch saves the biggest copy of an image that's still there into dir,
+// returning its name there.
+func fetch(name string, sizes map[string]string, dir string) (string, error) {
+    var tried []string
+    for _, size := range []string{"original", "large", "medium", "small"} {
+        src, ok := sizes[size]
+        if !ok || !strings.Contains(src, "://") {
+            continue
+        }
+        u, err := url.Parse(src)
+        if err != nil {
+            return "", err
+        }
+        file := name + path.Ext(u.Path)
+        if *dryRun {
+            logger.Info("would import", "image", name, "from", src, "to", filepath.Join(dir, file))
+            return file, nil
+        }
+        if err := download(src, filepath.Join(dir, file)); err != nil {
+            tried = append(tried, err.Error())
+            continue
+        }
+        logger.Info("imported", "image", name, "from", src, "to", filepath.Join(dir, file))
+        return file, nil
+    }
+    if len(tried) == 0 {
+        return "", errors.New("there's no URL to fetch")
+    }
+    return "", fmt.Errorf("couldn't fetch any size: %s", strings.Join(tried, "; "))
+}
+
+func download(src, file string) error {
+    resp, err := client.Get(src)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("%s responded with %s", src, resp.Status)
+    }
+    data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxImageSize))
+    if err != nil {
+        return err
+    }
+    if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+        return fmt.Errorf("%s isn't an image: %s", src, err)
+    }
+    if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+        return err
+    }
+    return ioutil.WriteFile(file, data, 0644)
+}
+
+func sortedNames(m map[string]map[string]string) []string {
+    names := make([]string, 0, len(m))
+    for name := range m {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
@@ -0,0 +1,268 @@
+// Package logging writes log lines as fields, in logfmt or JSON, so they can
+// be searched instead of squinted at. Every line has a time, a level and a
+// message, then whatever fields the logger and the call add.
+package logging
+
+import (
+    "bytes"
+    "config"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "unicode"
+)
+
+// Level is how much a line matters.
+type Level int
+
+const (
+    Debug Level = iota
+    Info
+    Warn
+    Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+    if l < Debug || l > Error {
+        return "level(" + strconv.Itoa(int(l)) + ")"
+    }
+    return levelNames[l]
+}
+
+// ParseLevel is the level with a name like "warn".
+func ParseLevel(name string) (Level, error) {
+    for i, n := range levelNames {
+        if strings.EqualFold(name, n) {
+            return Level(i), nil
+        }
+    }
+    return Info, fmt.Errorf("logging: no level called %q", name)
+}
+
+// Default is the logger New makes loggers from, set up by LOG_FORMAT and
+// LOG_LEVEL.
+var Default = NewLogger(os.Stdout, config.LogFormat, configLevel())
+
+func configLevel() Level {
+    level, err := ParseLevel(config.LogLevel)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%s, logging at info\n", err)
+    }
+    return level
+}
+
+// output is what every logger made from the same NewLogger shares.
+type output struct {
+    lock   sync.Mutex
+    w      io.Writer
+    json   bool
+    level  Level
+    buffer bytes.Buffer
+}
+
+// Logger writes lines with its fields on them.
+type Logger struct {
+    out    *output
+    fields []interface{}
+}
+
+// NewLogger writes to w in format, "json" or "logfmt", leaving out anything
+// less than level.
+func NewLogger(w io.Writer, format string, level Level) *Logger {
+    return &Logger{out: &output{w: w, json: format == "json", level: level}}
+}
+
+// New is a logger for a package, with its name on every line.
+func New(name string) *Logger {
+    return Default.With("logger", name)
+}
+
+// With is l with more fields, given as key, value, key, value.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+    fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+    fields = append(fields, l.fields...)
+    return &Logger{l.out, append(fields, keyvals...)}
+}
+
+// SetOutput changes where l, and every logger it shares a NewLogger with,
+// writes.
+func (l *Logger) SetOutput(w io.Writer) {
+    l.out.lock.Lock()
+    l.out.w = w
+    l.out.lock.Unlock()
+}
+
+// Enabled is whether lines at level get written, for skipping work that's
+// only for the log.
+func (l *Logger) Enabled(level Level) bool {
+    return level >= l.out.level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) { l.Log(Debug, msg, keyvals...) }
+func (l *Logger) Info(msg string, keyvals ...interface{})  { l.Log(Info, msg, keyvals...) }
+func (l *Logger) Warn(msg string, keyvals ...interface{})  { l.Log(Warn, msg, keyvals...) }
+func (l *Logger) Error(msg string, keyvals ...interface{}) { l.Log(Error, msg, keyvals...) }
+
+// Printf logs at info, for code that only has a message to give.
+func (l *Logger) Printf(format string, v ...interface{}) {
+    l.Log(Info, fmt.Sprintf(format, v...))
+}
+
+// Fatalf logs at error and exits.
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+    l.Log(Error, fmt.Sprintf(format, v...))
+    os.Exit(1)
+}
+
+// Std is l as a *log.Logger, for things that want one. What they print is
+// logged at info.
+func (l *Logger) Std() *log.Logger {
+    return log.New(stdWriter{l}, "", 0)
+}
+
+type stdWriter struct {
+    l *Logger
+}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+    w.l.Info(strings.TrimSuffix(string(p), "\n"))
+    return len(p), nil
+}
+
+// Log writes a line at level, if it's enabled.
+func (l *Logger) Log(level Level, msg string, keyvals ...interface{}) {
+    if !l.Enabled(level) {
+        return
+    }
+    fields := make([]interface{}, 0, 6+len(l.fields)+len(keyvals)+1)
+    fields = append(fields, "time", time.Now().UTC(), "level", level, "msg", msg)
+    fields = append(fields, l.fields...)
+    fields = append(fields, keyvals...)
+    if len(fields)%2 != 0 {
+        fields = append(fields, "(missing)")
+    }
+
+    out := l.out
+    out.lock.Lock()
+    defer out.lock.Unlock()
+    out.buffer.Reset()
+    if out.json {
+        writeJSON(&out.buffer, fields)
+    } else {
+        writeLogfmt(&out.buffer, fields)
+    }
+    out.w.Write(out.buffer.Bytes())
+}
+
+// value is what gets written for v. Durations are in seconds, like the
+// metrics.
+func value(v interface{}) interface{} {
+    switch v := v.(type) {
+    case nil:
+        return nil
+    case time.Time:
+        return v.Format("2006-01-02T15:04:05.000000Z07:00")
+    case time.Duration:
+        return v.Seconds()
+    case error:
+        return v.Error()
+    case fmt.Stringer:
+        return v.String()
+    case string, bool, int, int64, uint64, float64:
+        return v
+    }
+    return fmt.Sprint(v)
+}
+
+func writeJSON(buf *bytes.Buffer, fields []interface{}) {
+    buf.WriteByte('{')
+    for i := 0; i < len(fields); i += 2 {
+        if i > 0 {
+            buf.WriteByte(',')
+        }
+        key, _ := json.Marshal(fmt.Sprint(fields[i]))
+        buf.Write(key)
+        buf.WriteByte(':')
+        v, err := json.Marshal(value(fields[i+1]))
+        if err != nil {
+            // NaN and the infinities.
+            v, _ = json.Marshal(fmt.Sprint(fields[i+1]))
+        }
+        buf.Write(v)
+    }
+    buf.WriteString("}\n")
+}
+
+func writeLogfmt(buf *bytes.Buffer, fields []interface{}) {
+    for i := 0; i < len(fields); i += 2 {
+        if i > 0 {
+            buf.WriteByte(' ')
+        }
+        buf.WriteString(strings.Map(func(r rune) rune {
+            if r <= ' ' || r == '=' || r == '"' {
+                return '_'
+            }
+            return r
+        }, fmt.Sprint(fields[i])))
+        buf.WriteByte('=')
+        switch v := value(fields[i+1]).(type) {
+        case nil:
+            buf.WriteString("null")
+        case float64:
+            buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+        case string:
+            if needsQuotes(v) {
+                v = strconv.Quote(v)
+            }
+            buf.WriteString(v)
+        default:
+            fmt.Fprint(buf, v)
+        }
+    }
+    buf.WriteByte('\n')
+}
+
+func needsQuotes(s string) bool {
+    if s == "" || s == "null" {
+        return true
+    }
+    for _, r := range s {
+        if r <= ' ' || r == '=' || r == '"' || r == '\\' || !unicode.IsPrint(r) {
+            return true
+        }
+    }
+    return false
+}
+
+// RemoteAddr is who asked. Behind Heroku's router, that's the last address
+// in X-Forwarded-For, the one the router added; anything before it came from
+// the client and could say anything. Without a proxy to trust, all of it
+// could, so it's where the connection came from.
+func RemoteAddr(r *http.Request) string {
+    return ClientAddr(r.Header.Get("X-Forwarded-For"), r.RemoteAddr)
+}
+
+// ClientAddr is RemoteAddr, from the header and the address the connection
+// came from.
+func ClientAddr(forwardedFor, remoteAddr string) string {
+    if forwardedFor != "" && config.TrustProxy == "yes" {
+        addrs := strings.Split(forwardedFor, ",")
+        if addr := strings.TrimSpace(addrs[len(addrs)-1]); addr != "" {
+            return addr
+        }
+    }
+    if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+        return host
+    }
+    return remoteAddr
+}
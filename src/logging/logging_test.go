@@ -0,0 +1,85 @@
+package logging_test
+
+import (
+    "bytes"
+    "config"
+    "encoding/json"
+    "errors"
+    . "launchpad.net/gocheck"
+    "logging"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type LoggingSuite struct{}
+
+var _ = Suite(&LoggingSuite{})
+
+func (s *LoggingSuite) TestLogfmt(c *C) {
+    var buf bytes.Buffer
+    l := logging.NewLogger(&buf, "logfmt", logging.Info).With("logger", "test")
+    l.Info("request", "status", 200, "duration", 1500*time.Millisecond, "path", "/a b", "error", errors.New(`said "no"`), "empty", "")
+    line := buf.String()
+    c.Check(line, Matches, `time=\S+ level=info msg=request logger=test status=200 duration=1.5 path="/a b" error="said \\"no\\"" empty=""\n`)
+}
+
+func (s *LoggingSuite) TestJSON(c *C) {
+    var buf bytes.Buffer
+    l := logging.NewLogger(&buf, "json", logging.Debug)
+    l.With("request_id", "abc").Warn("slow", "seconds", 2*time.Second, "odd")
+    var line map[string]interface{}
+    c.Assert(json.Unmarshal(buf.Bytes(), &line), IsNil)
+    c.Check(line["level"], Equals, "warn")
+    c.Check(line["msg"], Equals, "slow")
+    c.Check(line["request_id"], Equals, "abc")
+    c.Check(line["seconds"], Equals, 2.0)
+    c.Check(line["odd"], Equals, "(missing)")
+    _, err := time.Parse(time.RFC3339, line["time"].(string))
+    c.Check(err, IsNil)
+}
+
+func (s *LoggingSuite) TestLevels(c *C) {
+    var buf bytes.Buffer
+    l := logging.NewLogger(&buf, "logfmt", logging.Warn)
+    l.Debug("no")
+    l.Info("no")
+    l.Printf("no %d", 1)
+    l.Warn("yes")
+    l.Error("yes")
+    c.Check(strings.Count(buf.String(), "msg=yes"), Equals, 2)
+    c.Check(strings.Contains(buf.String(), "msg=no"), Equals, false)
+    c.Check(l.Enabled(logging.Info), Equals, false)
+
+    level, err := logging.ParseLevel("ERROR")
+    c.Check(err, IsNil)
+    c.Check(level, Equals, logging.Error)
+    _, err = logging.ParseLevel("loud")
+    c.Check(err, NotNil)
+}
+
+func (s *LoggingSuite) TestStd(c *C) {
+    var buf bytes.Buffer
+    logging.NewLogger(&buf, "logfmt", logging.Info).Std().Printf("from %s", "elsewhere")
+    c.Check(buf.String(), Matches, `time=\S+ level=info msg="from elsewhere"\n`)
+}
+
+func (s *LoggingSuite) TestRemoteAddr(c *C) {
+    config.TrustProxy = "yes"
+    defer func() { config.TrustProxy = "no" }()
+    r, _ := http.NewRequest("GET", "/", nil)
+    r.RemoteAddr = "10.0.0.1:1234"
+    c.Check(logging.RemoteAddr(r), Equals, "10.0.0.1")
+    r.Header.Set("X-Forwarded-For", "192.0.2.1")
+    c.Check(logging.RemoteAddr(r), Equals, "192.0.2.1")
+    // Only the last one is the router's.
+    r.Header.Set("X-Forwarded-For", "203.0.113.9, 192.0.2.1")
+    c.Check(logging.RemoteAddr(r), Equals, "192.0.2.1")
+
+    // Without the router, the header's just whatever the client said.
+    config.TrustProxy = "no"
+    c.Check(logging.RemoteAddr(r), Equals, "10.0.0.1")
+}
@@ -0,0 +1,264 @@
+// Package media keeps uploaded files. They're streamed to disk under a name
+// made from what's in them, checked for type and size on the way, and
+// written down in an index with whatever else is known about them.
+package media
+
+import (
+    "atomicfile"
+    "bytes"
+    "crypto/sha1"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "image"
+    _ "image/gif"
+    _ "image/jpeg"
+    _ "image/png"
+    "io"
+    "io/ioutil"
+    "mime"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+var (
+    ErrType     = errors.New("only images, audio and video can be uploaded")
+    ErrTooBig   = errors.New("the file is too big")
+    ErrNotFound = errors.New("there's no such file")
+
+    // Types are what can be uploaded, and the extension each gets. SVG is
+    // left out on purpose, since it can carry scripts.
+    Types = map[string]string{
+        "image/jpeg":      ".jpg",
+        "image/png":       ".png",
+        "image/gif":       ".gif",
+        "image/webp":      ".webp",
+        "audio/mpeg":      ".mp3",
+        "audio/mp4":       ".m4a",
+        "audio/ogg":       ".ogg",
+        "audio/wave":      ".wav",
+        "video/mp4":       ".mp4",
+        "video/webm":      ".webm",
+        "video/ogg":       ".ogv",
+        "application/ogg": ".ogg",
+    }
+)
+
+// Item is one file in the library.
+type Item struct {
+    Name     string    `json:"name"`
+    Original string    `json:"original,omitempty"`
+    Type     string    `json:"type"`
+    Size     int64     `json:"size"`
+    Width    int       `json:"width,omitempty"`
+    Height   int       `json:"height,omitempty"`
+    Alt      string    `json:"alt,omitempty"`
+    Uploader string    `json:"uploader,omitempty"`
+    Uploaded time.Time `json:"uploaded"`
+}
+
+// IsImage tells whether the item can go in an img tag.
+func (i *Item) IsImage() bool {
+    return strings.HasPrefix(i.Type, "image/")
+}
+
+// Library is a directory of files and the index of them.
+type Library struct {
+    // Dir is where the files go.
+    Dir string
+    // MaxSize is the most one file can be.
+    MaxSize int64
+
+    index string
+    items map[string]*Item
+    lock  sync.RWMutex
+}
+
+// Open reads the index at index, for files kept in dir.
+func Open(dir, index string, maxSize int64) (*Library, error) {
+    l := &Library{Dir: dir, MaxSize: maxSize, index: index, items: make(map[string]*Item)}
+    data, err := ioutil.ReadFile(index)
+    if os.IsNotExist(err) {
+        return l, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var items []*Item
+    if err := json.Unmarshal(data, &items); err != nil {
+        return nil, err
+    }
+    for _, item := range items {
+        l.items[item.Name] = item
+    }
+    return l, nil
+}
+
+// save writes the index. The lock has to be held.
+func (l *Library) save() error {
+    data, err := json.MarshalIndent(l.list(), "", "  ")
+    if err != nil {
+        return err
+    }
+    return atomicfile.WriteFile(l.index, data, 0644)
+}
+
+type byUploaded []*Item
+
+func (b byUploaded) Len() int      { return len(b) }
+func (b byUploaded) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byUploaded) Less(i, j int) bool {
+    if b[i].Uploaded.Equal(b[j].Uploaded) {
+        return b[i].Name < b[j].Name
+    }
+    return b[i].Uploaded.After(b[j].Uploaded)
+}
+
+func (l *Library) list() []*Item {
+    items := make([]*Item, 0, len(l.items))
+    for _, item := range l.items {
+        items = append(items, item)
+    }
+    sort.Sort(byUploaded(items))
+    return items
+}
+
+// List is everything, newest first.
+func (l *Library) List() []*Item {
+    l.lock.RLock()
+    defer l.lock.RUnlock()
+    return l.list()
+}
+
+// Get finds an item by name.
+func (l *Library) Get(name string) (*Item, bool) {
+    l.lock.RLock()
+    defer l.lock.RUnlock()
+    item, ok := l.items[name]
+    return item, ok
+}
+
+// SetAlt changes the alt text for an item.
+func (l *Library) SetAlt(name, alt string) error {
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    item, ok := l.items[name]
+    if !ok {
+        return ErrNotFound
+    }
+    item.Alt = alt
+    return l.save()
+}
+
+// Delete takes an item out of the library, file and all.
+func (l *Library) Delete(name string) error {
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    if _, ok := l.items[name]; !ok {
+        return ErrNotFound
+    }
+    if err := os.Remove(filepath.Join(l.Dir, name)); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    delete(l.items, name)
+    return l.save()
+}
+
+// contentType works out what a file really is from how it starts. Sniffing
+// doesn't know every audio and video format, so for what it can't tell
+// what the upload said goes, as long as it isn't claiming to be an image.
+func contentType(head []byte, declared string) (string, error) {
+    sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+    if _, ok := Types[sniffed]; ok {
+        return sniffed, nil
+    }
+    declared, _, _ = mime.ParseMediaType(declared)
+    if _, ok := Types[declared]; ok && sniffed == "application/octet-stream" && !strings.HasPrefix(declared, "image/") {
+        return declared, nil
+    }
+    return "", ErrType
+}
+
+// Save streams r into the library. The same file uploaded twice ends up
+// as one item; the first one's details are kept, except for alt text if
+// it didn't have any.
+func (l *Library) Save(filename, declared string, r io.Reader, alt, uploader string) (*Item, error) {
+    head := make([]byte, 512)
+    n, err := io.ReadFull(r, head)
+    if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+        return nil, err
+    }
+    head = head[:n]
+    typ, err := contentType(head, declared)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := os.MkdirAll(l.Dir, 0755); err != nil {
+        return nil, err
+    }
+    tmp, err := ioutil.TempFile(l.Dir, ".upload-")
+    if err != nil {
+        return nil, err
+    }
+    defer os.Remove(tmp.Name())
+    hash := sha1.New()
+    size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(io.MultiReader(bytes.NewReader(head), r), l.MaxSize+1))
+    if err == nil && size > l.MaxSize {
+        err = ErrTooBig
+    }
+    if cerr := tmp.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    item := &Item{
+        Name:     hex.EncodeToString(hash.Sum(nil))[:20] + Types[typ],
+        Original: filepath.Base(filename),
+        Type:     typ,
+        Size:     size,
+        Alt:      alt,
+        Uploader: uploader,
+        Uploaded: time.Now().UTC(),
+    }
+    if item.IsImage() {
+        item.Width, item.Height = dimensions(tmp.Name())
+    }
+
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    // Put the file in place even if it's known, in case it went missing.
+    if err := os.Rename(tmp.Name(), filepath.Join(l.Dir, item.Name)); err != nil {
+        return nil, err
+    }
+    if existing, ok := l.items[item.Name]; ok {
+        if existing.Alt != "" || alt == "" {
+            return existing, nil
+        }
+        existing.Alt = alt
+        item = existing
+    }
+    l.items[item.Name] = item
+    return item, l.save()
+}
+
+// dimensions are zero for images Go can't read, like WebP.
+func dimensions(file string) (int, int) {
+    f, err := os.Open(file)
+    if err != nil {
+        return 0, 0
+    }
+    defer f.Close()
+    conf, _, err := image.DecodeConfig(f)
+    if err != nil {
+        return 0, 0
+    }
+    return conf.Width, conf.Height
+}
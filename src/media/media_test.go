@@ -0,0 +1,95 @@
+package media_test
+
+import (
+    "bytes"
+    "image"
+    "image/png"
+    "io/ioutil"
+    . "launchpad.net/gocheck"
+    "media"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MediaSuite struct {
+    dir string
+    lib *media.Library
+}
+
+var _ = Suite(&MediaSuite{})
+
+func (s *MediaSuite) SetUpTest(c *C) {
+    s.dir = c.MkDir()
+    var err error
+    s.lib, err = media.Open(filepath.Join(s.dir, "media"), filepath.Join(s.dir, "media.json"), 1000)
+    c.Assert(err, IsNil)
+}
+
+func kitty() []byte {
+    var buffer bytes.Buffer
+    png.Encode(&buffer, image.NewGray(image.Rect(0, 0, 30, 20)))
+    return buffer.Bytes()
+}
+
+func (s *MediaSuite) TestSave(c *C) {
+    item, err := s.lib.Save("Kitty.PNG", "image/png", bytes.NewReader(kitty()), "", "jane")
+    c.Assert(err, IsNil)
+    c.Check(item.Name, Matches, `[0-9a-f]{20}\.png`)
+    c.Check(item.Original, Equals, "Kitty.PNG")
+    c.Check(item.Type, Equals, "image/png")
+    c.Check(item.Width, Equals, 30)
+    c.Check(item.Height, Equals, 20)
+    c.Check(item.Uploader, Equals, "jane")
+    data, err := ioutil.ReadFile(filepath.Join(s.dir, "media", item.Name))
+    c.Assert(err, IsNil)
+    c.Check(data, DeepEquals, kitty())
+
+    // Again is the same item, with the alt text it was missing.
+    again, err := s.lib.Save("other.png", "image/png", bytes.NewReader(kitty()), "A kitty", "bob")
+    c.Assert(err, IsNil)
+    c.Check(again.Name, Equals, item.Name)
+    c.Check(again.Uploader, Equals, "jane")
+    c.Check(again.Alt, Equals, "A kitty")
+    c.Check(s.lib.List(), HasLen, 1)
+
+    // And it's all still there when the library is opened again.
+    lib, err := media.Open(filepath.Join(s.dir, "media"), filepath.Join(s.dir, "media.json"), 1000)
+    c.Assert(err, IsNil)
+    found, ok := lib.Get(item.Name)
+    c.Assert(ok, Equals, true)
+    c.Check(found.Alt, Equals, "A kitty")
+    c.Check(found.Width, Equals, 30)
+    c.Assert(lib.SetAlt(item.Name, "Still a kitty"), IsNil)
+    c.Check(lib.SetAlt("nope.png", "Nothing"), Equals, media.ErrNotFound)
+
+    c.Assert(lib.Delete(item.Name), IsNil)
+    c.Check(lib.List(), HasLen, 0)
+    _, err = ioutil.ReadFile(filepath.Join(s.dir, "media", item.Name))
+    c.Check(err, NotNil)
+    c.Check(lib.Delete(item.Name), Equals, media.ErrNotFound)
+}
+
+func (s *MediaSuite) TestLimits(c *C) {
+    // What's in it counts, not what it says it is.
+    _, err := s.lib.Save("kitty.png", "image/png", strings.NewReader("<script>alert(1)</script>"), "", "")
+    c.Check(err, Equals, media.ErrType)
+    _, err = s.lib.Save("kitty.svg", "image/svg+xml", strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), "", "")
+    c.Check(err, Equals, media.ErrType)
+    item, err := s.lib.Save("kitty.jpg", "image/jpeg", bytes.NewReader(kitty()), "", "")
+    c.Assert(err, IsNil)
+    c.Check(item.Type, Equals, "image/png")
+    c.Check(item.Name, Matches, `.*\.png`)
+
+    // Sniffing doesn't know this one, so what it says goes.
+    item, err = s.lib.Save("song.m4a", "audio/mp4", bytes.NewReader([]byte{0, 0, 0, 0x18, 0x66, 0x74, 0x79, 0x70, 0, 0, 0, 0}), "", "")
+    c.Assert(err, IsNil)
+    c.Check(item.Type, Equals, "audio/mp4")
+
+    _, err = s.lib.Save("big.gif", "image/gif", strings.NewReader("GIF89a"+strings.Repeat("x", 1000)), "", "")
+    c.Check(err, Equals, media.ErrTooBig)
+    files, _ := ioutil.ReadDir(filepath.Join(s.dir, "media"))
+    c.Check(files, HasLen, 2)
+}
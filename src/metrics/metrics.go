@@ -0,0 +1,303 @@
+// Package metrics keeps counters, gauges and histograms, and writes them out
+// in the Prometheus text format for scraping. It's just enough of the real
+// client for one site: metrics are made once, when packages load, and live
+// forever.
+package metrics
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// DefaultBuckets suit durations in seconds of things that happen while
+// somebody waits.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry is a set of metrics to write out together.
+type Registry struct {
+    lock    sync.Mutex
+    metrics map[string]metric
+}
+
+type metric interface {
+    write(w *bufio.Writer)
+}
+
+// Default is where the New functions put metrics.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+    return &Registry{metrics: make(map[string]metric)}
+}
+
+func (r *Registry) register(name string, m metric) {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    if _, ok := r.metrics[name]; ok {
+        panic("metrics: " + name + " is already registered")
+    }
+    r.metrics[name] = m
+}
+
+// WriteTo writes every metric in the text exposition format, sorted by name
+// so scrapes are easy to compare.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+    r.lock.Lock()
+    names := make([]string, 0, len(r.metrics))
+    for name := range r.metrics {
+        names = append(names, name)
+    }
+    r.lock.Unlock()
+    sort.Strings(names)
+
+    cw := &countingWriter{w: w}
+    bw := bufio.NewWriter(cw)
+    for _, name := range names {
+        r.lock.Lock()
+        m := r.metrics[name]
+        r.lock.Unlock()
+        m.write(bw)
+    }
+    err := bw.Flush()
+    return cw.n, err
+}
+
+type countingWriter struct {
+    w io.Writer
+    n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+    n, err := c.w.Write(p)
+    c.n += int64(n)
+    return n, err
+}
+
+// desc is what every kind of metric has: a name, something to say about it,
+// and the names of its labels.
+type desc struct {
+    name, help, kind string
+    labels           []string
+}
+
+func (d *desc) header(w *bufio.Writer) {
+    help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help)
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, help, d.name, d.kind)
+}
+
+// key is how a set of label values is kept in a map.
+func (d *desc) key(values []string) string {
+    if len(values) != len(d.labels) {
+        panic(fmt.Sprintf("metrics: %s takes %d label values, not %d", d.name, len(d.labels), len(values)))
+    }
+    return strings.Join(values, "\xff")
+}
+
+// series writes a line for one value, with the extra label, if there is
+// one, after the others.
+func (d *desc) series(w *bufio.Writer, suffix, key string, extra []string, v float64) {
+    w.WriteString(d.name + suffix)
+    var pairs []string
+    if len(d.labels) > 0 {
+        for i, value := range strings.Split(key, "\xff") {
+            pairs = append(pairs, d.labels[i]+`="`+escape(value)+`"`)
+        }
+    }
+    if len(extra) == 2 {
+        pairs = append(pairs, extra[0]+`="`+escape(extra[1])+`"`)
+    }
+    if len(pairs) > 0 {
+        w.WriteString("{" + strings.Join(pairs, ",") + "}")
+    }
+    w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func escape(value string) string {
+    return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(v float64) string {
+    switch {
+    case math.IsInf(v, 1):
+        return "+Inf"
+    case math.IsInf(v, -1):
+        return "-Inf"
+    case math.IsNaN(v):
+        return "NaN"
+    }
+    return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// Counter only goes up.
+type Counter struct {
+    desc
+    lock   sync.Mutex
+    values map[string]float64
+}
+
+// NewCounter makes a counter with the given labels. Counter names end in
+// _total.
+func NewCounter(name, help string, labels ...string) *Counter {
+    c := &Counter{desc: desc{name, help, "counter", labels}, values: make(map[string]float64)}
+    Default.register(name, c)
+    return c
+}
+
+// Inc adds one for the label values.
+func (c *Counter) Inc(labelValues ...string) {
+    c.Add(1, labelValues...)
+}
+
+// Add adds v, which can't be negative, for the label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+    if v < 0 {
+        panic("metrics: " + c.name + " can't go down")
+    }
+    key := c.key(labelValues)
+    c.lock.Lock()
+    c.values[key] += v
+    c.lock.Unlock()
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+    c.header(w)
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    for _, key := range sortedKeys(c.values) {
+        c.series(w, "", key, nil, c.values[key])
+    }
+}
+
+// Gauge goes up and down.
+type Gauge struct {
+    desc
+    lock   sync.Mutex
+    values map[string]float64
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+    g := &Gauge{desc: desc{name, help, "gauge", labels}, values: make(map[string]float64)}
+    Default.register(name, g)
+    return g
+}
+
+// Set sets the value for the label values.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+    key := g.key(labelValues)
+    g.lock.Lock()
+    g.values[key] = v
+    g.lock.Unlock()
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+    g.header(w)
+    g.lock.Lock()
+    defer g.lock.Unlock()
+    for _, key := range sortedKeys(g.values) {
+        g.series(w, "", key, nil, g.values[key])
+    }
+}
+
+// GaugeFunc is a gauge that's worked out each time it's scraped.
+type GaugeFunc struct {
+    desc
+    f func() float64
+}
+
+func NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+    g := &GaugeFunc{desc{name, help, "gauge", nil}, f}
+    Default.register(name, g)
+    return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+    g.header(w)
+    g.series(w, "", "", nil, g.f())
+}
+
+// Histogram counts observations into buckets, keeping their sum too.
+type Histogram struct {
+    desc
+    buckets []float64
+    lock    sync.Mutex
+    values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+    counts []uint64
+    count  uint64
+    sum    float64
+}
+
+// NewHistogram makes a histogram with the given upper bounds, which have to
+// go up. There's always a +Inf bucket on the end.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+    if !sort.Float64sAreSorted(buckets) {
+        panic("metrics: buckets for " + name + " have to go up")
+    }
+    h := &Histogram{desc: desc{name, help, "histogram", labels}, buckets: buckets, values: make(map[string]*histogramValue)}
+    Default.register(name, h)
+    return h
+}
+
+// Observe counts v for the label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+    key := h.key(labelValues)
+    h.lock.Lock()
+    defer h.lock.Unlock()
+    value, ok := h.values[key]
+    if !ok {
+        value = &histogramValue{counts: make([]uint64, len(h.buckets))}
+        h.values[key] = value
+    }
+    // Buckets are cumulative when they're written out, so each observation
+    // only goes in the first one it fits.
+    if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+        value.counts[i]++
+    }
+    value.count++
+    value.sum += v
+}
+
+// Since observes the seconds since start, for timing things.
+func (h *Histogram) Since(start time.Time, labelValues ...string) {
+    h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+    h.header(w)
+    h.lock.Lock()
+    defer h.lock.Unlock()
+    keys := make([]string, 0, len(h.values))
+    for key := range h.values {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        value := h.values[key]
+        var cumulative uint64
+        for i, bound := range h.buckets {
+            cumulative += value.counts[i]
+            h.series(w, "_bucket", key, []string{"le", formatFloat(bound)}, float64(cumulative))
+        }
+        h.series(w, "_bucket", key, []string{"le", "+Inf"}, float64(value.count))
+        h.series(w, "_sum", key, nil, value.sum)
+        h.series(w, "_count", key, nil, float64(value.count))
+    }
+}
@@ -0,0 +1,74 @@
+package metrics_test
+
+import (
+    "bytes"
+    . "launchpad.net/gocheck"
+    "metrics"
+    "strings"
+    "testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MetricsSuite struct{}
+
+var _ = Suite(&MetricsSuite{})
+
+func scrape(c *C) string {
+    var buf bytes.Buffer
+    n, err := metrics.Default.WriteTo(&buf)
+    c.Assert(err, IsNil)
+    c.Check(n, Equals, int64(buf.Len()))
+    return buf.String()
+}
+
+func (s *MetricsSuite) TestExposition(c *C) {
+    requests := metrics.NewCounter("test_requests_total", "Requests.\nBy route.", "route", "code")
+    requests.Inc("/a", "200")
+    requests.Inc("/a", "200")
+    requests.Add(3, `/b "quoted" \ path`, "404")
+    metrics.NewGauge("test_documents", "Documents.", "repo").Set(12, "posts")
+    metrics.NewGaugeFunc("test_answer", "The answer.", func() float64 { return 42 })
+    latency := metrics.NewHistogram("test_seconds", "Latency.", []float64{0.1, 1}, "route")
+    latency.Observe(0.05, "/a")
+    latency.Observe(0.1, "/a")
+    latency.Observe(0.5, "/a")
+    latency.Observe(7, "/a")
+
+    out := scrape(c)
+    c.Check(strings.Contains(out, `# HELP test_requests_total Requests.\nBy route.
+# TYPE test_requests_total counter
+test_requests_total{route="/a",code="200"} 2
+test_requests_total{route="/b \"quoted\" \\ path",code="404"} 3
+`), Equals, true)
+    c.Check(strings.Contains(out, "# TYPE test_documents gauge\ntest_documents{repo=\"posts\"} 12\n"), Equals, true)
+    c.Check(strings.Contains(out, "# TYPE test_answer gauge\ntest_answer 42\n"), Equals, true)
+    c.Check(strings.Contains(out, `# TYPE test_seconds histogram
+test_seconds_bucket{route="/a",le="0.1"} 2
+test_seconds_bucket{route="/a",le="1"} 3
+test_seconds_bucket{route="/a",le="+Inf"} 4
+test_seconds_sum{route="/a"} 7.65
+test_seconds_count{route="/a"} 4
+`), Equals, true)
+    // Sorted by name.
+    c.Check(strings.Index(out, "test_answer") < strings.Index(out, "test_documents"), Equals, true)
+}
+
+// panics is what f panicked with, if anything.
+func panics(f func()) (message string) {
+    defer func() {
+        if r := recover(); r != nil {
+            message = r.(string)
+        }
+    }()
+    f()
+    return ""
+}
+
+func (s *MetricsSuite) TestMisuse(c *C) {
+    counter := metrics.NewCounter("test_misuse_total", "Misuse.", "kind")
+    c.Check(panics(func() { counter.Inc() }), Matches, `.*takes 1 label values, not 0`)
+    c.Check(panics(func() { counter.Add(-1, "x") }), Matches, `.*can't go down`)
+    c.Check(panics(func() { metrics.NewCounter("test_misuse_total", "Again.") }), Matches, `.*already registered`)
+    c.Check(panics(func() { metrics.NewHistogram("test_backwards", "Backwards.", []float64{1, 0.1}) }), Matches, `.*have to go up`)
+}
@@ -0,0 +1,237 @@
+// Package micropub reads Micropub requests, so posts can be written from
+// any editor that speaks it. What happens to them is up to the caller.
+//
+// See http://www.w3.org/TR/micropub/ for the protocol.
+package micropub
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/url"
+    "strings"
+)
+
+const (
+    ActionCreate = "create"
+    ActionUpdate = "update"
+    ActionDelete = "delete"
+)
+
+var ErrNoURL = errors.New("url is required")
+
+// Properties are microformats2 properties. Values are mostly strings, but
+// can be objects, like {"html": "..."} for content.
+type Properties map[string][]interface{}
+
+// Get returns the first value of a property as a string, using the html or
+// value of an object.
+func (p Properties) Get(name string) string {
+    values := p[name]
+    if len(values) == 0 {
+        return ""
+    }
+    return stringOf(values[0])
+}
+
+// Strings returns every value of a property as a string.
+func (p Properties) Strings(name string) []string {
+    var values []string
+    for _, v := range p[name] {
+        if s := stringOf(v); s != "" {
+            values = append(values, s)
+        }
+    }
+    return values
+}
+
+// Has tells whether a property was given at all.
+func (p Properties) Has(name string) bool {
+    _, ok := p[name]
+    return ok
+}
+
+func stringOf(v interface{}) string {
+    switch v := v.(type) {
+    case string:
+        return v
+    case map[string]interface{}:
+        for _, key := range []string{"html", "value", "url"} {
+            if s, ok := v[key].(string); ok {
+                return s
+            }
+        }
+    }
+    return ""
+}
+
+// Request is a create, update or delete.
+type Request struct {
+    Action     string
+    Type       string
+    URL        string
+    Properties Properties
+
+    // For updates.
+    Replace Properties
+    Add     Properties
+    Delete  Properties
+    // DeleteNames are whole properties to remove.
+    DeleteNames []string
+}
+
+// ParseForm reads a form-encoded request. Multipart forms end up here too,
+// once the files are taken out.
+func ParseForm(form url.Values) (*Request, error) {
+    r := &Request{Action: form.Get("action"), URL: form.Get("url"), Properties: make(Properties)}
+    if r.Action == "" {
+        r.Action = ActionCreate
+    }
+    if r.Action != ActionCreate {
+        return r, r.check()
+    }
+    r.Type = "h-" + form.Get("h")
+    if r.Type == "h-" {
+        r.Type = "h-entry"
+    }
+    for key, values := range form {
+        switch key {
+        case "h", "action", "url", "access_token":
+            continue
+        }
+        name := strings.TrimSuffix(key, "[]")
+        for _, v := range values {
+            r.Properties[name] = append(r.Properties[name], v)
+        }
+    }
+    return r, r.check()
+}
+
+type jsonRequest struct {
+    Type       []string        `json:"type"`
+    Properties Properties      `json:"properties"`
+    Action     string          `json:"action"`
+    URL        string          `json:"url"`
+    Replace    Properties      `json:"replace"`
+    Add        Properties      `json:"add"`
+    Delete     json.RawMessage `json:"delete"`
+}
+
+// ParseJSON reads a JSON request.
+func ParseJSON(data []byte) (*Request, error) {
+    var j jsonRequest
+    if err := json.Unmarshal(data, &j); err != nil {
+        return nil, err
+    }
+    r := &Request{Action: j.Action, URL: j.URL, Properties: j.Properties, Replace: j.Replace, Add: j.Add}
+    if r.Action == "" {
+        r.Action = ActionCreate
+    }
+    if len(j.Type) > 0 {
+        r.Type = j.Type[0]
+    }
+    if r.Type == "" {
+        r.Type = "h-entry"
+    }
+    if r.Properties == nil {
+        r.Properties = make(Properties)
+    }
+    if len(j.Delete) > 0 {
+        // Either a list of names, or values to take out of each.
+        if err := json.Unmarshal(j.Delete, &r.DeleteNames); err != nil {
+            if err := json.Unmarshal(j.Delete, &r.Delete); err != nil {
+                return nil, errors.New("delete has to be a list of properties, or an object")
+            }
+        }
+    }
+    return r, r.check()
+}
+
+func (r *Request) check() error {
+    switch r.Action {
+    case ActionCreate:
+        if r.Type != "h-entry" {
+            return fmt.Errorf("can't create a %s", r.Type)
+        }
+    case ActionUpdate, ActionDelete:
+        if r.URL == "" {
+            return ErrNoURL
+        }
+    default:
+        return fmt.Errorf("can't %s", r.Action)
+    }
+    return nil
+}
+
+// Apply makes an update's changes to p.
+func (r *Request) Apply(p Properties) {
+    for name, values := range r.Replace {
+        p[name] = values
+    }
+    for name, values := range r.Add {
+        p[name] = append(p[name], values...)
+    }
+    for _, name := range r.DeleteNames {
+        delete(p, name)
+    }
+    for name, values := range r.Delete {
+        var kept []interface{}
+        for _, v := range p[name] {
+            if !contains(values, stringOf(v)) {
+                kept = append(kept, v)
+            }
+        }
+        if len(kept) == 0 {
+            delete(p, name)
+        } else {
+            p[name] = kept
+        }
+    }
+}
+
+func contains(values []interface{}, s string) bool {
+    for _, v := range values {
+        if stringOf(v) == s {
+            return true
+        }
+    }
+    return false
+}
+
+// Source is the answer to q=source: the post as properties, or only the ones
+// asked for.
+type Source struct {
+    Type       []string   `json:"type,omitempty"`
+    Properties Properties `json:"properties"`
+}
+
+func NewSource(p Properties, only []string) *Source {
+    if len(only) == 0 {
+        return &Source{Type: []string{"h-entry"}, Properties: p}
+    }
+    picked := make(Properties)
+    for _, name := range only {
+        if values, ok := p[name]; ok {
+            picked[name] = values
+        }
+    }
+    return &Source{Properties: picked}
+}
+
+// Config is the answer to q=config.
+type Config struct {
+    MediaEndpoint string   `json:"media-endpoint,omitempty"`
+    SyndicateTo   []string `json:"syndicate-to"`
+    Categories    []string `json:"categories,omitempty"`
+}
+
+// Error is what goes back when something's wrong, with one of the error
+// codes from the spec.
+type Error struct {
+    Code        string `json:"error"`
+    Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+    return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
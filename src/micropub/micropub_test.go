@@ -0,0 +1,58 @@
+package micropub_test
+
+import (
+    . "launchpad.net/gocheck"
+    "micropub"
+    "net/url"
+    "testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MicropubSuite struct{}
+
+var _ = Suite(&MicropubSuite{})
+
+func (s *MicropubSuite) TestParseForm(c *C) {
+    form, _ := url.ParseQuery("h=entry&content=Hi&category[]=go&category[]=web&access_token=x")
+    r, err := micropub.ParseForm(form)
+    c.Assert(err, IsNil)
+    c.Check(r.Action, Equals, micropub.ActionCreate)
+    c.Check(r.Type, Equals, "h-entry")
+    c.Check(r.Properties.Get("content"), Equals, "Hi")
+    c.Check(r.Properties.Strings("category"), DeepEquals, []string{"go", "web"})
+    c.Check(r.Properties.Has("access_token"), Equals, false)
+
+    form, _ = url.ParseQuery("h=event&name=Party")
+    _, err = micropub.ParseForm(form)
+    c.Check(err, ErrorMatches, "can't create a h-event")
+
+    form, _ = url.ParseQuery("action=delete")
+    _, err = micropub.ParseForm(form)
+    c.Check(err, Equals, micropub.ErrNoURL)
+}
+
+func (s *MicropubSuite) TestParseJSON(c *C) {
+    r, err := micropub.ParseJSON([]byte(`{"type":["h-entry"],"properties":{"content":[{"html":"<p>Hi</p>"}],"photo":[{"value":"http://example.com/a.jpg","alt":"A"}]}}`))
+    c.Assert(err, IsNil)
+    c.Check(r.Properties.Get("content"), Equals, "<p>Hi</p>")
+    c.Check(r.Properties.Strings("photo"), DeepEquals, []string{"http://example.com/a.jpg"})
+}
+
+func (s *MicropubSuite) TestUpdate(c *C) {
+    p := micropub.Properties{
+        "name":     {"Old"},
+        "category": {"go", "web", "misc"},
+        "summary":  {"Gone soon"},
+    }
+    r, err := micropub.ParseJSON([]byte(`{"action":"update","url":"http://example.com/post","replace":{"name":["New"]},"add":{"category":["more"]},"delete":{"category":["web"]}}`))
+    c.Assert(err, IsNil)
+    r.Apply(p)
+    c.Check(p.Get("name"), Equals, "New")
+    c.Check(p.Strings("category"), DeepEquals, []string{"go", "misc", "more"})
+
+    r, err = micropub.ParseJSON([]byte(`{"action":"update","url":"http://example.com/post","delete":["summary"]}`))
+    c.Assert(err, IsNil)
+    r.Apply(p)
+    c.Check(p.Has("summary"), Equals, false)
+}
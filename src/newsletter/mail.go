@@ -0,0 +1,114 @@
+package newsletter
+
+import (
+    "bytes"
+    "crypto/rand"
+    "encoding/hex"
+    "fmt"
+    "mime"
+    "mime/multipart"
+    "mime/quotedprintable"
+    "net"
+    "net/smtp"
+    "net/textproto"
+    "strings"
+    "time"
+)
+
+// Message is one email, with the same thing said as plain text and HTML.
+type Message struct {
+    To, Subject, Text, HTML string
+    // Unsubscribe is a URL that takes the recipient off the list, which mail
+    // programs show as a button.
+    Unsubscribe string
+}
+
+// Mailer sends through an SMTP server. It uses STARTTLS if the server has
+// it, and only signs in if there's a user name.
+type Mailer struct {
+    // Addr is the host:port of the server.
+    Addr               string
+    From               string
+    Username, Password string
+}
+
+// Send sends one message.
+func (m *Mailer) Send(msg *Message) error {
+    data, err := m.build(msg)
+    if err != nil {
+        return err
+    }
+    var auth smtp.Auth
+    if m.Username != "" {
+        host, _, _ := net.SplitHostPort(m.Addr)
+        auth = smtp.PlainAuth("", m.Username, m.Password, host)
+    }
+    return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, data)
+}
+
+// build writes out the message as multipart/alternative, plain text first
+// so the HTML is what gets shown when it can be.
+func (m *Mailer) build(msg *Message) ([]byte, error) {
+    if strings.ContainsAny(msg.To+msg.Subject+msg.Unsubscribe, "\r\n") {
+        return nil, fmt.Errorf("newsletter: a header has a line break in it")
+    }
+    var body bytes.Buffer
+    parts := multipart.NewWriter(&body)
+    for _, alt := range []struct{ contentType, content string }{
+        {"text/plain; charset=utf-8", msg.Text},
+        {"text/html; charset=utf-8", msg.HTML},
+    } {
+        w, err := parts.CreatePart(textproto.MIMEHeader{
+            "Content-Type":              {alt.contentType},
+            "Content-Transfer-Encoding": {"quoted-printable"},
+        })
+        if err != nil {
+            return nil, err
+        }
+        qp := quotedprintable.NewWriter(w)
+        if _, err := qp.Write([]byte(alt.content)); err != nil {
+            return nil, err
+        }
+        if err := qp.Close(); err != nil {
+            return nil, err
+        }
+    }
+    if err := parts.Close(); err != nil {
+        return nil, err
+    }
+
+    var data bytes.Buffer
+    header := func(name, value string) {
+        fmt.Fprintf(&data, "%s: %s\r\n", name, value)
+    }
+    header("From", m.From)
+    header("To", msg.To)
+    header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+    header("Date", time.Now().Format(time.RFC1123Z))
+    header("Message-ID", fmt.Sprintf("<%s@%s>", messageId(), domain(m.From)))
+    header("MIME-Version", "1.0")
+    if msg.Unsubscribe != "" {
+        header("List-Unsubscribe", "<"+msg.Unsubscribe+">")
+        header("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+    }
+    header("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+    data.WriteString("\r\n")
+    data.Write(body.Bytes())
+    return data.Bytes(), nil
+}
+
+func messageId() string {
+    p := make([]byte, 12)
+    if _, err := rand.Read(p); err != nil {
+        panic(err)
+    }
+    return hex.EncodeToString(p)
+}
+
+func domain(address string) string {
+    address = strings.TrimRight(address, ">")
+    if at := strings.LastIndex(address, "@"); at >= 0 {
+        return address[at+1:]
+    }
+    return "localhost"
+}
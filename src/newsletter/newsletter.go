@@ -0,0 +1,173 @@
+// Package newsletter keeps the people who want new posts by email, and
+// sends it to them. Subscribing takes confirming, and every send is written
+// down.
+package newsletter
+
+import (
+    "atomicfile"
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "logging"
+    "net/mail"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+var (
+    logger = logging.New("newsletter")
+
+    ErrBadEmail = errors.New("that doesn't look like an email address")
+)
+
+// Status is whether a subscriber has confirmed yet.
+type Status string
+
+const (
+    Pending   Status = "pending"
+    Confirmed Status = "confirmed"
+)
+
+// Subscriber is one address that asked for email.
+type Subscriber struct {
+    Email      string
+    Status     Status
+    Addr       string `json:",omitempty"`
+    Subscribed time.Time
+    Confirmed  time.Time
+}
+
+// CleanEmail checks an address somebody typed in and makes it the same
+// every time, so nobody gets subscribed twice.
+func CleanEmail(email string) (string, error) {
+    email = strings.TrimSpace(email)
+    a, err := mail.ParseAddress(email)
+    if err != nil || a.Address != email || len(email) > 254 || strings.ContainsAny(email, "\r\n") {
+        return "", ErrBadEmail
+    }
+    at := strings.LastIndex(email, "@")
+    return email[:at] + strings.ToLower(email[at:]), nil
+}
+
+// Store keeps the subscribers in a JSON file, like everything else in
+// DataDir.
+type Store struct {
+    Path        string
+    lock        sync.RWMutex
+    subscribers map[string]Subscriber
+}
+
+// Open loads the store at path, which doesn't have to exist yet.
+func Open(path string) (*Store, error) {
+    s := &Store{Path: path, subscribers: make(map[string]Subscriber)}
+    data, err := ioutil.ReadFile(path)
+    switch {
+    case os.IsNotExist(err):
+    case err != nil:
+        return nil, err
+    default:
+        var subscribers []Subscriber
+        if err := json.Unmarshal(data, &subscribers); err != nil {
+            return nil, err
+        }
+        for _, sub := range subscribers {
+            s.subscribers[sub.Email] = sub
+        }
+    }
+    return s, nil
+}
+
+// Subscribe adds a pending subscriber, unless they're already there, and
+// returns them either way.
+func (s *Store) Subscribe(email, addr string) (*Subscriber, error) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if sub, ok := s.subscribers[email]; ok {
+        return &sub, nil
+    }
+    sub := Subscriber{Email: email, Status: Pending, Addr: addr, Subscribed: time.Now().UTC()}
+    s.subscribers[email] = sub
+    return &sub, s.save()
+}
+
+// Confirm marks a subscriber as wanting the email after all. It's false if
+// they aren't there, say because they unsubscribed since.
+func (s *Store) Confirm(email string) (bool, error) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    sub, ok := s.subscribers[email]
+    if !ok {
+        return false, nil
+    }
+    if sub.Status == Confirmed {
+        return true, nil
+    }
+    sub.Status, sub.Confirmed = Confirmed, time.Now().UTC()
+    s.subscribers[email] = sub
+    return true, s.save()
+}
+
+// Unsubscribe takes somebody off the list. It's fine if they weren't on it.
+func (s *Store) Unsubscribe(email string) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if _, ok := s.subscribers[email]; !ok {
+        return nil
+    }
+    delete(s.subscribers, email)
+    return s.save()
+}
+
+// List is everybody, newest first.
+func (s *Store) List() []*Subscriber {
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    subscribers := make([]*Subscriber, 0, len(s.subscribers))
+    for email := range s.subscribers {
+        sub := s.subscribers[email]
+        subscribers = append(subscribers, &sub)
+    }
+    sort.Sort(byNewest(subscribers))
+    return subscribers
+}
+
+// Confirmed are the addresses to send to.
+func (s *Store) Confirmed() []string {
+    var emails []string
+    for _, sub := range s.List() {
+        if sub.Status == Confirmed {
+            emails = append(emails, sub.Email)
+        }
+    }
+    sort.Strings(emails)
+    return emails
+}
+
+// save writes the whole store atomically. The lock must be held.
+func (s *Store) save() error {
+    subscribers := make([]*Subscriber, 0, len(s.subscribers))
+    for email := range s.subscribers {
+        sub := s.subscribers[email]
+        subscribers = append(subscribers, &sub)
+    }
+    sort.Sort(byNewest(subscribers))
+    data, err := json.MarshalIndent(subscribers, "", "  ")
+    if err != nil {
+        return err
+    }
+    return atomicfile.WriteFile(s.Path, data, 0600)
+}
+
+type byNewest []*Subscriber
+
+func (b byNewest) Len() int      { return len(b) }
+func (b byNewest) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byNewest) Less(i, j int) bool {
+    if b[i].Subscribed.Equal(b[j].Subscribed) {
+        return b[i].Email < b[j].Email
+    }
+    return b[i].Subscribed.After(b[j].Subscribed)
+}
@@ -0,0 +1,125 @@
+package newsletter_test
+
+import (
+    "bytes"
+    "io/ioutil"
+    . "launchpad.net/gocheck"
+    "mime"
+    "mime/multipart"
+    "net/mail"
+    "newsletter"
+    "path/filepath"
+    "smtptest"
+    "strings"
+    "testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type NewsletterSuite struct {
+    dir string
+}
+
+var _ = Suite(&NewsletterSuite{})
+
+func (s *NewsletterSuite) SetUpTest(c *C) {
+    s.dir = c.MkDir()
+}
+
+func (s *NewsletterSuite) TestCleanEmail(c *C) {
+    email, err := newsletter.CleanEmail(" Jane.Doe@Example.COM ")
+    c.Assert(err, IsNil)
+    c.Check(email, Equals, "Jane.Doe@example.com")
+    for _, bad := range []string{"", "jane", "Jane <jane@example.com>", "jane@example.com\r\nBcc: x@example.com"} {
+        _, err := newsletter.CleanEmail(bad)
+        c.Check(err, Equals, newsletter.ErrBadEmail)
+    }
+}
+
+func (s *NewsletterSuite) TestStore(c *C) {
+    path := filepath.Join(s.dir, "subscribers.json")
+    store, err := newsletter.Open(path)
+    c.Assert(err, IsNil)
+    sub, err := store.Subscribe("jane@example.com", "192.0.2.1")
+    c.Assert(err, IsNil)
+    c.Check(sub.Status, Equals, newsletter.Pending)
+    _, err = store.Subscribe("bob@example.com", "")
+    c.Assert(err, IsNil)
+    c.Check(store.Confirmed(), HasLen, 0)
+
+    ok, err := store.Confirm("jane@example.com")
+    c.Assert(err, IsNil)
+    c.Check(ok, Equals, true)
+    ok, err = store.Confirm("nobody@example.com")
+    c.Assert(err, IsNil)
+    c.Check(ok, Equals, false)
+    // Subscribing again doesn't undo confirming.
+    sub, err = store.Subscribe("jane@example.com", "")
+    c.Assert(err, IsNil)
+    c.Check(sub.Status, Equals, newsletter.Confirmed)
+
+    store, err = newsletter.Open(path)
+    c.Assert(err, IsNil)
+    c.Check(store.Confirmed(), DeepEquals, []string{"jane@example.com"})
+    c.Check(store.List(), HasLen, 2)
+    c.Assert(store.Unsubscribe("jane@example.com"), IsNil)
+    c.Assert(store.Unsubscribe("jane@example.com"), IsNil)
+    c.Check(store.Confirmed(), HasLen, 0)
+}
+
+func (s *NewsletterSuite) TestSendAll(c *C) {
+    server := smtptest.NewServer()
+    defer server.Close()
+    mailer := &newsletter.Mailer{Addr: server.Addr, From: "blog@example.com"}
+    l, err := newsletter.OpenLog(filepath.Join(s.dir, "sends.json"))
+    c.Assert(err, IsNil)
+
+    send := mailer.SendAll(l, "digest", "New on the blog: Ünïcode", []string{"jane@example.com", "bad\r\n@example.com"}, func(to string) (*newsletter.Message, error) {
+        return &newsletter.Message{
+            To:          to,
+            Subject:     "New on the blog: Ünïcode",
+            Text:        "Read it at http://example.com/post\n",
+            HTML:        `<p><a href="http://example.com/post">Read it</a></p>`,
+            Unsubscribe: "http://example.com/unsubscribe?token=abc",
+        }, nil
+    })
+    c.Check(send.Sent, Equals, 1)
+    c.Check(send.Failed, Equals, 1)
+    c.Check(send.Errors, HasLen, 1)
+
+    messages := server.Messages()
+    c.Assert(messages, HasLen, 1)
+    c.Check(messages[0].From, Equals, "blog@example.com")
+    c.Check(messages[0].To, DeepEquals, []string{"jane@example.com"})
+    msg, err := mail.ReadMessage(strings.NewReader(messages[0].Data))
+    c.Assert(err, IsNil)
+    c.Check(msg.Header.Get("List-Unsubscribe"), Equals, "<http://example.com/unsubscribe?token=abc>")
+    c.Check(msg.Header.Get("List-Unsubscribe-Post"), Equals, "List-Unsubscribe=One-Click")
+    subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+    c.Assert(err, IsNil)
+    c.Check(subject, Equals, "New on the blog: Ünïcode")
+
+    mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+    c.Assert(err, IsNil)
+    c.Check(mediaType, Equals, "multipart/alternative")
+    parts := multipart.NewReader(msg.Body, params["boundary"])
+    var types, bodies []string
+    for {
+        part, err := parts.NextPart()
+        if err != nil {
+            break
+        }
+        data, _ := ioutil.ReadAll(part)
+        types = append(types, part.Header.Get("Content-Type"))
+        bodies = append(bodies, string(bytes.TrimSpace(data)))
+    }
+    c.Check(types, DeepEquals, []string{"text/plain; charset=utf-8", "text/html; charset=utf-8"})
+    c.Check(bodies, DeepEquals, []string{"Read it at http://example.com/post", `<p><a href="http://example.com/post">Read it</a></p>`})
+
+    l, err = newsletter.OpenLog(l.Path)
+    c.Assert(err, IsNil)
+    recent := l.Recent(10)
+    c.Assert(recent, HasLen, 1)
+    c.Check(recent[0].Subject, Equals, "New on the blog: Ünïcode")
+    c.Check(recent[0].Sent, Equals, 1)
+}
@@ -0,0 +1,100 @@
+package newsletter
+
+import (
+    "atomicfile"
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "sync"
+    "time"
+)
+
+// keepSends is how many sends the log remembers.
+const keepSends = 200
+
+// Send is one batch of email going out, and how it went.
+type Send struct {
+    Time    time.Time
+    Kind    string
+    Subject string
+    Sent    int
+    Failed  int
+    // Errors are the first few things that went wrong.
+    Errors []string `json:",omitempty"`
+}
+
+// Log keeps the last few hundred sends in a JSON file.
+type Log struct {
+    Path  string
+    lock  sync.Mutex
+    sends []*Send
+}
+
+// OpenLog loads the log at path, which doesn't have to exist yet.
+func OpenLog(path string) (*Log, error) {
+    l := &Log{Path: path}
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        return l, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal(data, &l.sends); err != nil {
+        return nil, err
+    }
+    return l, nil
+}
+
+// Add writes down a send.
+func (l *Log) Add(s *Send) error {
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    l.sends = append([]*Send{s}, l.sends...)
+    if len(l.sends) > keepSends {
+        l.sends = l.sends[:keepSends]
+    }
+    data, err := json.MarshalIndent(l.sends, "", "  ")
+    if err != nil {
+        return err
+    }
+    return atomicfile.WriteFile(l.Path, data, 0600)
+}
+
+// Recent is the last n sends, newest first.
+func (l *Log) Recent(n int) []*Send {
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    if n > len(l.sends) {
+        n = len(l.sends)
+    }
+    return append([]*Send(nil), l.sends[:n]...)
+}
+
+// maxErrors is how many errors one send keeps.
+const maxErrors = 5
+
+// SendAll sends a message to each address, made for it by build, and logs
+// how it went. One address failing doesn't stop the rest.
+func (m *Mailer) SendAll(l *Log, kind, subject string, to []string, build func(to string) (*Message, error)) *Send {
+    s := &Send{Time: time.Now().UTC(), Kind: kind, Subject: subject}
+    for _, address := range to {
+        msg, err := build(address)
+        if err == nil {
+            err = m.Send(msg)
+        }
+        if err != nil {
+            s.Failed++
+            logger.Printf("failed sending %s to %s: %s", kind, address, err)
+            if len(s.Errors) < maxErrors {
+                s.Errors = append(s.Errors, address+": "+err.Error())
+            }
+            continue
+        }
+        s.Sent++
+    }
+    if err := l.Add(s); err != nil {
+        logger.Printf("failed writing the send log: %s", err)
+    }
+    return s
+}
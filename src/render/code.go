@@ -0,0 +1,166 @@
+package render
+
+import (
+    "fmt"
+    "highlight"
+    "html"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+var (
+    fenceOpen   = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*([^`]*)$")
+    placeholder = regexp.MustCompile(`vl(?:placeholder|inline)(\d+)x`)
+    paragraph   = regexp.MustCompile(`<p>\s*vlplaceholder(\d+)x\s*</p>`)
+)
+
+// placeholders stand in for chunks of finished HTML while the rest of the
+// body goes through templates and markdown, so neither can mangle them.
+type placeholders []string
+
+func (p *placeholders) add(html string) string {
+    *p = append(*p, html)
+    return fmt.Sprintf("vlplaceholder%dx", len(*p)-1)
+}
+
+// addInline is add for HTML that belongs inside a paragraph, so it doesn't
+// take the paragraph's place when it's all there is in it.
+func (p *placeholders) addInline(html string) string {
+    *p = append(*p, html)
+    return fmt.Sprintf("vlinline%dx", len(*p)-1)
+}
+
+func (p placeholders) expand(html string) string {
+    lookup := func(re *regexp.Regexp) func(string) string {
+        return func(match string) string {
+            n, _ := strconv.Atoi(re.FindStringSubmatch(match)[1])
+            if n < len(p) {
+                return p[n]
+            }
+            return match
+        }
+    }
+    html = paragraph.ReplaceAllStringFunc(html, lookup(paragraph))
+    return placeholder.ReplaceAllStringFunc(html, lookup(placeholder))
+}
+
+// highlightFences pulls fenced code blocks out of the body, highlights them,
+// and leaves placeholders in their place. Fences that never close are left
+// alone.
+func highlightFences(body string, chunks *placeholders) string {
+    lines := strings.SplitAfter(body, "\n")
+    var out []string
+    for i := 0; i < len(lines); i++ {
+        m := fenceOpen.FindStringSubmatch(strings.TrimRight(lines[i], "\r\n"))
+        if m == nil {
+            out = append(out, lines[i])
+            continue
+        }
+
+        marker, info := m[1], m[2]
+        end := -1
+        for j := i + 1; j < len(lines); j++ {
+            line := strings.TrimSpace(lines[j])
+            if strings.HasPrefix(line, marker) && strings.Trim(line, marker[:1]) == "" {
+                end = j
+                break
+            }
+        }
+        if end < 0 {
+            out = append(out, lines[i])
+            continue
+        }
+
+        // Keep the line count the same so problems further down the body
+        // still get reported on the right line.
+        code := strings.Join(lines[i+1:end], "")
+        lang, opts := highlight.ParseInfo(info)
+        padding := end - i - 1
+        if padding < 1 {
+            padding = 1
+        }
+        out = append(out, "\n", chunks.add(highlight.Format(code, lang, opts)), strings.Repeat("\n", padding+1))
+        i = end
+    }
+    return strings.Join(out, "")
+}
+
+// protectCodeSpans does for inline code what highlightFences does for fenced
+// code, so shortcodes in backticks are shown instead of run. Indented code
+// blocks are left to markdown, and so is a span that never closes.
+func protectCodeSpans(body string, chunks *placeholders) string {
+    var out, text []string
+    flush := func() {
+        out = append(out, codeSpans(strings.Join(text, ""), chunks))
+        text = nil
+    }
+    inCode, blank := false, true
+    for _, line := range strings.SplitAfter(body, "\n") {
+        isBlank := strings.TrimSpace(line) == ""
+        indented := strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")
+        inCode = (inCode && (indented || isBlank)) || (blank && indented && !isBlank)
+        if inCode {
+            flush()
+            out = append(out, line)
+        } else {
+            text = append(text, line)
+        }
+        blank = isBlank
+    }
+    flush()
+    return strings.Join(out, "")
+}
+
+// codeSpans swaps every closed run of backticks in text for a placeholder.
+// Spans don't cross paragraphs.
+func codeSpans(text string, chunks *placeholders) string {
+    var out []string
+    for {
+        start := strings.Index(text, "`")
+        if start < 0 {
+            break
+        }
+        ticks := backticks(text[start:])
+        rest := text[start+ticks:]
+        end := closingTicks(rest, ticks)
+        if end < 0 {
+            out = append(out, text[:start+ticks])
+            text = rest
+            continue
+        }
+        code := strings.TrimSpace(rest[:end])
+        out = append(out, text[:start], chunks.addInline("<code>"+html.EscapeString(code)+"</code>"))
+        text = rest[end+ticks:]
+    }
+    return strings.Join(append(out, text), "")
+}
+
+// closingTicks finds a run of exactly n backticks in s before the next blank
+// line, or returns -1.
+func closingTicks(s string, n int) int {
+    if para := strings.Index(s, "\n\n"); para >= 0 {
+        s = s[:para]
+    }
+    for i := 0; i < len(s); {
+        j := strings.Index(s[i:], "`")
+        if j < 0 {
+            return -1
+        }
+        i += j
+        run := backticks(s[i:])
+        if run == n {
+            return i
+        }
+        i += run
+    }
+    return -1
+}
+
+func backticks(s string) int {
+    n := 0
+    for n < len(s) && s[n] == '`' {
+        n++
+    }
+    return n
+}
@@ -0,0 +1,246 @@
+// Package render turns markdown sources into the HTML that ends up on the
+// site, along with the bits we can work out from it along the way.
+package render
+
+import (
+    "bytes"
+    "content"
+    "fmt"
+    "github.com/darkhelmet/blargh/post"
+    "github.com/russross/blackfriday"
+    "html"
+    T "html/template"
+    "images"
+    "logging"
+    "metrics"
+    "regexp"
+    "shortcode"
+    "strings"
+    "sync"
+    "text/template"
+)
+
+const WordsPerMinute = 200
+
+var (
+    logger  = logging.New("render")
+    tags    = regexp.MustCompile(`(?s)<[^>]*>`)
+    scripts = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+
+    imgLookup      = regexp.MustCompile(`<img\s+([^>]*?)\s*src="\{\{\s*\.(\w+)\.(\w+)\s*\}\}"([^>]*?)\s*/?>`)
+    markdownLookup = regexp.MustCompile(`!\[([^\]]*)\]\(\{\{\s*\.(\w+)\.(\w+)\s*\}\}\)`)
+    altAttr        = regexp.MustCompile(`\balt="([^"]*)"`)
+    lock           sync.RWMutex
+    cache          = make(map[cacheKey]*Document)
+
+    cacheRequests = metrics.NewCounter("render_cache_requests_total", "Rendered documents asked for, by whether they were cached.", "result")
+)
+
+// Document is a rendered source.
+type Document struct {
+    HTML        T.HTML
+    TOC         []*Entry
+    ShowTOC     bool
+    WordCount   int
+    ReadingTime int
+    Images      map[string]map[string]string
+    Problems    []error
+}
+
+// Render runs the whole pipeline over a source. Code comes out first so
+// nothing else touches them, then shortcodes, so the images template and
+// markdown never see them either. Shortcodes that couldn't be expanded end up
+// in Problems.
+func Render(source *content.Source) (*Document, error) {
+    urls, err := resolveImages(source)
+    if err != nil {
+        return nil, err
+    }
+    var chunks placeholders
+    body := highlightFences(source.Body, &chunks)
+    body = protectCodeSpans(body, &chunks)
+    body, problems := shortcode.Expand(body, &shortcode.Context{Images: urls, Responsive: source.Images}, chunks.add)
+    body, err = expandImages(source.Path, body, urls, source.Images)
+    if err != nil {
+        return nil, err
+    }
+    html := string(blackfriday.MarkdownCommon([]byte(body)))
+    html, toc := anchorHeadings(chunks.expand(html))
+    words := countWords(html)
+    return &Document{
+        HTML:        T.HTML(html),
+        TOC:         toc,
+        ShowTOC:     source.Meta.Toc && len(toc) > 0,
+        WordCount:   words,
+        ReadingTime: readingTime(words),
+        Images:      urls,
+        Problems:    problems,
+    }, nil
+}
+
+// Lint renders a source and returns everything that went wrong.
+func Lint(source *content.Source) []error {
+    doc, err := Render(source)
+    if err != nil {
+        return []error{err}
+    }
+    return doc.Problems
+}
+
+// cacheKey is where a document came from. A post and a page can have the
+// same slug.
+type cacheKey struct {
+    dir, slug string
+}
+
+// Post returns the cached document for a post, rendering it the first time.
+// If anything goes wrong there's nothing to show: blargh's own HTML isn't any
+// use, since its templates choke on shortcodes. TestLint keeps that from
+// happening.
+func Post(p *post.Post) *Document {
+    return document(content.PostsDir, p)
+}
+
+// Page is Post for pages.
+func Page(p *post.Post) *Document {
+    return document(content.PagesDir, p)
+}
+
+func document(dir string, p *post.Post) *Document {
+    key := cacheKey{dir, p.Slug()}
+    lock.RLock()
+    doc, ok := cache[key]
+    lock.RUnlock()
+    if ok {
+        cacheRequests.Inc("hit")
+        return doc
+    }
+    cacheRequests.Inc("miss")
+
+    index, ok := content.Named(dir)
+    if !ok {
+        logger.Error("no sources loaded", "dir", dir)
+        return unrendered()
+    }
+    source, ok := index.Find(key.slug)
+    if !ok {
+        logger.Error("no source found", "dir", dir, "slug", key.slug)
+        return unrendered()
+    }
+    doc, err := Render(source)
+    if err != nil {
+        logger.Error("failed rendering", "path", source.Path, "error", err)
+        return unrendered()
+    }
+    for _, problem := range doc.Problems {
+        logger.Warn("problem rendering", "path", source.Path, "problem", problem)
+    }
+    lock.Lock()
+    cache[key] = doc
+    lock.Unlock()
+    return doc
+}
+
+// Flush forgets every rendered document, for after the sources are read
+// again.
+func Flush() {
+    lock.Lock()
+    cache = make(map[cacheKey]*Document)
+    lock.Unlock()
+}
+
+func unrendered() *Document {
+    return &Document{ReadingTime: readingTime(0)}
+}
+
+// Clean is the document as plain text, for snippets.
+func (d *Document) Clean() string {
+    text := scripts.ReplaceAllString(string(d.HTML), " ")
+    text = tags.ReplaceAllString(text, " ")
+    return strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+}
+
+// resolveImages returns the images front matter with the URLs of the
+// variants made when the source was read filled in.
+func resolveImages(source *content.Source) (map[string]map[string]string, error) {
+    if source.ImagesErr != nil {
+        return nil, source.ImagesErr
+    }
+    urls := make(map[string]map[string]string)
+    for name, sizes := range source.Meta.Images {
+        if _, ok := images.Local(sizes); !ok {
+            urls[name] = sizes
+            continue
+        }
+        img, ok := source.Images[name]
+        if !ok {
+            return nil, fmt.Errorf("image %#v wasn't processed when %s was read", name, source.Path)
+        }
+        urls[name] = img.URLs()
+    }
+    return urls, nil
+}
+
+// expandImages fills in the {{.name.size}} lookups into the images front
+// matter, the same way blargh does. Images that are nothing but a lookup,
+// as an img tag or in markdown, get the whole responsive tag if there are
+// variants, and load lazily either way.
+func expandImages(name, body string, urls map[string]map[string]string, responsive map[string]*images.Image) (string, error) {
+    if !strings.Contains(body, "{{") {
+        return body, nil
+    }
+    body = imgLookup.ReplaceAllStringFunc(body, func(tag string) string {
+        m := imgLookup.FindStringSubmatch(tag)
+        attrs := strings.TrimSpace(m[1] + " " + m[4])
+        alt := ""
+        if a := altAttr.FindStringSubmatch(attrs); a != nil {
+            alt = html.UnescapeString(a[1])
+            attrs = strings.TrimSpace(altAttr.ReplaceAllString(attrs, ""))
+        }
+        return lookupTag(m[2], m[3], alt, attrs, responsive)
+    })
+    body = markdownLookup.ReplaceAllStringFunc(body, func(image string) string {
+        m := markdownLookup.FindStringSubmatch(image)
+        return lookupTag(m[2], m[3], m[1], "", responsive)
+    })
+
+    t, err := template.New(name).Parse(body)
+    if err != nil {
+        return "", err
+    }
+    var buffer bytes.Buffer
+    err = t.Execute(&buffer, urls)
+    if err != nil {
+        return "", err
+    }
+    return buffer.String(), nil
+}
+
+// lookupTag is the img tag for the image and size a lookup names. Anything
+// without variants keeps the lookup in its src, for the template.
+func lookupTag(name, size, alt, attrs string, responsive map[string]*images.Image) string {
+    if img, ok := responsive[name]; ok {
+        return img.Tag(size, alt, attrs)
+    }
+    if !strings.Contains(attrs, "loading=") {
+        attrs = strings.TrimSpace(attrs + ` loading="lazy"`)
+    }
+    if attrs != "" {
+        attrs = " " + attrs
+    }
+    return fmt.Sprintf(`<img src="{{.%s.%s}}" alt="%s"%s>`, name, size, html.EscapeString(alt), attrs)
+}
+
+func countWords(html string) int {
+    html = scripts.ReplaceAllString(html, " ")
+    html = tags.ReplaceAllString(html, " ")
+    return len(strings.Fields(html))
+}
+
+func readingTime(words int) int {
+    minutes := (words + WordsPerMinute - 1) / WordsPerMinute
+    if minutes < 1 {
+        return 1
+    }
+    return minutes
+}
@@ -0,0 +1,143 @@
+package render_test
+
+import (
+    "content"
+    "github.com/darkhelmet/blargh/post"
+    "image"
+    "image/png"
+    "images"
+    "io/ioutil"
+    . "launchpad.net/gocheck"
+    "os"
+    "path/filepath"
+    "render"
+    "strings"
+    "testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type RenderSuite struct{}
+
+var _ = Suite(&RenderSuite{})
+
+func (s *RenderSuite) TestHeadingAnchors(c *C) {
+    doc, err := render.Render(&content.Source{
+        Meta: content.Meta{Toc: true},
+        Body: `<h2>Getting Started</h2>
+<p>Words go here.</p>
+<h3>The &amp; Bit</h3>
+<h2 id="custom">Getting Started</h2>
+<h2>Getting Started</h2>`,
+    })
+    c.Assert(err, IsNil)
+    html := string(doc.HTML)
+    c.Check(strings.Contains(html, `<h2 id="getting-started">`), Equals, true)
+    c.Check(strings.Contains(html, `<h3 id="the-bit">`), Equals, true)
+    c.Check(strings.Contains(html, `<h2 id="custom">`), Equals, true)
+    c.Check(strings.Contains(html, `<h2 id="getting-started-2">`), Equals, true)
+
+    c.Assert(doc.TOC, HasLen, 3)
+    c.Check(doc.TOC[0].Children, HasLen, 1)
+    c.Check(doc.TOC[0].Children[0].Text, Equals, "The & Bit")
+    c.Check(doc.ShowTOC, Equals, true)
+}
+
+func (s *RenderSuite) TestReadingTime(c *C) {
+    doc, err := render.Render(&content.Source{
+        Body: "<p>" + strings.Repeat("word ", 450) + "</p><script>var ignored = true;</script>",
+    })
+    c.Assert(err, IsNil)
+    c.Check(doc.WordCount, Equals, 450)
+    c.Check(doc.ReadingTime, Equals, 3)
+    c.Check(doc.ShowTOC, Equals, false)
+}
+
+func (s *RenderSuite) TestImages(c *C) {
+    doc, err := render.Render(&content.Source{
+        Meta: content.Meta{Images: map[string]map[string]string{
+            "kitty": {"large": "http://example.com/kitty.png"},
+        }},
+        Body: `<img src="{{.kitty.large}}" class="fright" />` + "\n\n" + `![A "kitty"]({{.kitty.large}})`,
+    })
+    c.Assert(err, IsNil)
+    html := string(doc.HTML)
+    c.Check(strings.Contains(html, `<img src="http://example.com/kitty.png" alt="" class="fright" loading="lazy">`), Equals, true)
+    c.Check(strings.Contains(html, `<img src="http://example.com/kitty.png" alt="A &#34;kitty&#34;" loading="lazy">`), Equals, true)
+}
+
+func (s *RenderSuite) TestLocalImages(c *C) {
+    dir := c.MkDir()
+    images.Output = filepath.Join(dir, "public")
+    c.Assert(os.Mkdir(filepath.Join(dir, "a-post"), 0755), IsNil)
+    f, err := os.Create(filepath.Join(dir, "a-post", "kitty.png"))
+    c.Assert(err, IsNil)
+    c.Assert(png.Encode(f, image.NewGray(image.Rect(0, 0, 600, 300))), IsNil)
+    f.Close()
+    source := "--- \nslugs: \n- a-post\nimages: \n  kitty: \n    original: kitty.png\n---\n" +
+        `<img src="{{.kitty.medium}}" alt="Kitty" class="fright" />` + "\n\n![Kitty]({{.kitty.small}})\n\n<a href=\"{{.kitty.original}}\">big</a>\n"
+    c.Assert(ioutil.WriteFile(filepath.Join(dir, "a-post.md"), []byte(source), 0644), IsNil)
+
+    // The variants are made when it's read, not when it's rendered.
+    read, err := content.Read(filepath.Join(dir, "a-post.md"))
+    c.Assert(err, IsNil)
+    c.Assert(read.ImagesErr, IsNil)
+    c.Assert(read.Images["kitty"], NotNil)
+    c.Assert(os.RemoveAll(images.Output), IsNil)
+    doc, err := render.Render(read)
+    c.Assert(err, IsNil)
+    _, err = os.Stat(images.Output)
+    c.Check(os.IsNotExist(err), Equals, true)
+
+    html := string(doc.HTML)
+    c.Check(html, Matches, `(?s).*<img src="[^"]+/a-post/kitty-medium-[0-9a-f]+\.png" srcset="[^"]+ 240w, [^"]+ 480w, [^"]+ 600w" sizes="[^"]+" width="480" height="240" alt="Kitty" loading="lazy" class="fright">.*`)
+    c.Check(html, Matches, `(?s).*<img src="[^"]+/a-post/kitty-small-[0-9a-f]+\.png" srcset="[^"]+" sizes="[^"]+" width="240" height="120" alt="Kitty" loading="lazy">.*`)
+    c.Check(html, Matches, `(?s).*<a href="[^"]+/a-post/kitty-[0-9a-f]+\.png">big</a>.*`)
+
+    // Something that wasn't read doesn't have any.
+    _, err = render.Render(&content.Source{Meta: read.Meta, Body: read.Body})
+    c.Check(err, NotNil)
+}
+
+func (s *RenderSuite) TestFencedCode(c *C) {
+    doc, err := render.Render(&content.Source{
+        Body: "Some code:\n\n```go {hl_lines=[1]}\nx := \"{{.not.an.image}}\"\n```\n\n<h2>After</h2>\n",
+    })
+    c.Assert(err, IsNil)
+    html := string(doc.HTML)
+    c.Check(strings.Contains(html, `<div class="highlight"><pre><code class="language-go" data-lang="go"><span class="line hl">x`), Equals, true)
+    c.Check(strings.Contains(html, `{{.not.an.image}}`), Equals, true)
+    c.Check(strings.Contains(html, "vlplaceholder"), Equals, false)
+    c.Check(doc.TOC, HasLen, 1)
+}
+
+func (s *RenderSuite) TestCodeSpans(c *C) {
+    doc, err := render.Render(&content.Source{
+        Body: "Embed with `{{< youtube abc >}}`.\n\n``{{.not.an.image}}``\n\nAn open ` tick.\n\n{{< youtube def >}}\n",
+    })
+    c.Assert(err, IsNil)
+    html := string(doc.HTML)
+    c.Check(strings.Contains(html, "Embed with <code>{{&lt; youtube abc &gt;}}</code>."), Equals, true)
+    c.Check(strings.Contains(html, "<code>{{.not.an.image}}</code>"), Equals, true)
+    c.Check(strings.Contains(html, "An open ` tick."), Equals, true)
+    c.Check(strings.Contains(html, "embed/abc"), Equals, false)
+    c.Check(strings.Contains(html, "embed/def"), Equals, true)
+    c.Check(strings.Contains(html, "vlinline"), Equals, false)
+}
+
+func (s *RenderSuite) TestSharedSlug(c *C) {
+    dir := c.MkDir()
+    for _, name := range []string{content.PostsDir, content.PagesDir} {
+        c.Assert(os.Mkdir(filepath.Join(dir, name), 0755), IsNil)
+        source := "--- \nslugs: \n- about\n---\nThe " + name + " one.\n"
+        c.Assert(ioutil.WriteFile(filepath.Join(dir, name, "about.md"), []byte(source), 0644), IsNil)
+        _, err := content.Load(filepath.Join(dir, name))
+        c.Assert(err, IsNil)
+    }
+    render.Flush()
+
+    p := &post.Post{Slugs: []string{"about"}}
+    c.Check(strings.Contains(string(render.Post(p).HTML), "The posts one."), Equals, true)
+    c.Check(strings.Contains(string(render.Page(p).HTML), "The pages one."), Equals, true)
+    c.Check(strings.Contains(string(render.Post(p).HTML), "The posts one."), Equals, true)
+}
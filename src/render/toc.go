@@ -0,0 +1,88 @@
+package render
+
+import (
+    "fmt"
+    "html"
+    "regexp"
+    "strings"
+    "unicode"
+)
+
+var (
+    headings  = regexp.MustCompile(`(?is)<h([1-6])([^>]*)>(.*?)</h[1-6]>`)
+    idAttr    = regexp.MustCompile(`(?i)\bid\s*=\s*"([^"]*)"`)
+    separator = regexp.MustCompile(`-+`)
+)
+
+// Entry is a heading in the table of contents.
+type Entry struct {
+    Level    int
+    ID, Text string
+    Children []*Entry
+}
+
+// anchorHeadings gives every heading an id, keeping any that are already
+// there, and builds the table of contents out of them. IDs come from the
+// heading text so links to them survive edits elsewhere in the post.
+func anchorHeadings(body string) (string, []*Entry) {
+    var (
+        root  []*Entry
+        stack []*Entry
+        seen  = make(map[string]bool)
+    )
+
+    body = headings.ReplaceAllStringFunc(body, func(match string) string {
+        parts := headings.FindStringSubmatch(match)
+        level := int(parts[1][0] - '0')
+        attrs, inner := parts[2], parts[3]
+        text := strings.TrimSpace(html.UnescapeString(tags.ReplaceAllString(inner, "")))
+
+        id := ""
+        if m := idAttr.FindStringSubmatch(attrs); m != nil {
+            id = m[1]
+            seen[id] = true
+        } else {
+            id = uniqueID(slugify(text), seen)
+            attrs = fmt.Sprintf(` id="%s"%s`, id, attrs)
+        }
+
+        entry := &Entry{Level: level, ID: id, Text: text}
+        for len(stack) > 0 && stack[len(stack)-1].Level >= level {
+            stack = stack[:len(stack)-1]
+        }
+        if len(stack) == 0 {
+            root = append(root, entry)
+        } else {
+            parent := stack[len(stack)-1]
+            parent.Children = append(parent.Children, entry)
+        }
+        stack = append(stack, entry)
+
+        return fmt.Sprintf("<h%d%s>%s</h%d>", level, attrs, inner, level)
+    })
+
+    return body, root
+}
+
+func slugify(text string) string {
+    slug := strings.Map(func(r rune) rune {
+        if unicode.IsLetter(r) || unicode.IsDigit(r) {
+            return unicode.ToLower(r)
+        }
+        return '-'
+    }, text)
+    slug = strings.Trim(separator.ReplaceAllString(slug, "-"), "-")
+    if slug == "" {
+        return "section"
+    }
+    return slug
+}
+
+func uniqueID(id string, seen map[string]bool) string {
+    candidate := id
+    for n := 2; seen[candidate]; n++ {
+        candidate = fmt.Sprintf("%s-%d", id, n)
+    }
+    seen[candidate] = true
+    return candidate
+}
@@ -3,22 +3,37 @@ package main
 import (
     "config"
     "fmt"
-    "log"
+    "logging"
     "net/http"
     "os"
+    "os/signal"
+    "syscall"
     "verboselogging"
 )
 
 var (
-    logger = log.New(os.Stdout, "[server] ", config.LogFlags)
+    logger = logging.New("server")
 )
 
+// reloadOnHangup reads the posts and pages again whenever we get a SIGHUP.
+func reloadOnHangup() {
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    for _ = range hup {
+        if err := verboselogging.Reload(); err != nil {
+            logger.Error("failed reloading", "error", err)
+        }
+    }
+}
+
 func main() {
     handler := verboselogging.SetupHandler()
     http.Handle("/", handler)
-    logger.Printf("verboselogging is starting on 0.0.0.0:%d", config.Port)
-    err := http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", config.Port), nil)
+    go reloadOnHangup()
+    addr := fmt.Sprintf("0.0.0.0:%d", config.Port)
+    logger.Info("starting", "addr", addr)
+    err := http.ListenAndServe(addr, nil)
     if err != nil {
-        logger.Fatalf("Failed to serve: %s", err)
+        logger.Fatalf("failed serving: %s", err)
     }
 }
@@ -0,0 +1,133 @@
+package shortcode
+
+import (
+    "fmt"
+    "html"
+    "net/url"
+    "regexp"
+)
+
+var (
+    videoID = regexp.MustCompile(`^[\w-]+$`)
+    gistID  = regexp.MustCompile(`^(?:[\w-]+/)?[0-9a-f]+$`)
+)
+
+func init() {
+    Register("youtube", youtube)
+    Register("vimeo", vimeo)
+    Register("gist", gist)
+    Register("speakerdeck", speakerdeck)
+    Register("figure", figure)
+    Register("img", img)
+}
+
+// embed wraps an iframe so it scales with the column. Nothing is loaded until
+// the frame scrolls into view.
+func embed(class, src, title, ratio string) string {
+    return fmt.Sprintf(`<div class="embed %s" style="padding-bottom: %s"><iframe src="%s" title="%s" loading="lazy" frameborder="0" allowfullscreen referrerpolicy="no-referrer"></iframe></div>`,
+        class, ratio, html.EscapeString(src), html.EscapeString(title))
+}
+
+// {{< youtube BAfy3IgVpjY >}} or {{< youtube id="BAfy3IgVpjY" start="30" >}}
+func youtube(ctx *Context, args Args) (string, error) {
+    id := args.Get(0, "id")
+    if !videoID.MatchString(id) {
+        return "", fmt.Errorf("bad video id %#v", id)
+    }
+    src := "https://www.youtube-nocookie.com/embed/" + id
+    if start := args.Get(-1, "start"); start != "" {
+        src += "?start=" + url.QueryEscape(start)
+    }
+    return embed("video youtube", src, args.Get(1, "title"), "56.25%"), nil
+}
+
+// {{< vimeo 6712657 >}}
+func vimeo(ctx *Context, args Args) (string, error) {
+    id := args.Get(0, "id")
+    if !videoID.MatchString(id) {
+        return "", fmt.Errorf("bad video id %#v", id)
+    }
+    src := fmt.Sprintf("https://player.vimeo.com/video/%s?dnt=1", id)
+    return embed("video vimeo", src, args.Get(1, "title"), "56.25%"), nil
+}
+
+// {{< speakerdeck 0dd3c5507fb701301b3c22000a8c4174 >}}
+func speakerdeck(ctx *Context, args Args) (string, error) {
+    id := args.Get(0, "id")
+    if !videoID.MatchString(id) {
+        return "", fmt.Errorf("bad deck id %#v", id)
+    }
+    src := "https://speakerdeck.com/player/" + id
+    return embed("slides speakerdeck", src, args.Get(1, "title"), "75%"), nil
+}
+
+// {{< gist darkhelmet/3375538 timing_attack.go >}}
+//
+// Gists need GitHub's script to render, so there's a plain link for anybody
+// who doesn't run it.
+func gist(ctx *Context, args Args) (string, error) {
+    id := args.Get(0, "id")
+    if !gistID.MatchString(id) {
+        return "", fmt.Errorf("bad gist id %#v", id)
+    }
+    src := fmt.Sprintf("https://gist.github.com/%s.js", id)
+    link := fmt.Sprintf("https://gist.github.com/%s", id)
+    if file := args.Get(1, "file"); file != "" {
+        src += "?file=" + url.QueryEscape(file)
+    }
+    return fmt.Sprintf(`<div class="embed gist"><script src="%s"></script><noscript><a href="%s">View the gist on GitHub</a></noscript></div>`,
+        html.EscapeString(src), html.EscapeString(link)), nil
+}
+
+// {{< img kitty size="large" alt="A sad kitty" >}}
+func img(ctx *Context, args Args) (string, error) {
+    return imageTag(ctx, args.Get(0, "image"), args.Get(1, "size"), args.Get(-1, "alt"))
+}
+
+// {{< figure image="kitty" size="large" caption="A sad kitty" >}}
+//
+// image names an entry in the images front matter. The figure links through
+// to the original when there is one.
+func figure(ctx *Context, args Args) (string, error) {
+    name, size := args.Get(0, "image"), args.Get(1, "size")
+    caption, alt := args.Get(-1, "caption"), args.Get(-1, "alt")
+    if alt == "" {
+        alt = caption
+    }
+    tag, err := imageTag(ctx, name, size, alt)
+    if err != nil {
+        return "", err
+    }
+    if original := ctx.Images[name]["original"]; original != "" && size != "original" {
+        tag = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(original), tag)
+    }
+    if caption != "" {
+        tag += fmt.Sprintf(`<figcaption>%s</figcaption>`, html.EscapeString(caption))
+    }
+    if size == "" {
+        size = "medium"
+    }
+    return fmt.Sprintf(`<figure class="%s">%s</figure>`, html.EscapeString(size), tag), nil
+}
+
+// imageTag builds the img tag for an entry in the images front matter. Local
+// images get the full responsive treatment, with every variant in srcset and
+// the size asked for as the fallback.
+func imageTag(ctx *Context, name, size, alt string) (string, error) {
+    if size == "" {
+        size = "medium"
+    }
+    sizes, ok := ctx.Images[name]
+    if !ok {
+        return "", fmt.Errorf("no image named %#v in the front matter", name)
+    }
+    src, ok := sizes[size]
+    if !ok {
+        return "", fmt.Errorf("image %#v has no %#v size", name, size)
+    }
+
+    if responsive, ok := ctx.Responsive[name]; ok {
+        return responsive.Tag(size, alt, ""), nil
+    }
+    return fmt.Sprintf(`<img src="%s" alt="%s" loading="lazy">`, html.EscapeString(src), html.EscapeString(alt)), nil
+}
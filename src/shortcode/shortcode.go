@@ -0,0 +1,126 @@
+// Package shortcode expands {{< name args >}} tags in post bodies into
+// markup. Shortcodes are registered by name, so new embeds only need a
+// function here instead of hand rolled HTML in every post.
+package shortcode
+
+import (
+    "fmt"
+    "images"
+    "regexp"
+    "strings"
+)
+
+var (
+    tag       = regexp.MustCompile(`{{<\s*([\w-]+)((?:[^>]|>[^}])*?)\s*>}}`)
+    argument  = regexp.MustCompile(`(?:([\w-]+)=)?(?:"((?:[^"\\]|\\.)*)"|(\S+))`)
+    registry  = make(map[string]Func)
+    unescaper = strings.NewReplacer(`\"`, `"`, `\\`, `\`)
+)
+
+// Context is what a shortcode gets to know about the post it's in. Images
+// kept next to the post are in Responsive as well as Images.
+type Context struct {
+    Images     map[string]map[string]string
+    Responsive map[string]*images.Image
+}
+
+// Args are the positional and named arguments given to a shortcode.
+type Args struct {
+    Positional []string
+    Named      map[string]string
+}
+
+// Get returns the named argument, falling back to the positional one at
+// index i. Pass a negative index for named only arguments.
+func (a Args) Get(i int, name string) string {
+    if value, ok := a.Named[name]; ok {
+        return value
+    }
+    if i >= 0 && i < len(a.Positional) {
+        return a.Positional[i]
+    }
+    return ""
+}
+
+// Func renders a shortcode.
+type Func func(ctx *Context, args Args) (string, error)
+
+// Error is a shortcode that couldn't be expanded.
+type Error struct {
+    Name string
+    Line int
+    Err  error
+}
+
+func (e *Error) Error() string {
+    return fmt.Sprintf("line %d: shortcode %#v: %s", e.Line, e.Name, e.Err)
+}
+
+// Register makes a shortcode available by name.
+func Register(name string, f Func) {
+    registry[name] = f
+}
+
+// Registered reports whether there is a shortcode with this name.
+func Registered(name string) bool {
+    _, ok := registry[name]
+    return ok
+}
+
+// ParseArgs splits the argument string of a shortcode.
+func ParseArgs(s string) Args {
+    args := Args{Named: make(map[string]string)}
+    for _, m := range argument.FindAllStringSubmatch(s, -1) {
+        value := m[3]
+        if value == "" {
+            value = unescaper.Replace(m[2])
+        }
+        if m[1] == "" {
+            args.Positional = append(args.Positional, value)
+        } else {
+            args.Named[m[1]] = value
+        }
+    }
+    return args
+}
+
+// Expand runs every shortcode in body, handing the markup to replace and
+// putting whatever it returns in place of the tag. Shortcodes that are
+// unknown or fail are handed over as escaped text instead, so the reader
+// sees what went wrong, and are reported back.
+func Expand(body string, ctx *Context, replace func(html string) string) (string, []error) {
+    var (
+        out  []string
+        errs []error
+        last int
+    )
+    for _, m := range tag.FindAllStringSubmatchIndex(body, -1) {
+        match, name := body[m[0]:m[1]], body[m[2]:m[3]]
+        out = append(out, body[last:m[0]])
+        last = m[1]
+
+        f, ok := registry[name]
+        if !ok {
+            errs = append(errs, &Error{name, lineAt(body, m[0]), fmt.Errorf("unknown shortcode")})
+            out = append(out, replace(escape(match)))
+            continue
+        }
+        html, err := f(ctx, ParseArgs(body[m[4]:m[5]]))
+        if err != nil {
+            errs = append(errs, &Error{name, lineAt(body, m[0]), err})
+            out = append(out, replace(escape(match)))
+            continue
+        }
+        out = append(out, replace(html))
+    }
+    out = append(out, body[last:])
+    return strings.Join(out, ""), errs
+}
+
+func lineAt(body string, offset int) int {
+    return strings.Count(body[:offset], "\n") + 1
+}
+
+func escape(s string) string {
+    return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
@@ -0,0 +1,64 @@
+package shortcode_test
+
+import (
+    "images"
+    . "launchpad.net/gocheck"
+    "shortcode"
+    "testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ShortcodeSuite struct{}
+
+var _ = Suite(&ShortcodeSuite{})
+
+func identity(html string) string { return html }
+
+func (s *ShortcodeSuite) TestParseArgs(c *C) {
+    args := shortcode.ParseArgs(` kitty size=large caption="A \"sad\" kitty" `)
+    c.Check(args.Positional, DeepEquals, []string{"kitty"})
+    c.Check(args.Named, DeepEquals, map[string]string{"size": "large", "caption": `A "sad" kitty`})
+    c.Check(args.Get(0, "image"), Equals, "kitty")
+    c.Check(args.Get(1, "size"), Equals, "large")
+    c.Check(args.Get(-1, "alt"), Equals, "")
+}
+
+func (s *ShortcodeSuite) TestExpand(c *C) {
+    body, errs := shortcode.Expand("Watch\n\n{{< youtube BAfy3IgVpjY >}}\n", &shortcode.Context{}, identity)
+    c.Check(errs, HasLen, 0)
+    c.Check(body, Equals, "Watch\n\n"+`<div class="embed video youtube" style="padding-bottom: 56.25%"><iframe src="https://www.youtube-nocookie.com/embed/BAfy3IgVpjY" title="" loading="lazy" frameborder="0" allowfullscreen referrerpolicy="no-referrer"></iframe></div>`+"\n")
+}
+
+func (s *ShortcodeSuite) TestFigure(c *C) {
+    ctx := &shortcode.Context{Images: map[string]map[string]string{
+        "kitty": {"large": "/large.png", "original": "/original.png"},
+    }}
+    body, errs := shortcode.Expand(`{{< figure image="kitty" size="large" caption="Sad <kitty>" >}}`, ctx, identity)
+    c.Check(errs, HasLen, 0)
+    c.Check(body, Equals, `<figure class="large"><a href="/original.png"><img src="/large.png" alt="Sad &lt;kitty&gt;" loading="lazy"></a><figcaption>Sad &lt;kitty&gt;</figcaption></figure>`)
+
+    _, errs = shortcode.Expand(`{{< figure image="dog" >}}`, ctx, identity)
+    c.Check(errs, HasLen, 1)
+}
+
+func (s *ShortcodeSuite) TestUnknown(c *C) {
+    body, errs := shortcode.Expand("one\ntwo {{< nope x >}}", &shortcode.Context{}, identity)
+    c.Assert(errs, HasLen, 1)
+    c.Check(errs[0].Error(), Equals, `line 2: shortcode "nope": unknown shortcode`)
+    c.Check(body, Equals, "one\ntwo {{&lt; nope x &gt;}}")
+}
+
+func (s *ShortcodeSuite) TestResponsiveImage(c *C) {
+    ctx := &shortcode.Context{
+        Images: map[string]map[string]string{"kitty": {"medium": "/m.png", "original": "/o.png"}},
+        Responsive: map[string]*images.Image{"kitty": &images.Image{Name: "kitty", Variants: []images.Variant{
+            {Size: "small", URL: "/s.png", Width: 240, Height: 120},
+            {Size: "medium", URL: "/m.png", Width: 480, Height: 240},
+            {Size: "original", URL: "/o.png", Width: 600, Height: 300},
+        }}},
+    }
+    body, errs := shortcode.Expand(`{{< img kitty alt="Kitty" >}}`, ctx, identity)
+    c.Check(errs, HasLen, 0)
+    c.Check(body, Equals, `<img src="/m.png" srcset="/s.png 240w, /m.png 480w, /o.png 600w" sizes="`+images.SizesAttr+`" width="480" height="240" alt="Kitty" loading="lazy">`)
+}
@@ -0,0 +1,115 @@
+// Package sitemap builds sitemaps.org documents, with Google's image
+// extension, and writes them out plain or gzipped. A big site is split into
+// several sitemaps tied together by an index.
+package sitemap
+
+import (
+    "compress/gzip"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "time"
+)
+
+const (
+    Namespace      = "http://www.sitemaps.org/schemas/sitemap/0.9"
+    ImageNamespace = "http://www.google.com/schemas/sitemap-image/1.1"
+)
+
+// Stylesheet is the XSL browsers use to show a sitemap as a page. It has to
+// come from the same host as the sitemap, so it's just a path.
+var Stylesheet = "/sitemap.xsl"
+
+// Index points at every child sitemap.
+type Index struct {
+    XMLName  xml.Name  `xml:"sitemapindex"`
+    XMLNS    string    `xml:"xmlns,attr"`
+    Sitemaps []Sitemap `xml:"sitemap"`
+}
+
+// Sitemap is an entry in an Index.
+type Sitemap struct {
+    Loc     string `xml:"loc"`
+    LastMod string `xml:"lastmod,omitempty"`
+}
+
+// URLSet is a single sitemap.
+type URLSet struct {
+    XMLName    xml.Name `xml:"urlset"`
+    XMLNS      string   `xml:"xmlns,attr"`
+    XMLNSImage string   `xml:"xmlns:image,attr"`
+    URLs       []URL    `xml:"url"`
+}
+
+// URL is an entry in a URLSet.
+type URL struct {
+    Loc        string  `xml:"loc"`
+    LastMod    string  `xml:"lastmod,omitempty"`
+    ChangeFreq string  `xml:"changefreq,omitempty"`
+    Priority   float64 `xml:"priority,omitempty"`
+    Images     []Image `xml:"image:image"`
+}
+
+// Image is an image that shows up on the page at a URL.
+type Image struct {
+    Loc string `xml:"image:loc"`
+}
+
+func NewIndex() *Index {
+    return &Index{XMLNS: Namespace}
+}
+
+func NewURLSet() *URLSet {
+    return &URLSet{XMLNS: Namespace, XMLNSImage: ImageNamespace}
+}
+
+// Add puts a sitemap in the index.
+func (i *Index) Add(loc string, lastmod time.Time) {
+    i.Sitemaps = append(i.Sitemaps, Sitemap{loc, Date(lastmod)})
+}
+
+// Add puts a URL in the sitemap and hands it back so images can be added.
+// The pointer is only good until the next Add.
+func (s *URLSet) Add(loc string, lastmod time.Time, changefreq string, priority float64) *URL {
+    s.URLs = append(s.URLs, URL{Loc: loc, LastMod: Date(lastmod), ChangeFreq: changefreq, Priority: priority})
+    return &s.URLs[len(s.URLs)-1]
+}
+
+// AddImage lists an image on the page.
+func (u *URL) AddImage(loc string) {
+    u.Images = append(u.Images, Image{loc})
+}
+
+// Date formats a lastmod. Zero times are left out.
+func Date(t time.Time) string {
+    if t.IsZero() {
+        return ""
+    }
+    return t.Format(time.RFC3339)
+}
+
+// Write encodes an Index or URLSet to w, gzipping it if asked.
+func Write(w io.Writer, v interface{}, compress bool) error {
+    if compress {
+        gz := gzip.NewWriter(w)
+        if err := write(gz, v); err != nil {
+            gz.Close()
+            return err
+        }
+        return gz.Close()
+    }
+    return write(w, v)
+}
+
+func write(w io.Writer, v interface{}) error {
+    if _, err := fmt.Fprintf(w, "%s<?xml-stylesheet type=\"text/xsl\" href=\"%s\"?>\n", xml.Header, Stylesheet); err != nil {
+        return err
+    }
+    encoder := xml.NewEncoder(w)
+    encoder.Indent("", "    ")
+    if err := encoder.Encode(v); err != nil {
+        return err
+    }
+    _, err := io.WriteString(w, "\n")
+    return err
+}
@@ -0,0 +1,51 @@
+package sitemap_test
+
+import (
+    "bytes"
+    "compress/gzip"
+    "io/ioutil"
+    . "launchpad.net/gocheck"
+    "sitemap"
+    "strings"
+    "testing"
+    "time"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type SitemapSuite struct{}
+
+var _ = Suite(&SitemapSuite{})
+
+func (s *SitemapSuite) TestURLSet(c *C) {
+    urls := sitemap.NewURLSet()
+    published := time.Date(2013, 4, 17, 20, 0, 0, 0, time.UTC)
+    urls.Add("http://verboselogging.com/2013/04/17/kitty", published, "monthly", 1.0).AddImage("http://cdn.verboselogging.com/kitty.png?a&b")
+    urls.Add("http://verboselogging.com/about", time.Time{}, "", 0)
+
+    var buffer bytes.Buffer
+    c.Assert(sitemap.Write(&buffer, urls, false), IsNil)
+    out := buffer.String()
+    c.Check(strings.HasPrefix(out, `<?xml version="1.0" encoding="UTF-8"?>`), Equals, true)
+    c.Check(out, Matches, `(?s).*<\?xml-stylesheet type="text/xsl" href="/sitemap.xsl"\?>.*`)
+    c.Check(out, Matches, `(?s).*<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:image="http://www.google.com/schemas/sitemap-image/1.1">.*`)
+    c.Check(out, Matches, `(?s).*<lastmod>2013-04-17T20:00:00Z</lastmod>\s*<changefreq>monthly</changefreq>\s*<priority>1</priority>.*`)
+    c.Check(out, Matches, `(?s).*<image:image>\s*<image:loc>http://cdn.verboselogging.com/kitty.png\?a&amp;b</image:loc>\s*</image:image>.*`)
+    c.Check(out, Matches, `(?s).*<loc>http://verboselogging.com/about</loc>\s*</url>.*`)
+}
+
+func (s *SitemapSuite) TestGzip(c *C) {
+    index := sitemap.NewIndex()
+    index.Add("http://verboselogging.com/sitemap-posts.xml.gz", time.Date(2013, 4, 17, 20, 0, 0, 0, time.UTC))
+
+    var plain, compressed bytes.Buffer
+    c.Assert(sitemap.Write(&plain, index, false), IsNil)
+    c.Assert(sitemap.Write(&compressed, index, true), IsNil)
+
+    r, err := gzip.NewReader(&compressed)
+    c.Assert(err, IsNil)
+    data, err := ioutil.ReadAll(r)
+    c.Assert(err, IsNil)
+    c.Check(string(data), Equals, plain.String())
+    c.Check(plain.String(), Matches, `(?s).*<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">\s*<sitemap>\s*<loc>http://verboselogging.com/sitemap-posts.xml.gz</loc>.*`)
+}
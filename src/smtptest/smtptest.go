@@ -0,0 +1,138 @@
+// Package smtptest is a pretend SMTP server for tests, in the spirit of
+// net/http/httptest. It takes whatever it's sent and keeps it.
+package smtptest
+
+import (
+    "bufio"
+    "net"
+    "strings"
+    "sync"
+)
+
+// Message is one mail the server was given.
+type Message struct {
+    From string
+    To   []string
+    Data string
+}
+
+// Server listens on a local port until it's closed.
+type Server struct {
+    // Addr is the host:port to send to.
+    Addr string
+
+    listener net.Listener
+    lock     sync.Mutex
+    messages []*Message
+    wg       sync.WaitGroup
+}
+
+// NewServer starts a server on a free port on the loopback interface.
+func NewServer() *Server {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        panic("smtptest: failed to listen: " + err.Error())
+    }
+    s := &Server{Addr: l.Addr().String(), listener: l}
+    go s.serve()
+    return s
+}
+
+// Messages are what's been sent so far, oldest first.
+func (s *Server) Messages() []*Message {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    return append([]*Message(nil), s.messages...)
+}
+
+// Reset forgets what's been sent.
+func (s *Server) Reset() {
+    s.lock.Lock()
+    s.messages = nil
+    s.lock.Unlock()
+}
+
+// Close stops listening and waits for connections to finish.
+func (s *Server) Close() {
+    s.listener.Close()
+    s.wg.Wait()
+}
+
+func (s *Server) serve() {
+    for {
+        conn, err := s.listener.Accept()
+        if err != nil {
+            return
+        }
+        s.wg.Add(1)
+        go func() {
+            defer s.wg.Done()
+            s.handle(conn)
+        }()
+    }
+}
+
+// handle speaks just enough SMTP for net/smtp. There's no STARTTLS and no
+// AUTH, which net/smtp is fine with when talking to localhost.
+func (s *Server) handle(conn net.Conn) {
+    defer conn.Close()
+    r := bufio.NewReader(conn)
+    reply := func(line string) {
+        conn.Write([]byte(line + "\r\n"))
+    }
+    reply("220 localhost smtptest")
+    m := &Message{}
+    for {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            return
+        }
+        line = strings.TrimRight(line, "\r\n")
+        verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+        switch verb {
+        case "EHLO", "HELO":
+            reply("250 localhost")
+        case "MAIL":
+            m = &Message{From: address(line)}
+            reply("250 OK")
+        case "RCPT":
+            m.To = append(m.To, address(line))
+            reply("250 OK")
+        case "DATA":
+            reply("354 go ahead")
+            var data []string
+            for {
+                line, err := r.ReadString('\n')
+                if err != nil {
+                    return
+                }
+                line = strings.TrimRight(line, "\r\n")
+                if line == "." {
+                    break
+                }
+                data = append(data, strings.TrimPrefix(line, "."))
+            }
+            m.Data = strings.Join(data, "\r\n") + "\r\n"
+            s.lock.Lock()
+            s.messages = append(s.messages, m)
+            s.lock.Unlock()
+            reply("250 OK")
+        case "RSET", "NOOP":
+            reply("250 OK")
+        case "QUIT":
+            reply("221 bye")
+            return
+        default:
+            reply("502 not here")
+        }
+    }
+}
+
+// address is what's in the angle brackets of a MAIL or RCPT.
+func address(line string) string {
+    start, end := strings.Index(line, "<"), strings.LastIndex(line, ">")
+    if start < 0 || end < start {
+        return ""
+    }
+    return line[start+1 : end]
+}
@@ -0,0 +1,201 @@
+package verboselogging
+
+import (
+    "activitypub"
+    "config"
+    "fmt"
+    "github.com/darkhelmet/blargh/post"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "path/filepath"
+    "render"
+    "strings"
+    "sync"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+    "webmention"
+)
+
+const (
+    activityContentType = activitypub.ContentType + "; charset=utf-8"
+    outboxSize          = 20
+)
+
+var (
+    actor         = newActor()
+    followers     = openFollowers()
+    fediverse     *activitypub.Service
+    fediverseErr  error
+    fediverseOnce sync.Once
+)
+
+func init() {
+    published.OnPublish(deliverPosts)
+}
+
+func newActor() *activitypub.Actor {
+    return &activitypub.Actor{
+        Id:                view.CanonicalURL("/actor"),
+        Type:              "Person",
+        PreferredUsername: config.ActorName,
+        Name:              config.SiteTitle,
+        Summary:           config.SiteDescription,
+        URL:               view.CanonicalURL("/"),
+        Icon:              &activitypub.Image{Type: "Image", URL: view.Gravatar(config.SiteContact) + "?s=300"},
+        Inbox:             view.CanonicalURL("/actor/inbox"),
+        Outbox:            view.CanonicalURL("/actor/outbox"),
+        Followers:         view.CanonicalURL("/actor/followers"),
+        Endpoints:         &activitypub.Endpoints{SharedInbox: view.CanonicalURL("/actor/inbox")},
+    }
+}
+
+func openFollowers() *activitypub.Store {
+    store, err := activitypub.OpenStore(filepath.Join(config.DataDir, "activitypub.json"))
+    if err != nil {
+        panic(err)
+    }
+    return store
+}
+
+// service sets up the actor the first time it's needed, since that can
+// mean making a key.
+func service() (*activitypub.Service, error) {
+    fediverseOnce.Do(func() {
+        key, err := activitypub.LoadKey(filepath.Join(config.DataDir, "actor.pem"))
+        if err != nil {
+            fediverseErr = err
+            return
+        }
+        fediverse, fediverseErr = activitypub.NewService(actor, key, followers, webmention.PublicClient(10*time.Second))
+        if fediverseErr == nil {
+            fediverse.Replyable = isPermalinkURL
+        }
+    })
+    return fediverse, fediverseErr
+}
+
+func isPermalinkURL(id string) bool {
+    u, err := url.Parse(id)
+    return err == nil && isPermalink(u)
+}
+
+func article(p *post.Post) *activitypub.Article {
+    return activitypub.NewArticle(actor, p, view.CanonicalURL(view.PostCanonical(p)), render.Post(p).HTML, func(tag string) string {
+        return view.CanonicalURL(view.TaxonomyPath("tag", tag))
+    })
+}
+
+func deliverPosts(fresh []*post.Post) {
+    s, err := service()
+    if err != nil {
+        logger.Error("failed setting up the actor", "error", err)
+        return
+    }
+    for _, p := range fresh {
+        go s.Deliver(activitypub.NewCreate(article(p)))
+    }
+}
+
+func respondActivity(req *web.Request, v interface{}) {
+    writeJSON(req.Respond(web.StatusOK,
+        web.HeaderContentType, activityContentType,
+        web.HeaderVary, web.HeaderAccept), v)
+}
+
+type jrdLink struct {
+    Rel  string `json:"rel"`
+    Type string `json:"type,omitempty"`
+    Href string `json:"href"`
+}
+
+type jrd struct {
+    Subject string    `json:"subject"`
+    Aliases []string  `json:"aliases"`
+    Links   []jrdLink `json:"links"`
+}
+
+func webfingerHandler(req *web.Request) {
+    resource := req.Param.Get("resource")
+    account := fmt.Sprintf("acct:%s@%s", config.ActorName, strings.Split(config.CanonicalHost, ":")[0])
+    if resource != account && resource != actor.Id && resource != actor.URL {
+        notFound(req)
+        return
+    }
+    w := req.Respond(web.StatusOK,
+        web.HeaderContentType, "application/jrd+json; charset=utf-8",
+        web.HeaderAccessControllAllowOrigin, "*")
+    writeJSON(w, &jrd{
+        Subject: account,
+        Aliases: []string{actor.Id, actor.URL},
+        Links: []jrdLink{
+            {"self", activitypub.ContentType, actor.Id},
+            {"http://webfinger.net/rel/profile-page", "text/html", actor.URL},
+        },
+    })
+}
+
+func actorHandler(req *web.Request) {
+    s, err := service()
+    if err != nil {
+        logFor(req).Error("failed setting up the actor", "error", err)
+        serverError(req, err)
+        return
+    }
+    respondActivity(req, s.Actor)
+}
+
+func inboxHandler(req *web.Request) {
+    s, err := service()
+    if err != nil {
+        logFor(req).Error("failed setting up the actor", "error", err)
+        serverError(req, err)
+        return
+    }
+    body, err := ioutil.ReadAll(io.LimitReader(req.Body, activitypub.MaxActivitySize))
+    if err != nil {
+        req.Error(web.StatusBadRequest, err)
+        return
+    }
+    header := http.Header(req.Header)
+    header.Set("Host", req.URL.Host)
+    err = s.Inbox(req.Method, req.RequestURI, header, body)
+    switch err {
+    case nil:
+        req.Respond(web.StatusAccepted)
+    case activitypub.ErrUnsigned, activitypub.ErrBadSignature, activitypub.ErrWrongActor:
+        req.Error(web.StatusUnauthorized, err)
+    default:
+        logFor(req).Warn("failed taking an activity", "error", err)
+        req.Error(web.StatusBadRequest, err)
+    }
+}
+
+type collection struct {
+    Context      string      `json:"@context"`
+    Id           string      `json:"id"`
+    Type         string      `json:"type"`
+    TotalItems   int         `json:"totalItems"`
+    OrderedItems interface{} `json:"orderedItems,omitempty"`
+}
+
+func outboxHandler(req *web.Request) {
+    latest, err := posts.FindLatest(outboxSize)
+    if err != nil {
+        logFor(req).Error("failed finding posts for the outbox", "error", err)
+        serverError(req, err)
+        return
+    }
+    var items []*activitypub.Activity
+    for _, p := range latest {
+        items = append(items, activitypub.NewCreate(article(p)))
+    }
+    respondActivity(req, &collection{"https://www.w3.org/ns/activitystreams", actor.Outbox, "OrderedCollection", posts.Len(), items})
+}
+
+// followersHandler only says how many; who they are is their business.
+func followersHandler(req *web.Request) {
+    respondActivity(req, &collection{"https://www.w3.org/ns/activitystreams", actor.Followers, "OrderedCollection", len(followers.Followers()), nil})
+}
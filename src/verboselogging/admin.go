@@ -0,0 +1,353 @@
+package verboselogging
+
+import (
+    "comments"
+    "config"
+    "content"
+    "errors"
+    "fmt"
+    "html/template"
+    "path/filepath"
+    "render"
+    "sort"
+    "strings"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+)
+
+const (
+    // newPostTemplate is what new posts start out as.
+    newPostTemplate = "views/new-post.md"
+    // adminTimeLayout is how dates are edited.
+    adminTimeLayout = "2006-01-02 15:04"
+    maxEditSize     = 1 << 20
+)
+
+type adminRow struct {
+    Title, Status, Date, URL, EditPath string
+    Live                               bool
+    t                                  time.Time
+}
+
+type adminSection struct {
+    Name string
+    Rows []*adminRow
+}
+
+type adminList struct {
+    User, XSRF string
+    Sections   []*adminSection
+    Audit      []*auditEntry
+    // Waiting is how many comments need moderating.
+    Waiting int
+}
+
+type byRecent []*adminRow
+
+func (b byRecent) Len() int           { return len(b) }
+func (b byRecent) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byRecent) Less(i, j int) bool { return b[i].t.After(b[j].t) }
+
+// editor is the form for one post or page.
+type editor struct {
+    Kind, Slug, Action, PreviewPath, URL, XSRF, Error string
+    // HistoryPath is empty when edits aren't being committed.
+    HistoryPath      string
+    New, Post, Saved bool
+
+    Title, Category, Tags, Description, PublishedOn, Zone string
+    Published, Toc                                        bool
+    Images, Body                                          string
+    Preview                                               template.HTML
+    // Media is the library, to pick images from.
+    Media []*mediaItem
+}
+
+// status says whether a post is a draft, waiting for its time, or up.
+func status(fm *content.FrontMatter, now time.Time) string {
+    t, _ := fm.Time()
+    switch {
+    case !fm.Published:
+        return "draft"
+    case t.After(now):
+        return "scheduled"
+    }
+    return "published"
+}
+
+// adminRepo is the repo a kind in an admin path means.
+func adminRepo(kind string) *Repo {
+    if kind == "pages" {
+        return pages
+    }
+    return posts
+}
+
+// publicPath is where a source shows up on the site.
+func publicPath(repo *Repo, fm *content.FrontMatter) string {
+    if repo == pages {
+        return "/" + fm.Slug()
+    }
+    t, _ := fm.Time()
+    return fmt.Sprintf("/%s/%s", t.Format("2006/01/02"), fm.Slug())
+}
+
+func adminHandler(req *web.Request) {
+    list := &adminList{XSRF: req.Param.Get(web.XSRFParamName)}
+    list.User = signedInUser(req)
+    list.Waiting = len(commentStore.List(comments.Pending))
+    now := time.Now()
+    for _, kind := range []string{"posts", "pages"} {
+        repo := adminRepo(kind)
+        section := &adminSection{Name: strings.Title(kind)}
+        for _, source := range repo.Sources.All() {
+            fm, _, err := content.ReadFile(source.Path)
+            if err != nil {
+                logFor(req).Error("failed reading a source", "path", source.Path, "error", err)
+                serverError(req, err)
+                return
+            }
+            t, _ := fm.Time()
+            row := &adminRow{
+                Title:    fm.Title,
+                Status:   status(fm, now),
+                Date:     t.Format(adminTimeLayout),
+                URL:      view.CanonicalURL(publicPath(repo, fm)),
+                EditPath: fmt.Sprintf("/admin/%s/%s", kind, fm.Slug()),
+                t:        t,
+            }
+            row.Live = row.Status == "published"
+            section.Rows = append(section.Rows, row)
+        }
+        sort.Sort(byRecent(section.Rows))
+        list.Sections = append(list.Sections, section)
+    }
+    var err error
+    if list.Audit, err = recentAudit(20); err != nil {
+        logFor(req).Error("failed reading the audit log", "error", err)
+    }
+    view.RenderLayout(req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8"), &view.RenderInfo{
+        Admin:     list,
+        Title:     "Admin",
+        PageTitle: "Admin",
+    })
+}
+
+// loadEditor fills in the form from the source at kind and slug, or from
+// the template for a new post.
+func loadEditor(req *web.Request) (*editor, *content.FrontMatter, string, error) {
+    kind, slug := req.URLParam["kind"], req.URLParam["slug"]
+    e := &editor{
+        Kind:        kind,
+        Slug:        slug,
+        Action:      fmt.Sprintf("/admin/%s/%s", kind, slug),
+        PreviewPath: fmt.Sprintf("/admin/preview/%s/%s", kind, slug),
+        XSRF:        req.Param.Get(web.XSRFParamName),
+        Post:        kind == "posts",
+        Zone:        time.Now().In(content.Location).Format("MST"),
+    }
+    var (
+        fm   *content.FrontMatter
+        body string
+        err  error
+    )
+    if slug == "new" {
+        if kind != "posts" {
+            return nil, nil, "", errNoSource
+        }
+        e.New, e.Slug = true, ""
+        if fm, body, err = content.ReadFile(newPostTemplate); err != nil {
+            return nil, nil, "", err
+        }
+        fm.SetTime(time.Now())
+    } else {
+        source, ok := adminRepo(kind).Sources.Find(slug)
+        if !ok {
+            return nil, nil, "", errNoSource
+        }
+        if fm, body, err = content.ReadFile(source.Path); err != nil {
+            return nil, nil, "", err
+        }
+        e.URL = view.CanonicalURL(publicPath(adminRepo(kind), fm))
+        if history() != nil {
+            e.HistoryPath = e.Action + "/history"
+        }
+    }
+    e.fill(fm, body)
+    return e, fm, body, nil
+}
+
+var errNoSource = errors.New("no such post or page")
+
+func (e *editor) fill(fm *content.FrontMatter, body string) {
+    t, _ := fm.Time()
+    e.Title = fm.Title
+    e.Category = fm.Category
+    e.Tags = strings.Join(fm.Tags, ", ")
+    e.Description = fm.Description
+    e.PublishedOn = t.Format(adminTimeLayout)
+    e.Published = fm.Published
+    e.Toc = fm.Toc
+    e.Images = formatImages(fm.Images)
+    e.Body = body
+}
+
+// read takes the form as submitted into the editor and fm.
+func (e *editor) read(req *web.Request, fm *content.FrontMatter) error {
+    p := req.Param
+    e.Title = strings.TrimSpace(p.Get("title"))
+    e.Category = strings.TrimSpace(p.Get("category"))
+    e.Tags = p.Get("tags")
+    e.Description = strings.TrimSpace(p.Get("description"))
+    e.PublishedOn = strings.TrimSpace(p.Get("publishedon"))
+    e.Published = p.Get("published") == "yes"
+    e.Toc = p.Get("toc") == "yes"
+    e.Images = p.Get("images")
+    e.Body = strings.Replace(p.Get("body"), "\r\n", "\n", -1)
+    if e.New {
+        e.Slug = strings.TrimSpace(p.Get("slug"))
+    }
+
+    fm.Title = e.Title
+    fm.Description = e.Description
+    fm.Published = e.Published
+    fm.Toc = e.Toc
+    fm.Images = parseImages(e.Images, fm.Images)
+    if e.Post {
+        fm.Category = e.Category
+        fm.Tags = nil
+        for _, tag := range strings.Split(e.Tags, ",") {
+            if tag = strings.TrimSpace(tag); tag != "" {
+                fm.Tags = append(fm.Tags, tag)
+            }
+        }
+        fm.Tags = uniqueStrings(fm.Tags)
+    }
+    t, err := time.ParseInLocation(adminTimeLayout, e.PublishedOn, content.Location)
+    if err != nil {
+        return fmt.Errorf("Published on has to look like %s.", adminTimeLayout)
+    }
+    fm.SetTime(t)
+    if fm.Title == "" {
+        return errors.New("There has to be a title.")
+    }
+    if e.Post && fm.Category == "" {
+        return errors.New("There has to be a category.")
+    }
+    return nil
+}
+
+// preview renders body the way it'll look, with the image variants made
+// when its source was last read.
+func preview(kind, slug string, fm *content.FrontMatter, body string) template.HTML {
+    s := &content.Source{
+        Path: filepath.Join(adminRepo(kind).Dir, "new.md"),
+        Meta: content.Meta{Id: fm.Id, Slugs: fm.Slugs, Toc: fm.Toc, Images: fm.Images},
+        Body: body,
+    }
+    if source, ok := adminRepo(kind).Sources.Find(slug); ok {
+        s.Path, s.Images, s.ImagesErr = source.Path, source.Images, source.ImagesErr
+    }
+    doc, err := render.Render(s)
+    if err != nil {
+        return template.HTML(fmt.Sprintf(`<p class="error">%s</p>`, template.HTMLEscapeString(err.Error())))
+    }
+    return doc.HTML
+}
+
+func renderEditor(req *web.Request, status int, e *editor) {
+    title := "Editing " + e.Title
+    if e.New {
+        title = "New post"
+    }
+    e.Media = mediaItems()
+    view.RenderLayout(req.Respond(status, web.HeaderContentType, "text/html; charset=utf-8"), &view.RenderInfo{
+        Editor:    e,
+        Title:     title,
+        PageTitle: title,
+    })
+}
+
+func adminEditHandler(req *web.Request) {
+    e, fm, body, err := loadEditor(req)
+    if err == errNoSource {
+        notFound(req)
+        return
+    }
+    if err != nil {
+        logFor(req).Error("failed loading the editor", "error", err)
+        serverError(req, err)
+        return
+    }
+    e.Saved = req.Param.Get("saved") != ""
+    e.Preview = preview(e.Kind, e.Slug, fm, body)
+    renderEditor(req, web.StatusOK, e)
+}
+
+func adminSaveHandler(req *web.Request) {
+    e, fm, _, err := loadEditor(req)
+    if err == errNoSource {
+        notFound(req)
+        return
+    }
+    if err != nil {
+        logFor(req).Error("failed loading the editor", "error", err)
+        serverError(req, err)
+        return
+    }
+    repo := adminRepo(e.Kind)
+    if err := e.read(req, fm); err != nil {
+        e.Error = err.Error()
+        e.Preview = preview(e.Kind, e.Slug, fm, e.Body)
+        renderEditor(req, web.StatusBadRequest, e)
+        return
+    }
+    if req.Param.Get("action") == "preview" {
+        e.Preview = preview(e.Kind, e.Slug, fm, e.Body)
+        renderEditor(req, web.StatusOK, e)
+        return
+    }
+
+    var path string
+    if e.New {
+        slug := e.Slug
+        if slug == "" {
+            slug = fm.Title
+        }
+        fm.Author = config.SiteAuthor
+        err = createSource(repo, slug, fm, e.Body, signedInUser(req))
+        path = sourcePath(repo, fm.Slug())
+    } else {
+        // It could have gone since the editor was loaded.
+        source, ok := repo.Sources.Find(req.URLParam["slug"])
+        if !ok {
+            notFound(req)
+            return
+        }
+        path = source.Path
+        err = saveSource(repo, path, fm, e.Body, signedInUser(req))
+    }
+    if err != nil {
+        logFor(req).Error("failed saving", "path", path, "error", err)
+        serverError(req, err)
+        return
+    }
+    audit(req, "saved", path)
+    req.Redirect(fmt.Sprintf("/admin/%s/%s?saved=1", e.Kind, fm.Slug()), false)
+}
+
+// adminPreviewHandler renders the body for the live preview.
+func adminPreviewHandler(req *web.Request) {
+    kind, slug := req.URLParam["kind"], req.URLParam["slug"]
+    fm := &content.FrontMatter{}
+    if source, ok := adminRepo(kind).Sources.Find(slug); ok {
+        fm.Id, fm.Slugs, fm.Images = source.Meta.Id, source.Meta.Slugs, source.Meta.Images
+    }
+    if _, ok := req.Param["images"]; ok {
+        fm.Images = parseImages(req.Param.Get("images"), fm.Images)
+    }
+    body := strings.Replace(req.Param.Get("body"), "\r\n", "\n", -1)
+    w := req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8")
+    w.Write([]byte(preview(kind, slug, fm, body)))
+}
@@ -0,0 +1,137 @@
+package verboselogging
+
+import (
+    "analytics"
+    "config"
+    "content"
+    "github.com/darkhelmet/blargh/post"
+    "net/http"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+)
+
+var (
+    pageViews = openPageViews()
+    // notCounted are the pages that are for the people running the site, not
+    // reading it.
+    notCounted = []string{"/admin", "/login", "/logout", "/webmention/moderation", "/subscribe", "/unsubscribe"}
+    postPath   = regexp.MustCompile(`^/(\d{4})/(\d{2})/(\d{2})/([^/.]+)$`)
+)
+
+func init() {
+    go func() {
+        for {
+            time.Sleep(time.Minute)
+            if err := pageViews.Flush(); err != nil {
+                logger.Error("failed writing page views", "error", err)
+            }
+        }
+    }()
+}
+
+func openPageViews() *analytics.Store {
+    store, err := analytics.Open(filepath.Join(config.DataDir, "analytics"))
+    if err != nil {
+        panic(err)
+    }
+    return store
+}
+
+// countViews counts the pages handler serves, unless analytics are off.
+func countViews(handler http.Handler) http.Handler {
+    if config.Analytics != "yes" {
+        return handler
+    }
+    return analytics.Handler{Handler: handler, Store: pageViews, Ignore: notCounted}
+}
+
+// postAt is the post a path is the permalink for.
+func postAt(path string) (*post.Post, bool) {
+    m := postPath.FindStringSubmatch(path)
+    if m == nil {
+        return nil, false
+    }
+    y, _ := strconv.Atoi(m[1])
+    mo, _ := strconv.Atoi(m[2])
+    d, _ := strconv.Atoi(m[3])
+    p, err := posts.FindByPermalink(y, time.Month(mo), d, m[4])
+    return p, err == nil
+}
+
+// popularPost is a post and how much it's been read.
+type popularPost struct {
+    *post.Post
+    analytics.Count
+}
+
+// popularPosts are the n posts read the most in the last while.
+func popularPosts(n int, since time.Duration) ([]*popularPost, error) {
+    now := time.Now()
+    pages, err := pageViews.Popular(now.Add(-since), now, n, func(path string) bool {
+        _, ok := postAt(path)
+        return ok
+    })
+    if err != nil {
+        return nil, err
+    }
+    var popular []*popularPost
+    for _, page := range pages {
+        if p, ok := postAt(page.Path); ok {
+            popular = append(popular, &popularPost{p, page.Count})
+        }
+    }
+    return popular, nil
+}
+
+type analyticsBar struct {
+    Label string
+    *analytics.Period
+}
+
+type analyticsDashboard struct {
+    Days    int
+    Ranges  []int
+    Enabled bool
+    *analytics.Summary
+    Bars    []*analyticsBar
+    Popular []*popularPost
+}
+
+var analyticsRanges = []int{1, 7, 30, 90}
+
+func adminAnalyticsHandler(req *web.Request) {
+    days, err := strconv.Atoi(req.Param.Get("days"))
+    if err != nil || days < 1 || days > 366 {
+        days = 7
+    }
+    now := time.Now()
+    since := time.Duration(days) * 24 * time.Hour
+    sum, err := pageViews.Summarize(now.Add(-since), now)
+    if err != nil {
+        logFor(req).Error("failed summing up page views", "error", err)
+        serverError(req, err)
+        return
+    }
+    d := &analyticsDashboard{Days: days, Ranges: analyticsRanges, Enabled: config.Analytics == "yes", Summary: sum}
+    // Days are UTC days, like the files they come from, but hours may as
+    // well be local.
+    for _, p := range sum.Periods {
+        label := p.Start.UTC().Format("Jan 2")
+        if days <= 2 {
+            label = p.Start.In(content.Location).Format("15:04")
+        }
+        d.Bars = append(d.Bars, &analyticsBar{label, p})
+    }
+    if d.Popular, err = popularPosts(10, since); err != nil {
+        logFor(req).Error("failed finding popular posts", "error", err)
+    }
+    view.RenderLayout(req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8"), &view.RenderInfo{
+        Analytics: d,
+        Title:     "Analytics",
+        PageTitle: "Analytics",
+    })
+}
@@ -0,0 +1,426 @@
+package verboselogging
+
+import (
+    "content"
+    "crypto/sha1"
+    "encoding/json"
+    "fmt"
+    "github.com/darkhelmet/blargh/errors"
+    "github.com/darkhelmet/blargh/post"
+    "net/url"
+    "render"
+    "sort"
+    "strconv"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+)
+
+const (
+    apiPrefix      = "/api/v1"
+    apiPerPage     = 10
+    apiMaxPerPage  = 50
+    apiCacheMaxAge = "public, max-age=300"
+)
+
+type apiPost struct {
+    Id          int       `json:"id,omitempty"`
+    Slug        string    `json:"slug"`
+    Title       string    `json:"title"`
+    Description string    `json:"description"`
+    Author      string    `json:"author"`
+    Category    string    `json:"category,omitempty"`
+    Tags        []string  `json:"tags,omitempty"`
+    PublishedOn time.Time `json:"published_on"`
+    URL         string    `json:"url"`
+    APIURL      string    `json:"api_url"`
+    WordCount   int       `json:"word_count"`
+    ReadingTime int       `json:"reading_time"`
+    HTML        string    `json:"html,omitempty"`
+}
+
+type apiPostList struct {
+    Posts   []*apiPost `json:"posts"`
+    Page    int        `json:"page"`
+    PerPage int        `json:"per_page"`
+    Total   int        `json:"total"`
+    Pages   int        `json:"pages"`
+    Next    string     `json:"next,omitempty"`
+    Prev    string     `json:"prev,omitempty"`
+}
+
+type apiTaxonomy struct {
+    Name   string `json:"name"`
+    Count  int    `json:"count"`
+    URL    string `json:"url"`
+    APIURL string `json:"api_url"`
+}
+
+type apiMonth struct {
+    Year   int    `json:"year"`
+    Month  int    `json:"month"`
+    Count  int    `json:"count"`
+    URL    string `json:"url"`
+    APIURL string `json:"api_url"`
+}
+
+type apiError struct {
+    Error string `json:"error"`
+}
+
+var apiRouter = web.NewRouter().
+    Register(apiPrefix+"/posts", "GET", apiPostsHandler).
+    Register(apiPrefix+"/posts/<id>", "GET", apiPostHandler).
+    Register(apiPrefix+"/pages", "GET", apiPagesHandler).
+    Register(apiPrefix+"/pages/<slug>", "GET", apiPageHandler).
+    Register(apiPrefix+"/categories", "GET", apiCategoriesHandler).
+    Register(apiPrefix+"/tags", "GET", apiTagsHandler).
+    Register(apiPrefix+"/months", "GET", apiMonthsHandler).
+    Register(apiPrefix+"<path:.*>", "*", func(req *web.Request) {
+        writeAPIError(req, web.StatusNotFound, "no such endpoint")
+    })
+
+func apiURL(path string, query url.Values) string {
+    u := view.CanonicalURL(apiPrefix + path)
+    if len(query) > 0 {
+        u += "?" + query.Encode()
+    }
+    return u
+}
+
+func newAPIPost(p *post.Post, full bool) *apiPost {
+    a := newAPIDocument(p, full, posts.Sources, render.Post(p))
+    a.Category, a.Tags = p.Category, p.Tags
+    a.URL = view.CanonicalURL(view.PostCanonical(p))
+    a.APIURL = apiURL("/posts/"+p.Slug(), nil)
+    return a
+}
+
+func newAPIPage(p *post.Post, full bool) *apiPost {
+    a := newAPIDocument(p, full, pages.Sources, render.Page(p))
+    a.URL = view.CanonicalURL(view.PageCanonical(p))
+    a.APIURL = apiURL("/pages/"+p.Slug(), nil)
+    return a
+}
+
+// newAPIDocument fills in what posts and pages have in common.
+func newAPIDocument(p *post.Post, full bool, sources *content.Index, doc *render.Document) *apiPost {
+    a := &apiPost{
+        Slug:        p.Slug(),
+        Title:       p.Title,
+        Description: p.Description,
+        Author:      p.Author,
+        PublishedOn: p.PublishedOn,
+        WordCount:   doc.WordCount,
+        ReadingTime: doc.ReadingTime,
+    }
+    if source, ok := sources.Find(p.Slug()); ok {
+        a.Id = source.Meta.Id
+    }
+    if full {
+        a.HTML = string(doc.HTML)
+    }
+    return a
+}
+
+// apiHandler serves /api/v1. Everything is read only and open to any origin.
+func apiHandler(req *web.Request) {
+    web.FilterRespond(req, func(status int, header web.Header) (int, web.Header) {
+        header.Set(web.HeaderAccessControllAllowOrigin, "*")
+        header.Set("Access-Control-Expose-Headers", "Etag")
+        return status, header
+    })
+    if req.Method == "OPTIONS" {
+        req.Respond(web.StatusNoContent,
+            "Access-Control-Allow-Methods", "GET, OPTIONS",
+            "Access-Control-Allow-Headers", "If-None-Match",
+            "Access-Control-Max-Age", "86400")
+        return
+    }
+    apiRouter.ServeWeb(req)
+}
+
+// writeAPI sends v as JSON, tagged with a hash of the body so clients can
+// ask again with If-None-Match and get a 304.
+func writeAPI(req *web.Request, v interface{}) {
+    data, err := json.Marshal(v)
+    if err != nil {
+        logFor(req).Error("failed encoding API response", "error", err)
+        writeAPIError(req, web.StatusInternalServerError, "internal error")
+        return
+    }
+    etag := fmt.Sprintf("%x", sha1.Sum(data))
+    for _, match := range req.Header.GetList(web.HeaderIfNoneMatch) {
+        if web.UnquoteHeaderValue(match) == etag {
+            req.Respond(web.StatusNotModified,
+                web.HeaderETag, web.QuoteHeaderValue(etag),
+                web.HeaderCacheControl, apiCacheMaxAge)
+            return
+        }
+    }
+    w := req.Respond(web.StatusOK,
+        web.HeaderContentType, "application/json; charset=utf-8",
+        web.HeaderETag, web.QuoteHeaderValue(etag),
+        web.HeaderCacheControl, apiCacheMaxAge)
+    w.Write(data)
+}
+
+func writeAPIError(req *web.Request, status int, message string) {
+    data, _ := json.Marshal(apiError{message})
+    w := req.Respond(status, web.HeaderContentType, "application/json; charset=utf-8")
+    w.Write(data)
+}
+
+// apiLookup handles what a repo method gave back, treating not found as an
+// empty list. It has already responded when it returns false.
+func apiLookup(req *web.Request, what string, found []*post.Post, err error) ([]*post.Post, bool) {
+    if err == nil {
+        return found, true
+    }
+    if _, ok := err.(errors.NotFound); ok {
+        return nil, true
+    }
+    logFor(req).Error("failed finding "+what+" for API", "error", err)
+    writeAPIError(req, web.StatusInternalServerError, "internal error")
+    return nil, false
+}
+
+// apiPostsHandler lists posts, newest first. Filter with q, category, tag,
+// and year plus month; page through with page and per_page.
+func apiPostsHandler(req *web.Request) {
+    q, category, tag := req.Param.Get("q"), req.Param.Get("category"), req.Param.Get("tag")
+    year, _ := strconv.Atoi(req.Param.Get("year"))
+    month, _ := strconv.Atoi(req.Param.Get("month"))
+    if (year == 0) != (month == 0) || month < 0 || month > 12 {
+        writeAPIError(req, web.StatusBadRequest, "year and month go together")
+        return
+    }
+
+    var found []*post.Post
+    var err error
+    switch {
+    case q != "":
+        found, err = posts.Search(q)
+    case tag != "":
+        found, err = posts.FindByTag(tag)
+    case category != "":
+        found, err = posts.FindByCategory(category)
+    case year != 0:
+        found, err = posts.FindByMonth(year, time.Month(month))
+    default:
+        found, err = posts.FindLatest(posts.Len())
+    }
+    found, ok := apiLookup(req, "posts", found, err)
+    if !ok {
+        return
+    }
+
+    // The repo only filters on one thing at a time, so the rest happen here.
+    var filtered []*post.Post
+    for _, p := range found {
+        if category != "" && p.Category != category {
+            continue
+        }
+        if tag != "" && !hasTag(p, tag) {
+            continue
+        }
+        if year != 0 && (p.PublishedOn.Year() != year || int(p.PublishedOn.Month()) != month) {
+            continue
+        }
+        filtered = append(filtered, p)
+    }
+
+    page, _ := strconv.Atoi(req.Param.Get("page"))
+    if page < 1 {
+        page = 1
+    }
+    perPage, _ := strconv.Atoi(req.Param.Get("per_page"))
+    if perPage < 1 {
+        perPage = apiPerPage
+    }
+    if perPage > apiMaxPerPage {
+        perPage = apiMaxPerPage
+    }
+
+    list := &apiPostList{
+        Posts:   []*apiPost{},
+        Page:    page,
+        PerPage: perPage,
+        Total:   len(filtered),
+        Pages:   (len(filtered) + perPage - 1) / perPage,
+    }
+    // Pages past the end are empty, and checking first keeps a huge page
+    // from overflowing.
+    if page <= list.Pages {
+        start, end := (page-1)*perPage, page*perPage
+        if end > len(filtered) {
+            end = len(filtered)
+        }
+        for _, p := range filtered[start:end] {
+            list.Posts = append(list.Posts, newAPIPost(p, false))
+        }
+    }
+
+    query := url.Values{}
+    for key, values := range req.Param {
+        query[key] = values
+    }
+    if page < list.Pages {
+        query.Set("page", strconv.Itoa(page+1))
+        list.Next = apiURL("/posts", query)
+    }
+    if page > 1 {
+        query.Set("page", strconv.Itoa(page-1))
+        list.Prev = apiURL("/posts", query)
+    }
+    writeAPI(req, list)
+}
+
+func hasTag(p *post.Post, tag string) bool {
+    for _, t := range p.Tags {
+        if t == tag {
+            return true
+        }
+    }
+    return false
+}
+
+// apiPostHandler finds a post by slug, or by the id in its front matter.
+func apiPostHandler(req *web.Request) {
+    key := req.URLParam["id"]
+    slug := key
+    if id, err := strconv.Atoi(key); err == nil {
+        if source, ok := posts.Sources.FindById(id); ok {
+            slug = source.Slug()
+        }
+    }
+    p, err := posts.FindBySlug(slug)
+    if err != nil {
+        if _, ok := err.(errors.NotFound); ok {
+            writeAPIError(req, web.StatusNotFound, fmt.Sprintf("no post %#v", key))
+            return
+        }
+        logFor(req).Error("failed finding post for API", "key", key, "error", err)
+        writeAPIError(req, web.StatusInternalServerError, "internal error")
+        return
+    }
+    writeAPI(req, newAPIPost(p, true))
+}
+
+func apiPagesHandler(req *web.Request) {
+    all, err := pages.FindLatest(pages.Len())
+    all, ok := apiLookup(req, "pages", all, err)
+    if !ok {
+        return
+    }
+    list := []*apiPost{}
+    for _, p := range all {
+        list = append(list, newAPIPage(p, false))
+    }
+    writeAPI(req, list)
+}
+
+func apiPageHandler(req *web.Request) {
+    slug := req.URLParam["slug"]
+    p, err := pages.FindBySlug(slug)
+    if err != nil {
+        if _, ok := err.(errors.NotFound); ok {
+            writeAPIError(req, web.StatusNotFound, fmt.Sprintf("no page %#v", slug))
+            return
+        }
+        logFor(req).Error("failed finding page for API", "slug", slug, "error", err)
+        writeAPIError(req, web.StatusInternalServerError, "internal error")
+        return
+    }
+    writeAPI(req, newAPIPage(p, true))
+}
+
+// byCountThenName sorts taxonomies by how many posts they have, most first.
+type byCountThenName []*apiTaxonomy
+
+func (b byCountThenName) Len() int      { return len(b) }
+func (b byCountThenName) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byCountThenName) Less(i, j int) bool {
+    if b[i].Count != b[j].Count {
+        return b[i].Count > b[j].Count
+    }
+    return b[i].Name < b[j].Name
+}
+
+// taxonomy counts posts under whatever names keys gives for each one.
+func taxonomy(all []*post.Post, kind string, keys func(*post.Post) []string) []*apiTaxonomy {
+    byName := make(map[string]*apiTaxonomy)
+    list := []*apiTaxonomy{}
+    for _, p := range all {
+        for _, name := range keys(p) {
+            t, ok := byName[name]
+            if !ok {
+                t = &apiTaxonomy{
+                    Name:   name,
+                    URL:    view.CanonicalURL(view.TaxonomyPath(kind, name)),
+                    APIURL: apiURL("/posts", url.Values{kind: {name}}),
+                }
+                byName[name] = t
+                list = append(list, t)
+            }
+            t.Count++
+        }
+    }
+    sort.Sort(byCountThenName(list))
+    return list
+}
+
+func apiCategoriesHandler(req *web.Request) {
+    all, err := posts.FindLatest(posts.Len())
+    all, ok := apiLookup(req, "posts", all, err)
+    if !ok {
+        return
+    }
+    writeAPI(req, taxonomy(all, "category", func(p *post.Post) []string {
+        return []string{p.Category}
+    }))
+}
+
+func apiTagsHandler(req *web.Request) {
+    all, err := posts.FindLatest(posts.Len())
+    all, ok := apiLookup(req, "posts", all, err)
+    if !ok {
+        return
+    }
+    writeAPI(req, taxonomy(all, "tag", func(p *post.Post) []string {
+        return p.Tags
+    }))
+}
+
+// apiMonthsHandler counts posts per month, newest month first.
+func apiMonthsHandler(req *web.Request) {
+    all, err := posts.FindLatest(posts.Len())
+    all, ok := apiLookup(req, "posts", all, err)
+    if !ok {
+        return
+    }
+    byMonth := make(map[string]*apiMonth)
+    var keys []string
+    for _, p := range all {
+        key := p.PublishedOn.Format("2006/01")
+        m, ok := byMonth[key]
+        if !ok {
+            year, month := p.PublishedOn.Year(), int(p.PublishedOn.Month())
+            m = &apiMonth{
+                Year:   year,
+                Month:  month,
+                URL:    view.CanonicalURL("/" + key),
+                APIURL: apiURL("/posts", url.Values{"year": {strconv.Itoa(year)}, "month": {strconv.Itoa(month)}}),
+            }
+            byMonth[key] = m
+            keys = append(keys, key)
+        }
+        m.Count++
+    }
+    sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+    months := []*apiMonth{}
+    for _, key := range keys {
+        months = append(months, byMonth[key])
+    }
+    writeAPI(req, months)
+}
@@ -0,0 +1,210 @@
+package verboselogging
+
+import (
+    "comments"
+    "config"
+    "errors"
+    "fmt"
+    "github.com/darkhelmet/blargh/post"
+    "path/filepath"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+)
+
+// maxCommentSize is plenty for MaxLength characters and the rest of the
+// form.
+const maxCommentSize = 64 << 10
+
+// commentTokenAge is how long somebody has to write their comment once the
+// post's loaded.
+const commentTokenAge = 24 * time.Hour
+
+// errStaleForm is for a comment without a good token: the form's from too
+// long ago, or from some other post, or from nowhere.
+var errStaleForm = errors.New("the form's too old, send it again")
+
+var (
+    commentStore = openComments()
+    spamFilter   = openSpamFilter()
+)
+
+func openComments() *comments.Store {
+    store, err := comments.Open(filepath.Join(config.DataDir, "comments.json"))
+    if err != nil {
+        panic(err)
+    }
+    return store
+}
+
+func openSpamFilter() *comments.Filter {
+    f, err := comments.OpenFilter(filepath.Join(config.DataDir, "spam.json"))
+    if err != nil {
+        panic(err)
+    }
+    return f
+}
+
+// commentsOpen is whether comments are taken. Nobody could moderate them
+// without somebody to sign in.
+func commentsOpen() bool {
+    return config.Comments == "yes" && signingIn()
+}
+
+// commentToken is signed with the permalink, standing in for an XSRF token
+// so the page doesn't need a cookie.
+func commentToken(permalink string) string {
+    return web.SignValue(config.SessionSecret, "comment", commentTokenAge, permalink)
+}
+
+type commentForm struct {
+    Action, Token, Notice, Error string
+    Name, Email, URL, Body       string
+    ReplyTo                      *comments.Comment
+}
+
+// newCommentForm is the form under p, replying to whatever the reply
+// parameter says if that's a comment showing on p.
+func newCommentForm(req *web.Request, p *post.Post) *commentForm {
+    if !commentsOpen() {
+        return nil
+    }
+    f := &commentForm{
+        Action: view.PostCanonical(p) + "/comments",
+        Token:  commentToken(view.PostCanonical(p)),
+    }
+    if c, ok := commentStore.Get(req.Param.Get("reply")); ok && c.Visible() && c.Target == view.CanonicalURL(view.PostCanonical(p)) {
+        f.ReplyTo = c
+    }
+    if req.Param.Get("commented") != "" {
+        f.Notice = "Thanks! Your comment will show up once it's been checked."
+    }
+    return f
+}
+
+func commentHandler(req *web.Request) {
+    p, err := findPermalink(req)
+    if err != nil || !commentsOpen() {
+        notFound(req)
+        return
+    }
+    permalink := view.PostCanonical(p)
+    done := permalink + "?commented=1#comment-form"
+    // People can't see the homepage field, so anything in it was filled in
+    // by a bot. It gets told everything went fine.
+    if req.Param.Get("homepage") != "" {
+        logFor(req).Info("dropped a comment that filled in the honeypot", "permalink", permalink)
+        req.Redirect(done, false)
+        return
+    }
+
+    form := req.Param
+    var c *comments.Comment
+    if token, terr := web.VerifyValue(config.SessionSecret, "comment", form.Get("token")); terr != nil || token != permalink {
+        err = errStaleForm
+    } else {
+        c, err = comments.New(view.CanonicalURL(permalink), form.Get("parent"), form.Get("name"), form.Get("email"), form.Get("url"), form.Get("body"), remoteHost(req))
+    }
+    if err == nil {
+        spamFilter.Classify(c)
+        err = commentStore.Add(c)
+    }
+    if err != nil {
+        f := newCommentForm(req, p)
+        f.Name, f.Email, f.URL, f.Body = form.Get("name"), form.Get("email"), form.Get("url"), form.Get("body")
+        if parent, ok := commentStore.Get(form.Get("parent")); ok && parent.Visible() && parent.Target == view.CanonicalURL(permalink) {
+            f.ReplyTo = parent
+        }
+        status := web.StatusBadRequest
+        if !isCommentError(err) {
+            logFor(req).Error("failed saving a comment", "permalink", permalink, "error", err)
+            status = web.StatusInternalServerError
+            err = errors.New("something went wrong saving it, sorry")
+        }
+        f.Error = fmt.Sprintf("Couldn't post that: %s.", err)
+        view.RenderLayout(req.Respond(status, web.HeaderContentType, "text/html; charset=utf-8"), &view.RenderInfo{
+            Post:        p,
+            CommentForm: f,
+            Title:       p.Title,
+            Canonical:   permalink,
+            Description: p.Description,
+        })
+        return
+    }
+    logFor(req).Info("received comment", "id", c.Id, "permalink", permalink, "status", c.Status, "spamminess", c.Spamminess)
+    req.Redirect(done, false)
+}
+
+// isCommentError tells the mistakes somebody commenting can fix from the
+// ones they can't.
+func isCommentError(err error) bool {
+    switch err {
+    case errStaleForm, comments.ErrNoName, comments.ErrNoBody, comments.ErrTooLong, comments.ErrBadURL, comments.ErrBadEmail, comments.ErrNoParent:
+        return true
+    }
+    return false
+}
+
+type commentSection struct {
+    Name     string
+    Comments []*comments.Comment
+}
+
+type commentQueue struct {
+    XSRF     string
+    Sections []*commentSection
+}
+
+func commentQueueHandler(req *web.Request) {
+    approved := commentStore.List(comments.Approved)
+    if len(approved) > 20 {
+        approved = approved[:20]
+    }
+    view.RenderLayout(req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8"), &view.RenderInfo{
+        CommentQueue: &commentQueue{
+            XSRF: req.Param.Get(web.XSRFParamName),
+            Sections: []*commentSection{
+                {"Waiting", commentStore.List(comments.Pending)},
+                {"Spam", commentStore.List(comments.Spam)},
+                {"Recently approved", approved},
+            },
+        },
+        Title:     "Comments",
+        PageTitle: "Comments",
+    })
+}
+
+// moderateCommentHandler approves a comment, or marks it as spam, and the
+// spam filter learns from it either way.
+func moderateCommentHandler(req *web.Request) {
+    id := req.URLParam["id"]
+    c, ok := commentStore.Get(id)
+    if !ok {
+        notFound(req)
+        return
+    }
+
+    var err error
+    switch req.Param.Get("action") {
+    case "approve":
+        if err = spamFilter.Moderate(c, comments.Approved); err == nil {
+            err = commentStore.Put(c)
+        }
+    case "spam":
+        if err = spamFilter.Moderate(c, comments.Spam); err == nil {
+            err = commentStore.Put(c)
+        }
+    case "delete":
+        err = commentStore.Delete(id)
+    default:
+        req.Error(web.StatusBadRequest, errors.New("unknown action"))
+        return
+    }
+    if err != nil {
+        logFor(req).Error("failed moderating comment", "id", id, "error", err)
+        serverError(req, err)
+        return
+    }
+    audit(req, "moderated comment", req.Param.Get("action")+" "+id+" by "+c.Name)
+    req.Redirect("/admin/comments", false)
+}
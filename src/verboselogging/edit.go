@@ -0,0 +1,111 @@
+package verboselogging
+
+import (
+    "atomicfile"
+    "content"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strconv"
+    "sync"
+)
+
+// editing keeps edits to posts and pages from stepping on each other,
+// whichever way they come in.
+var editing sync.Mutex
+
+// sourcePath is where the source for slug goes in repo.
+func sourcePath(repo *Repo, slug string) string {
+    return filepath.Join(repo.Dir, slug+".md")
+}
+
+// uniqueSlug makes slug into one nothing else in repo is using yet, as a
+// slug or a file name.
+func uniqueSlug(repo *Repo, slug string) string {
+    if slug == "" {
+        slug = "untitled"
+    }
+    unique := slug
+    for n := 2; ; n++ {
+        if _, err := os.Stat(sourcePath(repo, unique)); os.IsNotExist(err) && !content.Exists(unique) {
+            return unique
+        }
+        unique = slug + "-" + strconv.Itoa(n)
+    }
+}
+
+// saveSource writes a post or page, commits it as editor, and reloads, so
+// it's up right away.
+func saveSource(repo *Repo, path string, fm *content.FrontMatter, body, editor string) error {
+    editing.Lock()
+    defer editing.Unlock()
+    message := "Update " + historyPath(path)
+    if _, err := os.Stat(path); os.IsNotExist(err) {
+        message = "Add " + historyPath(path)
+    }
+    return replaceSource(repo, path, content.Encode(fm, body), editor, message)
+}
+
+// createSource adds a new post or page with the next id, and a slug made
+// from slug that nothing's using yet. Both get picked under the lock, so two
+// at once can't end up in the same file.
+func createSource(repo *Repo, slug string, fm *content.FrontMatter, body, editor string) error {
+    editing.Lock()
+    defer editing.Unlock()
+    fm.Id = content.NextId()
+    fm.Slugs = []string{uniqueSlug(repo, content.Slugify(slug))}
+    path := sourcePath(repo, fm.Slug())
+    return replaceSource(repo, path, content.Encode(fm, body), editor, "Add "+historyPath(path))
+}
+
+// restoreSource puts back a post or page exactly as it was, and reloads.
+func restoreSource(repo *Repo, path string, data []byte, editor, message string) error {
+    editing.Lock()
+    defer editing.Unlock()
+    return replaceSource(repo, path, data, editor, message)
+}
+
+// removeSource deletes a post or page, and reloads.
+func removeSource(repo *Repo, path, editor string) error {
+    editing.Lock()
+    defer editing.Unlock()
+    return replaceSource(repo, path, nil, editor, "Delete "+historyPath(path))
+}
+
+// replaceSource puts data at path, or deletes it if data is nil, and
+// reloads. Something the site can't load never stays: the old file goes
+// back and nothing's committed, so the site keeps running what it had.
+func replaceSource(repo *Repo, path string, data []byte, editor, message string) error {
+    if data != nil {
+        if _, _, err := content.Parse(data); err != nil {
+            return err
+        }
+    }
+    old, err := ioutil.ReadFile(path)
+    existed := err == nil
+    if err != nil && !os.IsNotExist(err) {
+        return err
+    }
+
+    if data == nil {
+        err = os.Remove(path)
+    } else {
+        err = atomicfile.WriteFile(path, data, 0644)
+    }
+    if err != nil {
+        return err
+    }
+    if reloadErr := repo.Reload(); reloadErr != nil {
+        if existed {
+            err = atomicfile.WriteFile(path, old, 0644)
+        } else {
+            err = os.Remove(path)
+        }
+        if err != nil {
+            logger.Error("failed putting back the old source", "path", path, "error", err)
+        }
+        return reloadErr
+    }
+    commitSource(path, editor, message)
+    return nil
+}
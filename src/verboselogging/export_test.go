@@ -0,0 +1,63 @@
+package verboselogging
+
+import (
+    "activitypub"
+    "config"
+    "github.com/darkhelmet/blargh/post"
+    "images"
+    "media"
+    "path/filepath"
+)
+
+// OnPublish calls f with the slugs of the posts published announces.
+func OnPublish(f func(slugs []string)) {
+    published.OnPublish(func(fresh []*post.Post) {
+        var slugs []string
+        for _, p := range fresh {
+            slugs = append(slugs, p.Slug())
+        }
+        f(slugs)
+    })
+}
+
+// AddReply takes a reply as if it came in through the inbox.
+func AddReply(r activitypub.Reply) error {
+    return followers.AddReply(r)
+}
+
+// UseDir moves everything the site writes under root, so tests don't touch
+// the real posts, pages, uploads or data: posts and pages are read from
+// root/posts and root/pages, uploads and image variants go in root/media and
+// root/images, and whatever was opened from the old DATA_DIR is opened again
+// from root/data.
+func UseDir(root string) error {
+    config.DataDir = filepath.Join(root, "data")
+    images.Output = filepath.Join(root, "images")
+    commentStore = openComments()
+    spamFilter = openSpamFilter()
+    subscribers = openSubscribers()
+    sendLog = openSendLog()
+    outbox = openOutbox()
+    sender.Outbox = outbox
+    mentions = openMentions()
+    receiver.Store = mentions
+    followers = openFollowers()
+    pageViews = openPageViews()
+    hub = openHub()
+
+    lib, err := media.Open(filepath.Join(root, "media"), filepath.Join(config.DataDir, "media.json"), int64(config.MaxUploadSize))
+    if err != nil {
+        return err
+    }
+    library = lib
+
+    historyDir = root
+    for _, repo := range []*Repo{posts, pages} {
+        dir := filepath.Join(root, repo.name())
+        repo.Dir, repo.Sources.Dir = dir, dir
+        if err := repo.Reload(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
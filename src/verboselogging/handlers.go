@@ -1,14 +1,17 @@
 package verboselogging
 
 import (
+    "bytes"
     "config"
+    "content"
     "fmt"
     "github.com/darkhelmet/blargh/errors"
     "github.com/darkhelmet/blargh/post"
     "github.com/darkhelmet/webutil"
-    "log"
+    "highlight"
+    "io"
+    "logging"
     "net/http"
-    "os"
     "regexp"
     "strconv"
     "strings"
@@ -19,49 +22,52 @@ import (
 )
 
 var (
-    logger        = log.New(os.Stdout, "[verboselogging] ", config.LogFlags)
+    logger        = logging.New("verboselogging")
     feedburner    = regexp.MustCompile("(?i)feedburner")
     feedburnerUrl = "http://feeds.feedburner.com/VerboseLogging"
-    posts         = NewRepo("posts")
-    pages         = NewRepo("pages")
+    posts         = NewRepo(content.PostsDir)
+    pages         = NewRepo(content.PagesDir)
+    highlightCSS  = highlight.Stylesheet()
 )
 
 func rootHandler(req *web.Request) {
     posts, err := posts.FindLatest(6)
     if err != nil {
-        logger.Printf("failed finding latest posts: %s", err)
+        logFor(req).Error("failed finding latest posts", "error", err)
         serverError(req, err)
     } else {
-        w := req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8")
-        view.RenderLayout(w, &view.RenderInfo{
+        respondListing(req, &view.RenderInfo{
             PostPreview:  posts,
             Canonical:    "/",
             ArchiveLinks: true,
             Description:  config.SiteDescription,
-        })
+        }, posts)
     }
 }
 
 func opensearchHandler(req *web.Request) {
     w := req.Respond(web.StatusOK, web.HeaderContentType, "application/xml; charset=utf-8")
-    view.RenderPartial(w, "opensearch.tmpl", nil)
+    view.RenderPartial(w, "opensearch.tmpl", &view.RenderInfo{
+        SiteTitle:       config.SiteTitle,
+        SiteDescription: config.SiteDescription,
+        SiteContact:     config.SiteContact,
+    })
 }
 
 func searchHandler(req *web.Request) {
-    query := req.Param.Get("query")
+    query := searchQuery(req)
     posts, err := posts.Search(query)
     if err != nil {
-        logger.Printf("failed finding posts with query %#v: %s", query, err)
+        logFor(req).Error("failed finding posts", "query", query, "error", err)
         serverError(req, err)
     } else {
-        w := req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8")
         title := fmt.Sprintf("Search results for %#v", query)
-        view.RenderLayout(w, &view.RenderInfo{
+        respondListing(req, &view.RenderInfo{
             PostPreview:  posts,
             Title:        title,
             PageTitle:    title,
             ArchiveLinks: true,
-        })
+        }, posts)
     }
 }
 
@@ -75,55 +81,47 @@ func feedHandler(req *web.Request) {
         }
     }
 
-    posts, err := posts.FindLatest(10)
-    if err != nil {
-        logger.Printf("failed getting posts for feed: %s", err)
+    var feed bytes.Buffer
+    if err := renderFeed(&feed); err != nil {
+        logFor(req).Error("failed getting posts for feed", "error", err)
         serverError(req, err)
-    } else {
-        w := req.Respond(web.StatusOK, web.HeaderContentType, "application/rss+xml; charset=utf-8")
-        view.RenderPartial(w, "feed.tmpl", &view.RenderInfo{
-            Post:            posts,
-            SiteTitle:       config.SiteTitle,
-            SiteDescription: config.SiteDescription,
-            SiteContact:     config.SiteContact,
-            SiteAuthor:      config.SiteAuthor,
-        })
+        return
+    }
+    header := []string{web.HeaderContentType, feedContentType}
+    for _, link := range feedLinks() {
+        header = append(header, "Link", link)
     }
+    req.Respond(web.StatusOK, header...).Write(feed.Bytes())
 }
 
-func sitemapHandler(req *web.Request) {
-    posts, err := posts.FindLatest(posts.Len())
-    if err != nil {
-        logger.Printf("failed getting posts for sitemap: %s", err)
-        serverError(req, err)
-    } else {
-        w := req.Respond(web.StatusOK, web.HeaderContentType, "application/xml; charset=utf-8")
-        view.RenderPartial(w, "sitemap.tmpl", &view.RenderInfo{Post: posts})
-    }
+func highlightStylesheetHandler(req *web.Request) {
+    w := req.Respond(web.StatusOK,
+        web.HeaderContentType, "text/css; charset=utf-8",
+        web.HeaderCacheControl, "public, max-age=86400")
+    io.WriteString(w, highlightCSS)
 }
 
 func fullArchiveHandler(req *web.Request) {
     posts, err := posts.FindLatest(posts.Len())
     if err != nil {
-        logger.Printf("failed getting posts for full archive: %s", err)
+        logFor(req).Error("failed getting posts for full archive", "error", err)
         serverError(req, err)
     } else {
-        w := req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8")
         title := "Full archives"
-        view.RenderLayout(w, &view.RenderInfo{
+        respondListing(req, &view.RenderInfo{
             FullArchive:  posts,
             Description:  title,
             Title:        title,
             ArchiveLinks: true,
-            Canonical:    req.URL.Path,
-        })
+            Canonical:    canonicalPath(req),
+        }, posts)
     }
 }
 
 func categoryArchiveHandler(req *web.Request) {
     posts, err := posts.FindLatest(posts.Len())
     if err != nil {
-        logger.Printf("failed getting posts for category archive: %s", err)
+        logFor(req).Error("failed getting posts for category archive", "error", err)
         serverError(req, err)
     } else {
         grouped := make(map[string][]*post.Post)
@@ -132,21 +130,20 @@ func categoryArchiveHandler(req *web.Request) {
             grouped[key] = append(grouped[key], post)
         }
 
-        w := req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8")
-        view.RenderLayout(w, &view.RenderInfo{
+        respondListing(req, &view.RenderInfo{
             CategoryArchive: grouped,
             Description:     "Archives by category",
             Title:           "Category archives",
             ArchiveLinks:    true,
-            Canonical:       req.URL.Path,
-        })
+            Canonical:       canonicalPath(req),
+        }, posts)
     }
 }
 
 func monthlyArchiveHandler(req *web.Request) {
     posts, err := posts.FindLatest(posts.Len())
     if err != nil {
-        logger.Printf("failed getting posts for monthly archive: %s", err)
+        logFor(req).Error("failed getting posts for monthly archive", "error", err)
         serverError(req, err)
     } else {
         grouped := make(map[int64][]*post.Post)
@@ -156,14 +153,13 @@ func monthlyArchiveHandler(req *web.Request) {
             grouped[key] = append(grouped[key], post)
         }
 
-        w := req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8")
-        view.RenderLayout(w, &view.RenderInfo{
+        respondListing(req, &view.RenderInfo{
             MonthlyArchive: grouped,
             Description:    "Archives by month",
             Title:          "Monthly archives",
             ArchiveLinks:   true,
-            Canonical:      req.URL.Path,
-        })
+            Canonical:      canonicalPath(req),
+        }, posts)
     }
 }
 
@@ -173,18 +169,17 @@ func monthlyHandler(req *web.Request) {
     m, _ := strconv.Atoi(month)
     posts, err := posts.FindByMonth(y, time.Month(m))
     if err != nil {
-        logger.Printf("failed finding posts in month %#v of %#v: %s", month, year, err)
+        logFor(req).Error("failed finding posts in month", "year", year, "month", month, "error", err)
         serverError(req, err)
     } else {
-        w := req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8")
         title := fmt.Sprintf("Archives for %s-%s", month, year)
-        view.RenderLayout(w, &view.RenderInfo{
+        respondListing(req, &view.RenderInfo{
             PostPreview:  posts,
             Title:        title,
-            Canonical:    req.URL.Path,
+            Canonical:    canonicalPath(req),
             ArchiveLinks: true,
             Description:  title,
-        })
+        }, posts)
     }
 }
 
@@ -192,45 +187,50 @@ func categoryHandler(req *web.Request) {
     category := req.URLParam["category"]
     posts, err := posts.FindByCategory(category)
     if err != nil {
-        logger.Printf("failed finding posts with category %#v: %s", category, err)
+        logFor(req).Error("failed finding posts with category", "category", category, "error", err)
         serverError(req, err)
     } else {
-        w := req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8")
         category = strings.Title(category)
         title := fmt.Sprintf("%s Articles", category)
-        view.RenderLayout(w, &view.RenderInfo{
+        respondListing(req, &view.RenderInfo{
             PostPreview: posts,
             Title:       title,
             PageTitle:   title,
-            Canonical:   req.URL.Path,
+            Canonical:   canonicalPath(req),
             Description: fmt.Sprintf("Articles in the %s category", category),
-        })
+        }, posts)
     }
 }
 
+// findPermalink is the post the year, month, day and slug in the URL point
+// at.
+func findPermalink(req *web.Request) (*post.Post, error) {
+    y, _ := strconv.Atoi(req.URLParam["year"])
+    m, _ := strconv.Atoi(req.URLParam["month"])
+    d, _ := strconv.Atoi(req.URLParam["day"])
+    return posts.FindByPermalink(y, time.Month(m), d, req.URLParam["slug"])
+}
+
 func permalinkHandler(req *web.Request) {
     slug := req.URLParam["slug"]
     year, month, day := req.URLParam["year"], req.URLParam["month"], req.URLParam["day"]
-    y, _ := strconv.Atoi(year)
-    m, _ := strconv.Atoi(month)
-    d, _ := strconv.Atoi(day)
-    post, err := posts.FindByPermalink(y, time.Month(m), d, slug)
+    post, err := findPermalink(req)
     if err != nil {
         switch err.(type) {
         case errors.NotFound:
             notFound(req)
         default:
-            logger.Printf("failed finding post with year(%#v) month(%#v) day(%#v) slug(%#v): %s (%T)", year, month, day, slug, err, err)
+            logFor(req).Error("failed finding post", "year", year, "month", month, "day", day, "slug", slug, "error", err, "error_type", fmt.Sprintf("%T", err))
             serverError(req, err)
         }
     } else {
-        w := req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8")
-        view.RenderLayout(w, &view.RenderInfo{
+        respondPost(req, &view.RenderInfo{
             Post:        post,
+            CommentForm: newCommentForm(req, post),
             Title:       post.Title,
             Canonical:   view.PostCanonical(post),
             Description: post.Description,
-        })
+        }, post)
     }
 }
 
@@ -238,18 +238,17 @@ func tagHandler(req *web.Request) {
     tag := req.URLParam["tag"]
     posts, err := posts.FindByTag(tag)
     if err != nil {
-        logger.Printf("failed finding posts with tag %#v: %s", tag, err)
+        logFor(req).Error("failed finding posts with tag", "tag", tag, "error", err)
         serverError(req, err)
     } else {
-        w := req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8")
         title := fmt.Sprintf("Articles tagged with %#v", tag)
-        view.RenderLayout(w, &view.RenderInfo{
+        respondListing(req, &view.RenderInfo{
             PostPreview: posts,
             Title:       title,
             PageTitle:   title,
-            Canonical:   req.URL.Path,
+            Canonical:   canonicalPath(req),
             Description: fmt.Sprintf("Articles with the %#v tag", tag),
-        })
+        }, posts)
     }
 }
 
@@ -261,21 +260,21 @@ func pageHandler(req *web.Request) {
         case errors.NotFound:
             notFound(req)
         default:
-            logger.Printf("failed finding page with slug %#v: %s (%T)", slug, err, err)
+            logFor(req).Error("failed finding page", "slug", slug, "error", err, "error_type", fmt.Sprintf("%T", err))
             serverError(req, err)
         }
     } else {
-        w := req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8")
-        view.RenderLayout(w, &view.RenderInfo{
+        respondPost(req, &view.RenderInfo{
             Page:        page,
             Title:       page.Title,
             Canonical:   view.PageCanonical(page),
             Description: page.Description,
-        })
+        }, page)
     }
 }
 
 func serverError(req *web.Request, err error) {
+    req.Env[errorKey] = err
     w := req.Respond(web.StatusInternalServerError, web.HeaderContentType, "text/html; charset=utf-8")
     view.RenderLayout(w, &view.RenderInfo{
         Error: true,
@@ -291,7 +290,9 @@ func notFound(req *web.Request) {
     })
 }
 
-func SetupHandler() http.Handler {
+// Router is every route on the site, without the middleware SetupHandler
+// puts around it.
+func Router() *web.Router {
     staticOptions := &web.ServeFileOptions{
         Header: web.Header{
             web.HeaderCacheControl:              {"public, max-age=31536000"},
@@ -299,27 +300,75 @@ func SetupHandler() http.Handler {
         },
     }
 
-    router := web.NewRouter().
-        Register("/", "GET", rootHandler).
+    return routes{web.NewRouter()}.
+        Register("/"+formatPattern, "GET", rootHandler).
+        Register("/metrics", "GET", metricsHandler).
         Register("/opensearch.xml", "GET", opensearchHandler).
-        Register("/search", "GET", searchHandler).
+        Register("/search"+formatPattern, "GET", searchHandler).
+        Register("/search/suggest", "GET", suggestHandler).
         Register("/feed", "GET", feedHandler).
+        Register("/hub", "POST", web.FormHandler(10000, false, web.HandlerFunc(hubHandler))).
         Register("/sitemap.xml<gzip:(\\.gz)?>", "GET", sitemapHandler).
-        Register("/archive/full", "GET", fullArchiveHandler).
-        Register("/archive/category", "GET", categoryArchiveHandler).
-        Register("/archive/month", "GET", monthlyArchiveHandler).
-        Register("/<year:\\d{4}>/<month:\\d{2}>", "GET", monthlyHandler).
-        Register("/category/<category>", "GET", categoryHandler).
-        Register("/<year:\\d{4}>/<month:\\d{2}>/<day:\\d{2}>/<slug>", "GET", permalinkHandler).
-        Register("/tag/<tag>", "GET", tagHandler).
-        Register("/<slug:\\w+>", "GET", pageHandler).
-        Register("/<path:.*>", "GET", web.DirectoryHandler("public", staticOptions))
+        Register("/sitemap-<name:[a-z]+>.xml<gzip:(\\.gz)?>", "GET", childSitemapHandler).
+        Register("/highlight.css", "GET", highlightStylesheetHandler).
+        Register("/api/v1<path:(/.*)?>", "*", apiHandler).
+        Register("/.well-known/webfinger", "GET", webfingerHandler).
+        Register("/actor", "GET", actorHandler).
+        Register("/actor/inbox", "POST", inboxHandler).
+        Register("/actor/outbox", "GET", outboxHandler).
+        Register("/actor/followers", "GET", followersHandler).
+        Register("/micropub", "GET", micropubAuth(micropubQueryHandler),
+            "POST", web.FormHandler(maxMicropubSize, false, micropubAuth(micropubHandler))).
+        Register("/micropub/media", "POST", micropubAuth(mediaHandler)).
+        Register("/xmlrpc", "POST", xmlrpcHandler).
+        Register("/login", "GET", web.FormHandler(0, true, web.HandlerFunc(loginHandler)),
+            "POST", web.FormHandler(10000, true, web.HandlerFunc(loginPostHandler))).
+        Register("/logout", "POST", web.FormHandler(10000, true, signedIn(logoutHandler))).
+        Register("/admin", "GET", web.FormHandler(0, true, signedIn(adminHandler))).
+        Register("/admin/media", "GET", web.FormHandler(0, true, signedIn(adminMediaHandler)),
+            "POST", web.FormHandler(0, true, signedIn(adminUploadHandler))).
+        Register("/admin/media/<name:[0-9a-f]+\\.[a-z0-9]+>", "POST", web.FormHandler(10000, true, signedIn(adminMediaItemHandler))).
+        Register("/admin/preview/<kind:posts|pages>/<slug:[^/]+>", "POST", web.FormHandler(maxEditSize, true, signedIn(adminPreviewHandler))).
+        Register("/admin/<kind:posts|pages>/<slug:[^/]+>/history", "GET", web.FormHandler(0, true, signedIn(adminHistoryHandler))).
+        Register("/admin/<kind:posts|pages>/<slug:[^/]+>/history/<rev:[0-9a-f]{7,40}>", "GET", web.FormHandler(0, true, signedIn(adminRevisionHandler)),
+            "POST", web.FormHandler(10000, true, signedIn(adminRevertHandler))).
+        Register("/admin/<kind:posts|pages>/<slug:[^/]+>", "GET", web.FormHandler(0, true, signedIn(adminEditHandler)),
+            "POST", web.FormHandler(maxEditSize, true, signedIn(adminSaveHandler))).
+        Register("/admin/analytics", "GET", signedIn(adminAnalyticsHandler)).
+        Register("/admin/newsletter", "GET", web.FormHandler(0, true, signedIn(adminNewsletterHandler)),
+            "POST", web.FormHandler(10000, true, signedIn(adminNewsletterPostHandler))).
+        Register("/subscribe", "GET", web.FormHandler(0, true, web.HandlerFunc(subscribeHandler)),
+            "POST", web.FormHandler(10000, true, web.HandlerFunc(subscribePostHandler))).
+        Register("/subscribe/confirm", "GET", subscribeConfirmHandler).
+        Register("/unsubscribe", "GET", unsubscribeHandler,
+            "POST", web.FormHandler(10000, false, web.HandlerFunc(unsubscribePostHandler))).
+        Register("/webmention", "POST", web.FormHandler(10000, false, web.HandlerFunc(webmentionHandler))).
+        Register("/admin/comments", "GET", web.FormHandler(0, true, signedIn(commentQueueHandler))).
+        Register("/admin/comments/<id:[0-9a-f]+>", "POST", web.FormHandler(10000, true, signedIn(moderateCommentHandler))).
+        Register("/webmention/moderation", "GET", web.FormHandler(0, true, signedIn(moderationHandler))).
+        Register("/webmention/moderation/<id:[0-9a-f]+>", "POST", web.FormHandler(10000, true, signedIn(moderateHandler))).
+        Register("/webmention/moderation/reply", "POST", web.FormHandler(10000, true, signedIn(moderateReplyHandler))).
+        Register("/webmention/outgoing/<slug:[^/]+>", "GET", signedIn(outgoingHandler)).
+        Register("/archive/full"+formatPattern, "GET", fullArchiveHandler).
+        Register("/archive/category"+formatPattern, "GET", categoryArchiveHandler).
+        Register("/archive/month"+formatPattern, "GET", monthlyArchiveHandler).
+        Register("/<year:\\d{4}>/<month:\\d{2}>"+formatPattern, "GET", monthlyHandler).
+        Register("/category/<category:[^/]+?>"+formatPattern, "GET", categoryHandler).
+        Register("/<year:\\d{4}>/<month:\\d{2}>/<day:\\d{2}>/<slug:[^/]+?>/comments", "POST", web.FormHandler(maxCommentSize, false, web.HandlerFunc(commentHandler))).
+        Register("/<year:\\d{4}>/<month:\\d{2}>/<day:\\d{2}>/<slug:[^/]+?>"+formatPattern, "GET", permalinkHandler).
+        Register("/tag/<tag:[^/]+?>"+formatPattern, "GET", tagHandler).
+        Register("/<slug:\\w+>"+formatPattern, "GET", pageHandler).
+        Register("/media/<path:.*>", "GET", web.DirectoryHandler(library.Dir, staticOptions)).
+        Register("/<path:.*>", "GET", web.DirectoryHandler("public", staticOptions)).
+        Router
+}
 
-    var handler http.Handler = adapter.HTTPHandler{router}
-    handler = webutil.GzipHandler{handler}
-    handler = webutil.LoggerHandler{handler, logger}
-    handler = webutil.HerokuHandler{handler, logger}
-    handler = webutil.CanonicalHostHandler{handler, config.CanonicalHost, "http"}
+func SetupHandler() http.Handler {
+    var handler http.Handler = adapter.HTTPHandler{measured(Router())}
+    handler = gzipped(handler)
+    handler = countViews(handler)
+    handler = webutil.HerokuHandler{handler, logger.Std()}
+    handler = webutil.CanonicalHostHandler{handler, config.CanonicalHost, config.CanonicalScheme}
     handler = webutil.EnsureRequestBodyClosedHandler{handler}
     return handler
 }
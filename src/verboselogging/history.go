@@ -0,0 +1,187 @@
+package verboselogging
+
+import (
+    "config"
+    "content"
+    "fmt"
+    "gitstore"
+    "path/filepath"
+    "strings"
+    "sync"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+)
+
+// maxRevisions is how much history is shown for one post or page.
+const maxRevisions = 50
+
+var historyInit sync.Once
+
+// historyDir is the work tree posts and pages are committed from.
+var historyDir = "."
+
+// historyPath is path the way the history knows it, from historyDir.
+func historyPath(path string) string {
+    if rel, err := filepath.Rel(historyDir, path); err == nil {
+        return rel
+    }
+    return path
+}
+
+type historyPage struct {
+    Kind, Slug, Title, Path, EditPath, XSRF string
+    Revisions                               []*gitstore.Revision
+    // The rest is for looking at one revision.
+    Revision *gitstore.Revision
+    Diff     []gitstore.Line
+}
+
+// history is where edits get committed, or nil if they don't. It's the
+// repository the site is checked out from unless there's a git directory
+// set aside for it, and it's made if there isn't one yet. It only ever
+// lives here; nothing is pushed anywhere.
+func history() *gitstore.Repo {
+    if config.History != "yes" {
+        return nil
+    }
+    repo := &gitstore.Repo{Dir: historyDir, GitDir: config.HistoryGitDir}
+    historyInit.Do(func() {
+        if err := repo.Init(); err != nil {
+            logger.Error("failed setting up the history", "error", err)
+        }
+    })
+    return repo
+}
+
+// commitSource commits whatever happened to path. The edit's already been
+// made by now, so if this doesn't work it's only logged.
+func commitSource(path, editor, message string) {
+    h := history()
+    if h == nil {
+        return
+    }
+    if editor == "" {
+        editor = "anonymous"
+    }
+    email := editor + "@" + strings.Split(config.CanonicalHost, ":")[0]
+    if err := h.Commit(historyPath(path), gitstore.Editor{Name: editor, Email: email}, message); err != nil {
+        logger.Error("failed committing", "path", path, "error", err)
+    }
+}
+
+// loadHistory finds the source kind and slug in the URL mean, and its
+// revisions.
+func loadHistory(req *web.Request) (*historyPage, error) {
+    kind, slug := req.URLParam["kind"], req.URLParam["slug"]
+    source, ok := adminRepo(kind).Sources.Find(slug)
+    if !ok || history() == nil {
+        return nil, errNoSource
+    }
+    fm, _, err := content.ReadFile(source.Path)
+    if err != nil {
+        return nil, err
+    }
+    revisions, err := history().Log(historyPath(source.Path), maxRevisions)
+    if err != nil {
+        return nil, err
+    }
+    return &historyPage{
+        Kind:      kind,
+        Slug:      slug,
+        Title:     fm.Title,
+        Path:      historyPath(source.Path),
+        EditPath:  fmt.Sprintf("/admin/%s/%s", kind, slug),
+        XSRF:      req.Param.Get(web.XSRFParamName),
+        Revisions: revisions,
+    }, nil
+}
+
+func renderHistory(req *web.Request, h *historyPage) {
+    title := "History of " + h.Title
+    if h.Revision != nil {
+        title = fmt.Sprintf("%s as of %s", h.Title, h.Revision.Short())
+    }
+    view.RenderLayout(req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8"), &view.RenderInfo{
+        History:   h,
+        Title:     title,
+        PageTitle: title,
+    })
+}
+
+func adminHistoryHandler(req *web.Request) {
+    h, err := loadHistory(req)
+    if err == errNoSource {
+        notFound(req)
+        return
+    }
+    if err != nil {
+        logFor(req).Error("failed reading the history", "error", err)
+        serverError(req, err)
+        return
+    }
+    renderHistory(req, h)
+}
+
+// adminRevisionHandler shows what one commit changed.
+func adminRevisionHandler(req *web.Request) {
+    h, err := loadHistory(req)
+    if err == errNoSource {
+        notFound(req)
+        return
+    }
+    if err != nil {
+        logFor(req).Error("failed reading the history", "error", err)
+        serverError(req, err)
+        return
+    }
+    for _, revision := range h.Revisions {
+        if strings.HasPrefix(revision.Hash, req.URLParam["rev"]) {
+            h.Revision = revision
+            break
+        }
+    }
+    if h.Revision == nil {
+        notFound(req)
+        return
+    }
+    if h.Diff, err = history().Diff(h.Path, h.Revision.Hash); err != nil {
+        logFor(req).Error("failed reading the diff", "path", h.Path, "error", err)
+        serverError(req, err)
+        return
+    }
+    renderHistory(req, h)
+}
+
+// adminRevertHandler puts a source back the way it was as of a revision,
+// which is itself a new commit, so it can be undone too.
+func adminRevertHandler(req *web.Request) {
+    kind, slug, rev := req.URLParam["kind"], req.URLParam["slug"], req.URLParam["rev"]
+    repo := adminRepo(kind)
+    source, ok := repo.Sources.Find(slug)
+    if !ok || history() == nil {
+        notFound(req)
+        return
+    }
+    data, err := history().File(historyPath(source.Path), rev)
+    if err == gitstore.ErrBadRevision {
+        notFound(req)
+        return
+    }
+    if err != nil {
+        logFor(req).Error("failed reading an old revision", "path", source.Path, "rev", rev, "error", err)
+        serverError(req, err)
+        return
+    }
+    message := fmt.Sprintf("Revert %s to %.7s", historyPath(source.Path), rev)
+    if err := restoreSource(repo, source.Path, data, signedInUser(req), message); err != nil {
+        logFor(req).Error("failed reverting", "path", source.Path, "error", err)
+        serverError(req, err)
+        return
+    }
+    audit(req, "reverted", fmt.Sprintf("%s to %.7s", source.Path, rev))
+    // The slug might be different as of then.
+    if fm, _, err := content.ReadFile(source.Path); err == nil {
+        slug = fm.Slug()
+    }
+    req.Redirect(fmt.Sprintf("/admin/%s/%s?saved=1", kind, slug), false)
+}
@@ -0,0 +1,88 @@
+package verboselogging
+
+import (
+    "config"
+    "crypto/rand"
+    "encoding/hex"
+    "io"
+    "logging"
+    "sync/atomic"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+)
+
+const (
+    logKey   = "verboselogging.log"
+    errorKey = "verboselogging.error"
+)
+
+// notFounds is how many 404s there have been, for only logging some.
+var notFounds uint64
+
+// requestID is what Heroku's router called the request, or something made
+// up if it didn't say.
+func requestID(req *web.Request) string {
+    if id := req.Header.Get("X-Request-Id"); id != "" && len(id) <= 200 {
+        return id
+    }
+    b := make([]byte, 8)
+    if _, err := rand.Read(b); err != nil {
+        return "none"
+    }
+    return hex.EncodeToString(b)
+}
+
+// logFor is the logger for things that happen answering req, with its ID
+// and, once it's been routed, the route on every line.
+func logFor(req *web.Request) *logging.Logger {
+    l, ok := req.Env[logKey].(*logging.Logger)
+    if !ok {
+        l = logger
+    }
+    if route, ok := req.Env[routeKey].(string); ok {
+        l = l.With("route", route)
+    }
+    return l
+}
+
+// errorHandler answers like twister's own, but leaves the reason for the
+// request's log line instead of printing it.
+func errorHandler(req *web.Request, status int, reason error, header web.Header) {
+    if reason != nil {
+        req.Env[errorKey] = reason
+    }
+    header.Set(web.HeaderContentType, "text/plain; charset=utf-8")
+    io.WriteString(req.Responder.Respond(status, header), web.StatusText(status))
+}
+
+// logRequest writes the access log line for req. Most 404s are bots poking
+// around for things that were never here, so only one in LOG_404_SAMPLE of
+// them is logged, saying so.
+func logRequest(req *web.Request, status int, bytes int64, start time.Time) {
+    level := logging.Info
+    if status >= 500 {
+        level = logging.Error
+    }
+    fields := []interface{}{
+        "method", req.Method,
+        "path", req.URL.Path,
+        "status", status,
+        "bytes", bytes,
+        "duration", time.Since(start),
+        "remote_addr", remoteHost(req),
+        "user_agent", req.Header.Get(web.HeaderUserAgent),
+    }
+    if status == web.StatusNotFound && config.LogNotFound > 1 {
+        if (atomic.AddUint64(&notFounds, 1)-1)%uint64(config.LogNotFound) != 0 {
+            return
+        }
+        fields = append(fields, "sampled", config.LogNotFound)
+    }
+    if err, ok := req.Env[errorKey].(error); ok {
+        fields = append(fields, "error", err)
+    }
+    if _, ok := req.Env[routeKey]; !ok {
+        fields = append(fields, "route", "none")
+    }
+    logFor(req).Log(level, "request", fields...)
+}
@@ -0,0 +1,209 @@
+package verboselogging
+
+import (
+    "config"
+    "content"
+    "fmt"
+    "images"
+    "io"
+    "io/ioutil"
+    "media"
+    "net/url"
+    "path/filepath"
+    "sort"
+    "strings"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+)
+
+const mediaDir = "public/media"
+
+var library = openLibrary()
+
+func openLibrary() *media.Library {
+    lib, err := media.Open(mediaDir, filepath.Join(config.DataDir, "media.json"), int64(config.MaxUploadSize))
+    if err != nil {
+        panic(err)
+    }
+    return lib
+}
+
+// mediaItem is an item with what the admin needs to show and insert it.
+type mediaItem struct {
+    *media.Item
+    URL string
+    // Key is what it goes by in the images front matter.
+    Key string
+}
+
+type mediaLibrary struct {
+    XSRF, Error string
+    Items       []*mediaItem
+}
+
+func mediaItems() []*mediaItem {
+    var items []*mediaItem
+    for _, item := range library.List() {
+        items = append(items, &mediaItem{item, view.CanonicalURL("/media/" + item.Name), imageKey(item)})
+    }
+    return items
+}
+
+// imageKey makes a name for the images front matter out of what the file
+// was called, since that's what it'll be looked up by in templates.
+func imageKey(item *media.Item) string {
+    base := strings.TrimSuffix(item.Original, filepath.Ext(item.Original))
+    key := strings.Replace(content.Slugify(base), "-", "_", -1)
+    if key == "" || (key[0] >= '0' && key[0] <= '9') {
+        key = "image_" + strings.TrimSuffix(item.Name, filepath.Ext(item.Name))[:8]
+    }
+    return key
+}
+
+// readMultipart reads a multipart form, storing any files as it goes. Each
+// file shows up in the form as its URL. Alt text has to come before the
+// file to go with it.
+func readMultipart(req *web.Request, uploader string) (url.Values, error) {
+    m, err := web.NewMultipartReader(req, config.MaxUploadSize+maxMicropubSize)
+    if err != nil {
+        return nil, err
+    }
+    form := url.Values(req.Param)
+    for {
+        header, r, err := m.Next()
+        if err == io.EOF {
+            return form, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+        disp, params := header.GetValueParam(web.HeaderContentDisposition)
+        name := strings.TrimSuffix(params["name"], "[]")
+        if disp != "form-data" || name == "" {
+            continue
+        }
+        if params["filename"] == "" {
+            value, err := ioutil.ReadAll(io.LimitReader(r, maxMicropubSize))
+            if err != nil {
+                return nil, err
+            }
+            form.Add(name, string(value))
+            continue
+        }
+        contentType, _ := header.GetValueParam(web.HeaderContentType)
+        u, err := saveMedia(params["filename"], contentType, r, form.Get("alt"), uploader)
+        if err != nil {
+            return nil, err
+        }
+        form.Add(name, u)
+    }
+}
+
+// saveMedia puts an upload in the library and returns its URL.
+func saveMedia(filename, contentType string, r io.Reader, alt, uploader string) (string, error) {
+    item, err := library.Save(filename, contentType, r, alt, uploader)
+    if err != nil {
+        return "", err
+    }
+    return view.CanonicalURL("/media/" + item.Name), nil
+}
+
+func renderMedia(req *web.Request, status int, l *mediaLibrary) {
+    l.XSRF = req.Param.Get(web.XSRFParamName)
+    l.Items = mediaItems()
+    view.RenderLayout(req.Respond(status, web.HeaderContentType, "text/html; charset=utf-8"), &view.RenderInfo{
+        Media:     l,
+        Title:     "Media",
+        PageTitle: "Media",
+    })
+}
+
+func adminMediaHandler(req *web.Request) {
+    renderMedia(req, web.StatusOK, &mediaLibrary{})
+}
+
+// adminUploadHandler streams uploads into the library. The XSRF token comes
+// in the query, since twister doesn't read multipart forms for it.
+func adminUploadHandler(req *web.Request) {
+    form, err := readMultipart(req, signedInUser(req))
+    status := web.StatusBadRequest
+    switch {
+    case err == media.ErrType:
+        status = web.StatusUnsupportedMediaType
+    case err == media.ErrTooBig:
+        status = web.StatusRequestEntityTooLarge
+    case err == nil && len(form["file"]) == 0:
+        err = fmt.Errorf("there's no file")
+    }
+    if err != nil {
+        renderMedia(req, status, &mediaLibrary{Error: fmt.Sprintf("Couldn't upload that: %s.", err)})
+        return
+    }
+    audit(req, "uploaded", strings.Join(form["file"], " "))
+    req.Redirect("/admin/media", false)
+}
+
+// adminMediaItemHandler changes the alt text for an item, or deletes it.
+func adminMediaItemHandler(req *web.Request) {
+    name := req.URLParam["name"]
+    action := "described"
+    var err error
+    if req.Param.Get("action") == "delete" {
+        action = "deleted"
+        err = library.Delete(name)
+    } else {
+        err = library.SetAlt(name, strings.TrimSpace(req.Param.Get("alt")))
+    }
+    if err == media.ErrNotFound {
+        notFound(req)
+        return
+    }
+    if err != nil {
+        logFor(req).Error("failed changing media", "name", name, "error", err)
+        serverError(req, err)
+        return
+    }
+    audit(req, action, name)
+    req.Redirect("/admin/media", false)
+}
+
+// formatImages writes the images front matter one per line, as the name and
+// the original, for the editor.
+func formatImages(list map[string]map[string]string) string {
+    var names []string
+    for name := range list {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    var lines []string
+    for _, name := range names {
+        original := list[name]["original"]
+        if original == "" {
+            original = images.Largest(list[name])
+        }
+        lines = append(lines, name+" "+original)
+    }
+    return strings.Join(lines, "\n")
+}
+
+// parseImages reads what formatImages wrote, keeping the sizes of anything
+// that's still pointing at the same original.
+func parseImages(text string, existing map[string]map[string]string) map[string]map[string]string {
+    parsed := make(map[string]map[string]string)
+    for _, line := range strings.Split(text, "\n") {
+        fields := strings.Fields(line)
+        if len(fields) != 2 {
+            continue
+        }
+        name, original := fields[0], fields[1]
+        if sizes, ok := existing[name]; ok && (sizes["original"] == original || images.Largest(sizes) == original) {
+            parsed[name] = sizes
+        } else {
+            parsed[name] = map[string]string{"original": original}
+        }
+    }
+    if len(parsed) == 0 {
+        return nil
+    }
+    return parsed
+}
@@ -0,0 +1,410 @@
+package verboselogging
+
+import (
+    "bytes"
+    "config"
+    "content"
+    "crypto/subtle"
+    "fmt"
+    "media"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+    "xmlrpc"
+)
+
+// Fault codes, the same ones WordPress uses so clients know what they mean.
+const (
+    faultBadRequest = 400
+    faultForbidden  = 403
+    faultNotFound   = 404
+    faultServer     = 500
+    faultThrottled  = 429
+)
+
+// xmlrpcMethods are the MetaWeblog and Blogger methods, and where in the
+// params the user name is. The password comes right after it.
+var xmlrpcMethods = map[string]struct {
+    user int
+    call func(rpcParams) (interface{}, error)
+}{
+    "blogger.getUsersBlogs":     {1, getUsersBlogs},
+    "blogger.deletePost":        {2, deletePostById},
+    "metaWeblog.getCategories":  {1, getCategories},
+    "metaWeblog.getRecentPosts": {1, getRecentPosts},
+    "metaWeblog.getPost":        {1, getPost},
+    "metaWeblog.newPost":        {1, newPost},
+    "metaWeblog.editPost":       {1, editPost},
+    "metaWeblog.newMediaObject": {1, newMediaObject},
+}
+
+func fault(code int, format string, args ...interface{}) error {
+    return &xmlrpc.Fault{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// xmlrpcHandler answers MetaWeblog calls from desktop blogging clients.
+// With no password set there's no MetaWeblog at all.
+func xmlrpcHandler(req *web.Request) {
+    if config.BloggingPass == "" {
+        notFound(req)
+        return
+    }
+    body, err := req.BodyBytes(config.MaxUploadSize * 2)
+    if err != nil {
+        req.Error(web.StatusRequestEntityTooLarge, err)
+        return
+    }
+    w := req.Respond(web.StatusOK, web.HeaderContentType, "text/xml; charset=utf-8")
+    result, err := callXMLRPC(body, "addr:"+remoteHost(req))
+    if err != nil {
+        f, ok := err.(*xmlrpc.Fault)
+        if !ok {
+            logFor(req).Error("failed answering an XML-RPC call", "error", err)
+            f = &xmlrpc.Fault{Code: faultServer, Message: err.Error()}
+        }
+        xmlrpc.WriteFault(w, f)
+        return
+    }
+    if err := xmlrpc.WriteResponse(w, result); err != nil {
+        logFor(req).Error("failed writing an XML-RPC response", "error", err)
+    }
+}
+
+// callXMLRPC makes the call in body. Wrong passwords count against addr,
+// the same as signing in.
+func callXMLRPC(body []byte, addr string) (interface{}, error) {
+    name, params, err := xmlrpc.ParseCall(bytes.NewReader(body))
+    if err != nil {
+        return nil, fault(faultBadRequest, "%s", err)
+    }
+    method, ok := xmlrpcMethods[name]
+    if !ok {
+        return nil, fault(faultBadRequest, "%s isn't supported", name)
+    }
+    now := time.Now()
+    if loginThrottle.Wait(addr, now) > 0 {
+        return nil, fault(faultThrottled, "Too many wrong tries. Try again later.")
+    }
+    p := rpcParams(params)
+    user, pass := p.string(method.user), p.string(method.user+1)
+    if subtle.ConstantTimeCompare([]byte(user), []byte(config.BloggingUser)) != 1 ||
+        subtle.ConstantTimeCompare([]byte(pass), []byte(config.BloggingPass)) != 1 {
+        loginThrottle.Fail(addr, now)
+        return nil, fault(faultForbidden, "Incorrect user name or password.")
+    }
+    return method.call(p)
+}
+
+// rpcParams are forgiving about types, since clients are.
+type rpcParams []interface{}
+
+func (p rpcParams) get(i int) interface{} {
+    if i < len(p) {
+        return p[i]
+    }
+    return nil
+}
+
+func (p rpcParams) string(i int) string {
+    switch v := p.get(i).(type) {
+    case string:
+        return v
+    case int:
+        return strconv.Itoa(v)
+    }
+    return ""
+}
+
+func (p rpcParams) int(i int) int {
+    n, _ := strconv.Atoi(p.string(i))
+    return n
+}
+
+func (p rpcParams) bool(i int) bool {
+    b, _ := p.get(i).(bool)
+    return b
+}
+
+func (p rpcParams) strct(i int) map[string]interface{} {
+    s, _ := p.get(i).(map[string]interface{})
+    if s == nil {
+        s = make(map[string]interface{})
+    }
+    return s
+}
+
+func getUsersBlogs(p rpcParams) (interface{}, error) {
+    return []interface{}{map[string]interface{}{
+        "blogid":   "1",
+        "blogName": config.SiteTitle,
+        "url":      view.CanonicalURL("/"),
+        "xmlrpc":   view.CanonicalURL("/xmlrpc"),
+        "isAdmin":  true,
+    }}, nil
+}
+
+func getCategories(p rpcParams) (interface{}, error) {
+    var list []interface{}
+    for _, c := range categories() {
+        list = append(list, map[string]interface{}{
+            "categoryId":   c,
+            "categoryName": c,
+            "title":        c,
+            "description":  c,
+            "htmlUrl":      view.CanonicalURL("/category/" + c),
+            "rssUrl":       view.CanonicalURL("/feed"),
+        })
+    }
+    return list, nil
+}
+
+// postId is what clients know a post by: the id in its front matter, or
+// its slug for the few old posts without one.
+func postId(fm *content.FrontMatter) string {
+    if fm.Id > 0 {
+        return strconv.Itoa(fm.Id)
+    }
+    return fm.Slug()
+}
+
+func sourceById(postid string) (*content.Source, error) {
+    var (
+        source *content.Source
+        ok     bool
+    )
+    if id, err := strconv.Atoi(postid); err == nil {
+        source, ok = posts.Sources.FindById(id)
+    } else {
+        source, ok = posts.Sources.Find(postid)
+    }
+    if !ok {
+        return nil, fault(faultNotFound, "There's no post %s.", postid)
+    }
+    return source, nil
+}
+
+func getPost(p rpcParams) (interface{}, error) {
+    source, err := sourceById(p.string(0))
+    if err != nil {
+        return nil, err
+    }
+    fm, body, err := content.ReadFile(source.Path)
+    if err != nil {
+        return nil, err
+    }
+    return postStruct(fm, body), nil
+}
+
+type sourceFile struct {
+    fm   *content.FrontMatter
+    body string
+    t    time.Time
+}
+
+type byNewest []sourceFile
+
+func (b byNewest) Len() int           { return len(b) }
+func (b byNewest) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byNewest) Less(i, j int) bool { return b[i].t.After(b[j].t) }
+
+// getRecentPosts includes drafts, so the client can keep working on them.
+func getRecentPosts(p rpcParams) (interface{}, error) {
+    var files []sourceFile
+    for _, source := range posts.Sources.All() {
+        fm, body, err := content.ReadFile(source.Path)
+        if err != nil {
+            return nil, err
+        }
+        t, _ := fm.Time()
+        files = append(files, sourceFile{fm, body, t})
+    }
+    sort.Sort(byNewest(files))
+    n := p.int(3)
+    if n <= 0 || n > len(files) {
+        n = len(files)
+    }
+    list := []interface{}{}
+    for _, f := range files[:n] {
+        list = append(list, postStruct(f.fm, f.body))
+    }
+    return list, nil
+}
+
+func newPost(p rpcParams) (interface{}, error) {
+    fm := &content.FrontMatter{Author: config.SiteAuthor}
+    s := p.strct(3)
+    body := applyStruct(fm, s, p.bool(4), "")
+    slug := stringMember(s, "wp_slug")
+    if slug == "" {
+        slug = stringMember(s, "mt_basename")
+    }
+    if slug == "" {
+        slug = fm.Title
+    }
+    if err := createSource(posts, slug, fm, body, config.BloggingUser); err != nil {
+        return nil, err
+    }
+    logger.Info("created a post over XML-RPC", "slug", fm.Slug())
+    return postId(fm), nil
+}
+
+func editPost(p rpcParams) (interface{}, error) {
+    source, err := sourceById(p.string(0))
+    if err != nil {
+        return nil, err
+    }
+    fm, body, err := content.ReadFile(source.Path)
+    if err != nil {
+        return nil, err
+    }
+    body = applyStruct(fm, p.strct(3), p.bool(4), body)
+    if err := saveSource(posts, source.Path, fm, body, config.BloggingUser); err != nil {
+        return nil, err
+    }
+    logger.Info("updated a post over XML-RPC", "slug", fm.Slug())
+    return true, nil
+}
+
+func deletePostById(p rpcParams) (interface{}, error) {
+    source, err := sourceById(p.string(1))
+    if err != nil {
+        return nil, err
+    }
+    if err := removeSource(posts, source.Path, config.BloggingUser); err != nil {
+        return nil, err
+    }
+    logger.Info("deleted a post over XML-RPC", "path", source.Path)
+    return true, nil
+}
+
+func newMediaObject(p rpcParams) (interface{}, error) {
+    s := p.strct(3)
+    bits, _ := s["bits"].([]byte)
+    if len(bits) == 0 {
+        return nil, fault(faultBadRequest, "There's nothing in the file.")
+    }
+    u, err := saveMedia(stringMember(s, "name"), stringMember(s, "type"), bytes.NewReader(bits), "", config.BloggingUser)
+    if err == media.ErrType || err == media.ErrTooBig {
+        return nil, fault(faultBadRequest, "%s", err)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return map[string]interface{}{"url": u, "file": u, "type": stringMember(s, "type")}, nil
+}
+
+func stringMember(s map[string]interface{}, name string) string {
+    v, _ := s[name].(string)
+    return strings.TrimSpace(v)
+}
+
+// postStruct is a post the way MetaWeblog clients see it. The category is
+// the one category, and tags are keywords.
+func postStruct(fm *content.FrontMatter, body string) map[string]interface{} {
+    status := "publish"
+    if !fm.Published {
+        status = "draft"
+    }
+    t, _ := fm.Time()
+    category := []interface{}{}
+    if fm.Category != "" {
+        category = append(category, fm.Category)
+    }
+    return map[string]interface{}{
+        "postid":      postId(fm),
+        "userid":      "1",
+        "title":       fm.Title,
+        "description": strings.TrimRight(body, "\n"),
+        "mt_excerpt":  fm.Description,
+        "mt_keywords": strings.Join(fm.Tags, ", "),
+        "categories":  category,
+        "dateCreated": t,
+        "link":        sourceURL(fm),
+        "permaLink":   sourceURL(fm),
+        "wp_slug":     fm.Slug(),
+        "post_status": status,
+    }
+}
+
+// applyStruct fills in fm from what the client sent, and returns the new
+// body, or body if none was sent.
+// The first category is the category; any others are tags, along with the
+// keywords. Whatever's left out stays the way it was.
+func applyStruct(fm *content.FrontMatter, s map[string]interface{}, publish bool, body string) string {
+    fm.Published = publish
+    if title, ok := s["title"].(string); ok {
+        fm.Title = strings.TrimSpace(title)
+    }
+    if fm.Title == "" {
+        fm.Title = "Untitled"
+    }
+    if excerpt, ok := s["mt_excerpt"].(string); ok {
+        fm.Description = strings.TrimSpace(excerpt)
+    }
+
+    _, hasKeywords := s["mt_keywords"]
+    list, hasCategories := s["categories"].([]interface{})
+    if hasCategories || hasKeywords {
+        var tags []string
+        if hasCategories {
+            fm.Category = ""
+            for _, c := range list {
+                name, _ := c.(string)
+                if name == "" {
+                    continue
+                }
+                if fm.Category == "" {
+                    fm.Category = name
+                } else {
+                    tags = append(tags, name)
+                }
+            }
+        }
+        if hasKeywords {
+            for _, keyword := range strings.Split(stringMember(s, "mt_keywords"), ",") {
+                if keyword = strings.TrimSpace(keyword); keyword != "" {
+                    tags = append(tags, keyword)
+                }
+            }
+        } else {
+            tags = append(tags, fm.Tags...)
+        }
+        fm.Tags = uniqueStrings(tags)
+    }
+    if fm.Category == "" {
+        fm.Category = config.DefaultCategory
+    }
+
+    // Dates come without a zone, and everybody sends UTC.
+    if t, ok := s["date_created_gmt"].(time.Time); ok {
+        fm.SetTime(t)
+    } else if t, ok := s["dateCreated"].(time.Time); ok {
+        fm.SetTime(t)
+    } else if _, err := fm.Time(); err != nil {
+        fm.SetTime(time.Now())
+    }
+
+    if _, ok := s["description"]; !ok {
+        return body
+    }
+    body = strings.Replace(stringMember(s, "description"), "\r\n", "\n", -1)
+    if more := stringMember(s, "mt_text_more"); more != "" {
+        body += "\n\n" + strings.Replace(more, "\r\n", "\n", -1)
+    }
+    return body
+}
+
+func uniqueStrings(list []string) []string {
+    seen := make(map[string]bool)
+    var unique []string
+    for _, s := range list {
+        if !seen[s] {
+            seen[s] = true
+            unique = append(unique, s)
+        }
+    }
+    return unique
+}
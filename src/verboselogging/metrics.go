@@ -0,0 +1,167 @@
+package verboselogging
+
+import (
+    "config"
+    "crypto/subtle"
+    "github.com/darkhelmet/webutil"
+    "io"
+    "metrics"
+    "net/http"
+    "runtime"
+    "strconv"
+    "strings"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+)
+
+const routeKey = "verboselogging.route"
+
+var (
+    sizeBuckets  = []float64{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20}
+    ratioBuckets = []float64{.1, .2, .3, .4, .5, .6, .7, .8, .9, 1}
+
+    requestsTotal  = metrics.NewCounter("http_requests_total", "Requests, by route pattern, method and status.", "route", "method", "code")
+    requestSeconds = metrics.NewHistogram("http_request_duration_seconds", "Time spent answering requests, by route pattern and method.", metrics.DefaultBuckets, "route", "method")
+    responseBytes  = metrics.NewHistogram("http_response_size_bytes", "Response bodies before compression, by route pattern.", sizeBuckets, "route")
+    gzipInBytes    = metrics.NewCounter("http_gzip_in_bytes_total", "Bytes of responses that went into gzip.")
+    gzipOutBytes   = metrics.NewCounter("http_gzip_out_bytes_total", "Bytes that came out of gzip.")
+    gzipRatio      = metrics.NewHistogram("http_gzip_ratio", "Compressed size over uncompressed size, for each gzipped response.", ratioBuckets)
+    _              = metrics.NewGaugeFunc("go_goroutines", "Goroutines that exist right now.", func() float64 { return float64(runtime.NumGoroutine()) })
+    _              = metrics.NewGaugeFunc("go_memstats_alloc_bytes", "Bytes allocated and still in use.", allocBytes)
+)
+
+func allocBytes() float64 {
+    var m runtime.MemStats
+    runtime.ReadMemStats(&m)
+    return float64(m.Alloc)
+}
+
+// routes is a router that notes which pattern each request matched, so
+// requests can be counted by route and not by every path there is.
+type routes struct {
+    *web.Router
+}
+
+func (r routes) Register(pattern string, handlers ...interface{}) routes {
+    for i := 1; i < len(handlers); i += 2 {
+        handlers[i] = tagRoute(pattern, handlers[i])
+    }
+    r.Router.Register(pattern, handlers...)
+    return r
+}
+
+func tagRoute(pattern string, handler interface{}) interface{} {
+    var h web.Handler
+    switch handler := handler.(type) {
+    case web.Handler:
+        h = handler
+    case func(*web.Request):
+        h = web.HandlerFunc(handler)
+    default:
+        // Let the router say what's wrong.
+        return handler
+    }
+    return web.HandlerFunc(func(req *web.Request) {
+        req.Env[routeKey] = pattern
+        h.ServeWeb(req)
+    })
+}
+
+// measured counts, times and logs the requests handler answers. Requests
+// the router turned away itself, like ones with the wrong method, are
+// "none".
+func measured(handler web.Handler) web.Handler {
+    return web.HandlerFunc(func(req *web.Request) {
+        start := time.Now()
+        id := requestID(req)
+        req.Env[logKey] = logger.With("request_id", id)
+        m := &measuredResponder{Responder: req.Responder, status: web.StatusOK, id: id}
+        req.Responder = m
+        req.ErrorHandler = errorHandler
+        handler.ServeWeb(req)
+        logRequest(req, m.status, m.bytes, start)
+        route, _ := req.Env[routeKey].(string)
+        if route == "" {
+            route = "none"
+        }
+        requestsTotal.Inc(route, req.Method, strconv.Itoa(m.status))
+        requestSeconds.Since(start, route, req.Method)
+        responseBytes.Observe(float64(m.bytes), route)
+    })
+}
+
+type measuredResponder struct {
+    web.Responder
+    status int
+    bytes  int64
+    id     string
+}
+
+func (m *measuredResponder) Respond(status int, header web.Header) io.Writer {
+    m.status = status
+    if header == nil {
+        header = web.Header{}
+    }
+    header.Set("X-Request-Id", m.id)
+    return &countingWriter{m.Responder.Respond(status, header), &m.bytes}
+}
+
+type countingWriter struct {
+    io.Writer
+    n *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+    n, err := c.Writer.Write(p)
+    *c.n += int64(n)
+    return n, err
+}
+
+// gzipped is handler in webutil.GzipHandler, with the bytes on both sides
+// counted.
+func gzipped(handler http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var in, out int64
+        inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            handler.ServeHTTP(&countingResponseWriter{w, &in}, r)
+        })
+        cw := &countingResponseWriter{w, &out}
+        webutil.GzipHandler{inner}.ServeHTTP(cw, r)
+        if w.Header().Get("Content-Encoding") == "gzip" && in > 0 {
+            gzipInBytes.Add(float64(in))
+            gzipOutBytes.Add(float64(out))
+            gzipRatio.Observe(float64(out) / float64(in))
+        }
+    })
+}
+
+type countingResponseWriter struct {
+    http.ResponseWriter
+    n *int64
+}
+
+func (c *countingResponseWriter) Write(p []byte) (int, error) {
+    n, err := c.ResponseWriter.Write(p)
+    *c.n += int64(n)
+    return n, err
+}
+
+// metricsHandler is for Prometheus to scrape. With METRICS_TOKEN set, it
+// has to be sent as a bearer token.
+func metricsHandler(req *web.Request) {
+    if config.Metrics != "yes" {
+        notFound(req)
+        return
+    }
+    if token := config.MetricsToken; token != "" {
+        sent := strings.TrimPrefix(req.Header.Get(web.HeaderAuthorization), "Bearer ")
+        if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+            req.Respond(web.StatusUnauthorized, web.HeaderWWWAuthenticate, `Bearer realm="metrics"`)
+            return
+        }
+    }
+    w := req.Respond(web.StatusOK, web.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+    if _, err := metrics.Default.WriteTo(w); err != nil {
+        logFor(req).Error("failed writing metrics", "error", err)
+    }
+}
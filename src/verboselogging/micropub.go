@@ -0,0 +1,333 @@
+package verboselogging
+
+import (
+    "config"
+    "content"
+    "crypto/subtle"
+    "fmt"
+    "media"
+    "micropub"
+    "net/url"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+)
+
+const maxMicropubSize = 1 << 20
+
+var markup = regexp.MustCompile(`(?s)<[^>]*>`)
+
+// micropubError sends one of the errors from the spec.
+func micropubError(req *web.Request, status int, code, description string) {
+    w := req.Respond(status, web.HeaderContentType, "application/json; charset=utf-8")
+    writeJSON(w, &micropub.Error{Code: code, Description: description})
+}
+
+// micropubAuth checks for the token in the header or the form. With no
+// token set there's no Micropub at all. Wrong tokens count against the
+// address, the same as signing in.
+func micropubAuth(h web.HandlerFunc) web.Handler {
+    return web.HandlerFunc(func(req *web.Request) {
+        if config.MicropubToken == "" {
+            notFound(req)
+            return
+        }
+        token := req.Param.Get("access_token")
+        if auth := req.Header.Get(web.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+            token = auth[len("Bearer "):]
+        }
+        if token == "" {
+            req.Respond(web.StatusUnauthorized, web.HeaderWWWAuthenticate, `Bearer realm="Micropub"`)
+            return
+        }
+        now, byAddr := time.Now(), "addr:"+remoteHost(req)
+        if loginThrottle.Wait(byAddr, now) > 0 {
+            micropubError(req, statusTooManyRequests, "forbidden", "too many wrong tokens, try again later")
+            return
+        }
+        if subtle.ConstantTimeCompare([]byte(token), []byte(config.MicropubToken)) != 1 {
+            loginThrottle.Fail(byAddr, now)
+            micropubError(req, web.StatusForbidden, "forbidden", "that's not the token")
+            return
+        }
+        h(req)
+    })
+}
+
+func micropubQueryHandler(req *web.Request) {
+    switch req.Param.Get("q") {
+    case "config":
+        writeJSON(respondAs(req, "application/json; charset=utf-8"), &micropub.Config{
+            MediaEndpoint: view.CanonicalURL("/micropub/media"),
+            SyndicateTo:   []string{},
+            Categories:    categories(),
+        })
+    case "syndicate-to":
+        writeJSON(respondAs(req, "application/json; charset=utf-8"), map[string][]string{"syndicate-to": {}})
+    case "source":
+        source, ok := findSource(req.Param.Get("url"))
+        if !ok {
+            micropubError(req, web.StatusBadRequest, "invalid_request", "there's no post at that url")
+            return
+        }
+        fm, body, err := content.ReadFile(source.Path)
+        if err != nil {
+            logFor(req).Error("failed reading a source", "path", source.Path, "error", err)
+            serverError(req, err)
+            return
+        }
+        only := req.Param["properties[]"]
+        if only == nil {
+            only = req.Param["properties"]
+        }
+        writeJSON(respondAs(req, "application/json; charset=utf-8"), micropub.NewSource(toProperties(fm, body), only))
+    default:
+        micropubError(req, web.StatusBadRequest, "invalid_request", "q has to be config, source or syndicate-to")
+    }
+}
+
+func micropubHandler(req *web.Request) {
+    var (
+        r   *micropub.Request
+        err error
+    )
+    switch req.ContentType {
+    case "application/json":
+        var data []byte
+        if data, err = req.BodyBytes(maxMicropubSize); err == nil {
+            r, err = micropub.ParseJSON(data)
+        }
+    case "multipart/form-data":
+        var form url.Values
+        if form, err = readMultipart(req, "micropub"); err == nil {
+            r, err = micropub.ParseForm(form)
+        }
+    default:
+        r, err = micropub.ParseForm(url.Values(req.Param))
+    }
+    if err == media.ErrType {
+        micropubError(req, web.StatusUnsupportedMediaType, "invalid_request", err.Error())
+        return
+    }
+    if err == media.ErrTooBig {
+        micropubError(req, web.StatusRequestEntityTooLarge, "invalid_request", err.Error())
+        return
+    }
+    if err != nil {
+        micropubError(req, web.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    switch r.Action {
+    case micropub.ActionCreate:
+        createPost(req, r.Properties)
+    case micropub.ActionUpdate:
+        updatePost(req, r)
+    case micropub.ActionDelete:
+        deletePost(req, r.URL)
+    }
+}
+
+func createPost(req *web.Request, props micropub.Properties) {
+    fm := &content.FrontMatter{Author: config.SiteAuthor}
+    body := fromProperties(props, fm)
+    if err := createSource(posts, slugFor(props, fm), fm, body, "micropub"); err != nil {
+        logFor(req).Error("failed saving a post from micropub", "error", err)
+        serverError(req, err)
+        return
+    }
+    logFor(req).Info("created a post over micropub", "slug", fm.Slug())
+    req.Respond(web.StatusCreated, web.HeaderLocation, sourceURL(fm))
+}
+
+func updatePost(req *web.Request, r *micropub.Request) {
+    source, ok := findSource(r.URL)
+    if !ok {
+        micropubError(req, web.StatusBadRequest, "invalid_request", "there's no post at that url")
+        return
+    }
+    fm, body, err := content.ReadFile(source.Path)
+    if err != nil {
+        logFor(req).Error("failed reading a source", "path", source.Path, "error", err)
+        serverError(req, err)
+        return
+    }
+    props := toProperties(fm, body)
+    r.Apply(props)
+    body = fromProperties(props, fm)
+    if err := saveSource(posts, source.Path, fm, body, "micropub"); err != nil {
+        logFor(req).Error("failed saving a post from micropub", "path", source.Path, "error", err)
+        serverError(req, err)
+        return
+    }
+    logFor(req).Info("updated a post over micropub", "slug", fm.Slug())
+    // The date might have moved, and the permalink with it.
+    if location := sourceURL(fm); location != r.URL {
+        req.Respond(web.StatusCreated, web.HeaderLocation, location)
+        return
+    }
+    req.Respond(web.StatusNoContent)
+}
+
+func deletePost(req *web.Request, u string) {
+    source, ok := findSource(u)
+    if !ok {
+        micropubError(req, web.StatusBadRequest, "invalid_request", "there's no post at that url")
+        return
+    }
+    if err := removeSource(posts, source.Path, "micropub"); err != nil {
+        logFor(req).Error("failed deleting a post from micropub", "path", source.Path, "error", err)
+        serverError(req, err)
+        return
+    }
+    logFor(req).Info("deleted a post over micropub", "path", source.Path)
+    req.Respond(web.StatusNoContent)
+}
+
+// mediaHandler is the media endpoint, taking a single file.
+func mediaHandler(req *web.Request) {
+    form, err := readMultipart(req, "micropub")
+    switch {
+    case err == media.ErrType:
+        micropubError(req, web.StatusUnsupportedMediaType, "invalid_request", err.Error())
+    case err == media.ErrTooBig:
+        micropubError(req, web.StatusRequestEntityTooLarge, "invalid_request", err.Error())
+    case err != nil:
+        micropubError(req, web.StatusBadRequest, "invalid_request", err.Error())
+    case len(form["file"]) == 0:
+        micropubError(req, web.StatusBadRequest, "invalid_request", "there's no file")
+    default:
+        req.Respond(web.StatusCreated, web.HeaderLocation, form.Get("file"))
+    }
+}
+
+// findSource finds the post behind a permalink, published or not.
+func findSource(u string) (*content.Source, bool) {
+    parsed, err := url.Parse(u)
+    if err != nil || parsed.Host != config.CanonicalHost {
+        return nil, false
+    }
+    m := permalinkPath.FindStringSubmatch(parsed.Path)
+    if m == nil {
+        return nil, false
+    }
+    return posts.Sources.Find(m[4])
+}
+
+func sourceURL(fm *content.FrontMatter) string {
+    t, _ := fm.Time()
+    return view.CanonicalURL(fmt.Sprintf("/%s/%s", t.Format("2006/01/02"), fm.Slug()))
+}
+
+// categories are the ones posts are already in.
+func categories() []string {
+    all, _ := posts.All()
+    seen := make(map[string]bool)
+    var names []string
+    for _, p := range all {
+        if p.Category != "" && !seen[p.Category] {
+            seen[p.Category] = true
+            names = append(names, p.Category)
+        }
+    }
+    sort.Strings(names)
+    return names
+}
+
+// toProperties is a post the way Micropub sees it. The blog's category is
+// just the first of the categories.
+func toProperties(fm *content.FrontMatter, body string) micropub.Properties {
+    props := micropub.Properties{
+        "name":        {fm.Title},
+        "content":     {body},
+        "post-status": {"published"},
+        "url":         {sourceURL(fm)},
+    }
+    if !fm.Published {
+        props["post-status"] = []interface{}{"draft"}
+    }
+    if fm.Description != "" {
+        props["summary"] = []interface{}{fm.Description}
+    }
+    if t, err := fm.Time(); err == nil {
+        props["published"] = []interface{}{t.Format(time.RFC3339)}
+    }
+    var category []interface{}
+    if fm.Category != "" {
+        category = append(category, fm.Category)
+    }
+    for _, tag := range fm.Tags {
+        category = append(category, tag)
+    }
+    if len(category) > 0 {
+        props["category"] = category
+    }
+    return props
+}
+
+// fromProperties fills in fm from props, and returns the body. Anything
+// that's already a category is the category, and everything else is a
+// tag. Photos go at the end of the body.
+func fromProperties(props micropub.Properties, fm *content.FrontMatter) string {
+    body := strings.Replace(props.Get("content"), "\r\n", "\n", -1)
+    for _, photo := range props.Strings("photo") {
+        body = strings.TrimRight(body, "\n") + fmt.Sprintf("\n\n![](%s)\n", photo)
+    }
+
+    fm.Title = props.Get("name")
+    if fm.Title == "" {
+        fm.Title = titleFrom(body)
+    }
+    fm.Description = props.Get("summary")
+    fm.Published = props.Get("post-status") != "draft"
+
+    known := make(map[string]bool)
+    for _, c := range categories() {
+        known[c] = true
+    }
+    fm.Category, fm.Tags = "", nil
+    for _, c := range props.Strings("category") {
+        if fm.Category == "" && known[c] {
+            fm.Category = c
+        } else {
+            fm.Tags = append(fm.Tags, c)
+        }
+    }
+    if fm.Category == "" {
+        fm.Category = config.DefaultCategory
+    }
+
+    published, err := time.Parse(time.RFC3339, props.Get("published"))
+    if err != nil {
+        if _, err := fm.Time(); err == nil {
+            return body
+        }
+        published = time.Now()
+    }
+    fm.SetTime(published)
+    return body
+}
+
+// slugFor is what the slug for a new post is made from.
+func slugFor(props micropub.Properties, fm *content.FrontMatter) string {
+    if slug := props.Get("mp-slug"); slug != "" {
+        return slug
+    }
+    return fm.Title
+}
+
+// titleFrom makes a title for a post that came without one, out of its
+// first few words.
+func titleFrom(body string) string {
+    words := strings.Fields(markup.ReplaceAllString(body, ""))
+    if len(words) > 8 {
+        return strings.Join(words[:8], " ") + "…"
+    }
+    if len(words) == 0 {
+        return "Untitled"
+    }
+    return strings.Join(words, " ")
+}
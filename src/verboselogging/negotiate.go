@@ -0,0 +1,168 @@
+package verboselogging
+
+import (
+    "config"
+    "encoding/json"
+    "fmt"
+    "github.com/darkhelmet/blargh/post"
+    "io"
+    "io/ioutil"
+    "strconv"
+    "strings"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+)
+
+// format is one of the ways a page can go out.
+type format int
+
+const (
+    formatHTML format = iota
+    formatJSON
+    formatMarkdown
+    formatActivity
+)
+
+// formatPattern goes on the end of routes that can be asked for as
+// something other than HTML with a suffix.
+const formatPattern = "<format:(?:\\.json|\\.md)?>"
+
+var (
+    formatSuffixes = map[string]format{
+        ".json": formatJSON,
+        ".md":   formatMarkdown,
+    }
+    formatTypes = map[string]format{
+        "text/html":                 formatHTML,
+        "application/xhtml+xml":     formatHTML,
+        "text/*":                    formatHTML,
+        "*/*":                       formatHTML,
+        "application/json":          formatJSON,
+        "text/markdown":             formatMarkdown,
+        "text/x-markdown":           formatMarkdown,
+        "application/activity+json": formatActivity,
+        "application/ld+json":       formatActivity,
+    }
+)
+
+// negotiate picks a format. A suffix on the URL wins, then the best thing in
+// Accept we know how to make. Anything else gets HTML.
+func negotiate(req *web.Request) format {
+    if f, ok := formatSuffixes[req.URLParam["format"]]; ok {
+        return f
+    }
+    for _, accept := range req.Header.GetAccept(web.HeaderAccept) {
+        if q, ok := accept.Param["q"]; ok {
+            if quality, _ := strconv.ParseFloat(q, 64); quality <= 0 {
+                continue
+            }
+        }
+        if f, ok := formatTypes[strings.ToLower(accept.Value)]; ok {
+            return f
+        }
+    }
+    return formatHTML
+}
+
+// canonicalPath is the request path without a format suffix.
+func canonicalPath(req *web.Request) string {
+    return strings.TrimSuffix(req.URL.Path, req.URLParam["format"])
+}
+
+func respondAs(req *web.Request, contentType string) io.Writer {
+    return req.Respond(web.StatusOK,
+        web.HeaderContentType, contentType,
+        web.HeaderVary, web.HeaderAccept)
+}
+
+type jsonListing struct {
+    Title       string     `json:"title"`
+    Description string     `json:"description,omitempty"`
+    URL         string     `json:"url"`
+    Posts       []*apiPost `json:"posts"`
+}
+
+// respondListing sends a list of posts. info is only used for HTML, other
+// than its title and description, and the home page goes by the site's
+// title.
+func respondListing(req *web.Request, info *view.RenderInfo, posts []*post.Post) {
+    title := info.Title
+    if title == "" {
+        title = config.SiteTitle
+    }
+    switch negotiate(req) {
+    case formatJSON:
+        listing := &jsonListing{
+            Title:       title,
+            Description: info.Description,
+            URL:         view.CanonicalURL(canonicalPath(req)),
+            Posts:       []*apiPost{},
+        }
+        for _, p := range posts {
+            listing.Posts = append(listing.Posts, newAPIPost(p, false))
+        }
+        writeJSON(respondAs(req, "application/json; charset=utf-8"), listing)
+    case formatMarkdown:
+        w := respondAs(req, "text/markdown; charset=utf-8")
+        fmt.Fprintf(w, "# %s\n\n", title)
+        if info.Description != "" && info.Description != title {
+            fmt.Fprintf(w, "%s\n\n", info.Description)
+        }
+        for _, p := range posts {
+            fmt.Fprintf(w, "- [%s](%s)", p.Title, view.CanonicalURL(view.PostCanonical(p)))
+            if p.Description != "" {
+                fmt.Fprintf(w, ": %s", p.Description)
+            }
+            io.WriteString(w, "\n")
+        }
+    default:
+        view.RenderLayout(respondAs(req, "text/html; charset=utf-8"), info)
+    }
+}
+
+// respondPost sends a single post or page. Markdown is the source file as
+// written, front matter and all.
+func respondPost(req *web.Request, info *view.RenderInfo, p *post.Post) {
+    switch negotiate(req) {
+    case formatJSON:
+        var a *apiPost
+        if info.Page != nil {
+            a = newAPIPage(p, true)
+        } else {
+            a = newAPIPost(p, true)
+        }
+        writeJSON(respondAs(req, "application/json; charset=utf-8"), a)
+    case formatMarkdown:
+        var data []byte
+        sources := posts.Sources
+        if info.Page != nil {
+            sources = pages.Sources
+        }
+        if source, ok := sources.Find(p.Slug()); ok {
+            var err error
+            if data, err = ioutil.ReadFile(source.Path); err != nil {
+                logFor(req).Error("failed reading the source of a post", "slug", p.Slug(), "error", err)
+                data = nil
+            }
+        }
+        if data == nil {
+            req.Respond(web.StatusNotAcceptable, web.HeaderVary, web.HeaderAccept)
+            return
+        }
+        respondAs(req, "text/markdown; charset=utf-8").Write(data)
+    case formatActivity:
+        if info.Page != nil {
+            req.Respond(web.StatusNotAcceptable, web.HeaderVary, web.HeaderAccept)
+            return
+        }
+        respondActivity(req, article(p))
+    default:
+        view.RenderLayout(respondAs(req, "text/html; charset=utf-8"), info)
+    }
+}
+
+func writeJSON(w io.Writer, v interface{}) {
+    if err := json.NewEncoder(w).Encode(v); err != nil {
+        logger.Error("failed writing JSON", "error", err)
+    }
+}
@@ -0,0 +1,325 @@
+package verboselogging
+
+import (
+    "auth"
+    "config"
+    "fmt"
+    "github.com/darkhelmet/blargh/post"
+    "net/url"
+    "newsletter"
+    "path/filepath"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+)
+
+const (
+    // A confirmation link is good for a week, and an unsubscribe link for
+    // as long as anybody keeps the email.
+    confirmMaxAge     = 7 * 24 * time.Hour
+    unsubscribeMaxAge = 10 * 365 * 24 * time.Hour
+)
+
+var (
+    subscribers = openSubscribers()
+    sendLog     = openSendLog()
+    // Only sending the email to confirm counts, so nobody can use the form
+    // to flood somebody's inbox: three an hour to one email, and five an
+    // hour from one address that are sending again to somebody already sent
+    // to, so one office full of readers doesn't use up a whole hour.
+    confirmThrottle   = auth.NewThrottle(3, time.Hour)
+    subscribeThrottle = auth.NewThrottle(5, time.Hour)
+)
+
+func init() {
+    published.OnPublish(func(fresh []*post.Post) {
+        if subscribing() {
+            go sendDigest(fresh)
+        }
+    })
+}
+
+func openSubscribers() *newsletter.Store {
+    store, err := newsletter.Open(filepath.Join(config.DataDir, "subscribers.json"))
+    if err != nil {
+        panic(err)
+    }
+    return store
+}
+
+func openSendLog() *newsletter.Log {
+    l, err := newsletter.OpenLog(filepath.Join(config.DataDir, "sends.json"))
+    if err != nil {
+        panic(err)
+    }
+    return l
+}
+
+// subscribing tells whether there's a way to send email, and a secret to
+// sign the links in it.
+func subscribing() bool {
+    return config.SMTPAddr != "" && config.NewsletterKey != ""
+}
+
+func mailer() *newsletter.Mailer {
+    return &newsletter.Mailer{
+        Addr:     config.SMTPAddr,
+        From:     config.MailFrom,
+        Username: config.SMTPUser,
+        Password: config.SMTPPassword,
+    }
+}
+
+// tokenURL is a link to path that proves it was sent to email, for the
+// purpose context names.
+func tokenURL(path, context, email string, maxAge time.Duration) string {
+    token := web.SignValue(config.NewsletterKey, context, maxAge, email)
+    return view.CanonicalURL(path + "?token=" + url.QueryEscape(token))
+}
+
+type confirmEmail struct {
+    SiteTitle, Email, ConfirmURL string
+}
+
+type digestEmail struct {
+    SiteTitle      string
+    Posts          []*view.EmailPost
+    UnsubscribeURL string
+}
+
+// sendDigest emails the posts that just went up to everybody who's
+// confirmed, each with their own way to unsubscribe.
+func sendDigest(fresh []*post.Post) *newsletter.Send {
+    to := subscribers.Confirmed()
+    if len(fresh) == 0 || len(to) == 0 {
+        return nil
+    }
+    var items []*view.EmailPost
+    for _, p := range fresh {
+        items = append(items, &view.EmailPost{
+            Title:       p.Title,
+            URL:         view.CanonicalURL(view.PostCanonical(p)),
+            Description: p.Description,
+            PublishedOn: p.PublishedOn,
+        })
+    }
+    subject := fmt.Sprintf("New on %s: %s", config.SiteTitle, fresh[len(fresh)-1].Title)
+    if len(fresh) > 1 {
+        subject = fmt.Sprintf("%d new posts on %s", len(fresh), config.SiteTitle)
+    }
+    send := mailer().SendAll(sendLog, "digest", subject, to, func(email string) (*newsletter.Message, error) {
+        unsubscribe := tokenURL("/unsubscribe", "unsubscribe", email, unsubscribeMaxAge)
+        text, html, err := view.RenderEmail("digest", &digestEmail{config.SiteTitle, items, unsubscribe})
+        if err != nil {
+            return nil, err
+        }
+        return &newsletter.Message{To: email, Subject: subject, Text: text, HTML: html, Unsubscribe: unsubscribe}, nil
+    })
+    logger.Info("sent a digest", "subject", subject, "sent", send.Sent, "failed", send.Failed)
+    return send
+}
+
+// sendConfirmation asks email to say they really want posts.
+func sendConfirmation(email string) *newsletter.Send {
+    subject := "Confirm your subscription to " + config.SiteTitle
+    return mailer().SendAll(sendLog, "confirm", subject, []string{email}, func(email string) (*newsletter.Message, error) {
+        data := &confirmEmail{config.SiteTitle, email, tokenURL("/subscribe/confirm", "confirm", email, confirmMaxAge)}
+        text, html, err := view.RenderEmail("confirm", data)
+        if err != nil {
+            return nil, err
+        }
+        return &newsletter.Message{To: email, Subject: subject, Text: text, HTML: html}, nil
+    })
+}
+
+type subscribePage struct {
+    XSRF, Email, Token, Notice, Error string
+    // Unsubscribing is for asking whether they really want to.
+    Unsubscribing bool
+}
+
+func renderSubscribe(req *web.Request, status int, title string, s *subscribePage) {
+    view.RenderLayout(req.Respond(status, web.HeaderContentType, "text/html; charset=utf-8"), &view.RenderInfo{
+        Subscribe: s,
+        Title:     title,
+        PageTitle: title,
+    })
+}
+
+func subscribeHandler(req *web.Request) {
+    if !subscribing() {
+        notFound(req)
+        return
+    }
+    renderSubscribe(req, web.StatusOK, "Get new posts by email", &subscribePage{XSRF: req.Param.Get(web.XSRFParamName)})
+}
+
+func subscribePostHandler(req *web.Request) {
+    if !subscribing() {
+        notFound(req)
+        return
+    }
+    s := &subscribePage{XSRF: req.Param.Get(web.XSRFParamName), Email: req.Param.Get("email")}
+    title := "Get new posts by email"
+    email, err := newsletter.CleanEmail(s.Email)
+    if err != nil {
+        s.Error = "That doesn't look like an email address."
+        renderSubscribe(req, web.StatusBadRequest, title, s)
+        return
+    }
+    now := time.Now()
+    byAddr, byEmail := "addr:"+remoteHost(req), "email:"+email
+    if subscribeThrottle.Wait(byAddr, now) > 0 || confirmThrottle.Wait(byEmail, now) > 0 {
+        s.Error = "That's a few too many tries. Try again later."
+        renderSubscribe(req, statusTooManyRequests, title, s)
+        return
+    }
+
+    sub, err := subscribers.Subscribe(email, remoteHost(req))
+    if err != nil {
+        logFor(req).Error("failed subscribing", "error", err)
+        serverError(req, err)
+        return
+    }
+    // Somebody already confirmed gets told the same thing, so the form
+    // doesn't give away who's subscribed.
+    if sub.Status == newsletter.Pending {
+        if confirmThrottle.Failures(byEmail, now) > 0 {
+            subscribeThrottle.Fail(byAddr, now)
+        }
+        confirmThrottle.Fail(byEmail, now)
+        if send := sendConfirmation(email); send.Failed > 0 {
+            s.Error = "Couldn't send the email to confirm. Try again in a bit."
+            renderSubscribe(req, web.StatusInternalServerError, title, s)
+            return
+        }
+    }
+    renderSubscribe(req, web.StatusOK, "Check your email", &subscribePage{
+        Notice: "There's an email on its way to " + email + " with a link to confirm. Nothing will be sent until that link is followed.",
+    })
+}
+
+// verifyToken is who the token in the request was sent to.
+func verifyToken(req *web.Request, context string) (string, bool) {
+    email, err := web.VerifyValue(config.NewsletterKey, context, req.Param.Get("token"))
+    if err != nil {
+        renderSubscribe(req, web.StatusBadRequest, "Hmm", &subscribePage{Error: "That link has expired, or it isn't quite right."})
+        return "", false
+    }
+    return email, true
+}
+
+func subscribeConfirmHandler(req *web.Request) {
+    if !subscribing() {
+        notFound(req)
+        return
+    }
+    email, ok := verifyToken(req, "confirm")
+    if !ok {
+        return
+    }
+    // The link is proof enough, even if they unsubscribed in between.
+    _, err := subscribers.Subscribe(email, remoteHost(req))
+    if err == nil {
+        _, err = subscribers.Confirm(email)
+    }
+    if err != nil {
+        logFor(req).Error("failed confirming a subscriber", "error", err)
+        serverError(req, err)
+        return
+    }
+    renderSubscribe(req, web.StatusOK, "You're subscribed", &subscribePage{Notice: "New posts will be sent to " + email + "."})
+}
+
+// unsubscribeHandler asks first, since mail programs like to follow links
+// to see what's there.
+func unsubscribeHandler(req *web.Request) {
+    if !subscribing() {
+        notFound(req)
+        return
+    }
+    email, ok := verifyToken(req, "unsubscribe")
+    if !ok {
+        return
+    }
+    renderSubscribe(req, web.StatusOK, "Unsubscribe", &subscribePage{Email: email, Token: req.Param.Get("token"), Unsubscribing: true})
+}
+
+// unsubscribePostHandler is the button on the page, or a mail program's
+// one-click unsubscribe, so there's no XSRF token; the signed one is
+// enough.
+func unsubscribePostHandler(req *web.Request) {
+    if !subscribing() {
+        notFound(req)
+        return
+    }
+    email, ok := verifyToken(req, "unsubscribe")
+    if !ok {
+        return
+    }
+    if err := subscribers.Unsubscribe(email); err != nil {
+        logFor(req).Error("failed unsubscribing", "error", err)
+        serverError(req, err)
+        return
+    }
+    renderSubscribe(req, web.StatusOK, "You're unsubscribed", &subscribePage{Notice: "Nothing more will be sent to " + email + "."})
+}
+
+type newsletterAdmin struct {
+    XSRF, Notice string
+    Enabled      bool
+    Subscribers  []*newsletter.Subscriber
+    Confirmed    int
+    Sends        []*newsletter.Send
+}
+
+func renderNewsletterAdmin(req *web.Request, notice string) {
+    n := &newsletterAdmin{
+        XSRF:        req.Param.Get(web.XSRFParamName),
+        Notice:      notice,
+        Enabled:     subscribing(),
+        Subscribers: subscribers.List(),
+        Confirmed:   len(subscribers.Confirmed()),
+        Sends:       sendLog.Recent(50),
+    }
+    view.RenderLayout(req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8"), &view.RenderInfo{
+        Newsletter: n,
+        Title:      "Subscribers",
+        PageTitle:  "Subscribers",
+    })
+}
+
+func adminNewsletterHandler(req *web.Request) {
+    renderNewsletterAdmin(req, "")
+}
+
+// adminNewsletterPostHandler removes a subscriber, or sends the newest post
+// again, say after a send that failed.
+func adminNewsletterPostHandler(req *web.Request) {
+    switch req.Param.Get("action") {
+    case "remove":
+        email := req.Param.Get("email")
+        if err := subscribers.Unsubscribe(email); err != nil {
+            logFor(req).Error("failed removing a subscriber", "error", err)
+            serverError(req, err)
+            return
+        }
+        audit(req, "removed subscriber", email)
+        req.Redirect("/admin/newsletter", false)
+    case "send":
+        latest, err := posts.FindLatest(1)
+        if err != nil || len(latest) == 0 || !subscribing() {
+            renderNewsletterAdmin(req, "There's nothing to send, or no way to send it.")
+            return
+        }
+        send := sendDigest(latest)
+        if send == nil {
+            renderNewsletterAdmin(req, "Nobody's subscribed yet.")
+            return
+        }
+        audit(req, "sent digest", send.Subject)
+        renderNewsletterAdmin(req, fmt.Sprintf("Sent to %d, %d failed.", send.Sent, send.Failed))
+    default:
+        notFound(req)
+    }
+}
@@ -0,0 +1,119 @@
+package verboselogging
+
+import (
+    "config"
+    "fmt"
+    "github.com/darkhelmet/blargh/errors"
+    "github.com/darkhelmet/blargh/post"
+    "net/url"
+    "path/filepath"
+    "render"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+    "webmention"
+)
+
+var (
+    outbox = openOutbox()
+    sender = webmention.NewSender(outbox)
+)
+
+// With SEND_MENTIONS=yes mentions get sent as posts go up, and with
+// dry-run what would be sent just gets logged, once. Posts that were up
+// before never get anything sent, but once a post's links have gone out,
+// edits to it get kept up with.
+func init() {
+    switch config.SendMentions {
+    case "yes":
+        published.OnPublish(queueMentions)
+        posts.OnReload(requeueMentions)
+        go func() {
+            for {
+                sender.Deliver()
+                time.Sleep(time.Minute)
+            }
+        }()
+    case "dry-run":
+        published.OnPublish(func(fresh []*post.Post) { go dryRunMentions(fresh) })
+    }
+}
+
+func openOutbox() *webmention.Outbox {
+    o, err := webmention.OpenOutbox(filepath.Join(config.DataDir, "outbox.json"))
+    if err != nil {
+        panic(err)
+    }
+    return o
+}
+
+// outgoingLinks is where a post links to, other than here.
+func outgoingLinks(p *post.Post) (string, []string) {
+    source := view.CanonicalURL(view.PostCanonical(p))
+    base, _ := url.Parse(source)
+    return source, webmention.ExternalLinks(string(render.Post(p).HTML), base)
+}
+
+// queueMentions lines up the links from posts that just went up.
+func queueMentions(fresh []*post.Post) {
+    for _, p := range fresh {
+        source, links := outgoingLinks(p)
+        if err := sender.Queue(source, links); err != nil {
+            logger.Error("failed queueing mentions", "source", source, "error", err)
+        }
+    }
+}
+
+// requeueMentions catches links added to or taken out of posts that are
+// already in the outbox.
+func requeueMentions(r *Repo) {
+    all, err := r.FindLatest(r.Len())
+    if err != nil {
+        logger.Error("failed finding posts to send mentions for", "error", err)
+        return
+    }
+    sent := make(map[string]bool)
+    for _, source := range outbox.Sources() {
+        sent[source] = true
+    }
+    var edited []*post.Post
+    for _, p := range all {
+        if sent[view.CanonicalURL(view.PostCanonical(p))] {
+            edited = append(edited, p)
+        }
+    }
+    queueMentions(edited)
+}
+
+func dryRunMentions(fresh []*post.Post) {
+    for _, p := range fresh {
+        source, links := outgoingLinks(p)
+        for _, report := range sender.DryRun(source, links) {
+            logger.Info("dry run of sending mentions", "source", source, "report", report)
+        }
+    }
+}
+
+// outgoingHandler is the dry run report for a post: what's been sent and
+// what would be sent now.
+func outgoingHandler(req *web.Request) {
+    p, err := posts.FindBySlug(req.URLParam["slug"])
+    if err != nil {
+        switch err.(type) {
+        case errors.NotFound:
+            notFound(req)
+        default:
+            serverError(req, err)
+        }
+        return
+    }
+    source, links := outgoingLinks(p)
+    w := req.Respond(web.StatusOK, web.HeaderContentType, "text/plain; charset=utf-8")
+    fmt.Fprintf(w, "%s\n\n", source)
+    for _, report := range sender.DryRun(source, links) {
+        fmt.Fprintln(w, report)
+        if report.Error != "" && report.Attempts > 0 {
+            fmt.Fprintf(w, "    %d attempts, last error: %s\n", report.Attempts, report.Error)
+        }
+    }
+}
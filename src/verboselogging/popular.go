@@ -0,0 +1,76 @@
+package verboselogging
+
+import (
+    "time"
+    "view"
+)
+
+const (
+    popularCount   = 5
+    popularRefresh = 10 * time.Minute
+    // A view in the trending list counts half as much for every day old it
+    // is, and after a week it's not worth counting.
+    trendingHalfLife = 24 * time.Hour
+    trendingWindow   = 7 * 24 * time.Hour
+    mostReadWindow   = 30 * 24 * time.Hour
+)
+
+func init() {
+    refreshPopular()
+    posts.OnReload(func(*Repo) { refreshPopular() })
+    go func() {
+        for {
+            time.Sleep(popularRefresh)
+            refreshPopular()
+        }
+    }()
+}
+
+// refreshPopular works out the lists of posts in the footer again. Until
+// there are views to go by, it's the newest posts instead.
+func refreshPopular() {
+    now := time.Now()
+    isPost := func(path string) bool {
+        _, ok := postAt(path)
+        return ok
+    }
+    mostRead := &view.PostList{Name: "Most read this month"}
+    read, err := pageViews.Popular(now.Add(-mostReadWindow), now, popularCount, isPost)
+    if err != nil {
+        logger.Error("failed finding the most read posts", "error", err)
+    }
+    for _, page := range read {
+        if p, ok := postAt(page.Path); ok {
+            mostRead.Posts = append(mostRead.Posts, p)
+        }
+    }
+    trending := &view.PostList{Name: "Trending"}
+    trend, err := pageViews.Trending(now.Add(-trendingWindow), now, trendingHalfLife, popularCount, isPost)
+    if err != nil {
+        logger.Error("failed finding trending posts", "error", err)
+    }
+    for _, page := range trend {
+        if p, ok := postAt(page.Path); ok {
+            trending.Posts = append(trending.Posts, p)
+        }
+    }
+
+    switch {
+    case len(mostRead.Posts) == 0:
+        // Nothing's been read this month, so nothing's trending either, and
+        // one list of the newest posts is plenty.
+        mostRead, trending = newestPosts(), nil
+    case len(trending.Posts) == 0:
+        trending = newestPosts()
+    }
+    view.SetPopular(mostRead, trending)
+}
+
+func newestPosts() *view.PostList {
+    newest, err := posts.FindLatest(popularCount)
+    if err != nil {
+        logger.Error("failed finding the newest posts", "error", err)
+        return nil
+    }
+    return &view.PostList{Name: "Newest posts", Posts: newest}
+}
@@ -0,0 +1,71 @@
+package verboselogging
+
+import (
+    "github.com/darkhelmet/blargh/post"
+    "sync"
+    "time"
+)
+
+// publishGrace is how long before the server started a post can have gone
+// up and still count as new, which is about as long as a deploy takes.
+// Restarting that soon after a post goes up announces it again.
+const publishGrace = 15 * time.Minute
+
+// published notices posts going up, whether they were just added or their
+// time finally came, and tells whoever's listening. Nothing on disk outlives
+// a deploy on Heroku, so it goes by publishedon: posts dated after the
+// server started, or only just before, are new the first time they're seen
+// with their time past. That way deploying a post dated now counts.
+var published = &publisher{repo: posts, since: time.Now().Add(-publishGrace), seen: make(map[string]bool)}
+
+func init() {
+    posts.OnReload(func(*Repo) { published.check() })
+    go func() {
+        for {
+            time.Sleep(time.Minute)
+            published.check()
+        }
+    }()
+}
+
+type publisher struct {
+    repo  *Repo
+    lock  sync.Mutex
+    since time.Time
+    seen  map[string]bool
+    hooks []func([]*post.Post)
+}
+
+// OnPublish calls f with the posts that went up since last time, oldest
+// first.
+func (p *publisher) OnPublish(f func([]*post.Post)) {
+    p.lock.Lock()
+    p.hooks = append(p.hooks, f)
+    p.lock.Unlock()
+}
+
+func (p *publisher) check() {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    all, err := p.repo.FindLatest(p.repo.Len())
+    if err != nil {
+        logger.Error("failed finding published posts", "error", err)
+        return
+    }
+
+    var fresh []*post.Post
+    now := time.Now()
+    for i := len(all) - 1; i >= 0; i-- {
+        on, key := all[i].PublishedOn, all[i].Slug()
+        if on.After(p.since) && !on.After(now) && !p.seen[key] {
+            p.seen[key] = true
+            fresh = append(fresh, all[i])
+        }
+    }
+    if len(fresh) == 0 {
+        return
+    }
+    for _, hook := range p.hooks {
+        hook(fresh)
+    }
+}
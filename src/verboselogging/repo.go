@@ -1,15 +1,33 @@
 package verboselogging
 
 import (
+    "content"
     "fmt"
     "github.com/darkhelmet/blargh"
     "github.com/darkhelmet/blargh/errors"
     "github.com/darkhelmet/blargh/post"
+    "metrics"
+    "path/filepath"
+    "render"
+    "sync"
     "time"
 )
 
+var (
+    repoDocuments = metrics.NewGauge("blog_repo_documents", "Posts or pages loaded, by repo.", "repo")
+    repoReloads   = metrics.NewCounter("blog_repo_reloads_total", "Times a repo was read again, by repo and whether it worked.", "repo", "result")
+)
+
+// Repo is a blargh repo that can be read from disk again while the site is
+// running. Everything goes through the lock so a reload never shows up half
+// done.
 type Repo struct {
-    blargh.Repo
+    Dir     string
+    Sources *content.Index
+
+    lock     sync.RWMutex
+    repo     blargh.Repo
+    reloaded []func(*Repo)
 }
 
 func NewRepo(dir string) *Repo {
@@ -17,7 +35,89 @@ func NewRepo(dir string) *Repo {
     if err != nil {
         panic(err)
     }
-    return &Repo{repo}
+    sources, err := content.Load(dir)
+    if err != nil {
+        panic(err)
+    }
+    r := &Repo{Dir: dir, Sources: sources, repo: repo}
+    repoDocuments.Set(float64(repo.Len()), r.name())
+    return r
+}
+
+// name is what the repo's called in metrics, like posts.
+func (r *Repo) name() string {
+    return filepath.Base(r.Dir)
+}
+
+// Reload reads the directory again and swaps it in, then lets everyone
+// who asked with OnReload know. If anything can't be read, the old posts
+// stay put.
+func (r *Repo) Reload() error {
+    repo, err := blargh.NewFileRepo(r.Dir)
+    if err == nil {
+        err = r.Sources.Reload()
+    }
+    if err != nil {
+        repoReloads.Inc(r.name(), "error")
+        return err
+    }
+    repoReloads.Inc(r.name(), "ok")
+    repoDocuments.Set(float64(repo.Len()), r.name())
+    r.lock.Lock()
+    r.repo = repo
+    hooks := r.reloaded
+    r.lock.Unlock()
+    render.Flush()
+
+    logger.Info("reloaded", "repo", r.name(), "documents", repo.Len())
+    for _, hook := range hooks {
+        hook(r)
+    }
+    return nil
+}
+
+// OnReload calls f after every reload.
+func (r *Repo) OnReload(f func(*Repo)) {
+    r.lock.Lock()
+    r.reloaded = append(r.reloaded, f)
+    r.lock.Unlock()
+}
+
+// Reload reloads the posts and the pages.
+func Reload() error {
+    for _, repo := range []*Repo{posts, pages} {
+        if err := repo.Reload(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (r *Repo) current() blargh.Repo {
+    r.lock.RLock()
+    defer r.lock.RUnlock()
+    return r.repo
+}
+
+func (r *Repo) Len() int                   { return r.current().Len() }
+func (r *Repo) All() ([]*post.Post, error) { return r.current().All() }
+func (r *Repo) FindLatest(n int) ([]*post.Post, error) {
+    return r.current().FindLatest(n)
+}
+func (r *Repo) FindBySlug(slug string) (*post.Post, error) {
+    return r.current().FindBySlug(slug)
+}
+func (r *Repo) FindByCategory(c string) ([]*post.Post, error) {
+    return r.current().FindByCategory(c)
+}
+func (r *Repo) FindByTag(t string) ([]*post.Post, error) {
+    return r.current().FindByTag(t)
+}
+func (r *Repo) FindByMonth(y int, m time.Month) ([]*post.Post, error) {
+    return r.current().FindByMonth(y, m)
+}
+func (r *Repo) Search(q string) ([]*post.Post, error) {
+    return r.current().Search(q)
 }
 
 func (r *Repo) FindByPermalink(year int, month time.Month, day int, slug string) (*post.Post, error) {
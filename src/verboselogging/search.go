@@ -0,0 +1,127 @@
+package verboselogging
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+)
+
+const suggestionLimit = 10
+
+// Suggestion is a completion for the browser's search box.
+type Suggestion struct {
+    Text, Description, URL string
+}
+
+// Suggest completes a search from post titles and tags. Titles that start
+// with the query come first, then tags, then titles with a word that starts
+// with it.
+func (r *Repo) Suggest(query string, limit int) ([]Suggestion, error) {
+    query = strings.ToLower(strings.TrimSpace(query))
+    if query == "" {
+        return nil, nil
+    }
+    all, err := r.FindLatest(r.Len())
+    if err != nil {
+        return nil, err
+    }
+
+    var prefixed, tagged, worded []Suggestion
+    seen := make(map[string]bool)
+    add := func(list *[]Suggestion, s Suggestion) {
+        if key := strings.ToLower(s.Text); !seen[key] {
+            seen[key] = true
+            *list = append(*list, s)
+        }
+    }
+
+    counts := make(map[string]int)
+    for _, p := range all {
+        title := strings.ToLower(p.Title)
+        if strings.HasPrefix(title, query) {
+            add(&prefixed, Suggestion{p.Title, p.Description, view.CanonicalURL(view.PostCanonical(p))})
+        }
+        for _, tag := range p.Tags {
+            if strings.HasPrefix(strings.ToLower(tag), query) {
+                counts[tag]++
+            }
+        }
+    }
+
+    tags := byCount{counts: counts}
+    for tag := range counts {
+        tags.tags = append(tags.tags, tag)
+    }
+    sort.Sort(tags)
+    for _, tag := range tags.tags {
+        description := fmt.Sprintf("Articles tagged with %#v (%d)", tag, counts[tag])
+        add(&tagged, Suggestion{tag, description, view.CanonicalURL(view.TaxonomyPath("tag", tag))})
+    }
+
+    for _, p := range all {
+        for n, word := range strings.Fields(strings.ToLower(p.Title)) {
+            if n > 0 && strings.HasPrefix(word, query) {
+                add(&worded, Suggestion{p.Title, p.Description, view.CanonicalURL(view.PostCanonical(p))})
+                break
+            }
+        }
+    }
+
+    suggestions := append(append(prefixed, tagged...), worded...)
+    if len(suggestions) > limit {
+        suggestions = suggestions[:limit]
+    }
+    return suggestions, nil
+}
+
+// byCount puts the most used tags first, alphabetically when it's a tie.
+type byCount struct {
+    tags   []string
+    counts map[string]int
+}
+
+func (b byCount) Len() int      { return len(b.tags) }
+func (b byCount) Swap(i, j int) { b.tags[i], b.tags[j] = b.tags[j], b.tags[i] }
+func (b byCount) Less(i, j int) bool {
+    if b.counts[b.tags[i]] != b.counts[b.tags[j]] {
+        return b.counts[b.tags[i]] > b.counts[b.tags[j]]
+    }
+    return b.tags[i] < b.tags[j]
+}
+
+// suggestHandler speaks the OpenSearch suggestions format: the query, then
+// the completions, their descriptions and where they go.
+func suggestHandler(req *web.Request) {
+    query := searchQuery(req)
+    suggestions, err := posts.Suggest(query, suggestionLimit)
+    if err != nil {
+        logFor(req).Error("failed suggesting", "query", query, "error", err)
+        serverError(req, err)
+        return
+    }
+
+    texts := make([]string, len(suggestions))
+    descriptions := make([]string, len(suggestions))
+    urls := make([]string, len(suggestions))
+    for n, s := range suggestions {
+        texts[n], descriptions[n], urls[n] = s.Text, s.Description, s.URL
+    }
+    w := req.Respond(web.StatusOK,
+        web.HeaderContentType, "application/x-suggestions+json; charset=utf-8",
+        web.HeaderCacheControl, "public, max-age=3600")
+    if err := json.NewEncoder(w).Encode([]interface{}{query, texts, descriptions, urls}); err != nil {
+        logFor(req).Error("failed writing suggestions", "error", err)
+    }
+}
+
+// searchQuery is the q parameter, which is what the search form and
+// OpenSearch both send. Old links used query.
+func searchQuery(req *web.Request) string {
+    if q := req.Param.Get("q"); q != "" {
+        return q
+    }
+    return req.Param.Get("query")
+}
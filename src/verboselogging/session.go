@@ -0,0 +1,251 @@
+package verboselogging
+
+import (
+    "auth"
+    "bufio"
+    "config"
+    "errors"
+    "fmt"
+    "logging"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+)
+
+const (
+    sessionCookie = "session"
+    sessionMaxAge = 7 * 24 * time.Hour
+    // userKey is where the signed in user goes in the request Env.
+    userKey               = "verboselogging.user"
+    statusTooManyRequests = 429
+)
+
+var (
+    // Five wrong tries in fifteen minutes from one address and that's it for
+    // a while.
+    loginThrottle = auth.NewThrottle(5, 15*time.Minute)
+    // Wrong tries for one user only slow the next ones down, since anybody
+    // can run those up for any name and the user still has to get in.
+    userThrottle = auth.NewThrottle(5, 15*time.Minute)
+    auditLock    sync.Mutex
+)
+
+const maxLoginDelay = 8 * time.Second
+
+type login struct {
+    User, Next, XSRF, Error string
+}
+
+type auditEntry struct {
+    Time, User, Addr, Action, Detail string
+}
+
+// adminUsers are read from the config every time, which is cheap enough.
+func adminUsers() auth.Users {
+    users, err := auth.ParseUsers(config.AdminUsers)
+    if err != nil {
+        logger.Error("failed reading the admin users", "error", err)
+        return nil
+    }
+    return users
+}
+
+// signingIn tells whether anybody can sign in at all.
+func signingIn() bool {
+    return config.SessionSecret != "" && len(adminUsers()) > 0
+}
+
+// sessionUser is who the session cookie says is signed in, if anybody.
+func sessionUser(req *web.Request) string {
+    name, err := web.VerifyValue(config.SessionSecret, sessionCookie, req.Cookie.Get(sessionCookie))
+    if err != nil {
+        return ""
+    }
+    if _, ok := adminUsers()[name]; !ok {
+        return ""
+    }
+    return name
+}
+
+// sessionCookieValue is the Set-Cookie for a session. Twister doesn't know
+// about SameSite, so that's tacked on.
+func sessionCookieValue(c *web.Cookie) string {
+    return c.Secure(config.SecureCookies == "yes").String() + "; SameSite=Lax"
+}
+
+// signedIn guards h so only somebody signed in gets to it. Anybody else
+// gets sent to sign in, or turned away if they're posting. With nobody to
+// sign in there's no admin at all.
+func signedIn(h web.HandlerFunc) web.Handler {
+    return web.HandlerFunc(func(req *web.Request) {
+        if !signingIn() {
+            notFound(req)
+            return
+        }
+        name := sessionUser(req)
+        if name == "" {
+            if req.Method == "GET" {
+                req.Redirect("/login?next="+url.QueryEscape(req.URL.RequestURI()), false)
+            } else {
+                req.Error(web.StatusForbidden, errors.New("not signed in"))
+            }
+            return
+        }
+        req.Env[userKey] = name
+        h(req)
+    })
+}
+
+// signedInUser is who signedIn let through.
+func signedInUser(req *web.Request) string {
+    name, _ := req.Env[userKey].(string)
+    return name
+}
+
+// safeNext only goes somewhere on this site.
+func safeNext(next string) string {
+    if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+        return "/admin"
+    }
+    return next
+}
+
+// remoteHost is the address of whoever's asking, not Heroku's router.
+func remoteHost(req *web.Request) string {
+    return logging.ClientAddr(req.Header.Get("X-Forwarded-For"), req.RemoteAddr)
+}
+
+// loginDelay is how long the next try for a user waits, given how many wrong
+// ones there have been lately: nothing for the first few, then a second,
+// doubling up to maxLoginDelay.
+func loginDelay(failures int) time.Duration {
+    if failures < 5 {
+        return 0
+    }
+    if failures >= 8 {
+        return maxLoginDelay
+    }
+    return time.Second << uint(failures-5)
+}
+
+func renderLogin(req *web.Request, status int, l *login) {
+    view.RenderLayout(req.Respond(status, web.HeaderContentType, "text/html; charset=utf-8"), &view.RenderInfo{
+        Login:     l,
+        Title:     "Sign in",
+        PageTitle: "Sign in",
+    })
+}
+
+func loginHandler(req *web.Request) {
+    if !signingIn() {
+        notFound(req)
+        return
+    }
+    next := safeNext(req.Param.Get("next"))
+    if sessionUser(req) != "" {
+        req.Redirect(next, false)
+        return
+    }
+    renderLogin(req, web.StatusOK, &login{Next: next, XSRF: req.Param.Get(web.XSRFParamName)})
+}
+
+func loginPostHandler(req *web.Request) {
+    if !signingIn() {
+        notFound(req)
+        return
+    }
+    now := time.Now()
+    l := &login{
+        User: strings.TrimSpace(req.Param.Get("user")),
+        Next: safeNext(req.Param.Get("next")),
+        XSRF: req.Param.Get(web.XSRFParamName),
+    }
+    req.Env[userKey] = l.User
+    byAddr, byUser := "addr:"+remoteHost(req), "user:"+l.User
+    if wait := loginThrottle.Wait(byAddr, now); wait > 0 {
+        audit(req, "throttled login", byAddr)
+        l.Error = fmt.Sprintf("Too many wrong tries. Try again in %d minutes.", int(wait/time.Minute)+1)
+        renderLogin(req, statusTooManyRequests, l)
+        return
+    }
+    time.Sleep(loginDelay(userThrottle.Failures(byUser, now)))
+    u, err := adminUsers().Authenticate(l.User, req.Param.Get("password"), req.Param.Get("code"), now)
+    if err != nil {
+        loginThrottle.Fail(byAddr, now)
+        userThrottle.Fail(byUser, now)
+        audit(req, "failed login", "")
+        l.Error = "That's not the right user name, password or code."
+        renderLogin(req, web.StatusForbidden, l)
+        return
+    }
+    loginThrottle.Reset(byAddr)
+    userThrottle.Reset(byUser)
+    audit(req, "login", "")
+    value := web.SignValue(config.SessionSecret, sessionCookie, sessionMaxAge, u.Name)
+    req.Redirect(l.Next, false, web.HeaderSetCookie, sessionCookieValue(web.NewCookie(sessionCookie, value).MaxAge(sessionMaxAge)))
+}
+
+func logoutHandler(req *web.Request) {
+    audit(req, "logout", "")
+    req.Redirect("/", false, web.HeaderSetCookie, sessionCookieValue(web.NewCookie(sessionCookie, "").Delete().HTTPOnly(true)))
+}
+
+func auditPath() string {
+    return filepath.Join(config.DataDir, "audit.log")
+}
+
+// audit writes down who did what from where, one line per action.
+func audit(req *web.Request, action, detail string) {
+    fields := []string{time.Now().UTC().Format(time.RFC3339), signedInUser(req), remoteHost(req), action, detail}
+    for i, field := range fields {
+        fields[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(field)
+    }
+    logFor(req).Info("audit", "user", fields[1], "action", action, "detail", detail)
+
+    auditLock.Lock()
+    defer auditLock.Unlock()
+    f, err := os.OpenFile(auditPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+    if err != nil {
+        logFor(req).Error("failed opening the audit log", "error", err)
+        return
+    }
+    defer f.Close()
+    if _, err := fmt.Fprintln(f, strings.Join(fields, "\t")); err != nil {
+        logFor(req).Error("failed writing the audit log", "error", err)
+    }
+}
+
+// recentAudit is the last n entries in the audit log, newest first.
+func recentAudit(n int) ([]*auditEntry, error) {
+    auditLock.Lock()
+    defer auditLock.Unlock()
+    f, err := os.Open(auditPath())
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    var entries []*auditEntry
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        fields := strings.Split(scanner.Text(), "\t")
+        if len(fields) != 5 {
+            continue
+        }
+        entries = append(entries, &auditEntry{fields[0], fields[1], fields[2], fields[3], fields[4]})
+        if len(entries) > n {
+            entries = entries[1:]
+        }
+    }
+    for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+        entries[i], entries[j] = entries[j], entries[i]
+    }
+    return entries, scanner.Err()
+}
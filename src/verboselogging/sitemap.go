@@ -0,0 +1,165 @@
+package verboselogging
+
+import (
+    "fmt"
+    "github.com/darkhelmet/blargh/post"
+    "images"
+    "render"
+    "sitemap"
+    "sort"
+    "strings"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+)
+
+// Every child sitemap, in the order they show up in the index, and what
+// it's made from. Each one was last modified when the newest of those was.
+var sitemaps = []struct {
+    Name  string
+    Posts func() ([]*post.Post, error)
+    Build func([]*post.Post) *sitemap.URLSet
+}{
+    {"posts", latestPosts, postsSitemap},
+    {"pages", pages.All, pagesSitemap},
+    {"taxonomies", latestPosts, taxonomiesSitemap},
+}
+
+func latestPosts() ([]*post.Post, error) {
+    return posts.FindLatest(posts.Len())
+}
+
+func lastModified(p *post.Post) time.Time {
+    if p.UpdatedAt.After(p.PublishedOn) {
+        return p.UpdatedAt
+    }
+    return p.PublishedOn
+}
+
+func newest(all []*post.Post) time.Time {
+    var t time.Time
+    for _, p := range all {
+        if modified := lastModified(p); modified.After(t) {
+            t = modified
+        }
+    }
+    return t
+}
+
+func absolute(url string) string {
+    if strings.HasPrefix(url, "/") && !strings.HasPrefix(url, "//") {
+        return view.CanonicalURL(url)
+    }
+    return url
+}
+
+// addImages lists every image from the images front matter, biggest size
+// only.
+func addImages(url *sitemap.URL, doc *render.Document) {
+    names := make([]string, 0, len(doc.Images))
+    for name := range doc.Images {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        if loc := images.Largest(doc.Images[name]); loc != "" {
+            url.AddImage(absolute(loc))
+        }
+    }
+}
+
+func postsSitemap(all []*post.Post) *sitemap.URLSet {
+    urls := sitemap.NewURLSet()
+    urls.Add(view.CanonicalURL("/"), newest(all), "daily", 0.5)
+    for _, p := range all {
+        addImages(urls.Add(view.CanonicalURL(view.PostCanonical(p)), lastModified(p), "monthly", 1.0), render.Post(p))
+    }
+    return urls
+}
+
+func pagesSitemap(all []*post.Post) *sitemap.URLSet {
+    urls := sitemap.NewURLSet()
+    for _, p := range all {
+        addImages(urls.Add(view.CanonicalURL(view.PageCanonical(p)), lastModified(p), "yearly", 0.3), render.Page(p))
+    }
+    return urls
+}
+
+// taxonomiesSitemap has the archives plus a listing for every category, tag
+// and month, each last modified when its newest post was.
+func taxonomiesSitemap(all []*post.Post) *sitemap.URLSet {
+    var paths []string
+    modified := make(map[string]time.Time)
+    touch := func(path string, t time.Time) {
+        if _, ok := modified[path]; !ok {
+            paths = append(paths, path)
+        }
+        if t.After(modified[path]) {
+            modified[path] = t
+        }
+    }
+    for _, p := range all {
+        t := lastModified(p)
+        touch("/archive/full", t)
+        touch("/archive/category", t)
+        touch("/archive/month", t)
+        touch(view.TaxonomyPath("category", p.Category), t)
+        for _, tag := range p.Tags {
+            touch(view.TaxonomyPath("tag", tag), t)
+        }
+        touch(p.PublishedOn.Format("/2006/01"), t)
+    }
+
+    urls := sitemap.NewURLSet()
+    for _, path := range paths {
+        urls.Add(view.CanonicalURL(path), modified[path], "weekly", 0.4)
+    }
+    return urls
+}
+
+func writeSitemap(req *web.Request, v interface{}) {
+    compress := req.URLParam["gzip"] != ""
+    contentType := "application/xml; charset=utf-8"
+    if compress {
+        contentType = "application/x-gzip"
+    }
+    w := req.Respond(web.StatusOK, web.HeaderContentType, contentType)
+    if err := sitemap.Write(w, v, compress); err != nil {
+        logFor(req).Error("failed writing sitemap", "error", err)
+    }
+}
+
+// sitemapHandler serves the index. The children are linked with the same
+// extension the index was asked for, so the .gz index points at .gz files.
+// None of them get built, just their posts looked at.
+func sitemapHandler(req *web.Request) {
+    index := sitemap.NewIndex()
+    for _, s := range sitemaps {
+        all, err := s.Posts()
+        if err != nil {
+            logFor(req).Error("failed finding posts for sitemap", "sitemap", s.Name, "error", err)
+            serverError(req, err)
+            return
+        }
+        index.Add(view.CanonicalURL(fmt.Sprintf("/sitemap-%s.xml%s", s.Name, req.URLParam["gzip"])), newest(all))
+    }
+    writeSitemap(req, index)
+}
+
+func childSitemapHandler(req *web.Request) {
+    name := req.URLParam["name"]
+    for _, s := range sitemaps {
+        if s.Name != name {
+            continue
+        }
+        all, err := s.Posts()
+        if err != nil {
+            logFor(req).Error("failed finding posts for sitemap", "sitemap", name, "error", err)
+            serverError(req, err)
+            return
+        }
+        writeSitemap(req, s.Build(all))
+        return
+    }
+    notFound(req)
+}
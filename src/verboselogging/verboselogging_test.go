@@ -1,17 +1,87 @@
 package verboselogging_test
 
 import (
+    "activitypub"
+    "auth"
+    "bytes"
+    "config"
+    "encoding/json"
+    "fmt"
+    "html"
+    "image"
+    "image/png"
+    "io"
+    "io/ioutil"
     . "launchpad.net/gocheck"
+    "logging"
+    "mime/multipart"
+    "mime/quotedprintable"
+    "net/http"
+    "net/http/httptest"
+    "net/mail"
+    "net/textproto"
+    "net/url"
+    "os"
+    "path/filepath"
+    "regexp"
+    "render"
+    "smtptest"
+    "strconv"
+    "strings"
     "testing"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
     VL "verboselogging"
 )
 
 func Test(t *testing.T) { TestingT(t) }
 
-type TestSuite struct{}
+type TestSuite struct {
+    // root is where the site reads and writes while testing, with copies
+    // of the posts and pages.
+    root string
+}
 
 var _ = Suite(&TestSuite{})
 
+// SetUpSuite keeps everything the tests write, edits and their history
+// included, out of the real posts, pages, uploads and data.
+func (ts *TestSuite) SetUpSuite(c *C) {
+    // Like on Heroku, the router says who asked.
+    config.TrustProxy = "yes"
+    config.HistoryGitDir = c.MkDir()
+    ts.root = c.MkDir()
+    for _, dir := range []string{"posts", "pages"} {
+        c.Assert(copyDir(dir, filepath.Join(ts.root, dir)), IsNil)
+    }
+    c.Assert(VL.UseDir(ts.root), IsNil)
+}
+
+func copyDir(from, to string) error {
+    if err := os.MkdirAll(to, 0755); err != nil {
+        return err
+    }
+    paths, err := filepath.Glob(filepath.Join(from, "*.md"))
+    if err != nil {
+        return err
+    }
+    for _, path := range paths {
+        data, err := ioutil.ReadFile(path)
+        if err != nil {
+            return err
+        }
+        if err := ioutil.WriteFile(filepath.Join(to, filepath.Base(path)), data, 0644); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// source is where the tests' copy of a post or page is.
+func (ts *TestSuite) source(path string) string {
+    return filepath.Join(ts.root, path)
+}
+
 func (ts *TestSuite) TestPostsLoad(c *C) {
     VL.NewRepo("posts")
     c.Succeed()
@@ -32,3 +102,1007 @@ func (ts *TestSuite) TestTimeZones(c *C) {
         }
     }
 }
+
+func (ts *TestSuite) TestLint(c *C) {
+    for _, dir := range []string{"posts", "pages"} {
+        repo := VL.NewRepo(dir)
+        for _, source := range repo.Sources.All() {
+            for _, problem := range render.Lint(source) {
+                c.Errorf("%s: %s", source.Path, problem)
+            }
+        }
+    }
+}
+
+// Shortcodes only work through render, so nothing may fall back on blargh's
+// own HTML.
+func (ts *TestSuite) TestShortcodes(c *C) {
+    w := get("/2013/05/01/ruby-batteries-included", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*<iframe src="https://www.youtube-nocookie.com/embed/BAfy3IgVpjY".*`)
+    c.Check(strings.Contains(w.Body.String(), "{{<"), Equals, false)
+
+    w = get("/tag/mwrc", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*<div class="snippet">At the beginning of April 2013, MWRC ensued.*`)
+}
+
+func (ts *TestSuite) TestMeta(c *C) {
+    w := get("/2013/05/01/ruby-batteries-included", nil)
+    c.Check(w.Body.String(), Matches, `(?s).*<meta property="og:type" content="article">.*<script type="application/ld\+json">.*`)
+
+    // Nothing to share about a page that isn't there.
+    w = get("/2013/05/01/no-such-post", nil)
+    c.Assert(w.Code, Equals, http.StatusNotFound)
+    c.Check(strings.Contains(w.Body.String(), "og:"), Equals, false)
+    c.Check(strings.Contains(w.Body.String(), "application/ld+json"), Equals, false)
+}
+
+func (ts *TestSuite) TestSuggest(c *C) {
+    repo := VL.NewRepo("posts")
+    suggestions, err := repo.Suggest("RUB", 10)
+    c.Assert(err, IsNil)
+    c.Assert(len(suggestions) > 0, Equals, true)
+    c.Check(len(suggestions) <= 10, Equals, true)
+
+    found := false
+    for _, s := range suggestions {
+        if s.Text == "ruby" {
+            found = true
+            c.Check(s.URL, Matches, `http://.*/tag/ruby`)
+        }
+    }
+    c.Check(found, Equals, true)
+
+    suggestions, err = repo.Suggest("  ", 10)
+    c.Assert(err, IsNil)
+    c.Check(suggestions, HasLen, 0)
+}
+
+func get(path string, header http.Header) *httptest.ResponseRecorder {
+    req, _ := http.NewRequest("GET", "http://"+config.CanonicalHost+path, nil)
+    for key, values := range header {
+        req.Header[key] = values
+    }
+    w := httptest.NewRecorder()
+    VL.SetupHandler().ServeHTTP(w, req)
+    return w
+}
+
+func post(path, form string) *httptest.ResponseRecorder {
+    return send("POST", path, "application/x-www-form-urlencoded", strings.NewReader(form), nil)
+}
+
+func send(method, path, contentType string, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+    req, _ := http.NewRequest(method, "http://"+config.CanonicalHost+path, body)
+    req.RemoteAddr = "192.0.2.1:1234"
+    req.Header.Set("Content-Type", contentType)
+    for key, values := range header {
+        req.Header[key] = values
+    }
+    w := httptest.NewRecorder()
+    VL.SetupHandler().ServeHTTP(w, req)
+    return w
+}
+
+func (ts *TestSuite) TestAPIPosts(c *C) {
+    w := get("/api/v1/posts?tag=ruby&per_page=5&page=2", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Header().Get("Content-Type"), Equals, "application/json; charset=utf-8")
+    c.Check(w.Header().Get("Access-Control-Allow-Origin"), Equals, "*")
+
+    var list struct {
+        Posts []struct {
+            Slug string
+            Tags []string
+        }
+        Page, Total int
+        Next, Prev  string
+    }
+    c.Assert(json.Unmarshal(w.Body.Bytes(), &list), IsNil)
+    c.Check(list.Page, Equals, 2)
+    c.Check(list.Posts, HasLen, 5)
+    c.Check(list.Prev, Matches, `.*page=1.*`)
+    for _, p := range list.Posts {
+        c.Check(p.Tags, Not(HasLen), 0)
+    }
+
+    etag := w.Header().Get("Etag")
+    c.Assert(etag, Not(Equals), "")
+    w = get("/api/v1/posts?tag=ruby&per_page=5&page=2", http.Header{"If-None-Match": {etag}})
+    c.Check(w.Code, Equals, http.StatusNotModified)
+
+    w = get("/api/v1/posts?per_page=50&page=368934881474191033", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `.*"posts":\[\].*`)
+}
+
+func (ts *TestSuite) TestAPIPost(c *C) {
+    w := get("/api/v1/posts/rubyconf-mission-complete", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    var p struct {
+        Id   int
+        Slug string
+        HTML string
+    }
+    c.Assert(json.Unmarshal(w.Body.Bytes(), &p), IsNil)
+    c.Check(p.Slug, Equals, "rubyconf-mission-complete")
+    c.Check(p.Id, Equals, 523)
+    c.Check(p.HTML, Not(Equals), "")
+
+    w = get("/api/v1/posts/523", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `.*"slug":"rubyconf-mission-complete".*`)
+
+    w = get("/api/v1/posts/nope-not-here", nil)
+    c.Check(w.Code, Equals, http.StatusNotFound)
+    c.Check(w.Body.String(), Matches, `\{"error":.*`)
+}
+
+func (ts *TestSuite) TestNegotiation(c *C) {
+    permalink := "/2012/11/08/rubyconf-mission-complete"
+    w := get(permalink, http.Header{"Accept": {"text/markdown, text/html;q=0.9"}})
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Header().Get("Content-Type"), Equals, "text/markdown; charset=utf-8")
+    c.Check(w.Header().Get("Vary"), Equals, "Accept")
+    c.Check(w.Body.String(), Matches, `(?s)---.*title: RubyConf.*`)
+
+    w = get(permalink+".json", http.Header{"Accept": {"text/html"}})
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Header().Get("Content-Type"), Equals, "application/json; charset=utf-8")
+    c.Check(w.Body.String(), Matches, `(?s).*"slug":"rubyconf-mission-complete".*`)
+
+    w = get("/tag/ruby.md", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s)# Articles tagged with "ruby"\n.*- \[.*\]\(http://.*`)
+
+    w = get("/", http.Header{"Accept": {"application/json"}})
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s)\{"title":"Verbose Logging","description":"software development.*","url":"http://`+config.CanonicalHost+`/","posts":\[\{.*`)
+
+    w = get("/search.json?q=rubyconf", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*"slug":"rubyconf-mission-complete".*`)
+
+    for _, path := range []string{"/archive/full", "/archive/category", "/archive/month"} {
+        w = get(path+".md", nil)
+        c.Assert(w.Code, Equals, http.StatusOK)
+        c.Check(w.Body.String(), Matches, `(?s)# \w+ archives\n.*- \[RubyConf Mission Complete\]\(http://.*`)
+        c.Check(get(path, nil).Header().Get("Content-Type"), Equals, "text/html; charset=utf-8")
+    }
+
+    w = get("/category/programming", http.Header{"Accept": {"application/json;q=0, */*"}})
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Header().Get("Content-Type"), Equals, "text/html; charset=utf-8")
+    c.Check(w.Header().Get("Vary"), Equals, "Accept")
+}
+
+func (ts *TestSuite) TestWebmentionEndpoint(c *C) {
+    w := post("/webmention", "source=http://example.com/&target=http://"+config.CanonicalHost+"/2012/11/08/nope")
+    c.Check(w.Code, Equals, http.StatusBadRequest)
+    c.Check(w.Body.String(), Equals, "target isn't a post here")
+
+    w = get("/2012/11/08/rubyconf-mission-complete", nil)
+    c.Check(w.Body.String(), Matches, `(?s).*<link rel="webmention" href="http://`+config.CanonicalHost+`/webmention">.*`)
+
+    // Nobody to sign in, no moderation.
+    w = get("/webmention/moderation", nil)
+    c.Check(w.Code, Equals, http.StatusNotFound)
+}
+
+func (ts *TestSuite) TestReplyModeration(c *C) {
+    defer setupAdmin()()
+    permalink := "http://" + config.CanonicalHost + "/2012/11/08/rubyconf-mission-complete"
+    id := "http://example.com/jane/statuses/1"
+    c.Assert(VL.AddReply(activitypub.Reply{Id: id, Actor: "http://example.com/jane", InReplyTo: permalink,
+        URL: id, Content: "Nice post!", AuthorName: "Jane Doe", Published: time.Now()}), IsNil)
+    c.Check(get("/2012/11/08/rubyconf-mission-complete", nil).Body.String(), Not(Matches), `(?s).*Nice post!.*`)
+
+    session := sessionFrom(signIn("jane", "hunter2", ""))
+    w := get("/webmention/moderation", session)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*Jane Doe.*Nice post!.*name="id" value="`+id+`".*`)
+
+    moderate := func(action string) int {
+        form := url.Values{"xsrf": {"abcd1234"}, "id": {id}, "action": {action}}
+        return send("POST", "/webmention/moderation/reply", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()), session).Code
+    }
+    c.Check(moderate("approve"), Equals, http.StatusFound)
+    c.Check(get("/2012/11/08/rubyconf-mission-complete", nil).Body.String(), Matches, `(?s).*Nice post!.*`)
+    c.Check(moderate("delete"), Equals, http.StatusFound)
+    c.Check(get("/2012/11/08/rubyconf-mission-complete", nil).Body.String(), Not(Matches), `(?s).*Nice post!.*`)
+}
+
+func (ts *TestSuite) TestReload(c *C) {
+    repo := VL.NewRepo("posts")
+    reloaded := 0
+    repo.OnReload(func(*VL.Repo) { reloaded++ })
+    before := repo.Len()
+    c.Assert(repo.Reload(), IsNil)
+    c.Check(reloaded, Equals, 1)
+    c.Check(repo.Len(), Equals, before)
+
+    c.Assert(VL.Reload(), IsNil)
+    w := get("/2012/11/08/rubyconf-mission-complete", nil)
+    c.Check(w.Code, Equals, http.StatusOK)
+}
+
+func (ts *TestSuite) TestFeedAdvertisesItself(c *C) {
+    w := get("/feed?no_fb=1", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    self := "http://" + config.CanonicalHost + "/feed?no_fb=1"
+    c.Check(w.Header()["Link"], DeepEquals, []string{"<" + self + `>; rel="self"`})
+    c.Check(strings.Contains(w.Body.String(), `<atom:link rel="self" type="application/rss+xml" href="`+html.EscapeString(self)+`"/>`), Equals, true)
+
+    // Fetching the feed from there gets the feed, not FeedBurner.
+    u, err := url.Parse(self)
+    c.Assert(err, IsNil)
+    c.Check(get(u.RequestURI(), nil).Code, Equals, http.StatusOK)
+
+    // There's no hub unless one is configured.
+    w = post("/hub", "hub.mode=subscribe&hub.callback=http://example.com/&hub.topic=http://"+config.CanonicalHost+"/feed")
+    c.Check(w.Code, Equals, http.StatusNotFound)
+}
+
+func (ts *TestSuite) TestWebfinger(c *C) {
+    host := strings.Split(config.CanonicalHost, ":")[0]
+    w := get("/.well-known/webfinger?resource=acct:"+config.ActorName+"@"+host, nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Header().Get("Content-Type"), Equals, "application/jrd+json; charset=utf-8")
+    c.Check(w.Body.String(), Matches, `(?s).*"rel":"self","type":"application/activity\+json","href":"http://`+config.CanonicalHost+`/actor".*`)
+
+    w = get("/.well-known/webfinger?resource=acct:someone@"+host, nil)
+    c.Check(w.Code, Equals, http.StatusNotFound)
+
+    // Pages aren't articles.
+    w = get("/about", http.Header{"Accept": {"application/activity+json"}})
+    c.Check(w.Code, Equals, http.StatusNotAcceptable)
+
+    // Ids use whichever scheme the site's served over.
+    config.CanonicalScheme = "https"
+    defer func() { config.CanonicalScheme = "http" }()
+    w = get("/2012/11/08/rubyconf-mission-complete", http.Header{"Accept": {"application/activity+json"}})
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*"id":"https://`+config.CanonicalHost+`/2012/11/08/rubyconf-mission-complete".*`)
+}
+
+func (ts *TestSuite) TestMicropub(c *C) {
+    // No token, no Micropub.
+    c.Check(get("/micropub?q=config", nil).Code, Equals, http.StatusNotFound)
+
+    config.MicropubToken = "sekrit"
+    defer func() { config.MicropubToken = "" }()
+    auth := http.Header{"Authorization": {"Bearer sekrit"}}
+
+    c.Check(get("/micropub?q=config", nil).Code, Equals, http.StatusUnauthorized)
+    c.Check(get("/micropub?q=config", http.Header{"Authorization": {"Bearer nope"}}).Code, Equals, http.StatusForbidden)
+    w := get("/micropub?q=config", auth)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*"media-endpoint":"http://`+config.CanonicalHost+`/micropub/media".*"programming".*`)
+
+    // Guessing at the token runs into the same wall as signing in.
+    guesser := http.Header{"Authorization": {"Bearer nope"}, "X-Forwarded-For": {"192.0.2.88"}}
+    for i := 0; i < 5; i++ {
+        c.Check(get("/micropub?q=config", guesser).Code, Equals, http.StatusForbidden)
+    }
+    guesser.Set("Authorization", "Bearer sekrit")
+    c.Check(get("/micropub?q=config", guesser).Code, Equals, 429)
+
+    w = post("/micropub", "h=entry&name=Hello+Micropub&content=Posted+from+*somewhere*+else.&category[]=programming&category[]=micropub&published=2013-05-02T10:00:00-06:00&access_token=sekrit")
+    c.Assert(w.Code, Equals, http.StatusCreated)
+    defer func() {
+        os.Remove(ts.source("posts/hello-micropub.md"))
+        VL.Reload()
+    }()
+    location := w.Header().Get("Location")
+    c.Check(location, Equals, "http://"+config.CanonicalHost+"/2013/05/02/hello-micropub")
+    w = get("/2013/05/02/hello-micropub", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*<title>Hello Micropub \| Verbose Logging</title>.*`)
+
+    w = get("/micropub?q=source&properties[]=category&properties[]=name&url="+location, auth)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Equals, `{"properties":{"category":["programming","micropub"],"name":["Hello Micropub"]}}`+"\n")
+
+    update := `{"action":"update","url":"` + location + `","replace":{"name":["Hello Again"]},"delete":{"category":["micropub"]}}`
+    w = send("POST", "/micropub", "application/json", strings.NewReader(update), auth)
+    c.Assert(w.Code, Equals, http.StatusNoContent)
+    source, err := ioutil.ReadFile(ts.source("posts/hello-micropub.md"))
+    c.Assert(err, IsNil)
+    c.Check(string(source), Matches, `(?s)--- \nid: \d+\nauthor: Daniel Huckstep\ntitle: Hello Again\ncategory: programming\n.*publishedon: 02 May 2013 10:00 MDT\nslugs: \n- hello-micropub\n---\nPosted from \*somewhere\* else.\n`)
+
+    w = post("/micropub", "action=delete&url="+location+"&access_token=sekrit")
+    c.Assert(w.Code, Equals, http.StatusNoContent)
+    c.Check(get("/2013/05/02/hello-micropub", nil).Code, Equals, http.StatusNotFound)
+}
+
+func (ts *TestSuite) TestMicropubConcurrentCreates(c *C) {
+    config.MicropubToken = "sekrit"
+    defer func() { config.MicropubToken = "" }()
+    const n = 8
+    defer func() {
+        os.Remove(ts.source("posts/hello-twice.md"))
+        for i := 2; i <= n; i++ {
+            os.Remove(ts.source(fmt.Sprintf("posts/hello-twice-%d.md", i)))
+        }
+        VL.Reload()
+    }()
+
+    // The same post a few times at once still makes that many.
+    codes := make(chan int, n)
+    for i := 0; i < n; i++ {
+        go func() {
+            codes <- post("/micropub", "h=entry&name=Hello+Twice&content=Again.&published=2013-05-02T10:00:00-06:00&access_token=sekrit").Code
+        }()
+    }
+    for i := 0; i < n; i++ {
+        c.Check(<-codes, Equals, http.StatusCreated)
+    }
+    made, err := filepath.Glob(ts.source("posts/hello-twice*.md"))
+    c.Assert(err, IsNil)
+    c.Check(made, HasLen, n)
+}
+
+func (ts *TestSuite) TestPublished(c *C) {
+    var announced []string
+    VL.OnPublish(func(slugs []string) { announced = append(announced, slugs...) })
+
+    config.MicropubToken = "sekrit"
+    defer func() { config.MicropubToken = "" }()
+    defer func() {
+        os.Remove(ts.source("posts/hello-now.md"))
+        os.Remove(ts.source("posts/hello-then.md"))
+        VL.Reload()
+    }()
+    now := url.QueryEscape(time.Now().Format(time.RFC3339))
+    c.Assert(post("/micropub", "h=entry&name=Hello+Now&content=New.&published="+now+"&access_token=sekrit").Code, Equals, http.StatusCreated)
+    c.Assert(post("/micropub", "h=entry&name=Hello+Then&content=Old.&published=2013-05-02T10:00:00-06:00&access_token=sekrit").Code, Equals, http.StatusCreated)
+    c.Assert(VL.Reload(), IsNil)
+    c.Check(announced, DeepEquals, []string{"hello-now"})
+}
+
+func (ts *TestSuite) TestMicropubMedia(c *C) {
+    config.MicropubToken = "sekrit"
+    defer func() { config.MicropubToken = "" }()
+    auth := http.Header{"Authorization": {"Bearer sekrit"}}
+
+    upload := func(filename, contentType, data string) *httptest.ResponseRecorder {
+        var body bytes.Buffer
+        m := multipart.NewWriter(&body)
+        header := make(textproto.MIMEHeader)
+        header.Set("Content-Disposition", `form-data; name="file"; filename="`+filename+`"`)
+        header.Set("Content-Type", contentType)
+        part, _ := m.CreatePart(header)
+        io.WriteString(part, data)
+        m.Close()
+        return send("POST", "/micropub/media", m.FormDataContentType(), &body, auth)
+    }
+
+    w := upload("photo.gif", "image/gif", "GIF89a not really")
+    c.Assert(w.Code, Equals, http.StatusCreated)
+    location := w.Header().Get("Location")
+    c.Check(location, Matches, "http://"+config.CanonicalHost+"/media/[0-9a-f]{20}\\.gif")
+    c.Check(get(strings.TrimPrefix(location, "http://"+config.CanonicalHost), nil).Body.String(), Equals, "GIF89a not really")
+
+    // The same thing twice is the same file.
+    c.Check(upload("again.gif", "image/gif", "GIF89a not really").Header().Get("Location"), Equals, location)
+    c.Check(upload("notes.txt", "text/plain", "just some notes").Code, Equals, http.StatusUnsupportedMediaType)
+    // What's in it counts, not what it says it is.
+    c.Check(upload("photo.png", "image/png", "<script>alert(1)</script>").Code, Equals, http.StatusUnsupportedMediaType)
+}
+
+// call makes an XML-RPC call; params are already XML values.
+func call(method string, params ...string) (int, string) {
+    body := "<?xml version=\"1.0\"?><methodCall><methodName>" + method + "</methodName><params>"
+    for _, param := range params {
+        body += "<param><value>" + param + "</value></param>"
+    }
+    body += "</params></methodCall>"
+    header := web.Header{web.HeaderContentType: {"text/xml"}, web.HeaderHost: {config.CanonicalHost}}
+    status, _, out := web.RunHandler("http://"+config.CanonicalHost+"/xmlrpc", "POST", header, []byte(body), VL.Router())
+    return status, string(out)
+}
+
+func (ts *TestSuite) TestMetaWeblog(c *C) {
+    status, _ := call("metaWeblog.getRecentPosts", "1", "me", "pass", "<int>1</int>")
+    c.Check(status, Equals, http.StatusNotFound)
+
+    config.BloggingUser, config.BloggingPass = "me", "pass"
+    defer func() { config.BloggingUser, config.BloggingPass = "", "" }()
+
+    status, out := call("metaWeblog.getRecentPosts", "1", "me", "nope", "<int>1</int>")
+    c.Check(status, Equals, http.StatusOK)
+    c.Check(out, Matches, `(?s).*<fault>.*<int>403</int>.*`)
+
+    _, out = call("metaWeblog.newPost", "1", "me", "pass", `<struct>
+        <member><name>title</name><value>Written in MarsEdit</value></member>
+        <member><name>description</name><value>Hello &lt;em&gt;there&lt;/em&gt;.</value></member>
+        <member><name>categories</name><value><array><data><value>software</value><value>mac</value></data></array></value></member>
+        <member><name>mt_keywords</name><value>editors, xml-rpc</value></member>
+        <member><name>dateCreated</name><value><dateTime.iso8601>20130503T16:00:00</dateTime.iso8601></value></member>
+    </struct>`, "<boolean>1</boolean>")
+    m := regexp.MustCompile(`<string>(\d+)</string>`).FindStringSubmatch(out)
+    c.Assert(m, NotNil)
+    id := m[1]
+    defer func() {
+        os.Remove(ts.source("posts/written-in-marsedit.md"))
+        VL.Reload()
+    }()
+    c.Check(get("/2013/05/03/written-in-marsedit", nil).Code, Equals, http.StatusOK)
+
+    _, out = call("metaWeblog.getPost", id, "me", "pass")
+    c.Check(out, Matches, `(?s).*<name>categories</name><value><array><data><value><string>software</string>.*`)
+    c.Check(out, Matches, `(?s).*<name>mt_keywords</name><value><string>mac, editors, xml-rpc</string>.*`)
+    c.Check(out, Matches, `(?s).*<name>dateCreated</name><value><dateTime.iso8601>20130503T16:00:00</dateTime.iso8601>.*`)
+    c.Check(out, Matches, `(?s).*<name>description</name><value><string>Hello &lt;em&gt;there&lt;/em&gt;.</string>.*`)
+
+    _, out = call("metaWeblog.editPost", id, "me", "pass", `<struct>
+        <member><name>title</name><value>Edited in MarsEdit</value></member>
+    </struct>`, "<boolean>0</boolean>")
+    c.Check(out, Matches, `(?s).*<boolean>1</boolean>.*`)
+    c.Check(get("/2013/05/03/written-in-marsedit", nil).Code, Equals, http.StatusNotFound)
+    _, out = call("metaWeblog.getPost", id, "me", "pass")
+    c.Check(out, Matches, `(?s).*<name>description</name><value><string>Hello &lt;em&gt;there&lt;/em&gt;.</string>.*`)
+    c.Check(out, Matches, `(?s).*<name>post_status</name><value><string>draft</string>.*<name>title</name><value><string>Edited in MarsEdit</string>.*`)
+    _, out = call("metaWeblog.getRecentPosts", "1", "me", "pass", "<int>3</int>")
+    c.Check(strings.Count(out, "<name>postid</name>"), Equals, 3)
+
+    _, out = call("blogger.deletePost", "<string>app</string>", id, "me", "pass", "<boolean>1</boolean>")
+    c.Check(out, Matches, `(?s).*<boolean>1</boolean>.*`)
+    _, err := os.Stat(ts.source("posts/written-in-marsedit.md"))
+    c.Check(os.IsNotExist(err), Equals, true)
+    _, out = call("metaWeblog.getPost", id, "me", "pass")
+    c.Check(out, Matches, `(?s).*<fault>.*<int>404</int>.*`)
+
+    _, out = call("metaWeblog.newMediaObject", "1", "me", "pass", `<struct>
+        <member><name>name</name><value>tiny.gif</value></member>
+        <member><name>type</name><value>image/gif</value></member>
+        <member><name>bits</name><value><base64>R0lGODlh</base64></value></member>
+    </struct>`)
+    m = regexp.MustCompile(`<name>url</name><value><string>http://[^/]+(/media/[0-9a-f]+\.gif)</string>`).FindStringSubmatch(out)
+    c.Assert(m, NotNil)
+    c.Check(get(m[1], nil).Body.String(), Equals, "GIF89a")
+
+    // Guessing at the password runs into the same wall as signing in.
+    for i := 0; i < 4; i++ {
+        call("metaWeblog.getRecentPosts", "1", "me", "nope", "<int>1</int>")
+    }
+    _, out = call("metaWeblog.getRecentPosts", "1", "me", "pass", "<int>1</int>")
+    c.Check(out, Matches, `(?s).*<fault>.*<int>429</int>.*`)
+}
+
+// adminHash is for "hunter2", hashed once since it's slow on purpose.
+var adminHash, _ = auth.HashPassword("hunter2")
+
+// setupAdmin lets jane sign in, and bob with a code too. The returned func
+// puts things back.
+func setupAdmin() func() {
+    config.AdminUsers = "jane:" + adminHash + ",bob:" + adminHash + ":" + totpSecret
+    config.SessionSecret = "shh"
+    return func() { config.AdminUsers, config.SessionSecret = "", "" }
+}
+
+const totpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func signIn(user, password, code string) *httptest.ResponseRecorder {
+    return signInFrom("", user, password, code)
+}
+
+// signInFrom signs in from addr, as Heroku's router would say.
+func signInFrom(addr, user, password, code string) *httptest.ResponseRecorder {
+    form := url.Values{"xsrf": {"abcd1234"}, "user": {user}, "password": {password}, "code": {code}, "next": {"/admin"}}
+    header := http.Header{"Cookie": {"xsrf=abcd1234"}}
+    if addr != "" {
+        header.Set("X-Forwarded-For", addr)
+    }
+    return send("POST", "/login", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()), header)
+}
+
+// sessionFrom is the cookies to send to be signed in, after signIn.
+func sessionFrom(w *httptest.ResponseRecorder) http.Header {
+    session := strings.SplitN(w.Header().Get("Set-Cookie"), ";", 2)[0]
+    return http.Header{"Cookie": {"xsrf=abcd1234; " + session}}
+}
+
+// sessionFor is the cookies to send to be signed in as user, for tests that
+// run after TestLogin has throttled signing in.
+func sessionFor(user string) http.Header {
+    value := web.SignValue(config.SessionSecret, "session", time.Hour, user)
+    return http.Header{"Cookie": {"xsrf=abcd1234; session=" + value}}
+}
+
+func (ts *TestSuite) TestLogin(c *C) {
+    c.Check(get("/login", nil).Code, Equals, http.StatusNotFound)
+    defer setupAdmin()()
+
+    w := get("/admin", nil)
+    c.Check(w.Code, Equals, http.StatusFound)
+    c.Check(w.Header().Get("Location"), Matches, `.*/login\?next=%2Fadmin`)
+    w = get("/login?next=//example.com/", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*name="next" value="/admin".*`)
+
+    w = signIn("jane", "hunter2", "")
+    c.Assert(w.Code, Equals, http.StatusFound)
+    c.Check(w.Header().Get("Location"), Matches, `.*/admin`)
+    c.Check(w.Header().Get("Set-Cookie"), Matches, `session=[0-9a-f]+~[0-9a-f]+~jane; path=/; max-age=604800; .*secure; HttpOnly; SameSite=Lax`)
+    session := sessionFrom(w)
+    w = get("/admin", session)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*Signed in as jane.*Recent activity.*jane.*login.*`)
+
+    // A session someone made up doesn't work, and neither does one for a
+    // user that's gone.
+    c.Check(get("/admin", http.Header{"Cookie": {"session=abc~ffffffffff~jane"}}).Code, Equals, http.StatusFound)
+    config.AdminUsers = "bob:" + adminHash
+    c.Check(get("/admin", session).Code, Equals, http.StatusFound)
+    setupAdmin()
+
+    c.Check(signIn("bob", "hunter2", "").Code, Equals, http.StatusForbidden)
+    code, _ := auth.Code(totpSecret, time.Now())
+    c.Check(signIn("bob", "hunter2", code).Code, Equals, http.StatusFound)
+    c.Check(signIn("bob", "hunter2", code).Code, Equals, http.StatusForbidden)
+
+    w = send("POST", "/logout", "application/x-www-form-urlencoded", strings.NewReader("xsrf=abcd1234"), session)
+    c.Check(w.Code, Equals, http.StatusFound)
+    c.Check(w.Header().Get("Set-Cookie"), Matches, `session=; .*max-age=-2592000.*`)
+
+    // Too many wrong tries and even the right password has to wait.
+    for i := 0; i < 5; i++ {
+        c.Check(signInFrom("192.0.2.66", "carl", "nope", "").Code, Equals, http.StatusForbidden)
+    }
+    w = signInFrom("192.0.2.66", "jane", "hunter2", "")
+    c.Check(w.Code, Equals, 429)
+    c.Check(w.Body.String(), Matches, `(?s).*Too many wrong tries.*`)
+    audit, err := ioutil.ReadFile(filepath.Join(config.DataDir, "audit.log"))
+    c.Assert(err, IsNil)
+    c.Check(string(audit), Matches, `(?s).*\tcarl\t192\.0\.2\.66\tfailed login\t\n.*\tjane\t192\.0\.2\.66\tthrottled login\taddr:192\.0\.2\.66\n$`)
+
+    // Guessing at jane's password from all over only slows her down, and
+    // it's the address the router saw that counts.
+    for i := 0; i < 5; i++ {
+        c.Check(signInFrom("192.0.2.1, 198.51.100."+strconv.Itoa(i), "jane", "nope", "").Code, Equals, http.StatusForbidden)
+    }
+    start := time.Now()
+    c.Check(signInFrom("203.0.113.1", "jane", "hunter2", "").Code, Equals, http.StatusFound)
+    c.Check(time.Since(start) >= time.Second, Equals, true)
+    audit, err = ioutil.ReadFile(filepath.Join(config.DataDir, "audit.log"))
+    c.Assert(err, IsNil)
+    c.Check(string(audit), Matches, `(?s).*\tjane\t198\.51\.100\.4\tfailed login\t\n.*\tjane\t203\.0\.113\.1\tlogin\t\n$`)
+}
+
+func (ts *TestSuite) TestAdmin(c *C) {
+    c.Check(get("/admin", nil).Code, Equals, http.StatusNotFound)
+    defer setupAdmin()()
+    session := sessionFrom(signIn("jane", "hunter2", ""))
+
+    w := get("/admin", session)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*Posts.*published.*Pages.*`)
+
+    w = get("/admin/posts/new", session)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*<form[^>]*action="/admin/posts/new".*name="title" value="Untitled".*`)
+    c.Check(get("/admin/pages/new", session).Code, Equals, http.StatusNotFound)
+    c.Check(get("/admin/posts/no-such-post", session).Code, Equals, http.StatusNotFound)
+
+    form := "title=Hello+Admin&category=programming&tags=admin,+go&publishedon=2013-05-03+09:30&published=yes&body=Written+in+the+admin.&images=kitty+http://example.com/kitty.png"
+    // Twister answers a bad XSRF token with a 404.
+    c.Check(send("POST", "/admin/posts/new", "application/x-www-form-urlencoded", strings.NewReader(form), nil).Code, Equals, http.StatusNotFound)
+    // And somebody not signed in can't post at all.
+    c.Check(send("POST", "/admin/posts/new", "application/x-www-form-urlencoded", strings.NewReader("xsrf=abcd1234&"+form), http.Header{"Cookie": {"xsrf=abcd1234"}}).Code, Equals, http.StatusForbidden)
+
+    w = send("POST", "/admin/posts/new", "application/x-www-form-urlencoded", strings.NewReader("xsrf=abcd1234&title=&publishedon=today"), session)
+    c.Check(w.Code, Equals, http.StatusBadRequest)
+    c.Check(w.Body.String(), Matches, `(?s).*Published on has to look like.*`)
+
+    w = send("POST", "/admin/posts/new", "application/x-www-form-urlencoded", strings.NewReader("xsrf=abcd1234&"+form), session)
+    c.Assert(w.Code, Equals, http.StatusFound)
+    defer func() {
+        os.Remove(ts.source("posts/hello-admin.md"))
+        VL.Reload()
+    }()
+    c.Check(w.Header().Get("Location"), Matches, `.*/admin/posts/hello-admin\?saved=1`)
+    source, err := ioutil.ReadFile(ts.source("posts/hello-admin.md"))
+    c.Assert(err, IsNil)
+    c.Check(string(source), Matches, `(?s)--- \nid: \d+\nauthor: Daniel Huckstep\ntitle: Hello Admin\ncategory: programming\n.*publishedon: 03 May 2013 09:30 MDT\nslugs: \n- hello-admin\ntags: \n- admin\n- go\nimages: \n  kitty: \n    original: "http://example.com/kitty.png"\n---\nWritten in the admin.\n`)
+    w = get("/2013/05/03/hello-admin", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*<title>Hello Admin \| Verbose Logging</title>.*`)
+
+    w = send("POST", "/admin/preview/posts/hello-admin", "application/x-www-form-urlencoded", strings.NewReader("xsrf=abcd1234&body=Changed"), session)
+    c.Check(w.Code, Equals, http.StatusOK)
+    c.Check(w.Header().Get("Content-Type"), Equals, "text/html; charset=utf-8")
+}
+
+func (ts *TestSuite) TestAdminMedia(c *C) {
+    defer setupAdmin()()
+    session := sessionFrom(signIn("jane", "hunter2", ""))
+
+    upload := func(filename string, data []byte) *httptest.ResponseRecorder {
+        var body bytes.Buffer
+        m := multipart.NewWriter(&body)
+        m.WriteField("alt", "A tiny kitty")
+        part, _ := m.CreateFormFile("file", filename)
+        part.Write(data)
+        m.Close()
+        return send("POST", "/admin/media?xsrf=abcd1234", m.FormDataContentType(), &body, session)
+    }
+
+    var kitty bytes.Buffer
+    png.Encode(&kitty, image.NewGray(image.Rect(0, 0, 3, 2)))
+    w := upload("Tiny Kitty.png", kitty.Bytes())
+    c.Assert(w.Code, Equals, http.StatusFound)
+    w = get("/admin/media", session)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    name := regexp.MustCompile(`/media/([0-9a-f]{20}\.png)`).FindStringSubmatch(w.Body.String())
+    c.Assert(name, NotNil)
+    c.Check(w.Body.String(), Matches, `(?s).*Tiny Kitty.png</a><br>\s*image/png, 3&times;2.*by jane.*value="A tiny kitty".*`)
+
+    c.Check(upload("notes.txt", []byte("just text")).Code, Equals, http.StatusUnsupportedMediaType)
+
+    w = send("POST", "/admin/media/"+name[1], "application/x-www-form-urlencoded", strings.NewReader("xsrf=abcd1234&alt=A+very+tiny+kitty"), session)
+    c.Check(w.Code, Equals, http.StatusFound)
+    c.Check(get("/admin/media", session).Body.String(), Matches, `(?s).*value="A very tiny kitty".*`)
+
+    // The editor has it to pick.
+    w = get("/admin/posts/new", session)
+    c.Check(w.Body.String(), Matches, `(?s).*data-key="tiny_kitty" data-url="http://`+config.CanonicalHost+`/media/`+name[1]+`".*`)
+
+    w = send("POST", "/admin/media/"+name[1], "application/x-www-form-urlencoded", strings.NewReader("xsrf=abcd1234&action=delete"), session)
+    c.Check(w.Code, Equals, http.StatusFound)
+    c.Check(get("/media/"+name[1], nil).Code, Equals, http.StatusNotFound)
+    c.Check(get("/admin/media", session).Body.String(), Not(Matches), `(?s).*Tiny Kitty.*`)
+}
+
+func (ts *TestSuite) TestHistory(c *C) {
+    defer setupAdmin()()
+    session := sessionFrom(signIn("jane", "hunter2", ""))
+    edit := func(path, form string) *httptest.ResponseRecorder {
+        return send("POST", path, "application/x-www-form-urlencoded", strings.NewReader("xsrf=abcd1234&"+form), session)
+    }
+
+    form := "title=Hello+History&category=programming&publishedon=2013-06-01+10:00&published=yes&body="
+    c.Assert(edit("/admin/posts/new", form+"First+try.").Code, Equals, http.StatusFound)
+    defer func() {
+        os.Remove(ts.source("posts/hello-history.md"))
+        VL.Reload()
+    }()
+    c.Assert(edit("/admin/posts/hello-history", form+"Second+try.").Code, Equals, http.StatusFound)
+    c.Check(get("/admin/posts/hello-history", session).Body.String(), Matches, `(?s).*href="/admin/posts/hello-history/history".*`)
+
+    w := get("/admin/posts/hello-history/history", session)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*Update posts/hello-history.md</td>\s*<td>jane</td>.*Add posts/hello-history.md</td>\s*<td>jane</td>.*`)
+    revs := regexp.MustCompile(`/history/([0-9a-f]{40})"`).FindAllStringSubmatch(w.Body.String(), -1)
+    c.Assert(revs, HasLen, 2)
+
+    w = get("/admin/posts/hello-history/history/"+revs[0][1], session)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*<span class="diff-remove">-First try.</span>\s*<span class="diff-add">&#43;Second try.</span>.*`)
+    c.Check(get("/admin/posts/hello-history/history/abcdef0", session).Code, Equals, http.StatusNotFound)
+
+    // Going back is one click, and is history too.
+    c.Check(send("POST", "/admin/posts/hello-history/history/"+revs[1][1], "application/x-www-form-urlencoded", strings.NewReader("xsrf=abcd1234"), nil).Code, Equals, http.StatusNotFound)
+    w = edit("/admin/posts/hello-history/history/"+revs[1][1], "")
+    c.Assert(w.Code, Equals, http.StatusFound)
+    c.Check(w.Header().Get("Location"), Matches, `.*/admin/posts/hello-history\?saved=1`)
+    source, err := ioutil.ReadFile(ts.source("posts/hello-history.md"))
+    c.Assert(err, IsNil)
+    c.Check(string(source), Matches, `(?s).*\nFirst try.\n$`)
+    w = get("/admin/posts/hello-history/history", session)
+    c.Check(w.Body.String(), Matches, `(?s).*Revert posts/hello-history.md to `+revs[1][1][:7]+`</td>.*`)
+
+    // If the site can't load the posts after saving, what was there is put
+    // back and nothing's committed.
+    broken := ts.source("posts/broken.md")
+    c.Assert(os.Mkdir(broken, 0755), IsNil)
+    c.Check(edit("/admin/posts/hello-history", form+"Third+try.").Code, Equals, http.StatusInternalServerError)
+    c.Assert(os.Remove(broken), IsNil)
+    source, err = ioutil.ReadFile(ts.source("posts/hello-history.md"))
+    c.Assert(err, IsNil)
+    c.Check(string(source), Matches, `(?s).*\nFirst try.\n$`)
+    c.Check(regexp.MustCompile(`/history/[0-9a-f]{40}"`).FindAllString(get("/admin/posts/hello-history/history", session).Body.String(), -1), HasLen, 3)
+
+    config.History = "no"
+    defer func() { config.History = "yes" }()
+    c.Check(get("/admin/posts/hello-history/history", session).Code, Equals, http.StatusNotFound)
+}
+
+func (ts *TestSuite) TestComments(c *C) {
+    permalink := "/2012/11/08/rubyconf-mission-complete"
+    // Nobody could moderate them, so there aren't any.
+    c.Check(get(permalink, nil).Body.String(), Not(Matches), `(?s).*Leave a comment.*`)
+
+    defer setupAdmin()()
+    w := get(permalink, nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*<form method="post" action="`+permalink+`/comments">\s*<input type="hidden" name="token" value="[^"]+">.*name="homepage".*`)
+    c.Check(w.Header().Get("Set-Cookie"), Equals, "")
+    c.Check(get(permalink+".json", nil).Header().Get("Set-Cookie"), Equals, "")
+    token := regexp.MustCompile(`name="token" value="([^"]+)"`).FindStringSubmatch(w.Body.String())
+    c.Assert(token, NotNil)
+    comment := func(form string) *httptest.ResponseRecorder {
+        return send("POST", permalink+"/comments", "application/x-www-form-urlencoded", strings.NewReader("token="+url.QueryEscape(html.UnescapeString(token[1]))+"&"+form), nil)
+    }
+
+    w = send("POST", permalink+"/comments", "application/x-www-form-urlencoded", strings.NewReader("name=Jane&body=Hi"), nil)
+    c.Check(w.Code, Equals, http.StatusBadRequest)
+    c.Check(w.Body.String(), Matches, `(?s).*Couldn&#39;t post that: the form&#39;s too old.*`)
+    other := web.SignValue(config.SessionSecret, "comment", time.Hour, "/2012/11/08/somewhere-else")
+    c.Check(send("POST", permalink+"/comments", "application/x-www-form-urlencoded", strings.NewReader("name=Jane&body=Hi&token="+url.QueryEscape(other)), nil).Code, Equals, http.StatusBadRequest)
+    w = comment("name=&body=Hi")
+    c.Check(w.Code, Equals, http.StatusBadRequest)
+    c.Check(w.Body.String(), Matches, `(?s).*Couldn&#39;t post that: there has to be a name.*<textarea[^>]*>Hi</textarea>.*`)
+    c.Check(comment("name=Jane&body=Hi&url=javascript:alert(1)").Code, Equals, http.StatusBadRequest)
+
+    // The honeypot looks like it worked, but nothing's kept.
+    w = comment("name=Bot&body=Buy+pills&homepage=http://pills.example.com/")
+    c.Check(w.Code, Equals, http.StatusFound)
+    w = comment("name=Jane+Doe&email=jane@example.com&url=http://jane.example.com/&body=Great+talk!%0A%0AThanks+for+the+slides.")
+    c.Assert(w.Code, Equals, http.StatusFound)
+    c.Check(w.Header().Get("Location"), Matches, `.*`+permalink+`\?commented=1#comment-form`)
+    c.Check(get(permalink+"?commented=1", nil).Body.String(), Matches, `(?s).*will show up once it&#39;s been checked.*`)
+    c.Check(get(permalink, nil).Body.String(), Not(Matches), `(?s).*Great talk!.*`)
+
+    session := sessionFrom(signIn("jane", "hunter2", ""))
+    c.Check(get("/admin", session).Body.String(), Matches, `(?s).*Moderate comments \(\d+ waiting\).*`)
+    w = get("/admin/comments", session)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Not(Matches), `(?s).*Buy pills.*`)
+    ids := regexp.MustCompile(`action="/admin/comments/([0-9a-f]{16})"`).FindAllStringSubmatch(w.Body.String(), -1)
+    c.Assert(ids, HasLen, 1)
+    id := ids[0][1]
+    moderate := func(id, action string) int {
+        return send("POST", "/admin/comments/"+id, "application/x-www-form-urlencoded", strings.NewReader("xsrf=abcd1234&action="+action), session).Code
+    }
+    defer moderate(id, "delete")
+    c.Check(moderate(id, "approve"), Equals, http.StatusFound)
+
+    w = get(permalink, nil)
+    c.Check(w.Body.String(), Matches, `(?s).*<li class="comment h-cite" id="comment-`+id+`">.*gravatar.com/avatar/9e26471d35a78862c17e467d87cddedf.png\?s=48&amp;d=identicon.*<a class="p-name u-url" href="http://jane.example.com/" rel="nofollow ugc">Jane Doe</a>.*<p>Great talk!</p><p>Thanks for the slides.</p>.*`)
+    c.Check(get(permalink+"?reply="+id, nil).Body.String(), Matches, `(?s).*Reply to Jane Doe.*name="parent" value="`+id+`".*`)
+
+    w = comment("name=Bob&parent=" + id + "&body=Me+too.")
+    c.Assert(w.Code, Equals, http.StatusFound)
+    w = get("/admin/comments", session)
+    reply := regexp.MustCompile(`action="/admin/comments/([0-9a-f]{16})"`).FindStringSubmatch(w.Body.String())
+    c.Assert(reply, NotNil)
+    defer moderate(reply[1], "delete")
+    c.Check(moderate(reply[1], "approve"), Equals, http.StatusFound)
+    c.Check(get(permalink, nil).Body.String(), Matches, `(?s).*id="comment-`+id+`">.*<ol class="comment-replies">\s*<li class="comment h-cite" id="comment-`+reply[1]+`">.*Me too.*`)
+    c.Check(comment("name=Eve&parent=ffffffffffffffff&body=Hi").Code, Equals, http.StatusBadRequest)
+
+    config.Comments = "no"
+    defer func() { config.Comments = "yes" }()
+    c.Check(comment("name=Jane&body=Hi").Code, Equals, http.StatusNotFound)
+    c.Check(get(permalink, nil).Body.String(), Not(Matches), `(?s).*Leave a comment.*`)
+}
+
+func (ts *TestSuite) TestNewsletter(c *C) {
+    c.Check(get("/subscribe", nil).Code, Equals, http.StatusNotFound)
+    server := smtptest.NewServer()
+    defer server.Close()
+    config.SMTPAddr, config.NewsletterKey = server.Addr, "shh"
+    defer func() { config.SMTPAddr, config.NewsletterKey = "", "" }()
+    // link is the path and query of the first link in the last email sent
+    // that goes to path.
+    link := func(path string) string {
+        messages := server.Messages()
+        c.Assert(messages, Not(HasLen), 0)
+        data, err := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(messages[len(messages)-1].Data)))
+        c.Assert(err, IsNil)
+        found := regexp.MustCompile(`https?://[^/]+(` + path + `\?token=[^\s"<]+)`).FindStringSubmatch(string(data))
+        c.Assert(found, NotNil)
+        return found[1]
+    }
+    subscribe := func(email string) *httptest.ResponseRecorder {
+        return send("POST", "/subscribe", "application/x-www-form-urlencoded", strings.NewReader("xsrf=abcd1234&email="+url.QueryEscape(email)), http.Header{"Cookie": {"xsrf=abcd1234"}})
+    }
+
+    c.Check(get("/", nil).Body.String(), Matches, `(?s).*<a href="/subscribe">.*`)
+    c.Check(get("/subscribe", nil).Body.String(), Matches, `(?s).*<form method="post" action="/subscribe">.*name="email".*`)
+    c.Check(subscribe("not an email").Code, Equals, http.StatusBadRequest)
+    c.Check(server.Messages(), HasLen, 0)
+    w := subscribe("Jane@Example.com")
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*on its way to Jane@example.com.*`)
+    c.Assert(server.Messages(), HasLen, 1)
+    c.Check(server.Messages()[0].To, DeepEquals, []string{"Jane@example.com"})
+    confirm := link("/subscribe/confirm")
+
+    defer setupAdmin()()
+    session := sessionFor("jane")
+    defer send("POST", "/admin/newsletter", "application/x-www-form-urlencoded", strings.NewReader("xsrf=abcd1234&action=remove&email=Jane%40example.com"), session)
+    c.Check(get("/admin/newsletter", session).Body.String(), Matches, `(?s).*0 confirmed.*<td>Jane@example.com</td>\s*<td>pending</td>.*`)
+
+    c.Check(get("/subscribe/confirm?token=nope", nil).Code, Equals, http.StatusBadRequest)
+    c.Check(get(strings.Replace(confirm, "/subscribe/confirm", "/unsubscribe", 1), nil).Code, Equals, http.StatusBadRequest)
+    w = get(confirm, nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*New posts will be sent to Jane@example.com.*`)
+    c.Check(get("/admin/newsletter", session).Body.String(), Matches, `(?s).*1 confirmed.*<td>Jane@example.com</td>\s*<td>confirmed</td>.*`)
+
+    // Asking again doesn't send another email to somebody who's confirmed.
+    server.Reset()
+    c.Check(subscribe("Jane@example.com").Code, Equals, http.StatusOK)
+    c.Check(server.Messages(), HasLen, 0)
+
+    w = send("POST", "/admin/newsletter", "application/x-www-form-urlencoded", strings.NewReader("xsrf=abcd1234&action=send"), session)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*Sent to 1, 0 failed.*<td>digest</td>.*`)
+    c.Assert(server.Messages(), HasLen, 1)
+    msg, err := mail.ReadMessage(strings.NewReader(server.Messages()[0].Data))
+    c.Assert(err, IsNil)
+    c.Check(msg.Header.Get("List-Unsubscribe"), Matches, `<http://.*/unsubscribe\?token=.*>`)
+    unsubscribe := link("/unsubscribe")
+
+    w = get(unsubscribe, nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*Stop sending new posts to Jane@example.com\?.*`)
+    c.Check(get("/admin/newsletter", session).Body.String(), Matches, `(?s).*1 confirmed.*`)
+    // One-click, the way a mail program would.
+    w = send("POST", unsubscribe, "application/x-www-form-urlencoded", strings.NewReader("List-Unsubscribe=One-Click"), nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*Nothing more will be sent to Jane@example.com.*`)
+    c.Check(get("/admin/newsletter", session).Body.String(), Matches, `(?s).*0 confirmed.*Nobody yet.*`)
+
+    // Plenty of readers can sign up from one address, but one email can
+    // only be sent so many.
+    server.Reset()
+    for i := 0; i < 6; i++ {
+        email := "reader" + strconv.Itoa(i) + "@example.com"
+        defer send("POST", "/admin/newsletter", "application/x-www-form-urlencoded", strings.NewReader("xsrf=abcd1234&action=remove&email="+url.QueryEscape(email)), session)
+        c.Check(subscribe(email).Code, Equals, http.StatusOK)
+    }
+    c.Check(subscribe("reader0@example.com").Code, Equals, http.StatusOK)
+    c.Check(subscribe("reader0@example.com").Code, Equals, http.StatusOK)
+    c.Check(subscribe("reader0@example.com").Code, Equals, 429)
+    c.Check(server.Messages(), HasLen, 8)
+}
+
+func (ts *TestSuite) TestAnalytics(c *C) {
+    permalink := "/2012/11/08/rubyconf-mission-complete"
+    browser := http.Header{
+        "User-Agent": {"Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0"},
+        "Referer":    {"https://duckduckgo.com/?q=rubyconf"},
+    }
+    for i := 0; i < 3; i++ {
+        c.Assert(get(permalink, browser).Code, Equals, http.StatusOK)
+    }
+    w := get("/", browser)
+    c.Check(w.Header().Get("Set-Cookie"), Equals, "")
+    c.Check(w.Body.String(), Not(Matches), `(?s).*(google-analytics|gaug\.es).*`)
+
+    defer setupAdmin()()
+    w = get("/admin/analytics?days=1", sessionFor("jane"))
+    c.Assert(w.Code, Equals, http.StatusOK)
+    body := w.Body.String()
+    c.Check(body, Matches, `(?s).*Last 1 days.*Popular posts.*RubyConf Mission Complete</a> &middot; \d+ views.*`)
+    c.Check(body, Matches, `(?s).*<td><a href="`+permalink+`">`+permalink+`</a></td>.*<td>search</td>.*<td>duckduckgo.com</td>.*`)
+    c.Check(body, Not(Matches), `(?s).*<td><a href="/admin.*`)
+    c.Check(get("/admin/analytics", nil).Code, Equals, http.StatusFound)
+}
+
+func (ts *TestSuite) TestPopular(c *C) {
+    permalink := "/2012/11/08/rubyconf-mission-complete"
+    browser := http.Header{"User-Agent": {"Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0"}}
+    for i := 0; i < 50; i++ {
+        c.Assert(get(permalink, browser).Code, Equals, http.StatusOK)
+    }
+    // Reloading works the lists out again, without waiting.
+    c.Assert(VL.Reload(), IsNil)
+    body := get("/", nil).Body.String()
+    c.Check(body, Matches, `(?s).*<section id="most-read"><h4>Most read this month</h4>\s*<ol>\s*<li><a href="http://[^/]+`+permalink+`">RubyConf Mission Complete</a></li>.*`)
+    c.Check(body, Matches, `(?s).*<section id="trending"><h4>Trending</h4>\s*<ol>\s*<li><a href="http://[^/]+`+permalink+`">.*`)
+}
+
+// renderRequests is how many rendered documents have been asked for.
+func renderRequests(c *C) int {
+    total := 0
+    for _, m := range regexp.MustCompile(`(?m)^render_cache_requests_total\{[^}]*\} (\d+)$`).FindAllStringSubmatch(get("/metrics", nil).Body.String(), -1) {
+        n, err := strconv.Atoi(m[1])
+        c.Assert(err, IsNil)
+        total += n
+    }
+    return total
+}
+
+func (ts *TestSuite) TestSitemap(c *C) {
+    // The index goes by post dates, without rendering anything.
+    before := renderRequests(c)
+    w := get("/sitemap.xml", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*<loc>http://`+regexp.QuoteMeta(config.CanonicalHost)+`/sitemap-posts.xml</loc>\s*<lastmod>2013-05-21T.*`)
+    c.Check(renderRequests(c), Equals, before)
+
+    w = get("/sitemap-posts.xml", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Body.String(), Matches, `(?s).*<loc>http://`+regexp.QuoteMeta(config.CanonicalHost)+`/2012/11/08/rubyconf-mission-complete</loc>.*`)
+}
+
+func (ts *TestSuite) TestTaxonomyURLs(c *C) {
+    source := "--- \nid: 9001\nauthor: Daniel Huckstep\ntitle: Odd Tags\ncategory: odd stuff\npublished: true\npublishedon: 02 May 2013 10:00 MDT\nslugs: \n- odd-tags\ntags: \n- zz c# and/or?\n---\nOdd.\n"
+    c.Assert(ioutil.WriteFile(ts.source("posts/odd-tags.md"), []byte(source), 0644), IsNil)
+    c.Assert(VL.Reload(), IsNil)
+    defer func() {
+        os.Remove(ts.source("posts/odd-tags.md"))
+        VL.Reload()
+    }()
+
+    tag := regexp.QuoteMeta("/tag/zz%20c%23%20and%2For%3F")
+    c.Check(get("/api/v1/tags", nil).Body.String(), Matches, `(?s).*"url":"http://[^"]+`+tag+`".*`)
+    c.Check(get("/api/v1/categories", nil).Body.String(), Matches, `(?s).*"url":"http://[^"]+/category/odd%20stuff".*`)
+    c.Check(get("/search/suggest?q=zz", nil).Body.String(), Matches, `(?s).*"http://[^"]+`+tag+`".*`)
+    w := get("/sitemap-taxonomies.xml", nil)
+    c.Check(w.Body.String(), Matches, `(?s).*<loc>http://[^<]+`+tag+`</loc>.*`)
+    c.Check(w.Body.String(), Matches, `(?s).*<loc>http://[^<]+/category/odd%20stuff</loc>.*`)
+}
+
+func (ts *TestSuite) TestMetrics(c *C) {
+    permalink := "/2012/11/08/rubyconf-mission-complete"
+    c.Assert(get("/", nil).Code, Equals, http.StatusOK)
+    c.Assert(get(permalink, nil).Code, Equals, http.StatusOK)
+    c.Assert(get(permalink, nil).Code, Equals, http.StatusOK)
+    c.Assert(get("/nope/not/here", nil).Code, Equals, http.StatusNotFound)
+    c.Assert(post("/feed", "").Code, Equals, http.StatusMethodNotAllowed)
+
+    w := get("/metrics", nil)
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Header().Get("Content-Type"), Equals, "text/plain; version=0.0.4; charset=utf-8")
+    body := w.Body.String()
+    for _, line := range []string{
+        `http_requests_total\{route="/<format:[^"]+",method="GET",code="200"\} \d+`,
+        `http_requests_total\{route="/<year:[^"]+<slug:[^"]+",method="GET",code="200"\} \d+`,
+        `http_requests_total\{route="/<path:\.\*>",method="GET",code="404"\} \d+`,
+        `http_requests_total\{route="none",method="POST",code="405"\} \d+`,
+        `http_request_duration_seconds_bucket\{route="/<format:[^"]+",method="GET",le="\+Inf"\} \d+`,
+        `http_response_size_bytes_count\{route="/<format:[^"]+"\} \d+`,
+        `# TYPE http_gzip_ratio histogram`,
+        `view_render_seconds_count\{template="layout.tmpl"\} \d+`,
+        `blog_repo_documents\{repo="posts"\} [1-9]\d*`,
+        `render_cache_requests_total\{result="hit"\} \d+`,
+        `# TYPE activitypub_actor_cache_requests_total counter`,
+        `go_goroutines \d+`,
+    } {
+        c.Check(body, Matches, `(?s).*\n`+line+`\n.*`)
+    }
+    // Paths don't turn into labels of their own.
+    c.Check(body, Not(Matches), `(?s).*rubyconf-mission-complete.*`)
+
+    config.MetricsToken = "s3cret"
+    defer func() { config.MetricsToken = "" }()
+    w = get("/metrics", nil)
+    c.Check(w.Code, Equals, http.StatusUnauthorized)
+    c.Check(w.Header().Get("Www-Authenticate"), Equals, `Bearer realm="metrics"`)
+    c.Check(get("/metrics", http.Header{"Authorization": {"Bearer nope"}}).Code, Equals, http.StatusUnauthorized)
+    c.Check(get("/metrics", http.Header{"Authorization": {"Bearer s3cret"}}).Code, Equals, http.StatusOK)
+
+    config.Metrics = "no"
+    defer func() { config.Metrics = "yes" }()
+    c.Check(get("/metrics", http.Header{"Authorization": {"Bearer s3cret"}}).Code, Equals, http.StatusNotFound)
+}
+
+func (ts *TestSuite) TestRequestLogging(c *C) {
+    var buf bytes.Buffer
+    logging.Default.SetOutput(&buf)
+    defer logging.Default.SetOutput(os.Stdout)
+
+    w := get("/", http.Header{"X-Request-Id": {"req-1234"}, "X-Forwarded-For": {"10.6.6.6, 203.0.113.7"}})
+    c.Assert(w.Code, Equals, http.StatusOK)
+    c.Check(w.Header().Get("X-Request-Id"), Equals, "req-1234")
+    c.Check(buf.String(), Matches, `(?s).*level=info msg=request logger=verboselogging request_id=req-1234 route="/<format:[^"]+" method=GET path=/ status=200 bytes=\d+ duration=[0-9.e-]+ remote_addr=203.0.113.7 .*`)
+
+    // Without one from the router, there's one made up.
+    c.Check(get("/", nil).Header().Get("X-Request-Id"), Matches, `[0-9a-f]{16}`)
+
+    // Only some of the 404s.
+    config.LogNotFound = 3
+    defer func() { config.LogNotFound = 10 }()
+    buf.Reset()
+    for i := 0; i < 3; i++ {
+        c.Assert(get("/nope/not/here", nil).Code, Equals, http.StatusNotFound)
+    }
+    c.Check(strings.Count(buf.String(), "msg=request"), Equals, 1)
+    c.Check(buf.String(), Matches, `(?s).*status=404 .*sampled=3.*`)
+}
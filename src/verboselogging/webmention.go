@@ -0,0 +1,127 @@
+package verboselogging
+
+import (
+    "activitypub"
+    "config"
+    "errors"
+    "net/url"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+    "webmention"
+)
+
+var (
+    permalinkPath = regexp.MustCompile(`^/(\d{4})/(\d{2})/(\d{2})/([^/]+?)/?$`)
+    mentions      = openMentions()
+    receiver      = webmention.NewReceiver(mentions, isPermalink)
+)
+
+func init() {
+    receiver.Start(2)
+}
+
+func openMentions() *webmention.Store {
+    store, err := webmention.Open(filepath.Join(config.DataDir, "webmentions.json"))
+    if err != nil {
+        panic(err)
+    }
+    return store
+}
+
+// isPermalink tells whether a mention target is one of our posts.
+func isPermalink(target *url.URL) bool {
+    if target.Host != config.CanonicalHost {
+        return false
+    }
+    m := permalinkPath.FindStringSubmatch(target.Path)
+    if m == nil {
+        return false
+    }
+    y, _ := strconv.Atoi(m[1])
+    mo, _ := strconv.Atoi(m[2])
+    d, _ := strconv.Atoi(m[3])
+    _, err := posts.FindByPermalink(y, time.Month(mo), d, m[4])
+    return err == nil
+}
+
+func webmentionHandler(req *web.Request) {
+    m, err := receiver.Receive(req.Param.Get("source"), req.Param.Get("target"))
+    if err != nil {
+        w := req.Respond(web.StatusBadRequest, web.HeaderContentType, "text/plain; charset=utf-8")
+        w.Write([]byte(err.Error()))
+        return
+    }
+    logFor(req).Info("received webmention", "id", m.Id, "source", m.Source)
+    w := req.Respond(web.StatusAccepted, web.HeaderContentType, "text/plain; charset=utf-8")
+    w.Write([]byte("Thanks! The mention will show up once it's been checked and approved."))
+}
+
+type moderation struct {
+    Mentions []*webmention.Mention
+    Replies  []*activitypub.Reply
+    XSRF     string
+}
+
+func moderationHandler(req *web.Request) {
+    view.RenderLayout(req.Respond(web.StatusOK, web.HeaderContentType, "text/html; charset=utf-8"), &view.RenderInfo{
+        Moderation: &moderation{mentions.List(), followers.Pending(), req.Param.Get(web.XSRFParamName)},
+        Title:      "Mentions",
+        PageTitle:  "Mentions",
+    })
+}
+
+func moderateHandler(req *web.Request) {
+    id := req.URLParam["id"]
+    m, ok := mentions.Get(id)
+    if !ok {
+        notFound(req)
+        return
+    }
+
+    var err error
+    switch req.Param.Get("action") {
+    case "approve":
+        err = mentions.Update(id, func(m *webmention.Mention) { m.Status = webmention.Approved })
+    case "reject":
+        err = mentions.Update(id, func(m *webmention.Mention) { m.Status = webmention.Rejected })
+    case "delete":
+        err = mentions.Delete(id)
+    default:
+        req.Error(web.StatusBadRequest, errors.New("unknown action"))
+        return
+    }
+    if err != nil {
+        logFor(req).Error("failed moderating mention", "id", id, "error", err)
+        serverError(req, err)
+        return
+    }
+    audit(req, "moderated", req.Param.Get("action")+" "+m.Source)
+    req.Redirect("/webmention/moderation", false)
+}
+
+// moderateReplyHandler approves or deletes a reply from the fediverse. Their
+// ids are URLs, so the id comes with the form.
+func moderateReplyHandler(req *web.Request) {
+    id := req.Param.Get("id")
+    var err error
+    switch req.Param.Get("action") {
+    case "approve":
+        err = followers.ApproveReply(id)
+    case "delete":
+        err = followers.RemoveReply(id)
+    default:
+        req.Error(web.StatusBadRequest, errors.New("unknown action"))
+        return
+    }
+    if err != nil {
+        logFor(req).Error("failed moderating reply", "id", id, "error", err)
+        serverError(req, err)
+        return
+    }
+    audit(req, "moderated reply", req.Param.Get("action")+" "+id)
+    req.Redirect("/webmention/moderation", false)
+}
@@ -0,0 +1,138 @@
+package verboselogging
+
+import (
+    "bytes"
+    "config"
+    "fmt"
+    "github.com/darkhelmet/blargh/post"
+    "io"
+    "net/http"
+    "path/filepath"
+    "time"
+    "vendor/github.com/garyburd/twister/web"
+    "view"
+    "webmention"
+    "websub"
+)
+
+const feedContentType = "application/rss+xml; charset=utf-8"
+
+var hub = openHub()
+
+func init() {
+    published.OnPublish(pingHub)
+}
+
+// hubURL is the hub feeds advertise: ours if it's turned on, otherwise
+// whichever one is configured, if any.
+func hubURL() string {
+    if config.BuiltinHub == "yes" {
+        return view.CanonicalURL("/hub")
+    }
+    return config.Hub
+}
+
+// feedPath is the feed as hubs and rel=self know it. Plain /feed sends
+// everybody but FeedBurner off to FeedBurner, so a hub or subscriber
+// fetching it there would never get the feed itself.
+const feedPath = "/feed?no_fb=1"
+
+func feedTopic() string {
+    return view.CanonicalURL(feedPath)
+}
+
+// oldFeedTopic is what subscriptions were made to before feedPath. They
+// keep getting the feed, but can't be renewed.
+func oldFeedTopic() string {
+    return view.CanonicalURL("/feed")
+}
+
+func openHub() *websub.Hub {
+    if config.BuiltinHub != "yes" {
+        return nil
+    }
+    h, err := websub.Open(filepath.Join(config.DataDir, "websub.json"), hubURL(), func(topic string) bool {
+        return topic == feedTopic()
+    })
+    if err != nil {
+        panic(err)
+    }
+    h.Client = webmention.PublicClient(10 * time.Second)
+    return h
+}
+
+// feedLinks are the Link headers that go along with the feed.
+func feedLinks() []string {
+    links := []string{fmt.Sprintf(`<%s>; rel="self"`, feedTopic())}
+    if hub := hubURL(); hub != "" {
+        links = append(links, fmt.Sprintf(`<%s>; rel="hub"`, hub))
+    }
+    return links
+}
+
+func renderFeed(w io.Writer) error {
+    posts, err := posts.FindLatest(10)
+    if err != nil {
+        return err
+    }
+    view.RenderPartial(w, "feed.tmpl", &view.RenderInfo{
+        Post:            posts,
+        Canonical:       feedPath,
+        Hub:             hubURL(),
+        SiteTitle:       config.SiteTitle,
+        SiteDescription: config.SiteDescription,
+        SiteContact:     config.SiteContact,
+        SiteAuthor:      config.SiteAuthor,
+    })
+    return nil
+}
+
+// pingHub lets the hub know the feed changed. Our own hub gets the feed
+// handed to it, anyone else's has to come and get it.
+func pingHub(fresh []*post.Post) {
+    logger.Info("pinging the hub", "posts", len(fresh))
+    if hub != nil {
+        var feed bytes.Buffer
+        if err := renderFeed(&feed); err != nil {
+            logger.Error("failed rendering the feed for the hub", "error", err)
+            return
+        }
+        go func() {
+            hub.Publish(feedTopic(), feed.Bytes(), feedContentType)
+            hub.Publish(oldFeedTopic(), feed.Bytes(), feedContentType)
+        }()
+        return
+    }
+    if config.Hub != "" {
+        client := &http.Client{Timeout: 10 * time.Second}
+        if err := websub.Ping(client, config.Hub, feedTopic()); err != nil {
+            logger.Error("failed pinging the hub", "hub", config.Hub, "error", err)
+        }
+    }
+}
+
+func hubHandler(req *web.Request) {
+    if hub == nil {
+        notFound(req)
+        return
+    }
+    switch mode := req.Param.Get("hub.mode"); mode {
+    case "publish":
+        // Only we publish here, and we don't need to ask.
+        req.Respond(web.StatusNoContent)
+    default:
+        intent, err := hub.Request(mode, req.Param.Get("hub.callback"), req.Param.Get("hub.topic"),
+            req.Param.Get("hub.lease_seconds"), req.Param.Get("hub.secret"))
+        if err != nil {
+            w := req.Respond(web.StatusBadRequest, web.HeaderContentType, "text/plain; charset=utf-8")
+            io.WriteString(w, err.Error())
+            return
+        }
+        go func() {
+            if err := hub.Verify(intent); err != nil {
+                logFor(req).Warn("failed verifying a subscription", "mode", intent.Mode, "callback", intent.Callback, "error", err)
+            }
+        }()
+        req.Respond(web.StatusAccepted)
+    }
+}
@@ -0,0 +1,40 @@
+package view
+
+import (
+    "bytes"
+    T "html/template"
+    TT "text/template"
+    "time"
+)
+
+// Emails come in pairs: name.txt for plain text and name.html for HTML,
+// both in views/email and given the same data.
+var (
+    emailFuncs = map[string]interface{}{
+        "CanonicalUrl": CanonicalURL,
+        "DisplayTime": func(t Formatter) string {
+            return t.Format("02 Jan 2006")
+        },
+    }
+    textEmails = TT.Must(TT.New("email").Funcs(emailFuncs).ParseGlob("views/email/*.txt"))
+    htmlEmails = T.Must(T.New("email").Funcs(emailFuncs).ParseGlob("views/email/*.html"))
+)
+
+// EmailPost is a post as it shows up in an email.
+type EmailPost struct {
+    Title, URL, Description string
+    PublishedOn             time.Time
+}
+
+// RenderEmail renders both halves of the email called name.
+func RenderEmail(name string, data interface{}) (text, html string, err error) {
+    defer renderSeconds.Since(time.Now(), "email/"+name)
+    var t, h bytes.Buffer
+    if err := textEmails.ExecuteTemplate(&t, name+".txt", data); err != nil {
+        return "", "", err
+    }
+    if err := htmlEmails.ExecuteTemplate(&h, name+".html", data); err != nil {
+        return "", "", err
+    }
+    return t.String(), h.String(), nil
+}
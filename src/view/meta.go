@@ -0,0 +1,267 @@
+package view
+
+import (
+    "config"
+    "encoding/json"
+    "fmt"
+    "github.com/darkhelmet/blargh/post"
+    T "html/template"
+    "images"
+    "render"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Meta is everything about a page that goes in the head for crawlers and
+// link previews: Open Graph, Twitter Cards and schema.org JSON-LD.
+type Meta struct {
+    OpenGraph []MetaTag
+    Twitter   []MetaTag
+    JSONLD    []T.JS
+}
+
+// MetaTag is a single <meta> property and its content.
+type MetaTag struct {
+    Property, Content string
+}
+
+type ldPerson struct {
+    Type  string `json:"@type"`
+    Name  string `json:"name"`
+    Email string `json:"email,omitempty"`
+    Image string `json:"image,omitempty"`
+}
+
+type ldBlogPosting struct {
+    Context          string    `json:"@context"`
+    Type             string    `json:"@type"`
+    Headline         string    `json:"headline"`
+    Description      string    `json:"description,omitempty"`
+    URL              string    `json:"url"`
+    MainEntityOfPage string    `json:"mainEntityOfPage"`
+    DatePublished    string    `json:"datePublished"`
+    Author           ldPerson  `json:"author"`
+    Publisher        ldPerson  `json:"publisher"`
+    Image            string    `json:"image,omitempty"`
+    ArticleSection   string    `json:"articleSection,omitempty"`
+    Keywords         string    `json:"keywords,omitempty"`
+    WordCount        int       `json:"wordCount,omitempty"`
+    IsPartOf         ldWebSite `json:"isPartOf"`
+}
+
+type ldWebSite struct {
+    Context     string `json:"@context,omitempty"`
+    Type        string `json:"@type"`
+    Name        string `json:"name"`
+    Description string `json:"description,omitempty"`
+    URL         string `json:"url"`
+}
+
+type ldWebPage struct {
+    Context     string    `json:"@context"`
+    Type        string    `json:"@type"`
+    Name        string    `json:"name"`
+    Description string    `json:"description,omitempty"`
+    URL         string    `json:"url"`
+    IsPartOf    ldWebSite `json:"isPartOf"`
+}
+
+type ldBreadcrumbList struct {
+    Context         string       `json:"@context"`
+    Type            string       `json:"@type"`
+    ItemListElement []ldListItem `json:"itemListElement"`
+}
+
+type ldListItem struct {
+    Type     string `json:"@type"`
+    Position int    `json:"position"`
+    Name     string `json:"name"`
+    Item     string `json:"item"`
+}
+
+type crumb struct {
+    Name, Path string
+}
+
+func gravatarURL(size int) string {
+    return fmt.Sprintf("%s?s=%d", Gravatar(config.SiteContact), size)
+}
+
+func website() ldWebSite {
+    return ldWebSite{
+        Type:        "WebSite",
+        Name:        config.SiteTitle,
+        Description: config.SiteDescription,
+        URL:         CanonicalURL("/"),
+    }
+}
+
+func jsonLD(v interface{}) T.JS {
+    data, err := json.Marshal(v)
+    if err != nil {
+        logger.Error("failed encoding JSON-LD", "error", err)
+        return T.JS("{}")
+    }
+    return T.JS(data)
+}
+
+func breadcrumbs(crumbs []crumb) T.JS {
+    list := ldBreadcrumbList{Context: "http://schema.org", Type: "BreadcrumbList"}
+    for n, c := range crumbs {
+        list.ItemListElement = append(list.ItemListElement, ldListItem{
+            Type:     "ListItem",
+            Position: n + 1,
+            Name:     c.Name,
+            Item:     CanonicalURL(c.Path),
+        })
+    }
+    return jsonLD(list)
+}
+
+// leadImage picks the post's image for link previews: whichever of its
+// images shows up first in the body, at the largest size we have. If none of
+// them are in the body, it's the first one by name.
+func leadImage(doc *render.Document) string {
+    best, image := len(doc.HTML), ""
+    for _, sizes := range doc.Images {
+        for _, url := range sizes {
+            if i := strings.Index(string(doc.HTML), url); i >= 0 && i < best {
+                best, image = i, images.Largest(sizes)
+            }
+        }
+    }
+    if image == "" && len(doc.Images) > 0 {
+        names := make([]string, 0, len(doc.Images))
+        for name := range doc.Images {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        image = images.Largest(doc.Images[names[0]])
+    }
+    return image
+}
+
+func buildMeta(data *RenderInfo) *Meta {
+    // Error pages aren't anything to share or index.
+    if data.NotFound || data.Error {
+        return nil
+    }
+    meta := new(Meta)
+    og := func(property, content string) {
+        if content != "" {
+            meta.OpenGraph = append(meta.OpenGraph, MetaTag{property, content})
+        }
+    }
+    twitter := func(property, content string) {
+        if content != "" {
+            meta.Twitter = append(meta.Twitter, MetaTag{property, content})
+        }
+    }
+
+    title, description, url := data.Title, data.Description, CanonicalURL(data.Canonical)
+    if title == "" {
+        title = config.SiteTitle
+    }
+    if description == "" {
+        description = config.SiteDescription
+    }
+    image := ""
+
+    switch {
+    case isPost(data.Post):
+        p := data.Post.(*post.Post)
+        doc := render.Post(p)
+        image = leadImage(doc)
+        url = CanonicalURL(PostCanonical(p))
+        published := p.PublishedOn.Format(time.RFC3339)
+
+        og("og:type", "article")
+        og("article:published_time", published)
+        og("article:author", config.SiteAuthor)
+        og("article:section", strings.Title(p.Category))
+        for _, tag := range p.Tags {
+            og("article:tag", tag)
+        }
+        meta.JSONLD = append(meta.JSONLD, jsonLD(ldBlogPosting{
+            Context:          "http://schema.org",
+            Type:             "BlogPosting",
+            Headline:         p.Title,
+            Description:      p.Description,
+            URL:              url,
+            MainEntityOfPage: url,
+            DatePublished:    published,
+            Author:           ldPerson{Type: "Person", Name: p.Author},
+            Publisher:        ldPerson{Type: "Person", Name: config.SiteAuthor, Image: gravatarURL(100)},
+            Image:            image,
+            ArticleSection:   strings.Title(p.Category),
+            Keywords:         strings.Join(p.Tags, ", "),
+            WordCount:        doc.WordCount,
+            IsPartOf:         website(),
+        }), breadcrumbs([]crumb{
+            {"Home", "/"},
+            {strings.Title(p.Category), TaxonomyPath("category", p.Category)},
+            {p.Title, PostCanonical(p)},
+        }))
+    case data.Page != nil:
+        p := data.Page.(*post.Post)
+        image = leadImage(render.Page(p))
+        url = CanonicalURL(PageCanonical(p))
+        og("og:type", "website")
+        meta.JSONLD = append(meta.JSONLD, jsonLD(ldWebPage{
+            Context:     "http://schema.org",
+            Type:        "WebPage",
+            Name:        p.Title,
+            Description: p.Description,
+            URL:         url,
+            IsPartOf:    website(),
+        }), breadcrumbs([]crumb{{"Home", "/"}, {p.Title, PageCanonical(p)}}))
+    case data.Canonical == "/":
+        og("og:type", "website")
+        site := website()
+        site.Context = "http://schema.org"
+        meta.JSONLD = append(meta.JSONLD, jsonLD(site))
+    default:
+        og("og:type", "website")
+        meta.JSONLD = append(meta.JSONLD, jsonLD(ldWebPage{
+            Context:     "http://schema.org",
+            Type:        "CollectionPage",
+            Name:        title,
+            Description: description,
+            URL:         url,
+            IsPartOf:    website(),
+        }))
+        if data.Canonical != "" {
+            meta.JSONLD = append(meta.JSONLD, breadcrumbs([]crumb{{"Home", "/"}, {title, data.Canonical}}))
+        }
+    }
+
+    card := "summary_large_image"
+    if image == "" {
+        image = gravatarURL(300)
+        card = "summary"
+    }
+
+    og("og:title", title)
+    og("og:description", description)
+    og("og:url", url)
+    og("og:image", image)
+    og("og:site_name", config.SiteTitle)
+    og("og:locale", "en_US")
+
+    twitter("twitter:card", card)
+    twitter("twitter:site", config.SiteTwitter)
+    twitter("twitter:creator", config.SiteTwitter)
+    twitter("twitter:title", title)
+    twitter("twitter:description", description)
+    twitter("twitter:image", image)
+
+    return meta
+}
+
+// isPost tells a single post apart from the feed, which passes a slice of
+// them in the same field.
+func isPost(i interface{}) bool {
+    _, ok := i.(*post.Post)
+    return ok
+}
@@ -0,0 +1,31 @@
+package view
+
+import (
+    "github.com/darkhelmet/blargh/post"
+    "sync"
+)
+
+// PostList is a few posts for the footer, under a heading.
+type PostList struct {
+    Name  string
+    Posts []*post.Post
+}
+
+var (
+    popularLock        sync.RWMutex
+    mostRead, trending *PostList
+)
+
+// SetPopular changes the lists of posts every page has in its footer.
+// Either can be nil, for none.
+func SetPopular(read, trend *PostList) {
+    popularLock.Lock()
+    mostRead, trending = read, trend
+    popularLock.Unlock()
+}
+
+func popular() (*PostList, *PostList) {
+    popularLock.RLock()
+    defer popularLock.RUnlock()
+    return mostRead, trending
+}
@@ -1,6 +1,8 @@
 package view
 
 import (
+    "activitypub"
+    "comments"
     "config"
     "crypto/md5"
     "encoding/json"
@@ -9,17 +11,20 @@ import (
     T "html/template"
     "io"
     "io/ioutil"
-    "log"
-    "os"
+    "logging"
+    "metrics"
+    "net/url"
+    "render"
     "strings"
     "time"
     "unicode"
     "unicode/utf8"
+    "webmention"
 )
 
 var (
     templates *T.Template
-    logger    = log.New(os.Stdout, "[view] ", config.LogFlags)
+    logger    = logging.New("view")
     middot    = T.HTML("&middot;")
     HTML      = func(s string) T.HTML { return T.HTML(s) }
     pageLinks = []PageLink{
@@ -32,6 +37,8 @@ var (
         PageLink{Name: "Sitemap", Path: "/sitemap.xml", Footer: true},
     }
     assets = make(map[string]string)
+
+    renderSeconds = metrics.NewHistogram("view_render_seconds", "Time spent rendering templates.", metrics.DefaultBuckets, "template")
 )
 
 type Formatter interface {
@@ -51,17 +58,22 @@ type PageLink struct {
 type RenderInfo struct {
     Page                                               interface{}
     Title, PageTitle, Description, Canonical, Gravatar string
+    Hub                                                string
     Error, NotFound, ArchiveLinks                      bool
+    Meta                                               *Meta
 
     SiteTitle, SiteDescription, SiteContact, SiteAuthor             string
     PageLinks                                                       []PageLink
     PostPreview, Post, FullArchive, CategoryArchive, MonthlyArchive interface{}
+    CommentForm, CommentQueue, Subscribe, Newsletter                interface{}
+    Moderation, Admin, Editor, Login, Media, History, Analytics     interface{}
+    MostRead, Trending                                              *PostList
 }
 
 func setupAssets() {
     data, err := ioutil.ReadFile("public/assets/manifest.json")
     if err != nil {
-        logger.Fatalf("failed to read asset manifest file: %s", err)
+        logger.Fatalf("failed reading the asset manifest: %s", err)
     }
     manifest := make(map[string]interface{})
     err = json.Unmarshal(data, &manifest)
@@ -95,9 +107,7 @@ func init() {
         "ImagePath": func(name string) string {
             return assetPath(fmt.Sprintf("images/%s", name))
         },
-        "CanonicalUrl": func(path string) string {
-            return fmt.Sprintf("http://%s%s", config.CanonicalHost, path)
-        },
+        "CanonicalUrl": CanonicalURL,
         "ISO8601": func(t Formatter) string {
             return t.Format(time.RFC3339)
         },
@@ -107,19 +117,13 @@ func init() {
         "DisplayTime": func(t Formatter) string {
             return t.Format("02 Jan 2006 15:04 MST")
         },
-        "Gravatar": func(email string) string {
-            email = strings.TrimFunc(email, unicode.IsSpace)
-            email = strings.ToLower(email)
-            hash := md5.New()
-            io.WriteString(hash, email)
-            return fmt.Sprintf("http://www.gravatar.com/avatar/%x.png", hash.Sum(nil))
-        },
+        "Gravatar": Gravatar,
         "CategoryPath": func(i interface{}) string {
             switch thing := i.(type) {
             case *post.Post:
-                return fmt.Sprintf("/category/%s", thing.Category)
+                return TaxonomyPath("category", thing.Category)
             case string:
-                return fmt.Sprintf("/category/%s", thing)
+                return TaxonomyPath("category", thing)
             default:
                 panic("YOU SHALL NOT PASS!!!")
             }
@@ -132,7 +136,7 @@ func init() {
             return t.Format("/2006/01")
         },
         "TagPath": func(tag string) string {
-            return fmt.Sprintf("/tag/%s", tag)
+            return TaxonomyPath("tag", tag)
         },
         "Truncate": func(length int, s string) string {
             if length < utf8.RuneCountInString(s) {
@@ -146,6 +150,20 @@ func init() {
         "Safe":          HTML,
         "PostCanonical": PostCanonical,
         "PageCanonical": PageCanonical,
+        "Document":      render.Post,
+        "PageDocument":  render.Page,
+        "Mentions": func(p *post.Post) []*webmention.Mention {
+            return webmention.For(CanonicalURL(PostCanonical(p)))
+        },
+        "Replies": func(p *post.Post) []*activitypub.Reply {
+            return activitypub.RepliesTo(CanonicalURL(PostCanonical(p)))
+        },
+        "EmailSubscriptions": func() bool {
+            return config.SMTPAddr != "" && config.NewsletterKey != ""
+        },
+        "Comments": func(p *post.Post) []*comments.Thread {
+            return comments.For(CanonicalURL(PostCanonical(p)))
+        },
     }).ParseGlob("views/*.tmpl"))
     setupAssets()
 }
@@ -154,25 +172,48 @@ func assetPath(name string) string {
     return fmt.Sprintf("%s/assets/%s", config.AssetHost, assets[name])
 }
 
+func Gravatar(email string) string {
+    email = strings.TrimFunc(email, unicode.IsSpace)
+    email = strings.ToLower(email)
+    hash := md5.New()
+    io.WriteString(hash, email)
+    return fmt.Sprintf("http://www.gravatar.com/avatar/%x.png", hash.Sum(nil))
+}
+
 func RenderLayout(w io.Writer, data *RenderInfo) {
+    defer renderSeconds.Since(time.Now(), "layout.tmpl")
     data.SiteTitle = config.SiteTitle
     data.SiteDescription = config.SiteDescription
     data.SiteContact = config.SiteContact
     data.SiteAuthor = config.SiteAuthor
     data.PageLinks = pageLinks
+    data.MostRead, data.Trending = popular()
+    data.Meta = buildMeta(data)
     err := templates.ExecuteTemplate(w, "layout.tmpl", data)
     if err != nil {
-        logger.Printf("error rendering template: %s", err)
+        logger.Error("failed rendering", "template", "layout.tmpl", "error", err)
     }
 }
 
 func RenderPartial(w io.Writer, name string, data interface{}) {
+    defer renderSeconds.Since(time.Now(), name)
     err := templates.ExecuteTemplate(w, name, data)
     if err != nil {
-        logger.Printf("error rendering partial: %s", err)
+        logger.Error("failed rendering", "template", name, "error", err)
     }
 }
 
+// CanonicalURL makes a path absolute on the canonical host.
+func CanonicalURL(path string) string {
+    return fmt.Sprintf("%s://%s%s", config.CanonicalScheme, config.CanonicalHost, path)
+}
+
+// TaxonomyPath is the listing for a category or tag, like /tag/go, with the
+// name escaped so it stays one piece of the path whatever's in it.
+func TaxonomyPath(kind, name string) string {
+    return fmt.Sprintf("/%s/%s", kind, strings.Replace(url.QueryEscape(name), "+", "%20", -1))
+}
+
 func PostCanonical(p *post.Post) string {
     return fmt.Sprintf("/%s/%s", p.PublishedOn.Format("2006/01/02"), p.Slug())
 }
@@ -0,0 +1,360 @@
+package webmention
+
+import (
+    "html"
+    "net/url"
+    "regexp"
+    "strings"
+    "time"
+)
+
+var (
+    token     = regexp.MustCompile(`(?s)<!--.*?-->|<!\[CDATA\[.*?\]\]>|<![^>]*>|<\?[^>]*>|<(/?)([a-zA-Z][a-zA-Z0-9:-]*)((?:[^>"']|"[^"]*"|'[^']*')*)>`)
+    attribute = regexp.MustCompile(`([^\s=/>"']+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+)))?`)
+    voids     = make(map[string]bool)
+    raws      = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+)
+
+func init() {
+    for _, tag := range strings.Fields("area base br col embed hr img input link meta param source track wbr") {
+        voids[tag] = true
+    }
+}
+
+// node is an element or, when Tag is empty, a run of text. It's nowhere
+// near a real HTML parser, but it copes with the markup blogs send out,
+// which is all mentions need.
+type node struct {
+    Tag      string
+    Attrs    map[string]string
+    Text     string
+    Parent   *node
+    Children []*node
+}
+
+func parseHTML(s string) *node {
+    root := &node{Tag: "#document"}
+    current := root
+    text := func(t string) {
+        if t != "" {
+            current.Children = append(current.Children, &node{Text: html.UnescapeString(t), Parent: current})
+        }
+    }
+
+    last := 0
+    for last < len(s) {
+        m := token.FindStringSubmatchIndex(s[last:])
+        if m == nil {
+            break
+        }
+        text(s[last : last+m[0]])
+        end := last + m[1]
+        if m[4] < 0 {
+            // Comment, doctype or processing instruction.
+            last = end
+            continue
+        }
+        closing := m[3] > m[2]
+        tag := strings.ToLower(s[last+m[4] : last+m[5]])
+        attrs := s[last+m[6] : last+m[7]]
+        last = end
+
+        if closing {
+            for n := current; n != root; n = n.Parent {
+                if n.Tag == tag {
+                    current = n.Parent
+                    break
+                }
+            }
+            continue
+        }
+
+        el := &node{Tag: tag, Attrs: parseAttrs(attrs), Parent: current}
+        current.Children = append(current.Children, el)
+        if raws[tag] {
+            // Everything up to the closing tag is text.
+            closer := strings.Index(strings.ToLower(s[last:]), "</"+tag)
+            if closer < 0 {
+                closer = len(s) - last
+            }
+            if tag == "title" || tag == "textarea" {
+                el.Children = append(el.Children, &node{Text: html.UnescapeString(s[last : last+closer]), Parent: el})
+            }
+            last += closer
+            if gt := strings.Index(s[last:], ">"); gt >= 0 {
+                last += gt + 1
+            } else {
+                last = len(s)
+            }
+            continue
+        }
+        if !voids[tag] && !strings.HasSuffix(strings.TrimSpace(attrs), "/") {
+            current = el
+        }
+    }
+    if last < len(s) {
+        text(s[last:])
+    }
+    return root
+}
+
+func parseAttrs(s string) map[string]string {
+    attrs := make(map[string]string)
+    for _, m := range attribute.FindAllStringSubmatch(s, -1) {
+        name := strings.ToLower(m[1])
+        if _, ok := attrs[name]; ok || name == "/" {
+            continue
+        }
+        attrs[name] = html.UnescapeString(m[2] + m[3] + m[4])
+    }
+    return attrs
+}
+
+func (n *node) hasClass(class string) bool {
+    for _, c := range strings.Fields(n.Attrs["class"]) {
+        if c == class {
+            return true
+        }
+    }
+    return false
+}
+
+// isRoot tells whether the node is a microformat of its own, like h-card.
+func (n *node) isRoot() bool {
+    for _, c := range strings.Fields(n.Attrs["class"]) {
+        if strings.HasPrefix(c, "h-") {
+            return true
+        }
+    }
+    return false
+}
+
+// walk visits every element under n, depth first. Returning false from f
+// skips the element's children.
+func (n *node) walk(f func(*node) bool) {
+    for _, child := range n.Children {
+        if child.Tag != "" && f(child) {
+            child.walk(f)
+        }
+    }
+}
+
+// find returns the first element under n with the class. Nested
+// microformats are only looked inside when nested is true.
+func (n *node) find(class string, nested bool) *node {
+    var found *node
+    n.walk(func(el *node) bool {
+        if found != nil {
+            return false
+        }
+        if el.hasClass(class) {
+            found = el
+            return false
+        }
+        return nested || !el.isRoot()
+    })
+    return found
+}
+
+func (n *node) text() string {
+    if n.Tag == "" {
+        return n.Text
+    }
+    if n.Tag == "img" {
+        return n.Attrs["alt"]
+    }
+    var parts []string
+    for _, child := range n.Children {
+        if child.Tag != "script" && child.Tag != "style" {
+            parts = append(parts, child.text())
+        }
+    }
+    return strings.Join(parts, "")
+}
+
+func collapse(s string) string {
+    return strings.Join(strings.Fields(s), " ")
+}
+
+// links returns every URL the document points at, resolved against base.
+func (n *node) links(base *url.URL) []string {
+    var links []string
+    n.walk(func(el *node) bool {
+        for _, attr := range []string{"href", "src"} {
+            if ref, ok := el.Attrs[attr]; ok {
+                if u, err := base.Parse(strings.TrimSpace(ref)); err == nil {
+                    links = append(links, u.String())
+                }
+            }
+        }
+        return true
+    })
+    return links
+}
+
+// property reads a microformats2 property from el, using the prefix on the
+// class to decide where the value lives.
+func property(el *node, base *url.URL) string {
+    switch {
+    case el == nil:
+        return ""
+    case hasPrefix(el, "u-"):
+        for _, attr := range []string{"href", "src"} {
+            if ref, ok := el.Attrs[attr]; ok {
+                if u, err := base.Parse(strings.TrimSpace(ref)); err == nil {
+                    return u.String()
+                }
+            }
+        }
+    case hasPrefix(el, "dt-"):
+        if t, ok := el.Attrs["datetime"]; ok {
+            return t
+        }
+    }
+    if title, ok := el.Attrs["title"]; ok && el.Tag == "abbr" {
+        return title
+    }
+    if value, ok := el.Attrs["value"]; ok && (el.Tag == "data" || el.Tag == "input") {
+        return value
+    }
+    return collapse(el.text())
+}
+
+func hasPrefix(el *node, prefix string) bool {
+    for _, c := range strings.Fields(el.Attrs["class"]) {
+        if strings.HasPrefix(c, prefix) {
+            return true
+        }
+    }
+    return false
+}
+
+// Entry is what we pull out of the h-entry on the mentioning page.
+type Entry struct {
+    Name      string
+    Content   string
+    URL       string
+    Published time.Time
+    Author    Author
+    Kind      string
+}
+
+var timeFormats = []string{
+    time.RFC3339,
+    "2006-01-02T15:04:05Z0700",
+    "2006-01-02T15:04Z07:00",
+    "2006-01-02T15:04:05",
+    "2006-01-02 15:04:05",
+    "2006-01-02",
+}
+
+func parseTime(s string) time.Time {
+    s = strings.TrimSpace(s)
+    for _, layout := range timeFormats {
+        if t, err := time.Parse(layout, s); err == nil {
+            return t
+        }
+    }
+    return time.Time{}
+}
+
+// kinds are the h-entry properties that say a mention is more than a
+// mention, checked in order.
+var kinds = []struct{ Class, Kind string }{
+    {"u-in-reply-to", "reply"},
+    {"u-like-of", "like"},
+    {"u-repost-of", "repost"},
+    {"u-bookmark-of", "bookmark"},
+}
+
+// parseEntry finds the first h-entry in the document and reads it. Pages
+// without one still get an author from the first h-card, if there is one.
+func parseEntry(doc *node, base *url.URL, target string) Entry {
+    entry := Entry{Kind: "mention"}
+    root := doc.find("h-entry", true)
+    if root == nil {
+        if card := doc.find("h-card", true); card != nil {
+            entry.Author = parseCard(card, base)
+        }
+        doc.walk(func(el *node) bool {
+            if el.Tag == "title" && entry.Name == "" {
+                entry.Name = collapse(el.text())
+            }
+            return entry.Name == ""
+        })
+        return entry
+    }
+
+    entry.Name = property(root.find("p-name", false), base)
+    if content := root.find("e-content", false); content != nil {
+        entry.Content = collapse(content.text())
+    } else if summary := root.find("p-summary", false); summary != nil {
+        entry.Content = property(summary, base)
+    }
+    entry.URL = property(root.find("u-url", false), base)
+    if published := root.find("dt-published", false); published != nil {
+        entry.Published = parseTime(property(published, base))
+    }
+
+    if author := root.find("p-author", true); author != nil {
+        entry.Author = parseCard(author, base)
+    } else if author := root.find("u-author", true); author != nil {
+        entry.Author = parseCard(author, base)
+    } else if card := doc.find("h-card", true); card != nil {
+        entry.Author = parseCard(card, base)
+    }
+
+    for _, k := range kinds {
+        found := false
+        root.walk(func(el *node) bool {
+            if el.hasClass(k.Class) && sameURL(property(el, base), target) {
+                found = true
+            }
+            return !found
+        })
+        if found {
+            entry.Kind = k.Kind
+            break
+        }
+    }
+    return entry
+}
+
+// parseCard reads an author, which is either an h-card or just a link.
+func parseCard(el *node, base *url.URL) Author {
+    if !el.hasClass("h-card") {
+        author := Author{Name: collapse(el.text())}
+        if href, ok := el.Attrs["href"]; ok {
+            if u, err := base.Parse(href); err == nil {
+                author.URL = u.String()
+            }
+        }
+        return author
+    }
+    author := Author{
+        Name:  property(el.find("p-name", false), base),
+        URL:   property(el.find("u-url", false), base),
+        Photo: property(el.find("u-photo", false), base),
+    }
+    if author.Name == "" {
+        author.Name = collapse(el.text())
+    }
+    if author.URL == "" {
+        if href, ok := el.Attrs["href"]; ok {
+            if u, err := base.Parse(href); err == nil {
+                author.URL = u.String()
+            }
+        }
+    }
+    if author.Photo == "" {
+        el.walk(func(img *node) bool {
+            if img.Tag == "img" && author.Photo == "" {
+                if u, err := base.Parse(img.Attrs["src"]); err == nil {
+                    author.Photo = u.String()
+                }
+            }
+            return author.Photo == ""
+        })
+    }
+    return author
+}
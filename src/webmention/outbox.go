@@ -0,0 +1,272 @@
+package webmention
+
+import (
+    "atomicfile"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "sort"
+    "sync"
+    "time"
+)
+
+// State is how telling a target about a link went.
+type State string
+
+const (
+    Queued      State = "queued"
+    Sent        State = "sent"
+    Failed      State = "failed"
+    Unsupported State = "unsupported"
+)
+
+// Delivery is one link from one of our posts.
+type Delivery struct {
+    Target   string
+    State    State
+    Protocol string `json:",omitempty"`
+    Endpoint string `json:",omitempty"`
+    Attempts int
+    Error    string    `json:",omitempty"`
+    Next     time.Time `json:",omitempty"`
+    Sent     time.Time `json:",omitempty"`
+
+    // Removed is set when the link went away from the post. The target
+    // gets told once more so it can take the mention down.
+    Removed bool `json:",omitempty"`
+}
+
+// Outbox remembers every link we've sent, or meant to send, for each post.
+// It's the same deal as Store: a JSON file, all in memory.
+type Outbox struct {
+    Path string
+
+    lock  sync.RWMutex
+    posts map[string]map[string]*Delivery
+}
+
+// OpenOutbox loads the outbox at path, which doesn't have to exist yet.
+func OpenOutbox(path string) (*Outbox, error) {
+    o := &Outbox{Path: path, posts: make(map[string]map[string]*Delivery)}
+    data, err := ioutil.ReadFile(path)
+    switch {
+    case os.IsNotExist(err):
+    case err != nil:
+        return nil, err
+    default:
+        if err := json.Unmarshal(data, &o.posts); err != nil {
+            return nil, err
+        }
+    }
+    return o, nil
+}
+
+// Deliveries returns copies of the deliveries for a post, sorted by
+// target.
+func (o *Outbox) Deliveries(source string) []Delivery {
+    o.lock.RLock()
+    defer o.lock.RUnlock()
+    var deliveries []Delivery
+    for _, d := range o.posts[source] {
+        deliveries = append(deliveries, *d)
+    }
+    sort.Sort(byTarget(deliveries))
+    return deliveries
+}
+
+// Sources returns every post in the outbox.
+func (o *Outbox) Sources() []string {
+    o.lock.RLock()
+    defer o.lock.RUnlock()
+    var sources []string
+    for source := range o.posts {
+        sources = append(sources, source)
+    }
+    sort.Strings(sources)
+    return sources
+}
+
+// update runs f on the deliveries for source under the lock, and saves if
+// f says it changed anything.
+func (o *Outbox) update(source string, f func(map[string]*Delivery) bool) error {
+    o.lock.Lock()
+    defer o.lock.Unlock()
+    deliveries, ok := o.posts[source]
+    if !ok {
+        deliveries = make(map[string]*Delivery)
+    }
+    if !f(deliveries) {
+        return nil
+    }
+    o.posts[source] = deliveries
+    data, err := json.MarshalIndent(o.posts, "", "  ")
+    if err != nil {
+        return err
+    }
+    return atomicfile.WriteFile(o.Path, data, 0644)
+}
+
+type byTarget []Delivery
+
+func (b byTarget) Len() int           { return len(b) }
+func (b byTarget) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byTarget) Less(i, j int) bool { return b[i].Target < b[j].Target }
+
+// Sender tells other sites when our posts link to them.
+type Sender struct {
+    Outbox *Outbox
+    Client *http.Client
+
+    // Backoff is how long to wait after the first failure. It doubles
+    // every time after that, until MaxAttempts have gone by.
+    Backoff     time.Duration
+    MaxAttempts int
+
+    delivering sync.Mutex
+}
+
+// NewSender makes a sender with the same careful client as NewReceiver.
+func NewSender(outbox *Outbox) *Sender {
+    return &Sender{
+        Outbox:      outbox,
+        Client:      PublicClient(10 * time.Second),
+        Backoff:     time.Minute,
+        MaxAttempts: 8,
+    }
+}
+
+// Queue lines up the links of a post that haven't been sent yet, and the
+// ones that were sent but are gone now. Links it already knows about are
+// left alone, so queueing the same post over and over is fine.
+func (s *Sender) Queue(source string, links []string) error {
+    now := time.Now()
+    return s.Outbox.update(source, func(deliveries map[string]*Delivery) bool {
+        changed := false
+        current := make(map[string]bool)
+        for _, link := range links {
+            current[link] = true
+            d, ok := deliveries[link]
+            switch {
+            case !ok:
+                deliveries[link] = &Delivery{Target: link, State: Queued, Next: now}
+            case d.Removed:
+                *d = Delivery{Target: link, State: Queued, Next: now}
+            default:
+                continue
+            }
+            changed = true
+        }
+        for target, d := range deliveries {
+            if !current[target] && !d.Removed && d.State == Sent {
+                *d = Delivery{Target: target, State: Queued, Next: now, Removed: true}
+                changed = true
+            }
+        }
+        return changed
+    })
+}
+
+// Deliver sends everything that's due, one at a time.
+func (s *Sender) Deliver() {
+    s.delivering.Lock()
+    defer s.delivering.Unlock()
+    for _, source := range s.Outbox.Sources() {
+        for _, d := range s.Outbox.Deliveries(source) {
+            if d.State != Queued || time.Now().Before(d.Next) {
+                continue
+            }
+            s.attempt(source, d)
+        }
+    }
+}
+
+func (s *Sender) attempt(source string, d Delivery) {
+    d.Attempts++
+    endpoint, err := Discover(s.Client, d.Target)
+    if err == nil && endpoint != nil {
+        d.Protocol, d.Endpoint = endpoint.Protocol, endpoint.URL
+        err = Send(s.Client, endpoint, source, d.Target)
+    }
+
+    switch {
+    case err != nil:
+        d.Error = err.Error()
+        if d.Attempts >= s.MaxAttempts {
+            d.State = Failed
+            logger.Printf("gave up telling %s about %s: %s", d.Target, source, err)
+        } else {
+            d.Next = time.Now().Add(s.Backoff << uint(d.Attempts-1))
+        }
+    case endpoint == nil:
+        d.State, d.Error = Unsupported, ""
+    default:
+        d.State, d.Error, d.Sent = Sent, "", time.Now()
+        logger.Printf("told %s about %s by %s", d.Target, source, d.Protocol)
+    }
+
+    // Queue may have had its way with the delivery while we were at it: if
+    // it's not the one we tried anymore, what happened doesn't apply.
+    err = s.Outbox.update(source, func(deliveries map[string]*Delivery) bool {
+        current, ok := deliveries[d.Target]
+        if !ok || current.State != Queued || current.Removed != d.Removed || current.Attempts != d.Attempts-1 {
+            return false
+        }
+        *current = d
+        return true
+    })
+    if err != nil {
+        logger.Printf("failed saving the outbox: %s", err)
+    }
+}
+
+// Report is what would happen to one link.
+type Report struct {
+    Delivery
+    Would string
+}
+
+func (r Report) String() string {
+    return fmt.Sprintf("%s: %s", r.Target, r.Would)
+}
+
+// DryRun works out what sending the links of a post would do, finding
+// endpoints without sending anything or touching the outbox.
+func (s *Sender) DryRun(source string, links []string) []Report {
+    known := make(map[string]Delivery)
+    for _, d := range s.Outbox.Deliveries(source) {
+        known[d.Target] = d
+    }
+    var reports []Report
+    for _, link := range links {
+        d, ok := known[link]
+        if !ok {
+            d = Delivery{Target: link}
+        }
+        r := Report{Delivery: d}
+        switch {
+        case d.State != "" && d.State != Queued:
+            r.Would = fmt.Sprintf("nothing, already %s", d.State)
+        default:
+            endpoint, err := Discover(s.Client, link)
+            switch {
+            case err != nil:
+                r.Would, r.Error = fmt.Sprintf("retry, discovery failed: %s", err), err.Error()
+            case endpoint == nil:
+                r.Would = "nothing, no endpoint"
+            default:
+                r.Protocol, r.Endpoint = endpoint.Protocol, endpoint.URL
+                r.Would = fmt.Sprintf("send a %s to %s", endpoint.Protocol, endpoint.URL)
+            }
+        }
+        reports = append(reports, r)
+        delete(known, link)
+    }
+    for _, d := range known {
+        if d.State == Sent && !d.Removed {
+            reports = append(reports, Report{d, "send again, the link was removed"})
+        }
+    }
+    return reports
+}
@@ -0,0 +1,256 @@
+package webmention
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "mime"
+    "net"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+)
+
+const (
+    // MaxSourceSize is as much of a source page as gets read.
+    MaxSourceSize = 1 << 20
+    UserAgent     = "verboselogging webmention (+http://verboselogging.com/webmention)"
+)
+
+var (
+    ErrBadSource   = errors.New("source must be an http or https URL")
+    ErrBadTarget   = errors.New("target must be an http or https URL")
+    ErrSame        = errors.New("source and target are the same")
+    ErrWrongTarget = errors.New("target isn't a post here")
+    ErrPrivate     = errors.New("source is on a private network")
+)
+
+// Receiver takes mentions in and verifies them in the background.
+type Receiver struct {
+    Store  *Store
+    Client *http.Client
+
+    // Accept tells whether a target is something here that can be
+    // mentioned.
+    Accept func(target *url.URL) bool
+
+    queue chan string
+}
+
+// NewReceiver makes a receiver whose client won't fetch anything on a
+// private or loopback address, so nobody can use us to poke around inside
+// the network we run on.
+func NewReceiver(store *Store, accept func(*url.URL) bool) *Receiver {
+    return &Receiver{
+        Store:  store,
+        Accept: accept,
+        Client: PublicClient(10 * time.Second),
+        queue:  make(chan string, 256),
+    }
+}
+
+// PublicClient is an HTTP client that refuses to connect to private,
+// loopback or link local addresses, even through a redirect.
+func PublicClient(timeout time.Duration) *http.Client {
+    dialer := &net.Dialer{Timeout: timeout}
+    return &http.Client{
+        Timeout: timeout,
+        Transport: &http.Transport{
+            Proxy: http.ProxyFromEnvironment,
+            Dial: func(network, addr string) (net.Conn, error) {
+                host, port, err := net.SplitHostPort(addr)
+                if err != nil {
+                    return nil, err
+                }
+                ips, err := net.LookupIP(host)
+                if err != nil {
+                    return nil, err
+                }
+                for _, ip := range ips {
+                    if private(ip) {
+                        return nil, ErrPrivate
+                    }
+                }
+                return dialer.Dial(network, net.JoinHostPort(ips[0].String(), port))
+            },
+        },
+    }
+}
+
+var privateNets []*net.IPNet
+
+func init() {
+    for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7", "fe80::/10", "169.254.0.0/16"} {
+        _, n, _ := net.ParseCIDR(cidr)
+        privateNets = append(privateNets, n)
+    }
+}
+
+func private(ip net.IP) bool {
+    if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() {
+        return true
+    }
+    for _, n := range privateNets {
+        if n.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// Start runs the workers that verify mentions, and queues up any that were
+// still waiting when we last stopped.
+func (r *Receiver) Start(workers int) {
+    for i := 0; i < workers; i++ {
+        go r.work()
+    }
+    go func() {
+        for _, m := range r.Store.List() {
+            if m.Verification == Unverified {
+                r.queue <- m.Id
+            }
+        }
+    }()
+}
+
+func (r *Receiver) work() {
+    for id := range r.queue {
+        if err := r.Verify(id); err != nil {
+            logger.Printf("failed verifying mention %s: %s", id, err)
+        }
+    }
+}
+
+func httpURL(s string) (*url.URL, bool) {
+    u, err := url.Parse(s)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return nil, false
+    }
+    return u, true
+}
+
+// Receive checks a source and target pair over and queues it for
+// verification. Sending the same pair again queues it again, which is how
+// senders tell us a page changed or went away.
+func (r *Receiver) Receive(source, target string) (*Mention, error) {
+    s, ok := httpURL(source)
+    if !ok {
+        return nil, ErrBadSource
+    }
+    t, ok := httpURL(target)
+    if !ok {
+        return nil, ErrBadTarget
+    }
+    s.Fragment, t.Fragment = "", ""
+    if s.String() == t.String() {
+        return nil, ErrSame
+    }
+    if !r.Accept(t) {
+        return nil, ErrWrongTarget
+    }
+
+    m, ok := r.Store.Get(Id(source, target))
+    if !ok {
+        m = &Mention{Id: Id(source, target), Source: source, Target: target, Status: Pending}
+    }
+    m.Verification = Unverified
+    m.Received = time.Now()
+    if err := r.Store.Put(m); err != nil {
+        return nil, err
+    }
+
+    select {
+    case r.queue <- m.Id:
+    default:
+        logger.Printf("queue is full, mention %s waits for a restart", m.Id)
+    }
+    return m, nil
+}
+
+// Verify fetches the source of a mention and checks that it links to the
+// target, reading the h-entry while it's there. The fetch can take a while,
+// so only what it found is saved: anything moderated meanwhile stays.
+func (r *Receiver) Verify(id string) error {
+    m, ok := r.Store.Get(id)
+    if !ok {
+        return fmt.Errorf("no mention %s", id)
+    }
+
+    entry, checkErr := r.check(m)
+    err := r.Store.Update(id, func(m *Mention) {
+        m.Checked = time.Now()
+        m.Error = ""
+        switch {
+        case checkErr == errGone:
+            m.Verification = Gone
+        case checkErr != nil:
+            m.Verification = Invalid
+            m.Error = checkErr.Error()
+        default:
+            m.Verification = Verified
+            m.Entry = entry
+        }
+    })
+    if err == ErrNoMention {
+        // Deleted while it was being checked.
+        return nil
+    }
+    return err
+}
+
+var errGone = errors.New("source is gone")
+
+func (r *Receiver) check(m *Mention) (Entry, error) {
+    req, err := http.NewRequest("GET", m.Source, nil)
+    if err != nil {
+        return Entry{}, err
+    }
+    req.Header.Set("Accept", "text/html, text/plain;q=0.5")
+    req.Header.Set("User-Agent", UserAgent)
+    resp, err := r.Client.Do(req)
+    if err != nil {
+        return Entry{}, err
+    }
+    defer resp.Body.Close()
+
+    switch {
+    case resp.StatusCode == http.StatusGone:
+        return Entry{}, errGone
+    case resp.StatusCode < 200 || resp.StatusCode > 299:
+        return Entry{}, fmt.Errorf("source responded with %s", resp.Status)
+    }
+    body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxSourceSize))
+    if err != nil {
+        return Entry{}, err
+    }
+
+    mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+    if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+        if strings.Contains(string(body), m.Target) {
+            return Entry{Kind: "mention"}, nil
+        }
+        return Entry{}, errors.New("source doesn't link to target")
+    }
+
+    doc, base := parseHTML(string(body)), resp.Request.URL
+    for _, link := range doc.links(base) {
+        if sameURL(link, m.Target) {
+            return parseEntry(doc, base, m.Target), nil
+        }
+    }
+    return Entry{}, errors.New("source doesn't link to target")
+}
+
+// sameURL compares links loosely: fragments and a trailing slash don't
+// count.
+func sameURL(a, b string) bool {
+    clean := func(s string) string {
+        if i := strings.Index(s, "#"); i >= 0 {
+            s = s[:i]
+        }
+        return strings.TrimSuffix(s, "/")
+    }
+    return clean(a) == clean(b)
+}
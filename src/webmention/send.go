@@ -0,0 +1,239 @@
+package webmention
+
+import (
+    "bytes"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "mime"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strings"
+)
+
+const (
+    ProtocolWebmention = "webmention"
+    ProtocolPingback   = "pingback"
+)
+
+var (
+    linkHeader = regexp.MustCompile(`<([^>]*)>((?:\s*;\s*[^;,]*)*)`)
+    relParam   = regexp.MustCompile(`(?i)\brel\s*=\s*(?:"([^"]*)"|([^\s;,]*))`)
+)
+
+// Endpoint is where to tell a target about a link to it.
+type Endpoint struct {
+    Protocol string
+    URL      string
+}
+
+// ExternalLinks returns the http links in a post's HTML that go somewhere
+// other than base's host, once each and in order.
+func ExternalLinks(html string, base *url.URL) []string {
+    var links []string
+    seen := make(map[string]bool)
+    parseHTML(html).walk(func(el *node) bool {
+        href, ok := el.Attrs["href"]
+        if el.Tag != "a" || !ok {
+            return true
+        }
+        u, err := base.Parse(strings.TrimSpace(href))
+        if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == base.Host {
+            return true
+        }
+        u.Fragment = ""
+        if link := u.String(); !seen[link] {
+            seen[link] = true
+            links = append(links, link)
+        }
+        return true
+    })
+    return links
+}
+
+// Discover finds the endpoint for target, preferring Webmention to
+// pingback. It returns nil without an error when the target takes neither.
+func Discover(client *http.Client, target string) (*Endpoint, error) {
+    req, err := http.NewRequest("GET", target, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Accept", "text/html, */*;q=0.5")
+    req.Header.Set("User-Agent", UserAgent)
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return nil, fmt.Errorf("target responded with %s", resp.Status)
+    }
+    base := resp.Request.URL
+
+    for _, header := range resp.Header[http.CanonicalHeaderKey("Link")] {
+        for _, m := range linkHeader.FindAllStringSubmatch(header, -1) {
+            if hasRel(relOf(m[2]), ProtocolWebmention) {
+                return resolve(ProtocolWebmention, base, m[1])
+            }
+        }
+    }
+
+    var doc *node
+    mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+    if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+        body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxSourceSize))
+        if err != nil {
+            return nil, err
+        }
+        doc = parseHTML(string(body))
+        if href, ok := findRel(doc, ProtocolWebmention, "link", "a"); ok {
+            return resolve(ProtocolWebmention, base, href)
+        }
+    }
+
+    if href := resp.Header.Get("X-Pingback"); href != "" {
+        return resolve(ProtocolPingback, base, href)
+    }
+    if doc != nil {
+        if href, ok := findRel(doc, ProtocolPingback, "link"); ok {
+            return resolve(ProtocolPingback, base, href)
+        }
+    }
+    return nil, nil
+}
+
+func relOf(params string) string {
+    if m := relParam.FindStringSubmatch(params); m != nil {
+        return m[1] + m[2]
+    }
+    return ""
+}
+
+func hasRel(rel, want string) bool {
+    for _, r := range strings.Fields(strings.ToLower(rel)) {
+        if r == want || (want == ProtocolWebmention && r == "http://webmention.org/") {
+            return true
+        }
+    }
+    return false
+}
+
+// findRel returns the href of the first of the tags with the rel.
+func findRel(doc *node, rel string, tags ...string) (string, bool) {
+    var href string
+    found := false
+    doc.walk(func(el *node) bool {
+        if found {
+            return false
+        }
+        for _, tag := range tags {
+            if h, ok := el.Attrs["href"]; ok && el.Tag == tag && hasRel(el.Attrs["rel"], rel) {
+                href, found = h, true
+            }
+        }
+        return !found
+    })
+    return href, found
+}
+
+// resolve makes the endpoint absolute. An empty href means the target is
+// its own endpoint.
+func resolve(protocol string, base *url.URL, href string) (*Endpoint, error) {
+    u, err := base.Parse(strings.TrimSpace(href))
+    if err != nil {
+        return nil, err
+    }
+    return &Endpoint{protocol, u.String()}, nil
+}
+
+// Send tells the endpoint that source links to target.
+func Send(client *http.Client, endpoint *Endpoint, source, target string) error {
+    switch endpoint.Protocol {
+    case ProtocolWebmention:
+        return sendWebmention(client, endpoint.URL, source, target)
+    case ProtocolPingback:
+        return sendPingback(client, endpoint.URL, source, target)
+    }
+    return fmt.Errorf("unknown protocol %#v", endpoint.Protocol)
+}
+
+func sendWebmention(client *http.Client, endpoint, source, target string) error {
+    form := url.Values{"source": {source}, "target": {target}}
+    req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.Header.Set("User-Agent", UserAgent)
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("endpoint responded with %s", resp.Status)
+    }
+    return nil
+}
+
+// pingbackAlreadyRegistered is the fault code for a ping the target has
+// already seen, which is as good as a success.
+const pingbackAlreadyRegistered = 48
+
+type pingbackResponse struct {
+    Fault []struct {
+        Name  string `xml:"name"`
+        Int   string `xml:"value>int"`
+        I4    string `xml:"value>i4"`
+        Value string `xml:"value>string"`
+    } `xml:"fault>value>struct>member"`
+}
+
+func sendPingback(client *http.Client, endpoint, source, target string) error {
+    var body bytes.Buffer
+    body.WriteString(`<?xml version="1.0"?><methodCall><methodName>pingback.ping</methodName><params>`)
+    for _, param := range []string{source, target} {
+        body.WriteString("<param><value><string>")
+        xml.EscapeText(&body, []byte(param))
+        body.WriteString("</string></value></param>")
+    }
+    body.WriteString("</params></methodCall>")
+
+    req, err := http.NewRequest("POST", endpoint, &body)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "text/xml")
+    req.Header.Set("User-Agent", UserAgent)
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("endpoint responded with %s", resp.Status)
+    }
+
+    var result pingbackResponse
+    if err := xml.NewDecoder(io.LimitReader(resp.Body, MaxSourceSize)).Decode(&result); err != nil {
+        return err
+    }
+    if len(result.Fault) == 0 {
+        return nil
+    }
+    var code, message string
+    for _, member := range result.Fault {
+        switch member.Name {
+        case "faultCode":
+            code = member.Int + member.I4
+        case "faultString":
+            message = member.Value
+        }
+    }
+    if code == fmt.Sprint(pingbackAlreadyRegistered) {
+        return nil
+    }
+    return fmt.Errorf("pingback fault %s: %s", code, message)
+}
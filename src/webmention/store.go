@@ -0,0 +1,177 @@
+package webmention
+
+import (
+    "atomicfile"
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "os"
+    "sort"
+    "sync"
+)
+
+var (
+    lock    sync.RWMutex
+    current *Store
+)
+
+// ErrNoMention is Update being asked for a mention that isn't there,
+// maybe because it was deleted in the meantime.
+var ErrNoMention = errors.New("no such mention")
+
+// Store keeps every mention in a JSON file. There aren't going to be that
+// many, so it's all in memory and the whole file is written on each change.
+type Store struct {
+    Path     string
+    lock     sync.RWMutex
+    mentions map[string]Mention
+}
+
+// Open loads the store at path, which doesn't have to exist yet, and makes
+// it the one For looks in.
+func Open(path string) (*Store, error) {
+    s := &Store{Path: path, mentions: make(map[string]Mention)}
+    data, err := ioutil.ReadFile(path)
+    switch {
+    case os.IsNotExist(err):
+    case err != nil:
+        return nil, err
+    default:
+        var mentions []Mention
+        if err := json.Unmarshal(data, &mentions); err != nil {
+            return nil, err
+        }
+        for _, m := range mentions {
+            s.mentions[m.Id] = m
+        }
+    }
+    lock.Lock()
+    current = s
+    lock.Unlock()
+    return s, nil
+}
+
+// For returns the mentions of target that should be shown, from the store
+// that was opened last.
+func For(target string) []*Mention {
+    lock.RLock()
+    s := current
+    lock.RUnlock()
+    if s == nil {
+        return nil
+    }
+    return s.For(target)
+}
+
+// Get returns a copy of a mention.
+func (s *Store) Get(id string) (*Mention, bool) {
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    m, ok := s.mentions[id]
+    return &m, ok
+}
+
+// Put adds or replaces a mention and saves the store.
+func (s *Store) Put(m *Mention) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.mentions[m.Id] = *m
+    return s.save()
+}
+
+// Update runs f on the mention with id under the lock, then saves it. It's
+// for changing part of a mention without undoing whatever else changed it
+// since it was read.
+func (s *Store) Update(id string, f func(m *Mention)) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    m, ok := s.mentions[id]
+    if !ok {
+        return ErrNoMention
+    }
+    f(&m)
+    s.mentions[id] = m
+    return s.save()
+}
+
+// Delete removes a mention and saves the store.
+func (s *Store) Delete(id string) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    delete(s.mentions, id)
+    return s.save()
+}
+
+// List returns every mention, newest first.
+func (s *Store) List() []*Mention {
+    return s.filter(func(*Mention) bool { return true }, byReceived)
+}
+
+// For returns the visible mentions of target, in the order they were
+// written.
+func (s *Store) For(target string) []*Mention {
+    return s.filter(func(m *Mention) bool {
+        return m.Target == target && m.Visible()
+    }, byPublished)
+}
+
+func (s *Store) filter(keep func(*Mention) bool, less func(a, b *Mention) bool) []*Mention {
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    var mentions []*Mention
+    for _, m := range s.mentions {
+        m := m
+        if keep(&m) {
+            mentions = append(mentions, &m)
+        }
+    }
+    sort.Sort(sorter{mentions, less})
+    return mentions
+}
+
+// save writes the whole store atomically. The lock must be held.
+func (s *Store) save() error {
+    mentions := make([]*Mention, 0, len(s.mentions))
+    for id := range s.mentions {
+        m := s.mentions[id]
+        mentions = append(mentions, &m)
+    }
+    sort.Sort(sorter{mentions, byReceived})
+    data, err := json.MarshalIndent(mentions, "", "  ")
+    if err != nil {
+        return err
+    }
+    return atomicfile.WriteFile(s.Path, data, 0644)
+}
+
+type sorter struct {
+    mentions []*Mention
+    less     func(a, b *Mention) bool
+}
+
+func (s sorter) Len() int           { return len(s.mentions) }
+func (s sorter) Swap(i, j int)      { s.mentions[i], s.mentions[j] = s.mentions[j], s.mentions[i] }
+func (s sorter) Less(i, j int) bool { return s.less(s.mentions[i], s.mentions[j]) }
+
+func byReceived(a, b *Mention) bool {
+    if a.Received.Equal(b.Received) {
+        return a.Id < b.Id
+    }
+    return a.Received.After(b.Received)
+}
+
+// byPublished falls back to when we got the mention for pages that don't
+// say when they were written.
+func byPublished(a, b *Mention) bool {
+    at, bt := a.Published, b.Published
+    if at.IsZero() {
+        at = a.Received
+    }
+    if bt.IsZero() {
+        bt = b.Received
+    }
+    if at.Equal(bt) {
+        return a.Id < b.Id
+    }
+    return at.Before(bt)
+}
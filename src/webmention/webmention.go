@@ -0,0 +1,79 @@
+// Package webmention takes part in IndieWeb conversations. Other sites tell
+// us they linked to a post, we check that they really did, and the ones that
+// pass moderation show up under the post.
+//
+// See http://webmention.net/draft/ for the protocol.
+package webmention
+
+import (
+    "crypto/sha1"
+    "fmt"
+    "logging"
+    "time"
+)
+
+var logger = logging.New("webmention")
+
+// Status is where a mention is in moderation.
+type Status string
+
+const (
+    Pending  Status = "pending"
+    Approved Status = "approved"
+    Rejected Status = "rejected"
+)
+
+// Verification is what we found when we went and looked at the source.
+type Verification string
+
+const (
+    Unverified Verification = "unverified"
+    Verified   Verification = "verified"
+    Invalid    Verification = "invalid"
+    Gone       Verification = "gone"
+)
+
+// Author is whoever wrote the mentioning page, from its h-card.
+type Author struct {
+    Name, URL, Photo string
+}
+
+// Mention is a source page that links to one of our posts.
+type Mention struct {
+    Id           string
+    Source       string
+    Target       string
+    Status       Status
+    Verification Verification
+    Error        string `json:",omitempty"`
+    Received     time.Time
+    Checked      time.Time
+    Entry
+}
+
+// Visible reports whether the mention should show up under the post.
+func (m *Mention) Visible() bool {
+    return m.Status == Approved && m.Verification == Verified
+}
+
+var verbs = map[string]string{
+    "reply":    "replied",
+    "like":     "liked this",
+    "repost":   "reposted this",
+    "bookmark": "bookmarked this",
+}
+
+// Verb says what the author did, for showing under the post.
+func (m *Mention) Verb() string {
+    if verb, ok := verbs[m.Kind]; ok {
+        return verb
+    }
+    return "mentioned this"
+}
+
+// Id is the same for every mention of target from source, so sending one
+// again updates it instead of piling up duplicates.
+func Id(source, target string) string {
+    sum := sha1.Sum([]byte(source + "\n" + target))
+    return fmt.Sprintf("%x", sum[:8])
+}
@@ -0,0 +1,352 @@
+package webmention_test
+
+import (
+    "fmt"
+    "io/ioutil"
+    . "launchpad.net/gocheck"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+    "webmention"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+const target = "http://verboselogging.com/2013/05/01/ruby-batteries-included"
+
+const reply = `<!DOCTYPE html>
+<html>
+<head><title>Re: batteries</title></head>
+<body>
+<article class="h-entry">
+  <h1 class="p-name">Re: batteries</h1>
+  <a class="p-author h-card" href="/about"><img class="u-photo" src="/me.jpg" alt=""> Jane Doe</a>
+  <time class="dt-published" datetime="2013-05-02T08:30:00-06:00">May 2</time>
+  <p>In reply to <a class="u-in-reply-to" href="%s">Ruby Batteries Included</a></p>
+  <div class="e-content">Great post &amp; <b>thanks</b>
+    for the   slides!</div>
+  <script>var nope = "</div>";</script>
+  <a class="u-url" href="/replies/1">permalink</a>
+</article>
+</body>
+</html>`
+
+type WebmentionSuite struct {
+    server   *httptest.Server
+    pages    map[string]string
+    fetching func()
+    store    *webmention.Store
+    receiver *webmention.Receiver
+}
+
+var _ = Suite(&WebmentionSuite{})
+
+func (s *WebmentionSuite) SetUpTest(c *C) {
+    s.pages = make(map[string]string)
+    s.fetching = nil
+    s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if s.fetching != nil {
+            s.fetching()
+        }
+        switch page, ok := s.pages[r.URL.Path]; {
+        case r.URL.Path == "/gone":
+            w.WriteHeader(http.StatusGone)
+        case !ok:
+            http.NotFound(w, r)
+        default:
+            w.Header().Set("Content-Type", "text/html; charset=utf-8")
+            fmt.Fprint(w, page)
+        }
+    }))
+
+    var err error
+    s.store, err = webmention.Open(filepath.Join(c.MkDir(), "webmentions.json"))
+    c.Assert(err, IsNil)
+    s.receiver = webmention.NewReceiver(s.store, func(u *url.URL) bool {
+        return u.Host == "verboselogging.com" && strings.HasPrefix(u.Path, "/2013/")
+    })
+    // The stand-in is on localhost, which the real client won't touch.
+    s.receiver.Client = http.DefaultClient
+}
+
+func (s *WebmentionSuite) TearDownTest(c *C) {
+    s.server.Close()
+}
+
+func (s *WebmentionSuite) receive(c *C, path string) *webmention.Mention {
+    m, err := s.receiver.Receive(s.server.URL+path, target)
+    c.Assert(err, IsNil)
+    c.Assert(s.receiver.Verify(m.Id), IsNil)
+    m, ok := s.store.Get(m.Id)
+    c.Assert(ok, Equals, true)
+    return m
+}
+
+func (s *WebmentionSuite) TestReply(c *C) {
+    s.pages["/reply"] = fmt.Sprintf(reply, target)
+    m := s.receive(c, "/reply")
+    c.Check(m.Verification, Equals, webmention.Verified)
+    c.Check(m.Status, Equals, webmention.Pending)
+    c.Check(m.Kind, Equals, "reply")
+    c.Check(m.Name, Equals, "Re: batteries")
+    c.Check(m.Content, Equals, "Great post & thanks for the slides!")
+    c.Check(m.URL, Equals, s.server.URL+"/replies/1")
+    c.Check(m.Published.Format("2006-01-02 15:04"), Equals, "2013-05-02 08:30")
+    c.Check(m.Author, Equals, webmention.Author{"Jane Doe", s.server.URL + "/about", s.server.URL + "/me.jpg"})
+
+    c.Check(webmention.For(target), HasLen, 0)
+    m.Status = webmention.Approved
+    c.Assert(s.store.Put(m), IsNil)
+    c.Check(webmention.For(target), HasLen, 1)
+
+    // Coming back after a change keeps the moderation decision.
+    m = s.receive(c, "/reply")
+    c.Check(m.Status, Equals, webmention.Approved)
+    c.Check(m.Visible(), Equals, true)
+
+    reopened, err := webmention.Open(s.store.Path)
+    c.Assert(err, IsNil)
+    c.Check(reopened.For(target), HasLen, 1)
+}
+
+func (s *WebmentionSuite) TestModeratedWhileVerifying(c *C) {
+    s.pages["/reply"] = fmt.Sprintf(reply, target)
+    m, err := s.receiver.Receive(s.server.URL+"/reply", target)
+    c.Assert(err, IsNil)
+    s.fetching = func() {
+        c.Check(s.store.Update(m.Id, func(m *webmention.Mention) { m.Status = webmention.Approved }), IsNil)
+    }
+    c.Assert(s.receiver.Verify(m.Id), IsNil)
+    m, _ = s.store.Get(m.Id)
+    c.Check(m.Status, Equals, webmention.Approved)
+    c.Check(m.Verification, Equals, webmention.Verified)
+    c.Check(m.Kind, Equals, "reply")
+
+    // Deleted meanwhile, it stays deleted.
+    s.fetching = func() { c.Check(s.store.Delete(m.Id), IsNil) }
+    c.Assert(s.receiver.Verify(m.Id), IsNil)
+    _, ok := s.store.Get(m.Id)
+    c.Check(ok, Equals, false)
+}
+
+func (s *WebmentionSuite) TestNoLink(c *C) {
+    s.pages["/elsewhere"] = fmt.Sprintf(reply, "http://example.com/")
+    m := s.receive(c, "/elsewhere")
+    c.Check(m.Verification, Equals, webmention.Invalid)
+    c.Check(m.Error, Equals, "source doesn't link to target")
+}
+
+func (s *WebmentionSuite) TestPlainMention(c *C) {
+    s.pages["/plain"] = fmt.Sprintf(`<html><head><title>Links</title></head><body><p>See <a href="%s#comments">this</a>.</p></body></html>`, target)
+    m := s.receive(c, "/plain")
+    c.Check(m.Verification, Equals, webmention.Verified)
+    c.Check(m.Kind, Equals, "mention")
+    c.Check(m.Name, Equals, "Links")
+}
+
+func (s *WebmentionSuite) TestGone(c *C) {
+    m := s.receive(c, "/gone")
+    c.Check(m.Verification, Equals, webmention.Gone)
+    c.Check(m.Visible(), Equals, false)
+}
+
+func (s *WebmentionSuite) TestReceiveChecks(c *C) {
+    _, err := s.receiver.Receive("ftp://example.com/", target)
+    c.Check(err, Equals, webmention.ErrBadSource)
+    _, err = s.receiver.Receive(target+"#comments", target)
+    c.Check(err, Equals, webmention.ErrSame)
+    _, err = s.receiver.Receive("http://example.com/", "http://example.com/2013/")
+    c.Check(err, Equals, webmention.ErrWrongTarget)
+    _, err = s.receiver.Receive("http://example.com/", "javascript:alert(1)")
+    c.Check(err, Equals, webmention.ErrBadTarget)
+}
+
+func (s *WebmentionSuite) TestPrivateSources(c *C) {
+    s.pages["/reply"] = fmt.Sprintf(reply, target)
+    s.receiver.Client = webmention.PublicClient(0)
+    m := s.receive(c, "/reply")
+    c.Check(m.Verification, Equals, webmention.Invalid)
+    c.Check(m.Error, Matches, ".*private network.*")
+}
+
+type SendSuite struct {
+    server   *httptest.Server
+    received []url.Values
+    pings    []string
+    sender   *webmention.Sender
+    sending  func()
+}
+
+var _ = Suite(&SendSuite{})
+
+const pingbackFault = `<?xml version="1.0"?>
+<methodResponse><fault><value><struct>
+<member><name>faultCode</name><value><int>%d</int></value></member>
+<member><name>faultString</name><value><string>nope</string></value></member>
+</struct></value></fault></methodResponse>`
+
+func (s *SendSuite) SetUpTest(c *C) {
+    s.received, s.pings, s.sending = nil, nil, nil
+    s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/header":
+            w.Header().Set("Link", `<http://example.com/a>; rel="other", </endpoint?from=header>; rel="webmention"`)
+            fmt.Fprint(w, "plain")
+        case "/html":
+            w.Header().Set("Content-Type", "text/html")
+            fmt.Fprint(w, `<html><head><link rel="pingback" href="/xmlrpc"><link rel="stylesheet webmention" href="endpoint"></head></html>`)
+        case "/pingback":
+            w.Header().Set("Content-Type", "text/html")
+            w.Header().Set("X-Pingback", s.server.URL+"/xmlrpc")
+        case "/registered":
+            w.Header().Set("Content-Type", "text/html")
+            fmt.Fprint(w, `<link rel="pingback" href="/xmlrpc?fault=48">`)
+        case "/broken":
+            w.Header().Set("Link", `</endpoint?fail=1>; rel=webmention`)
+        case "/endpoint":
+            if r.URL.Query().Get("fail") != "" {
+                http.Error(w, "oops", http.StatusInternalServerError)
+                return
+            }
+            r.ParseForm()
+            s.received = append(s.received, r.PostForm)
+            if s.sending != nil {
+                s.sending()
+            }
+            w.WriteHeader(http.StatusAccepted)
+        case "/xmlrpc":
+            body, _ := ioutil.ReadAll(r.Body)
+            s.pings = append(s.pings, string(body))
+            if r.URL.Query().Get("fault") != "" {
+                fmt.Fprintf(w, pingbackFault, 48)
+                return
+            }
+            fmt.Fprint(w, `<?xml version="1.0"?><methodResponse><params><param><value><string>ok</string></value></param></params></methodResponse>`)
+        case "/nothing":
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+
+    outbox, err := webmention.OpenOutbox(filepath.Join(c.MkDir(), "outbox.json"))
+    c.Assert(err, IsNil)
+    s.sender = webmention.NewSender(outbox)
+    s.sender.Client = http.DefaultClient
+    s.sender.Backoff = time.Hour
+}
+
+func (s *SendSuite) TearDownTest(c *C) {
+    s.server.Close()
+}
+
+func (s *SendSuite) TestExternalLinks(c *C) {
+    base, _ := url.Parse(target)
+    html := `<p><a href="http://example.com/a#x">a</a> <a href="/2013/">here</a> <a href="mailto:me@example.com">me</a>
+        <img src="http://example.com/i.png"> <a href="http://example.com/a">again</a> <a href="https://example.org/">b</a></p>`
+    c.Check(webmention.ExternalLinks(html, base), DeepEquals, []string{"http://example.com/a", "https://example.org/"})
+}
+
+func (s *SendSuite) TestDiscover(c *C) {
+    for path, want := range map[string]webmention.Endpoint{
+        "/header":   {webmention.ProtocolWebmention, s.server.URL + "/endpoint?from=header"},
+        "/html":     {webmention.ProtocolWebmention, s.server.URL + "/endpoint"},
+        "/pingback": {webmention.ProtocolPingback, s.server.URL + "/xmlrpc"},
+    } {
+        endpoint, err := webmention.Discover(http.DefaultClient, s.server.URL+path)
+        c.Assert(err, IsNil)
+        c.Assert(endpoint, NotNil)
+        c.Check(*endpoint, Equals, want)
+    }
+    endpoint, err := webmention.Discover(http.DefaultClient, s.server.URL+"/nothing")
+    c.Check(endpoint, IsNil)
+    c.Check(err, IsNil)
+}
+
+func (s *SendSuite) deliveries() map[string]webmention.Delivery {
+    deliveries := make(map[string]webmention.Delivery)
+    for _, d := range s.sender.Outbox.Deliveries(target) {
+        deliveries[strings.TrimPrefix(d.Target, s.server.URL)] = d
+    }
+    return deliveries
+}
+
+func (s *SendSuite) TestDeliver(c *C) {
+    links := []string{s.server.URL + "/header", s.server.URL + "/registered", s.server.URL + "/broken", s.server.URL + "/nothing"}
+    c.Assert(s.sender.Queue(target, links), IsNil)
+
+    reports := s.sender.DryRun(target, links)
+    c.Assert(reports, HasLen, 4)
+    c.Check(reports[0].Would, Equals, "send a webmention to "+s.server.URL+"/endpoint?from=header")
+    c.Check(reports[3].Would, Equals, "nothing, no endpoint")
+    c.Check(s.received, HasLen, 0)
+
+    s.sender.Deliver()
+    c.Assert(s.received, HasLen, 1)
+    c.Check(s.received[0].Get("source"), Equals, target)
+    c.Check(s.received[0].Get("target"), Equals, s.server.URL+"/header")
+    c.Assert(s.pings, HasLen, 1)
+    c.Check(s.pings[0], Matches, `(?s).*<string>`+target+`</string>.*`)
+
+    deliveries := s.deliveries()
+    c.Check(deliveries["/header"].State, Equals, webmention.Sent)
+    c.Check(deliveries["/registered"].State, Equals, webmention.Sent)
+    c.Check(deliveries["/nothing"].State, Equals, webmention.Unsupported)
+    broken := deliveries["/broken"]
+    c.Check(broken.State, Equals, webmention.Queued)
+    c.Check(broken.Attempts, Equals, 1)
+    c.Check(broken.Error, Equals, "endpoint responded with 500 Internal Server Error")
+    c.Check(broken.Next.After(time.Now().Add(59*time.Minute)), Equals, true)
+
+    // Reloading queues the same links again, which mustn't resend anything,
+    // and the broken one isn't due yet.
+    c.Assert(s.sender.Queue(target, links), IsNil)
+    s.sender.Deliver()
+    c.Check(s.received, HasLen, 1)
+    c.Check(s.pings, HasLen, 1)
+
+    // Taking a link out tells the target once more.
+    c.Assert(s.sender.Queue(target, links[1:]), IsNil)
+    s.sender.Deliver()
+    c.Check(s.received, HasLen, 2)
+    c.Check(s.deliveries()["/header"].Removed, Equals, true)
+    c.Check(s.deliveries()["/header"].State, Equals, webmention.Sent)
+
+    reopened, err := webmention.OpenOutbox(s.sender.Outbox.Path)
+    c.Assert(err, IsNil)
+    c.Check(reopened.Deliveries(target), HasLen, 4)
+}
+
+func (s *SendSuite) TestGiveUp(c *C) {
+    s.sender.Backoff = 0
+    s.sender.MaxAttempts = 2
+    c.Assert(s.sender.Queue(target, []string{s.server.URL + "/broken"}), IsNil)
+    s.sender.Deliver()
+    s.sender.Deliver()
+    c.Check(s.deliveries()["/broken"].State, Equals, webmention.Failed)
+    c.Check(s.deliveries()["/broken"].Attempts, Equals, 2)
+}
+
+// Putting a link back while the target's being told it's gone leaves it to
+// be sent again, rather than marked as removed.
+func (s *SendSuite) TestQueuedWhileSending(c *C) {
+    links := []string{s.server.URL + "/header"}
+    c.Assert(s.sender.Queue(target, links), IsNil)
+    s.sender.Deliver()
+    c.Assert(s.sender.Queue(target, nil), IsNil)
+    s.sending = func() { c.Check(s.sender.Queue(target, links), IsNil) }
+    s.sender.Deliver()
+    c.Check(s.received, HasLen, 2)
+    d := s.deliveries()["/header"]
+    c.Check(d.State, Equals, webmention.Queued)
+    c.Check(d.Removed, Equals, false)
+
+    s.sending = nil
+    s.sender.Deliver()
+    c.Check(s.received, HasLen, 3)
+    c.Check(s.deliveries()["/header"].State, Equals, webmention.Sent)
+    c.Check(s.deliveries()["/header"].Removed, Equals, false)
+}
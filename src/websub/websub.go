@@ -0,0 +1,306 @@
+// Package websub is a small WebSub (née PubSubHubbub) hub, enough to push
+// our own feed to whoever subscribes to it without relying on someone
+// else's hub.
+//
+// See http://www.w3.org/TR/websub/ for the protocol.
+package websub
+
+import (
+    "atomicfile"
+    "bytes"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha1"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "logging"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+)
+
+const (
+    DefaultLease = 10 * 24 * time.Hour
+    MaxLease     = 30 * 24 * time.Hour
+    UserAgent    = "verboselogging websub hub (+http://verboselogging.com/hub)"
+)
+
+var (
+    logger = logging.New("websub")
+
+    ErrBadMode     = errors.New("hub.mode must be subscribe or unsubscribe")
+    ErrBadCallback = errors.New("hub.callback must be an http or https URL")
+    ErrBadTopic    = errors.New("hub.topic isn't published here")
+    ErrBadSecret   = errors.New("hub.secret must be shorter than 200 bytes")
+)
+
+// Subscription is a callback that wants a topic.
+type Subscription struct {
+    Callback string
+    Topic    string
+    Secret   string `json:",omitempty"`
+    Expires  time.Time
+}
+
+// Hub keeps the subscriptions in a JSON file, like everything else in
+// DataDir.
+type Hub struct {
+    Path   string
+    URL    string
+    Client *http.Client
+
+    // Topics tells whether a topic is one of ours.
+    Topics func(topic string) bool
+
+    lock          sync.RWMutex
+    subscriptions map[string]Subscription
+}
+
+// Open loads the hub at path, which doesn't have to exist yet. The hub
+// lives at hubURL.
+func Open(path, hubURL string, topics func(string) bool) (*Hub, error) {
+    h := &Hub{
+        Path:          path,
+        URL:           hubURL,
+        Client:        &http.Client{Timeout: 10 * time.Second},
+        Topics:        topics,
+        subscriptions: make(map[string]Subscription),
+    }
+    data, err := ioutil.ReadFile(path)
+    switch {
+    case os.IsNotExist(err):
+    case err != nil:
+        return nil, err
+    default:
+        var subscriptions []Subscription
+        if err := json.Unmarshal(data, &subscriptions); err != nil {
+            return nil, err
+        }
+        for _, s := range subscriptions {
+            h.subscriptions[key(s.Callback, s.Topic)] = s
+        }
+    }
+    return h, nil
+}
+
+func key(callback, topic string) string {
+    return callback + " " + topic
+}
+
+// Intent is a subscribe or unsubscribe request that checked out, and is
+// waiting for the subscriber to confirm it.
+type Intent struct {
+    Mode  string
+    Lease time.Duration
+    Subscription
+
+    // Denied is why the hub won't do it, if it won't.
+    Denied string
+}
+
+// Request checks a subscribe or unsubscribe request. The hub answers 202
+// and then does the Intent with Verify in the background.
+func (h *Hub) Request(mode, callback, topic, lease, secret string) (*Intent, error) {
+    if mode != "subscribe" && mode != "unsubscribe" {
+        return nil, ErrBadMode
+    }
+    u, err := url.Parse(callback)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return nil, ErrBadCallback
+    }
+    if len(secret) >= 200 {
+        return nil, ErrBadSecret
+    }
+    i := &Intent{Mode: mode, Subscription: Subscription{Callback: callback, Topic: topic, Secret: secret}}
+    if !h.Topics(topic) {
+        i.Denied = ErrBadTopic.Error()
+        return i, nil
+    }
+
+    seconds, err := strconv.Atoi(lease)
+    i.Lease = time.Duration(seconds) * time.Second
+    if err != nil || i.Lease <= 0 {
+        i.Lease = DefaultLease
+    }
+    if i.Lease > MaxLease {
+        i.Lease = MaxLease
+    }
+    return i, nil
+}
+
+// Verify asks the subscriber whether they meant it, and makes the change
+// if they did. Denied intents just let the subscriber know.
+func (h *Hub) Verify(i *Intent) error {
+    if i.Denied != "" {
+        _, err := h.callback(i.Callback, url.Values{
+            "hub.mode":   {"denied"},
+            "hub.topic":  {i.Topic},
+            "hub.reason": {i.Denied},
+        })
+        return err
+    }
+
+    challenge, err := randomHex(16)
+    if err != nil {
+        return err
+    }
+    query := url.Values{
+        "hub.mode":      {i.Mode},
+        "hub.topic":     {i.Topic},
+        "hub.challenge": {challenge},
+    }
+    if i.Mode == "subscribe" {
+        query.Set("hub.lease_seconds", strconv.Itoa(int(i.Lease/time.Second)))
+    }
+    body, err := h.callback(i.Callback, query)
+    if err != nil {
+        return err
+    }
+    if string(bytes.TrimSpace(body)) != challenge {
+        return errors.New("callback didn't echo the challenge")
+    }
+
+    h.lock.Lock()
+    defer h.lock.Unlock()
+    s := i.Subscription
+    if i.Mode == "subscribe" {
+        s.Expires = time.Now().Add(i.Lease)
+        h.subscriptions[key(s.Callback, s.Topic)] = s
+    } else {
+        delete(h.subscriptions, key(s.Callback, s.Topic))
+    }
+    logger.Printf("%s %s to %s", i.Mode, s.Callback, s.Topic)
+    return h.save()
+}
+
+// callback GETs the subscriber with query added to whatever query string
+// it already has.
+func (h *Hub) callback(callback string, query url.Values) ([]byte, error) {
+    u, err := url.Parse(callback)
+    if err != nil {
+        return nil, err
+    }
+    q := u.Query()
+    for k, v := range query {
+        q[k] = v
+    }
+    u.RawQuery = q.Encode()
+    req, err := http.NewRequest("GET", u.String(), nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("User-Agent", UserAgent)
+    resp, err := h.Client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return nil, fmt.Errorf("callback responded with %s", resp.Status)
+    }
+    return ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+}
+
+// Subscriptions returns the live subscriptions to topic.
+func (h *Hub) Subscriptions(topic string) []Subscription {
+    h.lock.RLock()
+    defer h.lock.RUnlock()
+    var subscriptions []Subscription
+    now := time.Now()
+    for _, s := range h.subscriptions {
+        if s.Topic == topic && s.Expires.After(now) {
+            subscriptions = append(subscriptions, s)
+        }
+    }
+    return subscriptions
+}
+
+// Publish sends the new content of a topic to everyone subscribed to it.
+// Subscribers that say they're gone get dropped.
+func (h *Hub) Publish(topic string, content []byte, contentType string) {
+    for _, s := range h.Subscriptions(topic) {
+        status, err := h.distribute(s, content, contentType)
+        switch {
+        case status == http.StatusGone:
+            h.lock.Lock()
+            delete(h.subscriptions, key(s.Callback, s.Topic))
+            if err := h.save(); err != nil {
+                logger.Printf("failed saving subscriptions: %s", err)
+            }
+            h.lock.Unlock()
+        case err != nil:
+            logger.Printf("failed sending %s to %s: %s", topic, s.Callback, err)
+        }
+    }
+}
+
+func (h *Hub) distribute(s Subscription, content []byte, contentType string) (int, error) {
+    req, err := http.NewRequest("POST", s.Callback, bytes.NewReader(content))
+    if err != nil {
+        return 0, err
+    }
+    req.Header.Set("Content-Type", contentType)
+    req.Header.Set("User-Agent", UserAgent)
+    req.Header.Add("Link", fmt.Sprintf(`<%s>; rel="hub"`, h.URL))
+    req.Header.Add("Link", fmt.Sprintf(`<%s>; rel="self"`, s.Topic))
+    if s.Secret != "" {
+        req.Header.Set("X-Hub-Signature", "sha1="+Sign(s.Secret, content))
+    }
+    resp, err := h.Client.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return resp.StatusCode, fmt.Errorf("callback responded with %s", resp.Status)
+    }
+    return resp.StatusCode, nil
+}
+
+// Sign is the hex HMAC-SHA1 of content, for X-Hub-Signature.
+func Sign(secret string, content []byte) string {
+    mac := hmac.New(sha1.New, []byte(secret))
+    mac.Write(content)
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+// save writes every subscription atomically. The lock must be held.
+func (h *Hub) save() error {
+    subscriptions := make([]Subscription, 0, len(h.subscriptions))
+    for _, s := range h.subscriptions {
+        subscriptions = append(subscriptions, s)
+    }
+    data, err := json.MarshalIndent(subscriptions, "", "  ")
+    if err != nil {
+        return err
+    }
+    return atomicfile.WriteFile(h.Path, data, 0644)
+}
+
+func randomHex(n int) (string, error) {
+    p := make([]byte, n)
+    if _, err := rand.Read(p); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(p), nil
+}
+
+// Ping tells a hub somewhere else that topic changed.
+func Ping(client *http.Client, hub, topic string) error {
+    resp, err := client.PostForm(hub, url.Values{"hub.mode": {"publish"}, "hub.url": {topic}})
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("hub responded with %s", resp.Status)
+    }
+    return nil
+}
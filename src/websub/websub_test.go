@@ -0,0 +1,139 @@
+package websub_test
+
+import (
+    "io/ioutil"
+    . "launchpad.net/gocheck"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "path/filepath"
+    "testing"
+    "time"
+    "websub"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+const topic = "http://verboselogging.com/feed"
+
+type delivery struct {
+    header http.Header
+    body   string
+}
+
+type HubSuite struct {
+    subscriber *httptest.Server
+    hub        *websub.Hub
+    checks     []url.Values
+    deliveries []delivery
+    gone       bool
+}
+
+var _ = Suite(&HubSuite{})
+
+func (s *HubSuite) SetUpTest(c *C) {
+    s.checks, s.deliveries, s.gone = nil, nil, false
+    s.subscriber = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case "GET":
+            query := r.URL.Query()
+            s.checks = append(s.checks, query)
+            if query.Get("refuse") == "" {
+                w.Write([]byte(query.Get("hub.challenge")))
+            }
+        case "POST":
+            if s.gone {
+                w.WriteHeader(http.StatusGone)
+                return
+            }
+            body, _ := ioutil.ReadAll(r.Body)
+            s.deliveries = append(s.deliveries, delivery{r.Header, string(body)})
+        }
+    }))
+
+    var err error
+    s.hub, err = websub.Open(filepath.Join(c.MkDir(), "websub.json"), "http://verboselogging.com/hub", func(t string) bool {
+        return t == topic
+    })
+    c.Assert(err, IsNil)
+}
+
+func (s *HubSuite) TearDownTest(c *C) {
+    s.subscriber.Close()
+}
+
+func (s *HubSuite) subscribe(c *C, mode, callback, secret string) {
+    intent, err := s.hub.Request(mode, callback, topic, "3600", secret)
+    c.Assert(err, IsNil)
+    c.Assert(s.hub.Verify(intent), IsNil)
+}
+
+func (s *HubSuite) TestSubscribeAndPublish(c *C) {
+    s.subscribe(c, "subscribe", s.subscriber.URL+"/cb?id=1", "sekrit")
+    c.Assert(s.checks, HasLen, 1)
+    c.Check(s.checks[0].Get("hub.mode"), Equals, "subscribe")
+    c.Check(s.checks[0].Get("hub.topic"), Equals, topic)
+    c.Check(s.checks[0].Get("hub.lease_seconds"), Equals, "3600")
+    c.Check(s.checks[0].Get("id"), Equals, "1")
+
+    subscriptions := s.hub.Subscriptions(topic)
+    c.Assert(subscriptions, HasLen, 1)
+    c.Check(subscriptions[0].Expires.After(time.Now().Add(59*time.Minute)), Equals, true)
+
+    s.hub.Publish(topic, []byte("<rss/>"), "application/rss+xml")
+    c.Assert(s.deliveries, HasLen, 1)
+    d := s.deliveries[0]
+    c.Check(d.body, Equals, "<rss/>")
+    c.Check(d.header.Get("Content-Type"), Equals, "application/rss+xml")
+    c.Check(d.header.Get("X-Hub-Signature"), Equals, "sha1="+websub.Sign("sekrit", []byte("<rss/>")))
+    c.Check(d.header["Link"], DeepEquals, []string{`<http://verboselogging.com/hub>; rel="hub"`, `<` + topic + `>; rel="self"`})
+
+    reopened, err := websub.Open(s.hub.Path, s.hub.URL, s.hub.Topics)
+    c.Assert(err, IsNil)
+    c.Check(reopened.Subscriptions(topic), HasLen, 1)
+
+    s.subscribe(c, "unsubscribe", s.subscriber.URL+"/cb?id=1", "")
+    c.Check(s.hub.Subscriptions(topic), HasLen, 0)
+}
+
+func (s *HubSuite) TestRefused(c *C) {
+    intent, err := s.hub.Request("subscribe", s.subscriber.URL+"/cb?refuse=1", topic, "", "")
+    c.Assert(err, IsNil)
+    c.Check(intent.Lease, Equals, websub.DefaultLease)
+    c.Check(s.hub.Verify(intent), ErrorMatches, "callback didn't echo the challenge")
+    c.Check(s.hub.Subscriptions(topic), HasLen, 0)
+}
+
+func (s *HubSuite) TestDenied(c *C) {
+    intent, err := s.hub.Request("subscribe", s.subscriber.URL+"/cb", "http://example.com/feed", "", "")
+    c.Assert(err, IsNil)
+    c.Assert(s.hub.Verify(intent), IsNil)
+    c.Assert(s.checks, HasLen, 1)
+    c.Check(s.checks[0].Get("hub.mode"), Equals, "denied")
+    c.Check(s.hub.Subscriptions("http://example.com/feed"), HasLen, 0)
+
+    _, err = s.hub.Request("publish", s.subscriber.URL, topic, "", "")
+    c.Check(err, Equals, websub.ErrBadMode)
+    _, err = s.hub.Request("subscribe", "ftp://example.com/", topic, "", "")
+    c.Check(err, Equals, websub.ErrBadCallback)
+}
+
+func (s *HubSuite) TestGone(c *C) {
+    s.subscribe(c, "subscribe", s.subscriber.URL+"/cb", "")
+    s.gone = true
+    s.hub.Publish(topic, []byte("<rss/>"), "application/rss+xml")
+    c.Check(s.hub.Subscriptions(topic), HasLen, 0)
+}
+
+func (s *HubSuite) TestPing(c *C) {
+    var pinged url.Values
+    hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        r.ParseForm()
+        pinged = r.PostForm
+        w.WriteHeader(http.StatusNoContent)
+    }))
+    defer hub.Close()
+    c.Assert(websub.Ping(http.DefaultClient, hub.URL, topic), IsNil)
+    c.Check(pinged.Get("hub.mode"), Equals, "publish")
+    c.Check(pinged.Get("hub.url"), Equals, topic)
+}
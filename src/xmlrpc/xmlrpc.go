@@ -0,0 +1,324 @@
+// Package xmlrpc is just enough XML-RPC to answer calls: reading a method
+// call and writing back a response or a fault.
+//
+// Values come and go as plain Go values: string, int, bool, float64,
+// time.Time, []byte for base64, []interface{} for arrays and
+// map[string]interface{} for structs.
+//
+// See http://xmlrpc.scripting.com/spec.html for the format.
+package xmlrpc
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "io"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// DateLayout is how dateTime.iso8601 is written, which isn't quite ISO
+// 8601.
+const DateLayout = "20060102T15:04:05"
+
+var (
+    ErrNoMethod = errors.New("not a method call")
+
+    // Only what has to be escaped is, so text stays readable.
+    escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+    // Clients don't agree on how to write dates.
+    dateLayouts = []string{DateLayout, "2006-01-02T15:04:05", "20060102T15:04:05Z", "2006-01-02T15:04:05Z07:00", "20060102T15:04:05Z07:00"}
+)
+
+// Fault is an error that goes back to the client as a fault.
+type Fault struct {
+    Code    int
+    Message string
+}
+
+func (f *Fault) Error() string {
+    return fmt.Sprintf("fault %d: %s", f.Code, f.Message)
+}
+
+// ParseCall reads a methodCall.
+func ParseCall(r io.Reader) (string, []interface{}, error) {
+    d := xml.NewDecoder(r)
+    var (
+        method string
+        params []interface{}
+        found  bool
+    )
+    for {
+        t, err := d.Token()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return "", nil, err
+        }
+        start, ok := t.(xml.StartElement)
+        if !ok {
+            continue
+        }
+        switch start.Name.Local {
+        case "methodCall":
+            found = true
+        case "methodName":
+            if method, err = text(d); err != nil {
+                return "", nil, err
+            }
+        case "value":
+            v, err := parseValue(d)
+            if err != nil {
+                return "", nil, err
+            }
+            params = append(params, v)
+        }
+    }
+    if !found || method == "" {
+        return "", nil, ErrNoMethod
+    }
+    return strings.TrimSpace(method), params, nil
+}
+
+// text reads character data up to the end of the current element.
+func text(d *xml.Decoder) (string, error) {
+    var b bytes.Buffer
+    for {
+        t, err := d.Token()
+        if err != nil {
+            return "", err
+        }
+        switch t := t.(type) {
+        case xml.CharData:
+            b.Write(t)
+        case xml.StartElement:
+            return "", fmt.Errorf("unexpected <%s>", t.Name.Local)
+        case xml.EndElement:
+            return b.String(), nil
+        }
+    }
+}
+
+// parseValue reads what's in a <value>, up to and including </value>.
+func parseValue(d *xml.Decoder) (interface{}, error) {
+    var (
+        raw bytes.Buffer
+        v   interface{}
+        typ bool
+    )
+    for {
+        t, err := d.Token()
+        if err != nil {
+            return nil, err
+        }
+        switch t := t.(type) {
+        case xml.CharData:
+            raw.Write(t)
+        case xml.StartElement:
+            if typ {
+                return nil, fmt.Errorf("unexpected <%s>", t.Name.Local)
+            }
+            typ = true
+            if v, err = parseTyped(d, t.Name.Local); err != nil {
+                return nil, err
+            }
+        case xml.EndElement:
+            // A value with no type is a string.
+            if !typ {
+                return raw.String(), nil
+            }
+            return v, nil
+        }
+    }
+}
+
+func parseTyped(d *xml.Decoder, typ string) (interface{}, error) {
+    switch typ {
+    case "array":
+        return parseArray(d)
+    case "struct":
+        return parseStruct(d)
+    }
+    s, err := text(d)
+    if err != nil {
+        return nil, err
+    }
+    switch typ {
+    case "string":
+        return s, nil
+    case "int", "i4", "i8":
+        return strconv.Atoi(strings.TrimSpace(s))
+    case "boolean":
+        return strings.TrimSpace(s) == "1", nil
+    case "double":
+        return strconv.ParseFloat(strings.TrimSpace(s), 64)
+    case "dateTime.iso8601":
+        s = strings.TrimSpace(s)
+        for _, layout := range dateLayouts {
+            if t, err := time.Parse(layout, s); err == nil {
+                return t, nil
+            }
+        }
+        return nil, fmt.Errorf("can't read the date %#v", s)
+    case "base64":
+        return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+    case "nil":
+        return nil, nil
+    }
+    return nil, fmt.Errorf("unknown type %s", typ)
+}
+
+func parseArray(d *xml.Decoder) (interface{}, error) {
+    values := []interface{}{}
+    depth := 0
+    for {
+        t, err := d.Token()
+        if err != nil {
+            return nil, err
+        }
+        switch t := t.(type) {
+        case xml.StartElement:
+            if t.Name.Local == "value" {
+                v, err := parseValue(d)
+                if err != nil {
+                    return nil, err
+                }
+                values = append(values, v)
+            } else {
+                depth++
+            }
+        case xml.EndElement:
+            if depth == 0 {
+                return values, nil
+            }
+            depth--
+        }
+    }
+}
+
+func parseStruct(d *xml.Decoder) (interface{}, error) {
+    members := make(map[string]interface{})
+    var name string
+    for {
+        t, err := d.Token()
+        if err != nil {
+            return nil, err
+        }
+        switch t := t.(type) {
+        case xml.StartElement:
+            switch t.Name.Local {
+            case "name":
+                if name, err = text(d); err != nil {
+                    return nil, err
+                }
+            case "value":
+                v, err := parseValue(d)
+                if err != nil {
+                    return nil, err
+                }
+                members[strings.TrimSpace(name)] = v
+            }
+        case xml.EndElement:
+            if t.Name.Local == "struct" {
+                return members, nil
+            }
+        }
+    }
+}
+
+// WriteResponse writes a methodResponse with v as the one param.
+func WriteResponse(w io.Writer, v interface{}) error {
+    var b bytes.Buffer
+    b.WriteString(`<?xml version="1.0"?>` + "\n<methodResponse><params><param>")
+    if err := writeValue(&b, v); err != nil {
+        return err
+    }
+    b.WriteString("</param></params></methodResponse>\n")
+    _, err := b.WriteTo(w)
+    return err
+}
+
+// WriteFault writes a fault response.
+func WriteFault(w io.Writer, f *Fault) error {
+    var b bytes.Buffer
+    b.WriteString(`<?xml version="1.0"?>` + "\n<methodResponse><fault>")
+    writeValue(&b, map[string]interface{}{"faultCode": f.Code, "faultString": f.Message})
+    b.WriteString("</fault></methodResponse>\n")
+    _, err := b.WriteTo(w)
+    return err
+}
+
+func writeValue(b *bytes.Buffer, v interface{}) error {
+    b.WriteString("<value>")
+    switch v := v.(type) {
+    case nil:
+        b.WriteString("<nil/>")
+    case string:
+        b.WriteString("<string>")
+        escaper.WriteString(b, v)
+        b.WriteString("</string>")
+    case int:
+        fmt.Fprintf(b, "<int>%d</int>", v)
+    case bool:
+        if v {
+            b.WriteString("<boolean>1</boolean>")
+        } else {
+            b.WriteString("<boolean>0</boolean>")
+        }
+    case float64:
+        fmt.Fprintf(b, "<double>%s</double>", strconv.FormatFloat(v, 'f', -1, 64))
+    case time.Time:
+        fmt.Fprintf(b, "<dateTime.iso8601>%s</dateTime.iso8601>", v.UTC().Format(DateLayout))
+    case []byte:
+        fmt.Fprintf(b, "<base64>%s</base64>", base64.StdEncoding.EncodeToString(v))
+    case []string:
+        b.WriteString("<array><data>")
+        for _, s := range v {
+            writeValue(b, s)
+        }
+        b.WriteString("</data></array>")
+    case []interface{}:
+        b.WriteString("<array><data>")
+        for _, item := range v {
+            if err := writeValue(b, item); err != nil {
+                return err
+            }
+        }
+        b.WriteString("</data></array>")
+    case []map[string]interface{}:
+        b.WriteString("<array><data>")
+        for _, item := range v {
+            if err := writeValue(b, item); err != nil {
+                return err
+            }
+        }
+        b.WriteString("</data></array>")
+    case map[string]interface{}:
+        names := make([]string, 0, len(v))
+        for name := range v {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        b.WriteString("<struct>")
+        for _, name := range names {
+            b.WriteString("<member><name>")
+            escaper.WriteString(b, name)
+            b.WriteString("</name>")
+            if err := writeValue(b, v[name]); err != nil {
+                return err
+            }
+            b.WriteString("</member>")
+        }
+        b.WriteString("</struct>")
+    default:
+        return fmt.Errorf("can't write a %T", v)
+    }
+    b.WriteString("</value>")
+    return nil
+}
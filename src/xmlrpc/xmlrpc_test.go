@@ -0,0 +1,68 @@
+package xmlrpc_test
+
+import (
+    "bytes"
+    . "launchpad.net/gocheck"
+    "strings"
+    "testing"
+    "time"
+    "xmlrpc"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type XMLRPCSuite struct{}
+
+var _ = Suite(&XMLRPCSuite{})
+
+func (s *XMLRPCSuite) TestParseCall(c *C) {
+    method, params, err := xmlrpc.ParseCall(strings.NewReader(`<?xml version="1.0"?>
+<methodCall>
+  <methodName>metaWeblog.newPost</methodName>
+  <params>
+    <param><value><string>1</string></value></param>
+    <param><value>admin</value></param>
+    <param><value><i4>42</i4></value></param>
+    <param><value><struct>
+      <member><name>title</name><value><string>Fish &amp; Chips</string></value></member>
+      <member><name>categories</name><value><array><data>
+        <value><string>food</string></value>
+        <value>uk</value>
+      </data></array></value></member>
+      <member><name>dateCreated</name><value><dateTime.iso8601>20130502T16:00:00</dateTime.iso8601></value></member>
+      <member><name>bits</name><value><base64>aGk=</base64></value></member>
+    </struct></value></param>
+    <param><value><boolean>1</boolean></value></param>
+  </params>
+</methodCall>`))
+    c.Assert(err, IsNil)
+    c.Check(method, Equals, "metaWeblog.newPost")
+    c.Assert(params, HasLen, 5)
+    c.Check(params[0], Equals, "1")
+    c.Check(params[1], Equals, "admin")
+    c.Check(params[2], Equals, 42)
+    c.Check(params[4], Equals, true)
+    post := params[3].(map[string]interface{})
+    c.Check(post["title"], Equals, "Fish & Chips")
+    c.Check(post["categories"], DeepEquals, []interface{}{"food", "uk"})
+    c.Check(post["dateCreated"], DeepEquals, time.Date(2013, 5, 2, 16, 0, 0, 0, time.UTC))
+    c.Check(post["bits"], DeepEquals, []byte("hi"))
+
+    _, _, err = xmlrpc.ParseCall(strings.NewReader(`<methodResponse/>`))
+    c.Check(err, Equals, xmlrpc.ErrNoMethod)
+}
+
+func (s *XMLRPCSuite) TestWrite(c *C) {
+    var b bytes.Buffer
+    err := xmlrpc.WriteResponse(&b, map[string]interface{}{"ok": true, "tags": []interface{}{"a<b"}, "n": 3})
+    c.Assert(err, IsNil)
+    c.Check(b.String(), Equals, `<?xml version="1.0"?>`+"\n"+`<methodResponse><params><param><value><struct>`+
+        `<member><name>n</name><value><int>3</int></value></member>`+
+        `<member><name>ok</name><value><boolean>1</boolean></value></member>`+
+        `<member><name>tags</name><value><array><data><value><string>a&lt;b</string></value></data></array></value></member>`+
+        `</struct></value></param></params></methodResponse>`+"\n")
+
+    b.Reset()
+    xmlrpc.WriteFault(&b, &xmlrpc.Fault{Code: 403, Message: "Nope"})
+    c.Check(b.String(), Matches, `(?s).*<fault><value><struct><member><name>faultCode</name><value><int>403</int></value></member>.*Nope.*`)
+}